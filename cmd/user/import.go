@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"user/internal/domain"
+	"user/internal/interfaces"
+	"user/internal/presentation/importer"
+	"user/internal/presentation/validation"
+)
+
+// runImport выполняет подкоманду import:
+//
+//	user import [-format csv|ndjson] [-tenant id] [-dry-run] [-overwrite-password] [-report report.csv] users.csv
+//
+// Пользователи загружаются в организацию -tenant, по умолчанию в DEFAULT_TENANT
+// Пароли существующих пользователей перезаписываются только с -overwrite-password
+// Формат по умолчанию определяется по расширению файла
+// Отчет по незагруженным строкам пишется в файл -report или в stderr
+func runImport(args []string, imports interfaces.ImportRepo) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "", "file format: csv or ndjson")
+	dryRun := flags.Bool("dry-run", false, "validate and roll back without saving users")
+	overwritePassword := flags.Bool("overwrite-password", false, "replace passwords of existing users with passwords from the file")
+	reportPath := flags.String("report", "", "path of the CSV report with rejected rows")
+	tenant := flags.Uint64("tenant", domain.DEFAULT_TENANT, "organization of imported users")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New("usage: user import [-format csv|ndjson] [-tenant id] [-dry-run] [-overwrite-password] [-report report.csv] file")
+	}
+	path := flags.Arg(0)
+
+	if *format == "" {
+		*format = importer.Format("", path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening import file error: %v", err)
+	}
+	defer file.Close()
+
+	rows, rejected, err := importer.Parse(file, *format)
+	if err != nil {
+		return fmt.Errorf("invalid import file: %v", err)
+	}
+
+	valid, invalid := importer.Validate(rows, validation.CheckUser)
+
+	ctx := domain.WithTenant(domain.WithActor(context.Background(), domain.Actor{RequestId: "cli-import"}), *tenant)
+	params := domain.ImportJob{
+		Format:            *format,
+		DryRun:            *dryRun,
+		OverwritePassword: *overwritePassword,
+	}
+	id, err := imports.Create(ctx, params)
+	if err != nil {
+		return err
+	}
+
+	params.Id = *id
+	job, err := imports.Run(ctx, params, valid, append(rejected, invalid...))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("import %d %s: total %d, created %d, updated %d (passwords kept %d), failed %d\n", job.Id, job.Status, job.Total, job.Created, job.Updated, job.PasswordsKept, job.Failed)
+	if job.DryRun {
+		fmt.Println("dry run: no changes were saved")
+	}
+
+	if len(job.Errors) > 0 {
+		report := os.Stderr
+		if *reportPath != "" {
+			report, err = os.Create(*reportPath)
+			if err != nil {
+				return fmt.Errorf("creating report file error: %v", err)
+			}
+			defer report.Close()
+		}
+
+		err = importer.WriteReport(report, job.Errors)
+		if err != nil {
+			return fmt.Errorf("writing report error: %v", err)
+		}
+	}
+
+	if job.Status == domain.IMPORT_FAILED {
+		return errors.New(job.Error)
+	}
+
+	return nil
+}
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+	"user/internal/interfaces"
 	"user/internal/presentation/db"
+	"user/internal/presentation/grpcserver"
 	"user/internal/presentation/logger"
 	"user/internal/presentation/realization"
 	"user/internal/presentation/server"
+	"user/internal/presentation/validation"
+	"user/internal/presentation/webauthn"
 
 	"github.com/joho/godotenv"
 )
@@ -48,7 +56,33 @@ func main() {
 		return
 	}
 
-	cacheRepo := realization.NewConnectRedis(redisHost, redisPort, redisPass)
+	keyring, err := newKeyring()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Keyring creating error - %v", err))
+		return
+	}
+
+	rotationInterval, err := time.ParseDuration(os.Getenv("KEY_ROTATION_INTERVAL"))
+	if err != nil || rotationInterval <= 0 {
+		logger.Logger.Error("Invalid key rotation interval")
+		return
+	}
+
+	rotationBatch, err := strconv.Atoi(os.Getenv("KEY_ROTATION_BATCH_SIZE"))
+	if err != nil || rotationBatch <= 0 {
+		logger.Logger.Error("Invalid key rotation batch size")
+		return
+	}
+
+	// Строки, записанные до включения шифрования, шифруются до приема запросов
+	rotator := realization.NewKeyRotator(dataBase, keyring, rotationInterval, rotationBatch)
+	_, err = rotator.Backfill(context.Background())
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Encrypting users error - %v", err))
+		return
+	}
+
+	cacheRepo := realization.NewConnectRedis(redisHost, redisPort, redisPass, keyring)
 	server.CacheService = cacheRepo
 
 	serverPortStr := os.Getenv("SERVER_PORT")
@@ -58,8 +92,210 @@ func main() {
 		return
 	}
 
-	userService := realization.NewUserService(dataBase, cacheRepo)
+	grpcPort, err := strconv.Atoi(os.Getenv("GRPC_PORT"))
+	if err != nil {
+		logger.Logger.Error("Invalid gRPC port")
+		return
+	}
+
+	bcryptCost, err := strconv.Atoi(os.Getenv("BCRYPT_COST"))
+	if err != nil {
+		logger.Logger.Error("Invalid bcrypt cost")
+		return
+	}
+
+	hasher, err := realization.NewPasswordHasher(os.Getenv("PASSWORD_HASHER"), realization.NewArgon2idHasher(3, 64*1024, 2), realization.NewBcryptHasher(bcryptCost))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Password hasher creating error - %v", err))
+		return
+	}
+	validation.Hasher = hasher
+
+	userService := realization.NewUserService(dataBase, cacheRepo, keyring, hasher)
 	server.UserService = userService
+	server.AuditService = realization.NewAuditService(dataBase)
+	server.GdprService = realization.NewGdprService(dataBase, cacheRepo, keyring)
+	server.RoleService = realization.NewRoleService(dataBase)
+	server.OrganizationService = realization.NewOrganizationService(dataBase)
+
+	importService := realization.NewImportService(dataBase, cacheRepo, keyring)
+	server.ImportService = importService
+
+	// Подкоманда import загружает пользователей из файла без запуска сервера
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		err = runImport(os.Args[2:], importService)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Import error - %v", err))
+		}
+
+		_ = dataBase.CloseDB()
+		_ = cacheRepo.Close()
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	accessTTL, err := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL"))
+	if err != nil {
+		logger.Logger.Error("Invalid access token TTL")
+		return
+	}
+
+	refreshTTL, err := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL"))
+	if err != nil {
+		logger.Logger.Error("Invalid refresh token TTL")
+		return
+	}
+
+	mfaService := realization.NewMfaService(dataBase, keyring, os.Getenv("MFA_ISSUER"), time.Now)
+	server.MfaService = mfaService
+
+	authService := realization.NewAuthService(dataBase, hasher, keyring, mfaService, cacheRepo, accessTTL, refreshTTL, os.Getenv("EMAIL_VERIFICATION_REQUIRED") == "true")
+	server.AuthService = authService
+	server.SessionService = realization.NewSessionService(dataBase, cacheRepo, keyring)
+
+	maxAttempts, err := strconv.Atoi(os.Getenv("LOGIN_MAX_ATTEMPTS"))
+	if err != nil || maxAttempts <= 0 {
+		logger.Logger.Error("Invalid login max attempts")
+		return
+	}
+
+	maxIpAttempts, err := strconv.Atoi(os.Getenv("LOGIN_IP_MAX_ATTEMPTS"))
+	if err != nil || maxIpAttempts <= 0 {
+		logger.Logger.Error("Invalid login max attempts per address")
+		return
+	}
+
+	lockoutDuration, err := time.ParseDuration(os.Getenv("LOGIN_LOCKOUT_DURATION"))
+	if err != nil || lockoutDuration <= 0 {
+		logger.Logger.Error("Invalid login lockout duration")
+		return
+	}
+	server.LockoutService = realization.NewLockoutService(dataBase, cacheRepo, keyring, maxAttempts, maxIpAttempts, lockoutDuration)
+
+	server.PasskeyService = realization.NewPasskeyService(dataBase, keyring, authService, webauthn.RelyingParty{
+		Id:      os.Getenv("WEBAUTHN_RP_ID"),
+		Name:    os.Getenv("WEBAUTHN_RP_NAME"),
+		Origins: strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ","),
+	})
+
+	keyRotation, err := time.ParseDuration(os.Getenv("OAUTH_KEY_ROTATION_INTERVAL"))
+	if err != nil || keyRotation <= 0 {
+		logger.Logger.Error("Invalid oauth key rotation interval")
+		return
+	}
+
+	oauthService := realization.NewOAuthService(dataBase, keyring, strings.TrimSuffix(os.Getenv("OAUTH_ISSUER"), "/"), accessTTL, refreshTTL, keyRotation)
+	err = oauthService.RotateKeys(context.Background())
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("OAuth signing keys creating error - %v", err))
+		return
+	}
+	server.OAuthService = oauthService
+	server.OAuthClientService = realization.NewOAuthClientService(dataBase)
+	server.OAuthLoginUrl = os.Getenv("OAUTH_LOGIN_URL")
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		server.TrustedProxies = strings.Split(proxies, ",")
+	}
+
+	mailer, err := newMailer(os.Getenv("MAILER"))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Mailer creating error - %v", err))
+		return
+	}
+
+	verificationSecret, err := base64.StdEncoding.DecodeString(os.Getenv("EMAIL_VERIFICATION_SECRET"))
+	if err != nil {
+		logger.Logger.Error("EMAIL_VERIFICATION_SECRET is not base64")
+		return
+	}
+
+	verificationTTL, err := time.ParseDuration(os.Getenv("EMAIL_VERIFICATION_TTL"))
+	if err != nil || verificationTTL <= 0 {
+		logger.Logger.Error("Invalid email verification TTL")
+		return
+	}
+
+	verificationService, err := realization.NewVerificationService(dataBase, keyring, mailer, verificationSecret, verificationTTL, os.Getenv("EMAIL_VERIFICATION_URL"))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Verification service creating error - %v", err))
+		return
+	}
+	server.VerificationService = verificationService
+
+	resetTTL, err := time.ParseDuration(os.Getenv("PASSWORD_RESET_TTL"))
+	if err != nil || resetTTL <= 0 {
+		logger.Logger.Error("Invalid password reset TTL")
+		return
+	}
+	passwordResets := realization.NewPasswordResetService(dataBase, cacheRepo, keyring, mailer, resetTTL, os.Getenv("PASSWORD_RESET_URL"))
+	server.PasswordResetService = passwordResets
+
+	server.RequireIfMatch = os.Getenv("REQUIRE_IF_MATCH") == "true"
+
+	outboxTimeout, err := time.ParseDuration(os.Getenv("OUTBOX_TIMEOUT"))
+	if err != nil || outboxTimeout <= 0 {
+		logger.Logger.Error("Invalid outbox timeout")
+		return
+	}
+
+	outboxRetention, err := time.ParseDuration(os.Getenv("OUTBOX_RETENTION"))
+	if err != nil || outboxRetention <= 0 {
+		logger.Logger.Error("Invalid outbox retention")
+		return
+	}
+
+	publisher, err := newPublisher(os.Getenv("OUTBOX_PUBLISHER"), outboxTimeout)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Event publisher creating error - %v", err))
+		return
+	}
+
+	outboxInterval, err := time.ParseDuration(os.Getenv("OUTBOX_INTERVAL"))
+	if err != nil || outboxInterval <= 0 {
+		logger.Logger.Error("Invalid outbox interval")
+		return
+	}
+
+	outboxBatch, err := strconv.Atoi(os.Getenv("OUTBOX_BATCH_SIZE"))
+	if err != nil || outboxBatch <= 0 {
+		logger.Logger.Error("Invalid outbox batch size")
+		return
+	}
+
+	webhookService := realization.NewWebhookService(dataBase)
+	server.WebhookService = webhookService
+
+	webhookAttempts, err := strconv.Atoi(os.Getenv("WEBHOOK_MAX_ATTEMPTS"))
+	if err != nil || webhookAttempts <= 0 {
+		logger.Logger.Error("Invalid webhook max attempts")
+		return
+	}
+
+	webhookTimeout, err := time.ParseDuration(os.Getenv("WEBHOOK_TIMEOUT"))
+	if err != nil || webhookTimeout <= 0 {
+		logger.Logger.Error("Invalid webhook timeout")
+		return
+	}
+
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	relay := realization.NewOutboxRelay(dataBase, realization.NewMultiPublisher(publisher, webhookService), outboxInterval, outboxBatch, outboxTimeout, outboxRetention)
+	go relay.Run(relayCtx)
+
+	dispatcher := realization.NewWebhookDispatcher(dataBase, outboxInterval, outboxBatch, webhookAttempts, webhookTimeout)
+	go dispatcher.Run(relayCtx)
+
+	go rotator.Run(relayCtx)
+	go oauthService.RunKeyRotation(relayCtx)
+
+	grpcSrv := grpcserver.NewServer(userService, authService, server.RequireIfMatch)
+	go func() {
+		err := grpcSrv.Start(grpcPort)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("gRPC server working error - %v", err))
+		}
+	}()
 
 	srv := server.NewServer()
 	err = srv.Start(serverPort)
@@ -67,5 +303,70 @@ func main() {
 		logger.Logger.Error(fmt.Sprintf("Server working error - %v", err))
 	}
 
+	stopRelay()
+	grpcSrv.Shutdown()
 	srv.Shutdown()
+	passwordResets.Wait()
+}
+
+// newKeyring создает связку ключей шифрования персональных данных
+// Ключи берутся из каталога ENCRYPTION_KEYS_DIR или из строки ENCRYPTION_KEYS вида "id:base64,..."
+func newKeyring() (*realization.Keyring, error) {
+	var keys map[string][]byte
+	var err error
+	if dir := os.Getenv("ENCRYPTION_KEYS_DIR"); dir != "" {
+		keys, err = realization.ReadKeys(dir)
+	} else {
+		keys, err = realization.ParseKeys(os.Getenv("ENCRYPTION_KEYS"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	indexKey, err := base64.StdEncoding.DecodeString(os.Getenv("BLIND_INDEX_KEY"))
+	if err != nil {
+		return nil, fmt.Errorf("BLIND_INDEX_KEY is not base64")
+	}
+
+	return realization.NewKeyring(keys, os.Getenv("ENCRYPTION_ACTIVE_KEY"), indexKey)
+}
+
+// newMailer создает отправщик писем по его названию
+func newMailer(kind string) (interfaces.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	switch kind {
+	case "", "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return nil, fmt.Errorf("SMTP_HOST is required")
+		}
+		return realization.NewSMTPMailer(host, os.Getenv("SMTP_PORT"), os.Getenv("SMTP_USER"), os.Getenv("SMTP_PASSWORD"), from), nil
+	case "file":
+		return realization.NewFileMailer(from, os.Getenv("MAIL_DIR"))
+	case "memory":
+		return realization.NewMemoryMailer(), nil
+	}
+
+	return nil, fmt.Errorf("unknown mailer %q", kind)
+}
+
+// newPublisher создает публикатор событий outbox по его названию
+// timeout - ограничение времени отправки события по HTTP
+func newPublisher(kind string, timeout time.Duration) (interfaces.EventPublisher, error) {
+	switch kind {
+	case "", "stdout":
+		return realization.NewStdoutPublisher(), nil
+	case "memory":
+		return realization.NewMemoryPublisher(), nil
+	case "file":
+		return realization.NewFilePublisher(os.Getenv("OUTBOX_FILE"))
+	case "webhook":
+		url := os.Getenv("OUTBOX_WEBHOOK_URL")
+		if url == "" {
+			return nil, fmt.Errorf("OUTBOX_WEBHOOK_URL is required")
+		}
+		return realization.NewHTTPPublisher(url, timeout), nil
+	}
+
+	return nil, fmt.Errorf("unknown publisher %q", kind)
 }
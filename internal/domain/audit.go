@@ -0,0 +1,62 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	AUDIT_CREATE  = "create"
+	AUDIT_UPDATE  = "update"
+	AUDIT_DELETE  = "delete"
+	AUDIT_RESTORE = "restore"
+	AUDIT_PURGE   = "purge"
+
+	// REDACTED заменяет значения секретных полей в журнале
+	REDACTED = "[REDACTED]"
+)
+
+// FieldChange - изменение одного поля пользователя
+type FieldChange struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+// AuditEntry - запись журнала изменений пользователя
+type AuditEntry struct {
+	Id        Id                     `json:"id"`
+	UserId    Id                     `json:"user_id"`
+	ActorId   *Id                    `json:"actor_id"`
+	RequestId string                 `json:"request_id"`
+	Action    string                 `json:"action"`
+	Diff      map[string]FieldChange `json:"diff"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// AuditPage - страница журнала изменений
+type AuditPage struct {
+	Entries    []AuditEntry `json:"entries"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// Actor - инициатор изменения
+type Actor struct {
+	// UserId - пользователь, выполнивший запрос, nil для анонимных запросов
+	UserId    *Id
+	RequestId string
+	// SessionId - сессия, которой выполнен запрос, nil для анонимных запросов
+	SessionId *Id
+}
+
+type actorKey struct{}
+
+// WithActor сохраняет инициатора изменения в контексте
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom возвращает инициатора изменения из контекста
+func ActorFrom(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorKey{}).(Actor)
+	return actor
+}
@@ -0,0 +1,19 @@
+package domain
+
+const (
+	BULK_CREATE = "create"
+	BULK_UPDATE = "update"
+)
+
+// BulkItem - одна операция пакетной записи
+// Для update пользователь определяется по User.Id, ненулевая User.Version сверяется
+type BulkItem struct {
+	Op   string
+	User User
+}
+
+// BulkResult - результат операции пакетной записи, Err == nil означает успех
+type BulkResult struct {
+	Id  Id
+	Err error
+}
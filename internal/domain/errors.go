@@ -0,0 +1,45 @@
+package domain
+
+import "errors"
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidSort   = errors.New("invalid sort field")
+	ErrInvalidField  = errors.New("invalid export field")
+
+	// ErrVersionMismatch - версия пользователя изменилась с момента чтения
+	ErrVersionMismatch = errors.New("user version mismatch")
+
+	ErrLoginExists  = errors.New("user login already exists")
+	ErrUserNotFound = errors.New("user not found")
+
+	// ErrUserInactive - мягко удаленного пользователя нельзя изменить, не восстановив его
+	ErrUserInactive = errors.New("user is inactive")
+
+	// ErrBulkAborted - операция не выполнена, потому что в атомарном пакете упала другая операция
+	ErrBulkAborted = errors.New("bulk operation aborted")
+
+	// ErrAlreadyErased - персональные данные пользователя уже обезличены
+	ErrAlreadyErased = errors.New("user already erased")
+
+	ErrUnknownRole = errors.New("unknown role")
+
+	ErrSlugExists = errors.New("organization slug already exists")
+
+	// ErrLastRoleManager - после отзыва роли не останется пользователей, управляющих ролями
+	ErrLastRoleManager = errors.New("last role manager")
+
+	// ErrInvalidToken - токен неверный, просрочен или уже использован
+	ErrInvalidToken = errors.New("invalid token")
+
+	// ErrEmailNotVerified - вход запрещен до подтверждения email
+	ErrEmailNotVerified = errors.New("email is not verified")
+
+	// ErrInvalidCode - код TOTP или код восстановления не подошел
+	ErrInvalidCode       = errors.New("invalid code")
+	ErrMfaNotEnrolled    = errors.New("mfa is not enrolled")
+	ErrMfaAlreadyEnabled = errors.New("mfa is already enabled")
+
+	// ErrInvalidPasskey - ответ WebAuthn не прошел проверку
+	ErrInvalidPasskey = errors.New("invalid passkey response")
+)
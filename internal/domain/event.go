@@ -0,0 +1,43 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	EVENT_USER_CREATED = "user.created"
+	EVENT_USER_UPDATED = "user.updated"
+	EVENT_USER_DELETED = "user.deleted"
+)
+
+// EventTypes - все типы событий, на которые можно подписаться
+var EventTypes = []string{EVENT_USER_CREATED, EVENT_USER_UPDATED, EVENT_USER_DELETED}
+
+// Event - доменное событие жизненного цикла пользователя
+type Event struct {
+	Id         Id              `json:"id"`
+	Type       string          `json:"type"`
+	UserId     Id              `json:"user_id"`
+	TenantId   Id              `json:"tenant_id"`
+	Payload    json.RawMessage `json:"payload"`
+	RequestId  string          `json:"request_id,omitempty"`
+	OccurredAt time.Time       `json:"occurred_at"`
+}
+
+// UserEventPayload - данные события пользователя
+// Персональные данные и пароль в события не попадают, получатель запрашивает пользователя по идентификатору
+type UserEventPayload struct {
+	Id        Id         `json:"id"`
+	Version   uint64     `json:"version"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// Changed - измененные поля для user.updated
+	Changed []string `json:"changed,omitempty"`
+
+	// Purged - пользователь удален безвозвратно
+	Purged bool `json:"purged,omitempty"`
+
+	// Erased - персональные данные пользователя обезличены
+	Erased bool `json:"erased,omitempty"`
+}
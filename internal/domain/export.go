@@ -0,0 +1,13 @@
+package domain
+
+const (
+	EXPORT_CSV    = "csv"
+	EXPORT_NDJSON = "ndjson"
+
+	// EXPORT_COLUMNS - JSON объект, в котором каждому полю соответствует массив значений
+	EXPORT_COLUMNS = "columns"
+)
+
+// ExportFields - поля выгрузки пользователей в порядке по умолчанию
+// Пароль и его хэш не выгружаются
+var ExportFields = []string{"id", "email", "name", "surname", "birthday", "version", "created_at", "deleted_at"}
@@ -0,0 +1,45 @@
+package domain
+
+import "time"
+
+const (
+	AUDIT_CONSENT = "consent"
+	AUDIT_ERASE   = "erase"
+
+	// ERASED заменяет персональные данные в журнале после удаления по запросу пользователя
+	ERASED = "[ERASED]"
+)
+
+// PersonalFields - поля журнала с персональными данными, которые обезличиваются при удалении
+var PersonalFields = []string{"name", "surname", "birthday", "email"}
+
+// Consent - согласие пользователя на обработку данных с определенной целью
+type Consent struct {
+	Purpose   string    `json:"purpose"`
+	Granted   bool      `json:"granted"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Erasure - запись об удалении персональных данных пользователя
+// Сама запись персональных данных не содержит
+type Erasure struct {
+	UserId       Id        `json:"user_id"`
+	ActorId      *Id       `json:"actor_id"`
+	RequestId    string    `json:"request_id"`
+	AuditEntries int       `json:"audit_entries"`
+	Sessions     int       `json:"sessions"`
+	Consents     int       `json:"consents"`
+	ErasedAt     time.Time `json:"erased_at"`
+}
+
+// GdprArchive - все данные, которые хранятся о пользователе
+type GdprArchive struct {
+	ExportedAt time.Time    `json:"exported_at"`
+	Profile    User         `json:"profile"`
+	Audit      []AuditEntry `json:"audit"`
+	Sessions   []Session    `json:"sessions"`
+	Consents   []Consent    `json:"consents"`
+
+	// Erasure - запись об удалении, если данные пользователя уже обезличены
+	Erasure *Erasure `json:"erasure,omitempty"`
+}
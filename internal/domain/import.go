@@ -0,0 +1,48 @@
+package domain
+
+import "time"
+
+const (
+	IMPORT_CSV    = "csv"
+	IMPORT_NDJSON = "ndjson"
+
+	IMPORT_RUNNING = "running"
+	IMPORT_DONE    = "done"
+	IMPORT_FAILED  = "failed"
+)
+
+// ImportRow - пользователь из строки файла импорта
+// Line - номер строки файла, начиная с 1
+type ImportRow struct {
+	Line int
+	User User
+}
+
+// ImportError - строка файла, которая не была загружена
+type ImportError struct {
+	Line  int    `json:"line"`
+	Email string `json:"email"`
+	Error string `json:"error"`
+}
+
+// ImportJob - задача импорта пользователей
+// Пользователи сопоставляются по логину: новые создаются, существующие обновляются
+// Пароль существующего пользователя перезаписывается только при OverwritePassword,
+// иначе такие строки считаются в Updated и PasswordsKept
+type ImportJob struct {
+	Id                Id            `json:"id"`
+	Format            string        `json:"format"`
+	DryRun            bool          `json:"dry_run"`
+	OverwritePassword bool          `json:"overwrite_password"`
+	Status            string        `json:"status"`
+	Total             int           `json:"total"`
+	Created           int           `json:"created"`
+	Updated           int           `json:"updated"`
+	PasswordsKept     int           `json:"passwords_kept"`
+	Failed            int           `json:"failed"`
+	Error             string        `json:"error,omitempty"`
+	CreatedBy         *Id           `json:"created_by"`
+	CreatedAt         time.Time     `json:"created_at"`
+	FinishedAt        *time.Time    `json:"finished_at"`
+	Errors            []ImportError `json:"-"`
+}
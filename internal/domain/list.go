@@ -0,0 +1,36 @@
+package domain
+
+import "time"
+
+// UserFilter - параметры выборки списка пользователей
+type UserFilter struct {
+	// TenantId - организация пользователей, сервис берет ее из контекста
+	TenantId Id
+
+	// Login - точный email, ищется по слепому индексу без расшифровки
+	Login string
+	// LoginIndex - слепой индекс Login, сервис вычисляет его сам
+	LoginIndex string
+
+	// Фильтров по остальным персональным данным нет: они зашифрованы и не могут искаться по индексу
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	WithDeleted bool
+
+	// Sort - поле сортировки, префикс "-" задает обратный порядок
+	Sort string
+
+	// Cursor - курсор, полученный с предыдущей страницей
+	Cursor string
+	Limit  int
+
+	// Offset - количество пропускаемых записей для выдачи страниц по номеру, как startIndex в SCIM
+	// Вместе с курсором не используется
+	Offset int
+}
+
+// UserPage - страница списка пользователей
+type UserPage struct {
+	Users      []User `json:"users"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
@@ -0,0 +1,6 @@
+package domain
+
+const (
+	// AUDIT_LOCKOUT - блокировка учетной записи после неудачных входов и ее снятие
+	AUDIT_LOCKOUT = "lockout"
+)
@@ -0,0 +1,12 @@
+package domain
+
+const (
+	AUDIT_VERIFY = "verify"
+)
+
+// Mail - письмо пользователю
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
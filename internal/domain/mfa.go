@@ -0,0 +1,21 @@
+package domain
+
+const (
+	AUDIT_MFA = "mfa"
+)
+
+// TotpEnrollment - секрет TOTP, выдаваемый при подключении второго фактора
+type TotpEnrollment struct {
+	// Secret - секрет в base32 для ручного ввода в приложение
+	Secret string `json:"secret"`
+	// Uri - ссылка otpauth:// для QR-кода
+	Uri string `json:"otpauth_uri"`
+}
+
+// MfaChallenge - первый шаг входа пользователя с MFA
+// Пара токенов выдается после проверки кода по MfaToken
+type MfaChallenge struct {
+	MfaRequired bool   `json:"mfa_required"`
+	MfaToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
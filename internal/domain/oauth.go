@@ -0,0 +1,200 @@
+package domain
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PERM_OAUTH_CLIENTS_MANAGE = "oauth:clients:manage"
+
+	GRANT_AUTHORIZATION_CODE = "authorization_code"
+	GRANT_CLIENT_CREDENTIALS = "client_credentials"
+	GRANT_REFRESH_TOKEN      = "refresh_token"
+
+	// SCOPE_OPENID включает OpenID Connect: выдается ID токен и доступен /oauth/userinfo
+	SCOPE_OPENID  = "openid"
+	SCOPE_PROFILE = "profile"
+	SCOPE_EMAIL   = "email"
+
+	ALG_RS256 = "RS256"
+	ALG_ES256 = "ES256"
+
+	// PKCE_S256 - единственный поддерживаемый метод PKCE, plain не принимается
+	PKCE_S256 = "S256"
+)
+
+// UserScopes - области доступа к данным пользователя, токену client_credentials они не выдаются
+var UserScopes = []string{SCOPE_OPENID, SCOPE_PROFILE, SCOPE_EMAIL}
+
+// Коды ошибок OAuth2 (RFC 6749, раздел 5.2) и OpenID Connect
+const (
+	OAUTH_INVALID_REQUEST           = "invalid_request"
+	OAUTH_INVALID_CLIENT            = "invalid_client"
+	OAUTH_INVALID_GRANT             = "invalid_grant"
+	OAUTH_UNAUTHORIZED_CLIENT       = "unauthorized_client"
+	OAUTH_UNSUPPORTED_GRANT_TYPE    = "unsupported_grant_type"
+	OAUTH_UNSUPPORTED_RESPONSE_TYPE = "unsupported_response_type"
+	OAUTH_INVALID_SCOPE             = "invalid_scope"
+	OAUTH_ACCESS_DENIED             = "access_denied"
+	// OAUTH_INVALID_TOKEN - ошибка защищенного ресурса (RFC 6750, раздел 3.1)
+	OAUTH_INVALID_TOKEN = "invalid_token"
+)
+
+// OAuthError - ошибка протокола OAuth2, возвращается клиенту как есть
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// OAuthClient - приложение, которому пользователи организации разрешают вход через свою учетную запись
+type OAuthClient struct {
+	Id       Id     `json:"id"`
+	ClientId string `json:"client_id"`
+	// Secret - секрет конфиденциального клиента, возвращается только при создании и замене
+	Secret string `json:"client_secret,omitempty"`
+	// Public - клиент без секрета (SPA, мобильное приложение), вход только с PKCE
+	Public       bool     `json:"public"`
+	Name         string   `json:"name"`
+	RedirectUris []string `json:"redirect_uris"`
+	GrantTypes   []string `json:"grant_types"`
+	Scopes       []string `json:"scopes"`
+	// IdTokenAlg - алгоритм подписи ID токенов клиента, RS256 или ES256
+	IdTokenAlg string    `json:"id_token_signed_response_alg"`
+	OwnerId    *Id       `json:"owner_id"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	// TenantId - организация клиента, войти в клиент могут только ее пользователи
+	TenantId Id `json:"-"`
+}
+
+// Allows сообщает, что клиенту разрешен тип гранта
+func (c OAuthClient) Allows(grantType string) bool {
+	return slices.Contains(c.GrantTypes, grantType)
+}
+
+// OAuthAuthorizeRequest - параметры запроса авторизации
+type OAuthAuthorizeRequest struct {
+	ResponseType        string `form:"response_type" json:"response_type"`
+	ClientId            string `form:"client_id" json:"client_id"`
+	RedirectUri         string `form:"redirect_uri" json:"redirect_uri"`
+	Scope               string `form:"scope" json:"scope"`
+	State               string `form:"state" json:"state"`
+	Nonce               string `form:"nonce" json:"nonce"`
+	CodeChallenge       string `form:"code_challenge" json:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method" json:"code_challenge_method"`
+}
+
+// OAuthTokenRequest - параметры запроса к /oauth/token
+type OAuthTokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectUri  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	Scope        string `form:"scope"`
+	ClientId     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+}
+
+// OAuthTokens - ответ /oauth/token
+type OAuthTokens struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IdToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+// UserInfo - утверждения о пользователе по областям доступа токена
+type UserInfo struct {
+	Subject       string `json:"sub"`
+	Name          string `json:"name,omitempty"`
+	GivenName     string `json:"given_name,omitempty"`
+	FamilyName    string `json:"family_name,omitempty"`
+	Birthdate     string `json:"birthdate,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+}
+
+// UserInfoFrom возвращает утверждения о пользователе, доступные по областям scopes
+// sub есть всегда, profile добавляет имя и дату рождения, email - email и его подтверждение
+func UserInfoFrom(user User, emailVerified bool, scopes []string) UserInfo {
+	info := UserInfo{Subject: strconv.FormatUint(user.Id, 10)}
+
+	if slices.Contains(scopes, SCOPE_PROFILE) {
+		info.GivenName = user.FirstName
+		info.FamilyName = user.LastName
+		info.Name = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		if user.BirthDay != nil {
+			info.Birthdate = user.BirthDay.Format(time.DateOnly)
+		}
+	}
+
+	if slices.Contains(scopes, SCOPE_EMAIL) {
+		info.Email = user.Login
+		info.EmailVerified = &emailVerified
+	}
+
+	return info
+}
+
+// IdTokenClaims - утверждения ID токена, sub и утверждения о пользователе берутся из UserInfo
+type IdTokenClaims struct {
+	Issuer   string `json:"iss"`
+	Audience string `json:"aud"`
+	Expiry   int64  `json:"exp"`
+	IssuedAt int64  `json:"iat"`
+	Nonce    string `json:"nonce,omitempty"`
+	UserInfo
+}
+
+// Jwk - открытый ключ подписи в формате JWK (RFC 7517)
+type Jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// N и E - модуль и экспонента ключа RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Crv, X и Y - кривая и точка ключа EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// OpenIdConfiguration - документ /.well-known/openid-configuration
+type OpenIdConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksUri                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IdTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+}
+
+// ParseScope разбирает области доступа, разделенные пробелами
+func ParseScope(scope string) []string {
+	scopes := []string{}
+	for _, s := range strings.Fields(scope) {
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
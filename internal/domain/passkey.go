@@ -0,0 +1,122 @@
+package domain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+const (
+	AUDIT_PASSKEY = "passkey"
+)
+
+// Passkey - ключ WebAuthn пользователя
+type Passkey struct {
+	Id         Id         `json:"id"`
+	Name       string     `json:"name"`
+	SignCount  uint32     `json:"sign_count"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// Base64URL - двоичные данные WebAuthn, в JSON передаются в base64url без выравнивания
+type Base64URL []byte
+
+// MarshalJSON кодирует данные в base64url
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// UnmarshalJSON декодирует base64url, выравнивание допускается
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(trimPadding(s))
+	if err != nil {
+		return err
+	}
+
+	*b = decoded
+	return nil
+}
+
+// trimPadding удаляет выравнивание base64
+func trimPadding(s string) string {
+	for len(s) > 0 && s[len(s)-1] == '=' {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// PasskeyRelyingParty - сервис, для которого создается ключ
+type PasskeyRelyingParty struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// PasskeyUser - пользователь, для которого создается ключ
+type PasskeyUser struct {
+	Id          Base64URL `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+}
+
+// PasskeyCredentialParam - допустимый алгоритм ключа
+type PasskeyCredentialParam struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+// PasskeyDescriptor - идентификатор существующего ключа
+type PasskeyDescriptor struct {
+	Type string    `json:"type"`
+	Id   Base64URL `json:"id"`
+}
+
+// PasskeyCreationOptions - параметры navigator.credentials.create()
+type PasskeyCreationOptions struct {
+	Challenge              Base64URL                `json:"challenge"`
+	RelyingParty           PasskeyRelyingParty      `json:"rp"`
+	User                   PasskeyUser              `json:"user"`
+	PubKeyCredParams       []PasskeyCredentialParam `json:"pubKeyCredParams"`
+	Timeout                int64                    `json:"timeout"`
+	ExcludeCredentials     []PasskeyDescriptor      `json:"excludeCredentials"`
+	AuthenticatorSelection map[string]string        `json:"authenticatorSelection"`
+	Attestation            string                   `json:"attestation"`
+}
+
+// PasskeyRequestOptions - параметры navigator.credentials.get()
+type PasskeyRequestOptions struct {
+	Challenge        Base64URL `json:"challenge"`
+	RelyingPartyId   string    `json:"rpId"`
+	Timeout          int64     `json:"timeout"`
+	UserVerification string    `json:"userVerification"`
+}
+
+// PasskeyAttestation - результат navigator.credentials.create()
+type PasskeyAttestation struct {
+	Id       string    `json:"id"`
+	RawId    Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AttestationObject Base64URL `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// PasskeyAssertion - результат navigator.credentials.get()
+type PasskeyAssertion struct {
+	Id       string    `json:"id"`
+	RawId    Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AuthenticatorData Base64URL `json:"authenticatorData"`
+		Signature         Base64URL `json:"signature"`
+		UserHandle        Base64URL `json:"userHandle"`
+	} `json:"response"`
+}
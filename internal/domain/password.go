@@ -0,0 +1,5 @@
+package domain
+
+const (
+	AUDIT_PASSWORD_RESET = "password_reset"
+)
@@ -0,0 +1,48 @@
+package domain
+
+import (
+	"slices"
+	"time"
+)
+
+const (
+	ROLE_ADMIN   = "admin"
+	ROLE_SUPPORT = "support"
+
+	// ROLE_SELF есть у каждого пользователя, она не назначается и не отзывается
+	ROLE_SELF = "self"
+
+	PERM_USERS_READ_SELF   = "users:read:self"
+	PERM_USERS_READ_ANY    = "users:read:any"
+	PERM_USERS_WRITE_SELF  = "users:write:self"
+	PERM_USERS_WRITE_ANY   = "users:write:any"
+	PERM_USERS_DELETE_SELF = "users:delete:self"
+	PERM_USERS_DELETE_ANY  = "users:delete:any"
+
+	// PERM_USERS_MANAGE - восстановление, безвозвратное удаление, обезличивание, импорт и выгрузка пользователей
+	PERM_USERS_MANAGE = "users:manage"
+
+	PERM_WEBHOOKS_MANAGE = "webhooks:manage"
+	PERM_ROLES_MANAGE    = "roles:manage"
+
+	AUDIT_ROLE = "role"
+)
+
+// Role - роль с набором прав
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// UserRole - роль, назначенная пользователю
+type UserRole struct {
+	Role      string    `json:"role"`
+	GrantedBy *Id       `json:"granted_by"`
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+// Can сообщает, что у сессии есть право permission
+func (s *Session) Can(permission string) bool {
+	return slices.Contains(s.Permissions, permission)
+}
@@ -0,0 +1,73 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// Session описывает сессию пользователя, открытую при входе
+type Session struct {
+	Id               Id         `json:"id"`
+	UserId           Id         `json:"user_id"`
+	AccessExpiresAt  time.Time  `json:"access_expires_at"`
+	RefreshExpiresAt time.Time  `json:"refresh_expires_at"`
+	CreatedAt        time.Time  `json:"created_at"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+
+	// Device - браузер и система клиента, определенные по User-Agent
+	Device     string    `json:"device"`
+	Ip         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// Current - сессия, которой выполнен запрос
+	Current bool `json:"current,omitempty"`
+
+	// TenantId - организация пользователя
+	TenantId Id `json:"-"`
+	// Tenants - другие организации, в которых пользователь состоит
+	Tenants []Id `json:"-"`
+
+	// Roles - назначенные пользователю роли, роль self подразумевается
+	Roles []string `json:"-"`
+	// Permissions - права всех ролей пользователя
+	Permissions []string `json:"-"`
+}
+
+// Tokens - пара токенов, выдаваемая при входе и обновлении сессии
+type Tokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// Credentials - данные для входа пользователя
+type Credentials struct {
+	Login    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// SessionActivity - последний запрос сессии, горячая копия в Redis
+type SessionActivity struct {
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Ip         string    `json:"ip"`
+}
+
+// Client - клиент, выполняющий запрос
+type Client struct {
+	Ip        string
+	UserAgent string
+}
+
+type clientKey struct{}
+
+// WithClient сохраняет клиента запроса в контексте
+func WithClient(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// ClientFrom возвращает клиента запроса из контекста
+func ClientFrom(ctx context.Context) Client {
+	client, _ := ctx.Value(clientKey{}).(Client)
+	return client
+}
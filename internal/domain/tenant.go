@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+const (
+	// DEFAULT_TENANT - организация, в которой работают запросы без явной организации
+	// и в которую перенесены пользователи, созданные до появления организаций
+	DEFAULT_TENANT Id = 1
+
+	ROLE_OPERATOR = "operator"
+
+	PERM_ORGANIZATIONS_MANAGE = "organizations:manage"
+
+	// POLICY_SIGNUP и POLICY_LOGIN - анонимные действия, которые организация может разрешить в X-Tenant-Id:
+	// регистрация и вход с восстановлением доступа
+	POLICY_SIGNUP = "signup"
+	POLICY_LOGIN  = "login"
+)
+
+// Organization - организация (арендатор), пользователи которой изолированы от других организаций
+type Organization struct {
+	Id        Id        `json:"id"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// AllowSignup и AllowLogin - политика организации для анонимных запросов,
+	// без разрешения анонимный запрос не может выбрать организацию
+	AllowSignup bool `json:"allow_signup"`
+	AllowLogin  bool `json:"allow_login"`
+}
+
+// Allows сообщает, что политика организации разрешает анонимное действие policy
+func (o *Organization) Allows(policy string) bool {
+	switch policy {
+	case POLICY_SIGNUP:
+		return o.AllowSignup
+	case POLICY_LOGIN:
+		return o.AllowLogin
+	default:
+		return false
+	}
+}
+
+// Membership - доступ пользователя к другой организации
+type Membership struct {
+	OrganizationId Id        `json:"organization_id"`
+	UserId         Id        `json:"user_id"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type tenantKey struct{}
+
+// WithTenant сохраняет организацию запроса в контексте
+func WithTenant(ctx context.Context, tenant Id) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom возвращает организацию запроса из контекста
+// Без организации в контексте возвращается DEFAULT_TENANT
+func TenantFrom(ctx context.Context) Id {
+	tenant, ok := ctx.Value(tenantKey{}).(Id)
+	if !ok {
+		return DEFAULT_TENANT
+	}
+
+	return tenant
+}
+
+// Member сообщает, что сессия может работать в организации tenant
+func (s *Session) Member(tenant Id) bool {
+	return s.TenantId == tenant || slices.Contains(s.Tenants, tenant)
+}
@@ -9,6 +9,13 @@ type User struct {
 	BirthDay  *time.Time `json:"birthday"`
 	Login     string     `json:"email"`
 	Password  string     `json:"password"`
+	Version   uint64     `json:"version"`
+	CreatedAt time.Time  `json:"created_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// PlainPassword - пароль из запроса до хэширования, не сохраняется
+	// Хэш с новой солью всегда отличается от сохраненного, поэтому тот же пароль узнается только по нему
+	PlainPassword string `json:"-"`
 }
 
 func NewUser(name, surname, login, pass string, birth *time.Time) *User {
@@ -20,3 +27,26 @@ func NewUser(name, surname, login, pass string, birth *time.Time) *User {
 		Password:  pass,
 	}
 }
+
+// UserPatch - изменяемые поля пользователя, nil означает, что поле не меняется
+type UserPatch struct {
+	FirstName *string
+	LastName  *string
+	BirthDay  *time.Time
+	Login     *string
+	Password  *string
+
+	// PlainPassword - пароль из запроса до хэширования, по нему узнается тот же пароль, как в User
+	PlainPassword string
+
+	// ClearBirthDay удаляет дату рождения
+	ClearBirthDay bool
+
+	// Version - ожидаемая версия пользователя, 0 - без проверки
+	Version uint64
+}
+
+// Empty сообщает, что патч ничего не меняет
+func (p UserPatch) Empty() bool {
+	return p.FirstName == nil && p.LastName == nil && p.BirthDay == nil && p.Login == nil && p.Password == nil && !p.ClearBirthDay
+}
@@ -0,0 +1,67 @@
+package domain
+
+import "time"
+
+const (
+	DELIVERY_PENDING   = "pending"
+	DELIVERY_SUCCEEDED = "succeeded"
+	DELIVERY_DEAD      = "dead"
+)
+
+// Webhook - подписка внешнего получателя на события пользователей
+type Webhook struct {
+	Id  Id     `json:"id"`
+	Url string `json:"url"`
+	// Events - типы событий, пустой список означает подписку на все события
+	Events []string `json:"events"`
+	// Secret - ключ подписи HMAC, возвращается только при создании
+	Secret    string    `json:"secret,omitempty"`
+	OwnerId   *Id       `json:"owner_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Matches сообщает, что подписка получает события данного типа
+func (w Webhook) Matches(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WebhookDelivery - доставка одного события одной подписке
+type WebhookDelivery struct {
+	Id            Id               `json:"id"`
+	WebhookId     Id               `json:"webhook_id"`
+	EventId       Id               `json:"event_id"`
+	EventType     string           `json:"event_type"`
+	Status        string           `json:"status"`
+	Attempts      int              `json:"attempts"`
+	NextAttemptAt *time.Time       `json:"next_attempt_at,omitempty"`
+	LastError     *string          `json:"last_error,omitempty"`
+	CreatedAt     time.Time        `json:"created_at"`
+	DeliveredAt   *time.Time       `json:"delivered_at,omitempty"`
+	History       []WebhookAttempt `json:"history"`
+}
+
+// WebhookAttempt - одна попытка доставки
+type WebhookAttempt struct {
+	Id Id `json:"id"`
+	// StatusCode - код ответа получателя, nil при сетевой ошибке
+	StatusCode  *int      `json:"status_code"`
+	Error       *string   `json:"error,omitempty"`
+	DurationMs  int64     `json:"duration_ms"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+// DeliveryPage - страница доставок подписки
+type DeliveryPage struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
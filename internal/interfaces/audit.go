@@ -0,0 +1,12 @@
+package interfaces
+
+import (
+	"context"
+	"user/internal/domain"
+)
+
+// AuditRepo представляет интерфейс для чтения журнала изменений пользователей
+type AuditRepo interface {
+	// History возвращает страницу журнала пользователя, начиная с новых записей
+	History(ctx context.Context, userId domain.Id, cursor string, limit int) (*domain.AuditPage, error)
+}
@@ -0,0 +1,26 @@
+package interfaces
+
+import (
+	"context"
+	"user/internal/domain"
+)
+
+// AuthRepo представляет интерфейс для работы с сессиями пользователей
+type AuthRepo interface {
+	// Login проверяет учетные данные пользователя организации tenant и открывает новую сессию
+	// Для пользователя с MFA вместо токенов возвращается первый шаг входа
+	// Клиент сессии берется из контекста
+	Login(ctx context.Context, tenant domain.Id, login, password string) (*domain.Tokens, *domain.MfaChallenge, error)
+
+	// LoginMfa проверяет код второго фактора по токену первого шага и открывает новую сессию
+	LoginMfa(ctx context.Context, mfaToken, code string) (*domain.Tokens, error)
+
+	// Refresh выдает новую пару токенов по refresh токену
+	Refresh(refreshToken string) (*domain.Tokens, error)
+
+	// Logout отзывает сессию, которой принадлежит access токен
+	Logout(accessToken string) error
+
+	// Authenticate возвращает активную сессию по access токену и отмечает ее активность
+	Authenticate(ctx context.Context, accessToken string) (*domain.Session, error)
+}
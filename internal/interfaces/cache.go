@@ -1,18 +1,59 @@
 package interfaces
 
-import "user/internal/domain"
+import (
+	"time"
+	"user/internal/domain"
+)
 
 // CacheRepo представляет интерфейс для работы с кэшом
+// Ключи каждой организации хранятся в своем пространстве имен
 type CacheRepo interface {
 	// CreateKey создает ключ в кэше
-	CreateKey(domain.Id, domain.User) error
+	CreateKey(tenant, id domain.Id, user domain.User) error
 
-	// GetText получает пользователя из кэша по идентификатору
-	GetByKey(domain.Id) (*domain.User, error)
+	// GetByKey получает пользователя из кэша по идентификатору
+	GetByKey(tenant, id domain.Id) (*domain.User, error)
+
+	// CreateKeys создает ключи для нескольких пользователей за один запрос
+	CreateKeys(tenant domain.Id, users []domain.User) error
+
+	// GetByKeys получает пользователей по идентификаторам за один запрос
+	// Отсутствующих в кэше пользователей нет в результате
+	GetByKeys(tenant domain.Id, ids []domain.Id) (map[domain.Id]*domain.User, error)
 
 	// DelKey удаляет ключ из кэша
-	DelKey(domain.Id) error
+	DelKey(tenant, id domain.Id) error
 
 	// Close закрывает подключение
 	Close() error
 }
+
+// CounterRepo представляет интерфейс для счетчиков и отметок с ограниченным временем жизни
+type CounterRepo interface {
+	// Incr увеличивает счетчик и возвращает новое значение
+	// Время жизни ttl отсчитывается от первого увеличения
+	Incr(key string, ttl time.Duration) (int64, error)
+
+	// Decr уменьшает существующий счетчик, отсутствующий счетчик не создается
+	Decr(key string) error
+
+	// Mark создает отметку, которая исчезнет через ttl
+	Mark(key string, ttl time.Duration) error
+
+	// TTL возвращает наибольшее оставшееся время жизни из ключей, 0 - ни одного ключа нет
+	TTL(keys ...string) (time.Duration, error)
+
+	// Del удаляет ключи и возвращает количество удаленных
+	Del(keys ...string) (int64, error)
+}
+
+// SessionCacheRepo представляет интерфейс горячей копии активности сессий
+// Активность меняется при каждом запросе, поэтому в базу она переносится реже
+type SessionCacheRepo interface {
+	// Touch записывает последний запрос сессии, запись исчезнет через ttl
+	Touch(id domain.Id, activity domain.SessionActivity, ttl time.Duration) error
+
+	// Activity возвращает последние запросы сессий за один запрос
+	// Сессий без записи в кэше нет в результате
+	Activity(ids []domain.Id) (map[domain.Id]domain.SessionActivity, error)
+}
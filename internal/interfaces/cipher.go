@@ -0,0 +1,19 @@
+package interfaces
+
+// FieldCipher представляет интерфейс для шифрования персональных данных пользователя
+type FieldCipher interface {
+	// Encrypt шифрует значение активным ключом, результат содержит идентификатор ключа
+	Encrypt(plain string) (string, error)
+
+	// Decrypt расшифровывает значение ключом, идентификатор которого в нем записан
+	Decrypt(value string) (string, error)
+
+	// Rewrap перешифровывает ключ данных значения активным ключом, сами данные не меняются
+	Rewrap(value string) (string, error)
+
+	// BlindIndex возвращает HMAC значения для поиска и проверки уникальности без расшифровки
+	BlindIndex(value string) string
+
+	// ActiveKey возвращает идентификатор активного ключа
+	ActiveKey() string
+}
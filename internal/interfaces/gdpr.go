@@ -0,0 +1,16 @@
+package interfaces
+
+import (
+	"context"
+	"user/internal/domain"
+)
+
+// GdprRepo представляет интерфейс для выгрузки и удаления персональных данных пользователя
+type GdprRepo interface {
+	// Archive возвращает все данные пользователя, nil если пользователя нет
+	Archive(ctx context.Context, id domain.Id) (*domain.GdprArchive, error)
+	// Erase обезличивает пользователя и возвращает запись об удалении, nil если пользователя нет
+	Erase(ctx context.Context, id domain.Id) (*domain.Erasure, error)
+	// SetConsent сохраняет согласие пользователя, false если пользователя нет
+	SetConsent(ctx context.Context, id domain.Id, purpose string, granted bool) (bool, error)
+}
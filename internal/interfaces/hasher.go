@@ -0,0 +1,13 @@
+package interfaces
+
+// PasswordHasher представляет интерфейс для хэширования паролей
+type PasswordHasher interface {
+	// Hash возвращает хэш пароля в самоописывающем формате
+	Hash(pass string) (string, error)
+
+	// Verify проверяет, что пароль соответствует хэшу
+	Verify(pass, hash string) (bool, error)
+
+	// NeedsRehash сообщает, что хэш нужно пересчитать текущими параметрами
+	NeedsRehash(hash string) bool
+}
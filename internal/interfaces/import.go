@@ -0,0 +1,18 @@
+package interfaces
+
+import (
+	"context"
+	"user/internal/domain"
+)
+
+// ImportRepo представляет интерфейс импорта пользователей из файлов
+type ImportRepo interface {
+	// Create сохраняет задачу импорта в статусе running
+	Create(ctx context.Context, job domain.ImportJob) (*domain.Id, error)
+	// Get возвращает задачу вместе с отчетом, nil если она не найдена
+	Get(ctx context.Context, id domain.Id) (*domain.ImportJob, error)
+	// Run загружает проверенные строки и сохраняет итог задачи
+	// job - созданная задача с параметрами DryRun и OverwritePassword
+	// rejected - строки, не прошедшие проверку, попадают в отчет
+	Run(ctx context.Context, job domain.ImportJob, rows []domain.ImportRow, rejected []domain.ImportError) (*domain.ImportJob, error)
+}
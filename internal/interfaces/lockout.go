@@ -0,0 +1,28 @@
+package interfaces
+
+import (
+	"context"
+	"time"
+	"user/internal/domain"
+)
+
+// LockoutRepo представляет интерфейс защиты входа от перебора паролей
+// Организация учетной записи берется из контекста
+type LockoutRepo interface {
+	// Attempt учитывает попытку входа до проверки пароля и возвращает, сколько нужно подождать, 0 - вход разрешен
+	// Попытка считается неудачной, пока вход не подтвержден вызовом Succeed
+	// При превышении лимита блокирует учетную запись или адрес
+	Attempt(ctx context.Context, login, ip string) (time.Duration, error)
+
+	// AttemptMfa учитывает попытку ввода кода второго фактора для учетной записи токена первого шага
+	AttemptMfa(ctx context.Context, mfaToken, ip string) (time.Duration, error)
+
+	// Succeed сбрасывает счетчик неудачных попыток учетной записи и не учитывает попытку адреса после выдачи сессии
+	Succeed(ctx context.Context, login, ip string) error
+
+	// SucceedMfa - Succeed для учетной записи токена первого шага
+	SucceedMfa(ctx context.Context, mfaToken, ip string) error
+
+	// Unlock снимает блокировку с учетной записи пользователя
+	Unlock(ctx context.Context, id domain.Id) (bool, error)
+}
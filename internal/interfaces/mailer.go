@@ -0,0 +1,31 @@
+package interfaces
+
+import (
+	"context"
+	"user/internal/domain"
+)
+
+// Mailer представляет интерфейс отправки писем пользователям
+type Mailer interface {
+	// Send отправляет письмо, ошибка означает, что письмо не принято к доставке
+	Send(ctx context.Context, mail domain.Mail) error
+}
+
+// VerificationRepo представляет интерфейс подтверждения email
+type VerificationRepo interface {
+	// Send выдает токен подтверждения и отправляет его на email пользователя
+	Send(ctx context.Context, id domain.Id, login string) error
+	// Resend повторно отправляет токен, если в организации есть пользователь с неподтвержденным email
+	Resend(ctx context.Context, login string) error
+	// Verify подтверждает email по токену, ErrInvalidToken если токен неверный, просрочен или использован
+	Verify(ctx context.Context, token string) error
+}
+
+// PasswordResetRepo представляет интерфейс сброса пароля
+type PasswordResetRepo interface {
+	// Forgot выдает токен сброса и отправляет его, если в организации есть активный пользователь с таким email
+	Forgot(ctx context.Context, login string) error
+	// Reset устанавливает новый хэш пароля по токену и отзывает все сессии пользователя,
+	// ErrInvalidToken если токен неверный, просрочен или использован
+	Reset(ctx context.Context, token, password string) error
+}
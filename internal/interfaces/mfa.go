@@ -0,0 +1,18 @@
+package interfaces
+
+import (
+	"context"
+	"user/internal/domain"
+)
+
+// MfaRepo представляет интерфейс управления вторым фактором пользователей
+type MfaRepo interface {
+	// Enroll выдает новый секрет TOTP, MFA включается после подтверждения кодом
+	Enroll(ctx context.Context, id domain.Id) (*domain.TotpEnrollment, error)
+
+	// Confirm включает MFA по коду из приложения и возвращает коды восстановления
+	Confirm(ctx context.Context, id domain.Id, code string) ([]string, error)
+
+	// Disable отключает MFA, пустой code отключает без проверки кода
+	Disable(ctx context.Context, id domain.Id, code string) (bool, error)
+}
@@ -0,0 +1,44 @@
+package interfaces
+
+import (
+	"context"
+	"user/internal/domain"
+)
+
+// OAuthClientRepo представляет интерфейс регистрации клиентов OAuth2 организации
+type OAuthClientRepo interface {
+	// Create регистрирует клиента и возвращает его вместе с секретом
+	Create(ctx context.Context, client domain.OAuthClient) (*domain.OAuthClient, error)
+	// Get возвращает клиента без секрета, nil если он не найден
+	Get(ctx context.Context, id domain.Id) (*domain.OAuthClient, error)
+	// List возвращает всех клиентов без секретов
+	List(ctx context.Context) ([]domain.OAuthClient, error)
+	// Update меняет название, адреса возврата, гранты и области доступа клиента
+	Update(ctx context.Context, client domain.OAuthClient) (bool, error)
+	// Delete удаляет клиента вместе с выданными ему токенами
+	Delete(ctx context.Context, id domain.Id) (bool, error)
+	// RotateSecret выдает конфиденциальному клиенту новый секрет, прежний перестает действовать
+	RotateSecret(ctx context.Context, id domain.Id) (*domain.OAuthClient, error)
+}
+
+// OAuthRepo представляет интерфейс провайдера OAuth2 / OpenID Connect
+// Ошибки протокола возвращаются как *domain.OAuthError
+type OAuthRepo interface {
+	// Client возвращает клиента по публичному идентификатору, nil если он не найден
+	Client(ctx context.Context, clientId string) (*domain.OAuthClient, error)
+
+	// Authorize выдает код авторизации пользователю из контекста
+	Authorize(ctx context.Context, req domain.OAuthAuthorizeRequest) (string, error)
+
+	// Token выдает токены по коду авторизации, refresh токену или учетным данным клиента
+	Token(ctx context.Context, req domain.OAuthTokenRequest) (*domain.OAuthTokens, error)
+
+	// UserInfo возвращает утверждения о пользователе по access токену, nil если токен недействителен
+	UserInfo(ctx context.Context, accessToken string) (*domain.UserInfo, error)
+
+	// Keys возвращает опубликованные ключи подписи ID токенов
+	Keys(ctx context.Context) ([]domain.Jwk, error)
+
+	// Configuration возвращает документ discovery
+	Configuration() domain.OpenIdConfiguration
+}
@@ -0,0 +1,33 @@
+package interfaces
+
+import (
+	"context"
+	"user/internal/domain"
+)
+
+// OrganizationRepo представляет интерфейс для управления организациями и членством в них
+type OrganizationRepo interface {
+	// Create создает организацию, ErrSlugExists если короткое имя занято
+	Create(ctx context.Context, org domain.Organization) (*domain.Organization, error)
+	// List возвращает все организации
+	List(ctx context.Context) ([]domain.Organization, error)
+	// Exists сообщает, что организация существует
+	Exists(ctx context.Context, id domain.Id) (bool, error)
+	// Get возвращает организацию, nil если ее нет
+	Get(ctx context.Context, id domain.Id) (*domain.Organization, error)
+	// SetPolicy задает политику организации для анонимных запросов, nil если организации нет
+	SetPolicy(ctx context.Context, id domain.Id, allowSignup, allowLogin bool) (*domain.Organization, error)
+	// Members возвращает участников организации, nil если организации нет
+	Members(ctx context.Context, id domain.Id) ([]domain.Membership, error)
+	// AddMember дает пользователю доступ к организации, false если организации или пользователя нет
+	AddMember(ctx context.Context, id, userId domain.Id) (bool, error)
+	// RemoveMember забирает у пользователя доступ к организации, false если он не участник
+	RemoveMember(ctx context.Context, id, userId domain.Id) (bool, error)
+	// RotateScimToken выдает организации новый токен SCIM, прежний перестает действовать
+	// Токен возвращается один раз, пустая строка если организации нет
+	RotateScimToken(ctx context.Context, id domain.Id) (string, error)
+	// RevokeScimToken отключает SCIM организации, false если организации нет
+	RevokeScimToken(ctx context.Context, id domain.Id) (bool, error)
+	// ScimTenant возвращает организацию, которой выдан токен SCIM, nil если токен неверный
+	ScimTenant(ctx context.Context, token string) (*domain.Id, error)
+}
@@ -0,0 +1,27 @@
+package interfaces
+
+import (
+	"context"
+	"user/internal/domain"
+)
+
+// PasskeyRepo представляет интерфейс регистрации ключей WebAuthn и входа по ним
+type PasskeyRepo interface {
+	// BeginRegistration начинает регистрацию ключа пользователя
+	BeginRegistration(ctx context.Context, id domain.Id) (*domain.PasskeyCreationOptions, error)
+
+	// FinishRegistration проверяет ответ аутентификатора и сохраняет ключ
+	FinishRegistration(ctx context.Context, id domain.Id, name string, attestation domain.PasskeyAttestation) (*domain.Passkey, error)
+
+	// List возвращает ключи пользователя
+	List(ctx context.Context, id domain.Id) ([]domain.Passkey, error)
+
+	// Revoke удаляет ключ пользователя
+	Revoke(ctx context.Context, id, passkey domain.Id) (bool, error)
+
+	// BeginLogin начинает вход по ключу
+	BeginLogin(ctx context.Context) (*domain.PasskeyRequestOptions, error)
+
+	// FinishLogin проверяет подпись аутентификатора и открывает новую сессию
+	FinishLogin(ctx context.Context, assertion domain.PasskeyAssertion) (*domain.Tokens, error)
+}
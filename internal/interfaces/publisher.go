@@ -0,0 +1,14 @@
+package interfaces
+
+import (
+	"context"
+	"user/internal/domain"
+)
+
+// EventPublisher представляет интерфейс доставки доменных событий
+// Доставка идет по принципу at-least-once, поэтому получатели должны
+// быть готовы к повторам и различать события по идентификатору
+type EventPublisher interface {
+	// Publish доставляет событие, ошибка приводит к повторной попытке
+	Publish(context.Context, domain.Event) error
+}
@@ -0,0 +1,18 @@
+package interfaces
+
+import (
+	"context"
+	"user/internal/domain"
+)
+
+// RoleRepo представляет интерфейс для управления ролями пользователей
+type RoleRepo interface {
+	// Roles возвращает все роли с их правами
+	Roles(ctx context.Context) ([]domain.Role, error)
+	// UserRoles возвращает роли пользователя, nil если пользователя нет
+	UserRoles(ctx context.Context, id domain.Id) ([]domain.UserRole, error)
+	// Assign назначает роль пользователю, false если пользователя нет
+	Assign(ctx context.Context, id domain.Id, role string) (bool, error)
+	// Revoke отзывает роль у пользователя, false если пользователя нет
+	Revoke(ctx context.Context, id domain.Id, role string) (bool, error)
+}
@@ -0,0 +1,18 @@
+package interfaces
+
+import (
+	"context"
+	"user/internal/domain"
+)
+
+// SessionRepo представляет интерфейс управления активными сессиями пользователей
+type SessionRepo interface {
+	// List возвращает активные сессии пользователя, последние активные первыми
+	List(ctx context.Context, id domain.Id) ([]domain.Session, error)
+
+	// Revoke отзывает сессию пользователя
+	Revoke(ctx context.Context, id, session domain.Id) (bool, error)
+
+	// RevokeAll отзывает все сессии пользователя и возвращает их количество
+	RevokeAll(ctx context.Context, id domain.Id) (int64, error)
+}
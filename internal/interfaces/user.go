@@ -1,9 +1,57 @@
 package interfaces
 
-import "user/internal/domain"
+import (
+	"context"
+	"user/internal/domain"
+)
 
+// UserRepo представляет интерфейс для работы с пользователями
+// Изменения записываются в журнал от имени инициатора из контекста (domain.WithActor)
 type UserRepo interface {
-	Create(domain.User) (*domain.Id, error)
-	Get(domain.Id) (*domain.User, error)
-	Update(domain.User) error
+	Create(context.Context, domain.User) (*domain.Id, error)
+
+	// CreateDeleted создает пользователя сразу мягко удаленным в одной транзакции
+	CreateDeleted(context.Context, domain.User) (*domain.Id, error)
+	Get(ctx context.Context, id domain.Id, withDeleted bool) (*domain.User, error)
+
+	// GetFresh возвращает активного пользователя из базы, минуя кэш
+	GetFresh(ctx context.Context, id domain.Id) (*domain.User, error)
+
+	// Update обновляет пользователя, при ненулевой Version проверяет ее совпадение
+	Update(context.Context, domain.User) error
+
+	// Patch обновляет только переданные поля, возвращает false, если активного пользователя нет
+	Patch(context.Context, domain.Id, domain.UserPatch) (bool, error)
+
+	// List возвращает страницу пользователей по фильтру
+	List(context.Context, domain.UserFilter) (*domain.UserPage, error)
+
+	// Count возвращает количество пользователей по фильтру без учета курсора, лимита и смещения
+	Count(context.Context, domain.UserFilter) (int, error)
+
+	// Delete мягко удаляет пользователя, возвращает false, если активного пользователя нет
+	// При ненулевой версии проверяет ее совпадение
+	Delete(ctx context.Context, id domain.Id, version uint64) (bool, error)
+
+	// Restore восстанавливает мягко удаленного пользователя
+	Restore(context.Context, domain.Id) (bool, error)
+
+	// SetState обновляет поля и восстанавливает или мягко удаляет пользователя по active в одной транзакции
+	// Возвращает false, если пользователя нет, ErrUserInactive при изменении неактивного пользователя
+	SetState(ctx context.Context, id domain.Id, patch domain.UserPatch, active bool) (bool, error)
+
+	// Purge безвозвратно удаляет пользователя
+	Purge(context.Context, domain.Id) (bool, error)
+
+	// BatchGet возвращает активных пользователей по идентификаторам в порядке запроса
+	// Повторы и ненайденные идентификаторы в результат не попадают
+	BatchGet(context.Context, []domain.Id) ([]domain.User, error)
+
+	// Bulk создает и обновляет пользователей пакетом, результаты идут в порядке операций
+	// При atomic первая ошибка операции отменяет весь пакет
+	Bulk(ctx context.Context, items []domain.BulkItem, atomic bool) ([]domain.BulkResult, error)
+
+	// Export передает в write всех пользователей по фильтру, не загружая выборку в память
+	// passes - количество проходов по одному снимку данных
+	Export(ctx context.Context, filter domain.UserFilter, passes int, write func(pass int, user domain.User) error) error
 }
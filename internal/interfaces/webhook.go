@@ -0,0 +1,22 @@
+package interfaces
+
+import (
+	"context"
+	"user/internal/domain"
+)
+
+// WebhookRepo представляет интерфейс управления подписками на события
+type WebhookRepo interface {
+	// Create создает подписку и возвращает ее вместе с ключом подписи
+	Create(ctx context.Context, webhook domain.Webhook) (*domain.Webhook, error)
+	// Get возвращает подписку без ключа подписи, nil если она не найдена
+	Get(ctx context.Context, id domain.Id) (*domain.Webhook, error)
+	// List возвращает все подписки без ключей подписи
+	List(ctx context.Context) ([]domain.Webhook, error)
+	// Delete удаляет подписку вместе с историей доставок
+	Delete(ctx context.Context, id domain.Id) (bool, error)
+	// Deliveries возвращает страницу доставок подписки с историей попыток, начиная с новых
+	Deliveries(ctx context.Context, webhookId domain.Id, cursor string, limit int) (*domain.DeliveryPage, error)
+	// Redeliver ставит доставку в очередь повторно
+	Redeliver(ctx context.Context, webhookId, deliveryId domain.Id) (bool, error)
+}
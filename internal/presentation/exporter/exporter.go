@@ -0,0 +1,346 @@
+// Package exporter пишет выгрузку пользователей в CSV, NDJSON и колоночный JSON
+// по мере чтения строк, не накапливая их в памяти
+package exporter
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"user/internal/domain"
+)
+
+// FLUSH_ROWS - через сколько строк буфер отправляется получателю
+const FLUSH_ROWS = 1000
+
+// flusher - получатель, который умеет отправлять накопленные данные, например http.ResponseWriter
+type flusher interface {
+	Flush()
+}
+
+// Writer пишет пользователей в выбранном формате
+// Для CSV и NDJSON нужен один проход по выборке, для колоночного JSON - по проходу на поле
+type Writer struct {
+	dst    io.Writer
+	out    *bufio.Writer
+	csv    *csv.Writer
+	format string
+	fields []string
+
+	started bool
+	opened  int
+	first   bool
+	rows    int
+}
+
+// ParseFields разбирает список полей через запятую, пустая строка означает все поля
+func ParseFields(str string) ([]string, error) {
+	if strings.TrimSpace(str) == "" {
+		return slices.Clone(domain.ExportFields), nil
+	}
+
+	fields := []string{}
+	for _, field := range strings.Split(str, ",") {
+		field = strings.TrimSpace(field)
+		if !slices.Contains(domain.ExportFields, field) {
+			return nil, fmt.Errorf("%w: %q", domain.ErrInvalidField, field)
+		}
+		if !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+
+	return fields, nil
+}
+
+// NewWriter создает Writer формата format с полями fields
+func NewWriter(dst io.Writer, format string, fields []string) (*Writer, error) {
+	switch format {
+	case domain.EXPORT_CSV, domain.EXPORT_NDJSON, domain.EXPORT_COLUMNS:
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+
+	if len(fields) == 0 {
+		return nil, domain.ErrInvalidField
+	}
+
+	w := &Writer{
+		dst:    dst,
+		out:    bufio.NewWriter(dst),
+		format: format,
+		fields: fields,
+	}
+	if format == domain.EXPORT_CSV {
+		w.csv = csv.NewWriter(w.out)
+	}
+
+	return w, nil
+}
+
+// ContentType возвращает тип содержимого формата
+func ContentType(format string) string {
+	switch format {
+	case domain.EXPORT_CSV:
+		return "text/csv; charset=utf-8"
+	case domain.EXPORT_NDJSON:
+		return "application/x-ndjson"
+	}
+
+	return "application/json"
+}
+
+// Passes возвращает количество проходов по выборке, которое нужно формату
+func (w *Writer) Passes() int {
+	if w.format == domain.EXPORT_COLUMNS {
+		return len(w.fields)
+	}
+
+	return 1
+}
+
+// Write пишет пользователя, pass - номер прохода по выборке
+func (w *Writer) Write(pass int, user domain.User) error {
+	err := w.start()
+	if err != nil {
+		return err
+	}
+
+	switch w.format {
+	case domain.EXPORT_CSV:
+		err = w.writeCSV(user)
+	case domain.EXPORT_NDJSON:
+		err = w.writeNDJSON(user)
+	case domain.EXPORT_COLUMNS:
+		err = w.writeColumn(pass, user)
+	}
+	if err != nil {
+		return err
+	}
+
+	w.rows++
+	if w.rows%FLUSH_ROWS == 0 {
+		return w.flush()
+	}
+
+	return nil
+}
+
+// Close дописывает окончание выгрузки и отправляет буфер
+// Для пустой выборки CSV содержит только заголовок, колоночный JSON - пустые массивы
+func (w *Writer) Close() error {
+	err := w.start()
+	if err != nil {
+		return err
+	}
+
+	if w.format == domain.EXPORT_COLUMNS {
+		for w.opened < len(w.fields) {
+			err = w.openColumn()
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = w.out.WriteString("]}\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.flush()
+}
+
+// start пишет заголовок CSV или начало объекта JSON
+func (w *Writer) start() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+
+	switch w.format {
+	case domain.EXPORT_CSV:
+		return w.csv.Write(w.fields)
+	case domain.EXPORT_COLUMNS:
+		_, err := w.out.WriteString("{")
+		return err
+	}
+
+	return nil
+}
+
+// writeCSV пишет строку CSV, пустые значения записываются пустой строкой
+func (w *Writer) writeCSV(user domain.User) error {
+	record := make([]string, len(w.fields))
+	for i, field := range w.fields {
+		record[i] = text(value(user, field))
+	}
+
+	return w.csv.Write(record)
+}
+
+// writeNDJSON пишет объект JSON в строку, поля идут в порядке выбора
+func (w *Writer) writeNDJSON(user domain.User) error {
+	err := w.out.WriteByte('{')
+	if err != nil {
+		return err
+	}
+
+	for i, field := range w.fields {
+		if i > 0 {
+			err = w.out.WriteByte(',')
+			if err != nil {
+				return err
+			}
+		}
+
+		err = w.writeJSON(field)
+		if err != nil {
+			return err
+		}
+
+		err = w.out.WriteByte(':')
+		if err != nil {
+			return err
+		}
+
+		err = w.writeJSON(value(user, field))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = w.out.WriteString("}\n")
+	return err
+}
+
+// writeColumn дописывает значение поля прохода pass в его массив
+func (w *Writer) writeColumn(pass int, user domain.User) error {
+	if pass >= len(w.fields) {
+		return fmt.Errorf("export pass %d is out of %d fields", pass, len(w.fields))
+	}
+
+	for w.opened <= pass {
+		err := w.openColumn()
+		if err != nil {
+			return err
+		}
+	}
+
+	if !w.first {
+		err := w.out.WriteByte(',')
+		if err != nil {
+			return err
+		}
+	}
+	w.first = false
+
+	return w.writeJSON(value(user, w.fields[pass]))
+}
+
+// openColumn закрывает массив предыдущего поля и открывает массив следующего
+func (w *Writer) openColumn() error {
+	if w.opened > 0 {
+		_, err := w.out.WriteString("],")
+		if err != nil {
+			return err
+		}
+	}
+
+	err := w.writeJSON(w.fields[w.opened])
+	if err != nil {
+		return err
+	}
+
+	_, err = w.out.WriteString(":[")
+	if err != nil {
+		return err
+	}
+
+	w.opened++
+	w.first = true
+	return nil
+}
+
+// writeJSON пишет значение в JSON
+func (w *Writer) writeJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.out.Write(data)
+	return err
+}
+
+// flush отправляет буферы получателю
+func (w *Writer) flush() error {
+	if w.csv != nil {
+		w.csv.Flush()
+		err := w.csv.Error()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := w.out.Flush()
+	if err != nil {
+		return err
+	}
+
+	if f, ok := w.dst.(flusher); ok {
+		f.Flush()
+	}
+
+	return nil
+}
+
+// value возвращает значение поля пользователя, nil означает отсутствие значения
+// Дата рождения выгружается в виде 2006-01-02, время - в RFC 3339
+func value(user domain.User, field string) any {
+	switch field {
+	case "id":
+		return user.Id
+	case "email":
+		return user.Login
+	case "name":
+		return user.FirstName
+	case "surname":
+		return user.LastName
+	case "birthday":
+		if user.BirthDay == nil {
+			return nil
+		}
+		return user.BirthDay.Format(time.DateOnly)
+	case "version":
+		return user.Version
+	case "created_at":
+		return user.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case "deleted_at":
+		if user.DeletedAt == nil {
+			return nil
+		}
+		return user.DeletedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	return nil
+}
+
+// text переводит значение поля в строку CSV
+func text(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	}
+
+	return fmt.Sprint(v)
+}
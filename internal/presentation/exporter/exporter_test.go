@@ -0,0 +1,81 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	birthday = time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC)
+	created  = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	users    = []domain.User{
+		{Id: 1, FirstName: "John", Login: "john@example.com", Password: "hash", BirthDay: &birthday, Version: 2, CreatedAt: created},
+		{Id: 2, FirstName: "Jane, Jr", Login: "jane@example.com", Password: "hash", Version: 1, CreatedAt: created},
+	}
+)
+
+// export пишет пользователей всеми проходами формата
+func export(t *testing.T, format string, fields []string, users []domain.User) string {
+	var out bytes.Buffer
+	w, err := NewWriter(&out, format, fields)
+	require.NoError(t, err)
+
+	for pass := 0; pass < w.Passes(); pass++ {
+		for _, user := range users {
+			require.NoError(t, w.Write(pass, user))
+		}
+	}
+	require.NoError(t, w.Close())
+
+	return out.String()
+}
+
+// Тест выгрузки в CSV
+func TestWriter_CSV(t *testing.T) {
+	out := export(t, domain.EXPORT_CSV, []string{"id", "name", "birthday", "created_at"}, users)
+	assert.Equal(t, "id,name,birthday,created_at\n"+
+		"1,John,1990-05-17,2024-01-02T03:04:05Z\n"+
+		"2,\"Jane, Jr\",,2024-01-02T03:04:05Z\n", out)
+
+	assert.Equal(t, "id\n", export(t, domain.EXPORT_CSV, []string{"id"}, nil))
+}
+
+// Тест выгрузки в NDJSON: поля в порядке выбора, пароль не выгружается
+func TestWriter_NDJSON(t *testing.T) {
+	fields, err := ParseFields("")
+	require.NoError(t, err)
+
+	out := export(t, domain.EXPORT_NDJSON, []string{"email", "id", "deleted_at"}, users[:1])
+	assert.Equal(t, `{"email":"john@example.com","id":1,"deleted_at":null}`+"\n", out)
+
+	out = export(t, domain.EXPORT_NDJSON, fields, users[:1])
+	assert.NotContains(t, out, "hash")
+	assert.NotContains(t, out, "password")
+}
+
+// Тест колоночного JSON, в том числе пустой выборки
+func TestWriter_Columns(t *testing.T) {
+	out := export(t, domain.EXPORT_COLUMNS, []string{"id", "email"}, users)
+	assert.Equal(t, `{"id":[1,2],"email":["john@example.com","jane@example.com"]}`+"\n", out)
+
+	var columns map[string][]any
+	require.NoError(t, json.Unmarshal([]byte(export(t, domain.EXPORT_COLUMNS, []string{"id", "email"}, nil)), &columns))
+	assert.Equal(t, map[string][]any{"id": {}, "email": {}}, columns)
+}
+
+// Тест разбора списка полей
+func TestParseFields(t *testing.T) {
+	fields, err := ParseFields("email, id,email")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"email", "id"}, fields)
+
+	_, err = ParseFields("id,password")
+	assert.ErrorIs(t, err, domain.ErrInvalidField)
+}
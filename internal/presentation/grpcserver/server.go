@@ -0,0 +1,490 @@
+package grpcserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"user/internal/domain"
+	"user/internal/interfaces"
+	"user/internal/presentation/grpcserver/userpb"
+	"user/internal/presentation/logger"
+	"user/internal/presentation/validation"
+
+	"github.com/lib/pq"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	REQUEST_ID_METADATA = "x-request-id"
+
+	// TENANT_METADATA - организация запроса, без нее используется организация сессии
+	TENANT_METADATA = "x-tenant-id"
+
+	// AUTHORIZATION_METADATA - access токен вызывающего в виде "Bearer <token>"
+	AUTHORIZATION_METADATA = "authorization"
+
+	// MAX_BATCH - максимальное количество идентификаторов в BatchGet
+	MAX_BATCH = 100
+
+	NOT_UNIQUE_LOGIN = "23505"
+
+	// encryptedFilters - ответ на фильтры по зашифрованным полям, которые не могут искаться по индексу
+	encryptedFilters = "Filters email_prefix, name, birthday_from and birthday_to are not supported, personal data is encrypted: use email, created_from and created_to"
+)
+
+// Server определяет gRPC сервер сервиса пользователей
+type Server struct {
+	userpb.UnimplementedUserServiceServer
+
+	users  interfaces.UserRepo
+	auth   interfaces.AuthRepo
+	srv    *grpc.Server
+	health *health.Server
+
+	// requireVersion запрещает Update и Delete без ожидаемой версии, как REQUIRE_IF_MATCH в HTTP API
+	requireVersion bool
+}
+
+// NewServer создает новый экземпляр Server с проверкой здоровья и reflection
+// users - то же хранилище пользователей, что использует HTTP API
+// auth проверяет access токены вызывающих так же, как HTTP API
+// requireVersion требует версию в Update и Delete так же, как REQUIRE_IF_MATCH требует If-Match
+func NewServer(users interfaces.UserRepo, auth interfaces.AuthRepo, requireVersion bool) *Server {
+	s := &Server{
+		users:          users,
+		auth:           auth,
+		health:         health.NewServer(),
+		requireVersion: requireVersion,
+	}
+	s.srv = grpc.NewServer(grpc.ChainUnaryInterceptor(requestId, s.authenticate))
+
+	userpb.RegisterUserServiceServer(s.srv, s)
+	grpc_health_v1.RegisterHealthServer(s.srv, s.health)
+	reflection.Register(s.srv)
+
+	s.health.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+	s.health.SetServingStatus(userpb.UserService_ServiceDesc.ServiceName, grpc_health_v1.HealthCheckResponse_SERVING)
+
+	logger.Logger.Info("gRPC server has been created")
+	return s
+}
+
+// Start принимает соединения на порту port до вызова Shutdown
+func (s *Server) Start(port int) error {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("gRPC listening error: %v", err)
+	}
+
+	return s.Serve(lis)
+}
+
+// Serve принимает соединения из lis
+func (s *Server) Serve(lis net.Listener) error {
+	return s.srv.Serve(lis)
+}
+
+// Shutdown переводит сервис в NOT_SERVING и дожидается завершения текущих запросов
+func (s *Server) Shutdown() {
+	s.health.Shutdown()
+	s.srv.GracefulStop()
+}
+
+// Create создает пользователя, требует права users:write:any
+func (s *Server) Create(ctx context.Context, req *userpb.CreateRequest) (*userpb.CreateResponse, error) {
+	err := authorize(ctx, "", domain.PERM_USERS_WRITE_ANY)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := validUser(req.GetName(), req.GetSurname(), req.GetBirthday(), req.GetEmail(), req.GetPassword())
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := s.users.Create(ctx, *user)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	if id == nil {
+		return nil, status.Errorf(codes.AlreadyExists, "User with email %s already exist", user.Login)
+	}
+
+	return &userpb.CreateResponse{Id: *id}, nil
+}
+
+// Get возвращает пользователя, удаленных пользователей видит только право users:manage
+func (s *Server) Get(ctx context.Context, req *userpb.GetRequest) (*userpb.User, error) {
+	err := authorize(ctx, domain.PERM_USERS_READ_SELF, domain.PERM_USERS_READ_ANY, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetWithDeleted() {
+		err = authorize(ctx, "", domain.PERM_USERS_MANAGE)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.get(ctx, req.GetId(), req.GetWithDeleted())
+}
+
+// get возвращает пользователя без проверки прав
+func (s *Server) get(ctx context.Context, id domain.Id, withDeleted bool) (*userpb.User, error) {
+	user, err := s.users.Get(ctx, id, withDeleted)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	if user == nil {
+		return nil, status.Errorf(codes.NotFound, "User with id %d not exist", id)
+	}
+
+	return toProto(user), nil
+}
+
+// Update полностью обновляет пользователя и возвращает его новое состояние
+func (s *Server) Update(ctx context.Context, req *userpb.UpdateRequest) (*userpb.User, error) {
+	err := authorize(ctx, domain.PERM_USERS_WRITE_SELF, domain.PERM_USERS_WRITE_ANY, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.checkVersion(req.GetVersion())
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := validUser(req.GetName(), req.GetSurname(), req.GetBirthday(), req.GetEmail(), req.GetPassword())
+	if err != nil {
+		return nil, err
+	}
+
+	user.Id = req.GetId()
+	user.Version = req.GetVersion()
+	err = s.users.Update(ctx, *user)
+	if err != nil {
+		return nil, mutationError(err, user.Login)
+	}
+
+	return s.get(ctx, req.GetId(), false)
+}
+
+// Delete мягко удаляет пользователя
+func (s *Server) Delete(ctx context.Context, req *userpb.DeleteRequest) (*userpb.DeleteResponse, error) {
+	err := authorize(ctx, domain.PERM_USERS_DELETE_SELF, domain.PERM_USERS_DELETE_ANY, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.checkVersion(req.GetVersion())
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := s.users.Delete(ctx, req.GetId(), req.GetVersion())
+	if err != nil {
+		return nil, mutationError(err, "")
+	}
+
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "User with id %d not exist", req.GetId())
+	}
+
+	return &userpb.DeleteResponse{}, nil
+}
+
+// checkVersion отклоняет изменение без ожидаемой версии, если она обязательна
+func (s *Server) checkVersion(version uint64) error {
+	if s.requireVersion && version == 0 {
+		return status.Error(codes.FailedPrecondition, "Version is required")
+	}
+
+	return nil
+}
+
+// List возвращает страницу пользователей по тем же правилам, что и GET /users
+func (s *Server) List(ctx context.Context, req *userpb.ListRequest) (*userpb.ListResponse, error) {
+	err := authorize(ctx, "", domain.PERM_USERS_READ_ANY)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetWithDeleted() {
+		err = authorize(ctx, "", domain.PERM_USERS_MANAGE)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if req.GetLimit() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Invalid limit")
+	}
+
+	if req.GetEmailPrefix() != "" || req.GetName() != "" || req.GetBirthdayFrom() != "" || req.GetBirthdayTo() != "" {
+		return nil, status.Error(codes.InvalidArgument, encryptedFilters)
+	}
+
+	filter := domain.UserFilter{
+		Login:       req.GetEmail(),
+		WithDeleted: req.GetWithDeleted(),
+		Sort:        req.GetSort(),
+		Cursor:      req.GetCursor(),
+		Limit:       int(req.GetLimit()),
+	}
+
+	if req.CreatedFrom != nil {
+		t := req.GetCreatedFrom().AsTime()
+		filter.CreatedFrom = &t
+	}
+	if req.CreatedTo != nil {
+		t := req.GetCreatedTo().AsTime()
+		filter.CreatedTo = &t
+	}
+
+	page, err := s.users.List(ctx, filter)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrInvalidSort):
+			return nil, status.Error(codes.InvalidArgument, "Invalid sort")
+		case errors.Is(err, domain.ErrInvalidCursor):
+			return nil, status.Error(codes.InvalidArgument, "Invalid cursor")
+		default:
+			return nil, status.Error(codes.Internal, "Internal error")
+		}
+	}
+
+	resp := &userpb.ListResponse{
+		Users:      make([]*userpb.User, 0, len(page.Users)),
+		NextCursor: page.NextCursor,
+	}
+	for i := range page.Users {
+		resp.Users = append(resp.Users, toProto(&page.Users[i]))
+	}
+
+	return resp, nil
+}
+
+// BatchGet возвращает пользователей в порядке запроса, ненайденные идентификаторы попадают в missing
+func (s *Server) BatchGet(ctx context.Context, req *userpb.BatchGetRequest) (*userpb.BatchGetResponse, error) {
+	if len(req.GetIds()) > MAX_BATCH {
+		return nil, status.Errorf(codes.InvalidArgument, "No more than %d ids are allowed", MAX_BATCH)
+	}
+
+	err := authorize(ctx, domain.PERM_USERS_READ_SELF, domain.PERM_USERS_READ_ANY, req.GetIds()...)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &userpb.BatchGetResponse{
+		Users:   []*userpb.User{},
+		Missing: []uint64{},
+	}
+	users, err := s.users.BatchGet(ctx, req.GetIds())
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	found := make(map[domain.Id]bool, len(users))
+	for i := range users {
+		found[users[i].Id] = true
+		resp.Users = append(resp.Users, toProto(&users[i]))
+	}
+
+	seen := map[domain.Id]bool{}
+	for _, id := range req.GetIds() {
+		if !found[id] && !seen[id] {
+			resp.Missing = append(resp.Missing, id)
+		}
+		seen[id] = true
+	}
+
+	return resp, nil
+}
+
+// requestId сохраняет идентификатор запроса из метаданных x-request-id
+// или генерирует новый и возвращает его в заголовках ответа
+func requestId(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(REQUEST_ID_METADATA); len(values) > 0 && len(values[0]) <= 64 {
+			id = values[0]
+		}
+	}
+
+	if id == "" {
+		buf := make([]byte, 16)
+		_, _ = rand.Read(buf)
+		id = hex.EncodeToString(buf)
+	}
+
+	_ = grpc.SetHeader(ctx, metadata.Pairs(REQUEST_ID_METADATA, id))
+	return handler(domain.WithActor(ctx, domain.Actor{RequestId: id}), req)
+}
+
+type sessionKey struct{}
+
+// authenticate проверяет access токен из метаданных authorization и сохраняет сессию в контексте
+// Организация берется из сессии, чужая организация из x-tenant-id доступна ее участнику
+// и пользователю с правом organizations:manage
+// Проверка здоровья и reflection доступны без токена
+func (s *Server) authenticate(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !strings.HasPrefix(info.FullMethod, "/"+userpb.UserService_ServiceDesc.ServiceName+"/") {
+		return handler(ctx, req)
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(AUTHORIZATION_METADATA)
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "Authorization is required")
+	}
+
+	token, found := strings.CutPrefix(values[0], "Bearer ")
+	if !found || token == "" {
+		return nil, status.Error(codes.Unauthenticated, "Invalid authorization metadata")
+	}
+
+	session, err := s.auth.Authenticate(ctx, token)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	if session == nil {
+		return nil, status.Error(codes.Unauthenticated, "Invalid token")
+	}
+
+	tenant := session.TenantId
+	if values := md.Get(TENANT_METADATA); len(values) > 0 {
+		tenant, err = strconv.ParseUint(values[0], 10, 64)
+		if err != nil || tenant == 0 {
+			return nil, status.Error(codes.InvalidArgument, "Invalid tenant")
+		}
+
+		if !session.Member(tenant) && !session.Can(domain.PERM_ORGANIZATIONS_MANAGE) {
+			logger.Logger.Warn(fmt.Sprintf("Access to tenant %d denied: user %d, request %s", tenant, session.UserId, domain.ActorFrom(ctx).RequestId))
+			return nil, status.Error(codes.PermissionDenied, "Access to tenant denied")
+		}
+	}
+
+	actor := domain.ActorFrom(ctx)
+	actor.UserId = &session.UserId
+	actor.SessionId = &session.Id
+	ctx = domain.WithTenant(domain.WithActor(ctx, actor), tenant)
+	return handler(context.WithValue(ctx, sessionKey{}, session), req)
+}
+
+// authorize проверяет право сессии вызова на действие с пользователями targets и записывает решение в лог
+// Если все targets - сам пользователь, достаточно права own, иначе нужно право any
+func authorize(ctx context.Context, own, any string, targets ...domain.Id) error {
+	session, _ := ctx.Value(sessionKey{}).(*domain.Session)
+	if session == nil {
+		return status.Error(codes.Unauthenticated, "Authorization is required")
+	}
+
+	permission := any
+	if own != "" && len(targets) > 0 && !slices.ContainsFunc(targets, func(id domain.Id) bool { return id != session.UserId }) {
+		permission = own
+	}
+
+	if !session.Can(permission) {
+		logger.Logger.Warn(fmt.Sprintf("Access denied: user %d %v, permission %s, targets %v, request %s", session.UserId, session.Roles, permission, targets, domain.ActorFrom(ctx).RequestId))
+		return status.Error(codes.PermissionDenied, "Access denied")
+	}
+
+	return nil
+}
+
+// validUser проверяет поля пользователя по тем же правилам, что и HTTP API, и хэширует пароль
+func validUser(name, surname, birthday, email, password string) (*domain.User, error) {
+	if email == "" {
+		return nil, status.Error(codes.InvalidArgument, "Email is required")
+	}
+
+	if !validation.IsValidEmail(email) {
+		return nil, status.Error(codes.InvalidArgument, "Invalid email")
+	}
+
+	birthDay, err := parseDate(birthday, "birthday")
+	if err != nil {
+		return nil, err
+	}
+
+	hashPass, err := validation.ValidPass(password)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid password")
+	}
+
+	return &domain.User{
+		FirstName:     name,
+		LastName:      surname,
+		BirthDay:      birthDay,
+		Login:         email,
+		Password:      hashPass,
+		PlainPassword: password,
+	}, nil
+}
+
+// mutationError переводит ошибку изменения пользователя в статус gRPC
+func mutationError(err error, login string) error {
+	if errors.Is(err, domain.ErrVersionMismatch) {
+		return status.Error(codes.Aborted, "User has been modified")
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == NOT_UNIQUE_LOGIN {
+		return status.Errorf(codes.AlreadyExists, "User with email %s already exist", login)
+	}
+
+	return status.Error(codes.Internal, "Internal error")
+}
+
+// parseDate разбирает дату в формате YYYY-MM-DD, пустая строка означает отсутствие даты
+func parseDate(value, field string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid %s", field)
+	}
+
+	return &t, nil
+}
+
+// toProto переводит пользователя в сообщение gRPC, пароль не передается
+func toProto(user *domain.User) *userpb.User {
+	msg := &userpb.User{
+		Id:        user.Id,
+		Name:      user.FirstName,
+		Surname:   user.LastName,
+		Email:     user.Login,
+		Version:   user.Version,
+		CreatedAt: timestamppb.New(user.CreatedAt),
+	}
+
+	if user.BirthDay != nil {
+		msg.Birthday = user.BirthDay.Format(time.DateOnly)
+	}
+	if user.DeletedAt != nil {
+		msg.DeletedAt = timestamppb.New(*user.DeletedAt)
+	}
+
+	return msg
+}
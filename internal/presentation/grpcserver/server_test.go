@@ -0,0 +1,470 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+	"user/internal/interfaces"
+	"user/internal/presentation/grpcserver/userpb"
+	"user/internal/presentation/logger"
+	"user/internal/presentation/realization"
+	"user/internal/presentation/validation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// memoryRepo - хранилище пользователей в памяти для тестов
+type memoryRepo struct {
+	mu     sync.Mutex
+	users  map[domain.Id]domain.User
+	nextId domain.Id
+	actor  domain.Actor
+	tenant domain.Id
+}
+
+func (r *memoryRepo) Create(ctx context.Context, user domain.User) (*domain.Id, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.actor = domain.ActorFrom(ctx)
+	r.tenant = domain.TenantFrom(ctx)
+	for _, u := range r.users {
+		if u.Login == user.Login {
+			return nil, nil
+		}
+	}
+
+	r.nextId++
+	user.Id = r.nextId
+	user.Version = 1
+	user.CreatedAt = time.Now()
+	r.users[user.Id] = user
+	return &user.Id, nil
+}
+
+func (r *memoryRepo) Get(_ context.Context, id domain.Id, withDeleted bool) (*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || (user.DeletedAt != nil && !withDeleted) {
+		return nil, nil
+	}
+
+	user.Password = "***"
+	return &user, nil
+}
+
+func (r *memoryRepo) GetFresh(ctx context.Context, id domain.Id) (*domain.User, error) {
+	return r.Get(ctx, id, false)
+}
+
+func (r *memoryRepo) Update(_ context.Context, user domain.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	before, ok := r.users[user.Id]
+	if !ok || before.DeletedAt != nil {
+		return nil
+	}
+
+	if user.Version != 0 && user.Version != before.Version {
+		return domain.ErrVersionMismatch
+	}
+
+	user.Version = before.Version + 1
+	user.CreatedAt = before.CreatedAt
+	r.users[user.Id] = user
+	return nil
+}
+
+func (r *memoryRepo) CreateDeleted(context.Context, domain.User) (*domain.Id, error) {
+	return nil, nil
+}
+
+func (r *memoryRepo) Patch(context.Context, domain.Id, domain.UserPatch) (bool, error) {
+	return false, nil
+}
+
+func (r *memoryRepo) Count(context.Context, domain.UserFilter) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.users), nil
+}
+
+func (r *memoryRepo) List(_ context.Context, filter domain.UserFilter) (*domain.UserPage, error) {
+	if filter.Sort != "" && filter.Sort != "id" {
+		return nil, domain.ErrInvalidSort
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	page := &domain.UserPage{Users: []domain.User{}}
+	for id := domain.Id(1); id <= r.nextId; id++ {
+		if user, ok := r.users[id]; ok && user.DeletedAt == nil && (filter.Login == "" || user.Login == filter.Login) {
+			page.Users = append(page.Users, user)
+		}
+	}
+
+	return page, nil
+}
+
+func (r *memoryRepo) Delete(_ context.Context, id domain.Id, version uint64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt != nil {
+		return false, nil
+	}
+
+	if version != 0 && version != user.Version {
+		return false, domain.ErrVersionMismatch
+	}
+
+	now := time.Now()
+	user.DeletedAt = &now
+	user.Version++
+	r.users[id] = user
+	return true, nil
+}
+
+func (r *memoryRepo) Restore(context.Context, domain.Id) (bool, error) {
+	return false, nil
+}
+
+func (r *memoryRepo) SetState(context.Context, domain.Id, domain.UserPatch, bool) (bool, error) {
+	return false, nil
+}
+
+func (r *memoryRepo) Purge(context.Context, domain.Id) (bool, error) {
+	return false, nil
+}
+
+func (r *memoryRepo) BatchGet(ctx context.Context, ids []domain.Id) ([]domain.User, error) {
+	users := []domain.User{}
+	seen := map[domain.Id]bool{}
+	for _, id := range ids {
+		user, _ := r.Get(ctx, id, false)
+		if user != nil && !seen[id] {
+			users = append(users, *user)
+		}
+		seen[id] = true
+	}
+
+	return users, nil
+}
+
+func (r *memoryRepo) Bulk(context.Context, []domain.BulkItem, bool) ([]domain.BulkResult, error) {
+	return nil, nil
+}
+
+func (r *memoryRepo) Export(context.Context, domain.UserFilter, int, func(int, domain.User) error) error {
+	return nil
+}
+
+// memoryAuth - проверка access токенов по заранее выданным сессиям
+type memoryAuth struct {
+	interfaces.AuthRepo
+	sessions map[string]*domain.Session
+}
+
+func (a *memoryAuth) Authenticate(_ context.Context, token string) (*domain.Session, error) {
+	return a.sessions[token], nil
+}
+
+const (
+	// ADMIN_TOKEN - сессия администратора организации по умолчанию, участника организации 2
+	ADMIN_TOKEN = "admin-token"
+	// SELF_TOKEN - сессия пользователя 1 только с правами на свой профиль
+	SELF_TOKEN = "self-token"
+)
+
+// withToken добавляет access токен в метаданные вызова
+func withToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, AUTHORIZATION_METADATA, "Bearer "+token)
+}
+
+func setup(t *testing.T) (userpb.UserServiceClient, *grpc.ClientConn, *memoryRepo) {
+	return setupServer(t, false)
+}
+
+// setupServer поднимает сервер на bufconn, requireVersion передается в NewServer
+func setupServer(t *testing.T, requireVersion bool) (userpb.UserServiceClient, *grpc.ClientConn, *memoryRepo) {
+	require.NoError(t, logger.NewLogger())
+
+	hasher, err := realization.NewPasswordHasher(realization.BCRYPT, realization.NewArgon2idHasher(1, 64*1024, 2), realization.NewBcryptHasher(4))
+	require.NoError(t, err)
+	validation.Hasher = hasher
+
+	repo := &memoryRepo{users: map[domain.Id]domain.User{}}
+	auth := &memoryAuth{sessions: map[string]*domain.Session{
+		ADMIN_TOKEN: {
+			Id:       10,
+			UserId:   100,
+			TenantId: domain.DEFAULT_TENANT,
+			Tenants:  []domain.Id{2},
+			Roles:    []string{domain.ROLE_ADMIN},
+			Permissions: []string{
+				domain.PERM_USERS_READ_ANY, domain.PERM_USERS_WRITE_ANY, domain.PERM_USERS_DELETE_ANY, domain.PERM_USERS_MANAGE,
+			},
+		},
+		SELF_TOKEN: {
+			Id:          11,
+			UserId:      1,
+			TenantId:    domain.DEFAULT_TENANT,
+			Roles:       []string{domain.ROLE_SELF},
+			Permissions: []string{domain.PERM_USERS_READ_SELF, domain.PERM_USERS_WRITE_SELF, domain.PERM_USERS_DELETE_SELF},
+		},
+	}}
+	srv := NewServer(repo, auth, requireVersion)
+
+	lis := bufconn.Listen(1024 * 1024)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	t.Cleanup(srv.Shutdown)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return userpb.NewUserServiceClient(conn), conn, repo
+}
+
+// Тест создания, получения, обновления и удаления пользователя
+func TestUserService(t *testing.T) {
+	client, _, repo := setup(t)
+	ctx := metadata.AppendToOutgoingContext(withToken(context.Background(), ADMIN_TOKEN), REQUEST_ID_METADATA, "grpc-test")
+
+	var header metadata.MD
+	created, err := client.Create(ctx, &userpb.CreateRequest{
+		Name:     "John",
+		Surname:  "Doe",
+		Birthday: "1990-05-17",
+		Email:    "john@example.com",
+		Password: "StrongPassword123!",
+	}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"grpc-test"}, header.Get(REQUEST_ID_METADATA))
+	assert.Equal(t, "grpc-test", repo.actor.RequestId)
+	require.NotNil(t, repo.actor.UserId)
+	assert.Equal(t, domain.Id(100), *repo.actor.UserId)
+	assert.Equal(t, domain.DEFAULT_TENANT, repo.tenant)
+
+	_, err = client.Create(ctx, &userpb.CreateRequest{Email: "john@example.com", Password: "StrongPassword123!"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	user, err := client.Get(ctx, &userpb.GetRequest{Id: created.GetId()})
+	require.NoError(t, err)
+	assert.Equal(t, "John", user.GetName())
+	assert.Equal(t, "1990-05-17", user.GetBirthday())
+	assert.Equal(t, uint64(1), user.GetVersion())
+
+	updated, err := client.Update(ctx, &userpb.UpdateRequest{
+		Id:       created.GetId(),
+		Name:     "Jane",
+		Email:    "jane@example.com",
+		Password: "StrongPassword123!",
+		Version:  1,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Jane", updated.GetName())
+	assert.Equal(t, "", updated.GetBirthday())
+	assert.Equal(t, uint64(2), updated.GetVersion())
+
+	_, err = client.Delete(ctx, &userpb.DeleteRequest{Id: created.GetId(), Version: 1})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	_, err = client.Delete(ctx, &userpb.DeleteRequest{Id: created.GetId(), Version: 2})
+	require.NoError(t, err)
+
+	_, err = client.Get(ctx, &userpb.GetRequest{Id: created.GetId()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	deleted, err := client.Get(ctx, &userpb.GetRequest{Id: created.GetId(), WithDeleted: true})
+	require.NoError(t, err)
+	assert.NotNil(t, deleted.GetDeletedAt())
+}
+
+// Тест проверки входных данных
+func TestUserService_InvalidArgument(t *testing.T) {
+	client, _, _ := setup(t)
+	ctx := withToken(context.Background(), ADMIN_TOKEN)
+
+	_, err := client.Create(ctx, &userpb.CreateRequest{Email: "invalid", Password: "StrongPassword123!"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Create(ctx, &userpb.CreateRequest{Email: "john@example.com", Password: "1"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Create(ctx, &userpb.CreateRequest{Email: "john@example.com", Password: "StrongPassword123!", Birthday: "17.05.1990"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.List(ctx, &userpb.ListRequest{Sort: "password"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Персональные данные зашифрованы, фильтры по ним отклоняются
+	_, err = client.List(ctx, &userpb.ListRequest{EmailPrefix: "john"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.BatchGet(ctx, &userpb.BatchGetRequest{Ids: make([]uint64, MAX_BATCH+1)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Get(metadata.AppendToOutgoingContext(ctx, TENANT_METADATA, "acme"), &userpb.GetRequest{Id: 1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// Тест обязательной версии в Update и Delete
+func TestUserService_RequireVersion(t *testing.T) {
+	client, _, _ := setupServer(t, true)
+	ctx := withToken(context.Background(), ADMIN_TOKEN)
+
+	created, err := client.Create(ctx, &userpb.CreateRequest{Email: "john@example.com", Password: "StrongPassword123!"})
+	require.NoError(t, err)
+
+	_, err = client.Update(ctx, &userpb.UpdateRequest{Id: created.GetId(), Email: "jane@example.com", Password: "StrongPassword123!"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = client.Delete(ctx, &userpb.DeleteRequest{Id: created.GetId()})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	updated, err := client.Update(ctx, &userpb.UpdateRequest{Id: created.GetId(), Email: "jane@example.com", Password: "StrongPassword123!", Version: 1})
+	require.NoError(t, err)
+
+	_, err = client.Delete(ctx, &userpb.DeleteRequest{Id: created.GetId(), Version: updated.GetVersion()})
+	require.NoError(t, err)
+}
+
+// Тест организации запроса из метаданных x-tenant-id
+func TestUserService_Tenant(t *testing.T) {
+	client, _, repo := setup(t)
+	ctx := metadata.AppendToOutgoingContext(withToken(context.Background(), ADMIN_TOKEN), TENANT_METADATA, "2")
+
+	_, err := client.Create(ctx, &userpb.CreateRequest{Email: "john@example.com", Password: "StrongPassword123!"})
+	require.NoError(t, err)
+	assert.Equal(t, domain.Id(2), repo.tenant)
+
+	ctx = metadata.AppendToOutgoingContext(withToken(context.Background(), ADMIN_TOKEN), TENANT_METADATA, "3")
+	_, err = client.Create(ctx, &userpb.CreateRequest{Email: "jane@example.com", Password: "StrongPassword123!"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Equal(t, domain.Id(2), repo.tenant)
+}
+
+// Тест аутентификации и прав вызывающего
+func TestUserService_Auth(t *testing.T) {
+	client, conn, _ := setup(t)
+	admin := withToken(context.Background(), ADMIN_TOKEN)
+	self := withToken(context.Background(), SELF_TOKEN)
+
+	_, err := client.Create(context.Background(), &userpb.CreateRequest{Email: "john@example.com", Password: "StrongPassword123!"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.Get(withToken(context.Background(), "unknown"), &userpb.GetRequest{Id: 1})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.Get(metadata.AppendToOutgoingContext(context.Background(), AUTHORIZATION_METADATA, ADMIN_TOKEN), &userpb.GetRequest{Id: 1})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.Create(self, &userpb.CreateRequest{Email: "john@example.com", Password: "StrongPassword123!"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	for _, email := range []string{"john@example.com", "jane@example.com"} {
+		_, err = client.Create(admin, &userpb.CreateRequest{Email: email, Password: "StrongPassword123!"})
+		require.NoError(t, err)
+	}
+
+	user, err := client.Get(self, &userpb.GetRequest{Id: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "john@example.com", user.GetEmail())
+
+	_, err = client.Get(self, &userpb.GetRequest{Id: 2})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.Get(self, &userpb.GetRequest{Id: 1, WithDeleted: true})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.Update(self, &userpb.UpdateRequest{Id: 2, Email: "jane@example.com", Password: "StrongPassword123!"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.Delete(self, &userpb.DeleteRequest{Id: 2})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.List(self, &userpb.ListRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.BatchGet(self, &userpb.BatchGetRequest{Ids: []uint64{1, 2}})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	batch, err := client.BatchGet(self, &userpb.BatchGetRequest{Ids: []uint64{1}})
+	require.NoError(t, err)
+	assert.Len(t, batch.GetUsers(), 1)
+
+	_, err = client.Delete(self, &userpb.DeleteRequest{Id: 1})
+	require.NoError(t, err)
+
+	// Проверка здоровья не требует токена
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+}
+
+// Тест получения списка и пачки пользователей
+func TestUserService_ListBatchGet(t *testing.T) {
+	client, _, _ := setup(t)
+	ctx := withToken(context.Background(), ADMIN_TOKEN)
+
+	ids := []uint64{}
+	for _, email := range []string{"a@example.com", "b@example.com"} {
+		created, err := client.Create(ctx, &userpb.CreateRequest{Email: email, Password: "StrongPassword123!"})
+		require.NoError(t, err)
+		ids = append(ids, created.GetId())
+	}
+
+	list, err := client.List(ctx, &userpb.ListRequest{})
+	require.NoError(t, err)
+	assert.Len(t, list.GetUsers(), 2)
+
+	list, err = client.List(ctx, &userpb.ListRequest{Email: "b@example.com"})
+	require.NoError(t, err)
+	require.Len(t, list.GetUsers(), 1)
+	assert.Equal(t, ids[1], list.GetUsers()[0].GetId())
+
+	batch, err := client.BatchGet(ctx, &userpb.BatchGetRequest{Ids: []uint64{ids[1], 100, ids[0], ids[1]}})
+	require.NoError(t, err)
+	require.Len(t, batch.GetUsers(), 2)
+	assert.Equal(t, ids[1], batch.GetUsers()[0].GetId())
+	assert.Equal(t, ids[0], batch.GetUsers()[1].GetId())
+	assert.Equal(t, []uint64{100}, batch.GetMissing())
+}
+
+// Тест проверки здоровья сервиса
+func TestHealth(t *testing.T) {
+	_, conn, _ := setup(t)
+
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{
+		Service: userpb.UserService_ServiceDesc.ServiceName,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, resp.GetStatus())
+}
@@ -0,0 +1,286 @@
+// Package importer читает пользователей из файлов CSV и NDJSON
+// и формирует отчет по строкам, которые не удалось загрузить
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"user/internal/domain"
+)
+
+const (
+	// MAX_ROWS - максимальное количество строк в файле
+	MAX_ROWS = 100000
+
+	// MAX_FIELD - максимальная длина текстового поля, как у колонок таблицы users
+	MAX_FIELD = 255
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown import format")
+	ErrTooManyRows   = fmt.Errorf("no more than %d rows are allowed", MAX_ROWS)
+)
+
+// Колонки CSV совпадают с полями пользователя в JSON API: email, password, name, surname, birthday
+// Без email и password файл не принимается
+var required = []string{"email", "password"}
+
+// record - строка файла до проверки
+type record struct {
+	Name     string `json:"name"`
+	Surname  string `json:"surname"`
+	Birthday string `json:"birthday"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// Format определяет формат файла по типу содержимого или расширению имени
+// Возвращает пустую строку, если формат не распознан
+func Format(contentType, name string) string {
+	switch {
+	case strings.HasPrefix(contentType, "text/csv"), strings.HasSuffix(name, ".csv"):
+		return domain.IMPORT_CSV
+	case strings.HasPrefix(contentType, "application/x-ndjson"), strings.HasPrefix(contentType, "application/jsonl"),
+		strings.HasSuffix(name, ".ndjson"), strings.HasSuffix(name, ".jsonl"):
+		return domain.IMPORT_NDJSON
+	}
+
+	return ""
+}
+
+// Parse читает строки файла в формате format
+// Строки, которые не удалось разобрать, и повторы логина попадают в отчет
+// Ошибка возвращается, только если файл нельзя прочитать целиком
+func Parse(r io.Reader, format string) ([]domain.ImportRow, []domain.ImportError, error) {
+	var (
+		records []record
+		lines   []int
+		rejects []domain.ImportError
+		err     error
+	)
+
+	switch format {
+	case domain.IMPORT_CSV:
+		records, lines, rejects, err = readCSV(r)
+	case domain.IMPORT_NDJSON:
+		records, lines, rejects, err = readNDJSON(r)
+	default:
+		return nil, nil, ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows := make([]domain.ImportRow, 0, len(records))
+	seen := make(map[string]int, len(records))
+	for i, rec := range records {
+		user, err := rec.user()
+		if err != nil {
+			rejects = append(rejects, reject(lines[i], rec.Email, err.Error()))
+			continue
+		}
+
+		if first, ok := seen[user.Login]; ok {
+			rejects = append(rejects, reject(lines[i], rec.Email, fmt.Sprintf("Duplicate of line %d", first)))
+			continue
+		}
+		seen[user.Login] = lines[i]
+
+		rows = append(rows, domain.ImportRow{Line: lines[i], User: user})
+	}
+
+	return rows, rejects, nil
+}
+
+// Validate проверяет строки функцией validate, она же может изменить пользователя,
+// например заменить пароль его хэшем
+// Строки с ошибкой попадают в отчет с текстом ошибки
+func Validate(rows []domain.ImportRow, validate func(*domain.User) error) ([]domain.ImportRow, []domain.ImportError) {
+	valid := make([]domain.ImportRow, 0, len(rows))
+	rejects := []domain.ImportError{}
+	for _, row := range rows {
+		err := validate(&row.User)
+		if err != nil {
+			rejects = append(rejects, reject(row.Line, row.User.Login, err.Error()))
+			continue
+		}
+
+		valid = append(valid, row)
+	}
+
+	return valid, rejects
+}
+
+// WriteReport пишет отчет в CSV с колонками line, email, error в порядке строк файла
+func WriteReport(w io.Writer, rejects []domain.ImportError) error {
+	rejects = slices.Clone(rejects)
+	slices.SortStableFunc(rejects, func(a, b domain.ImportError) int {
+		return a.Line - b.Line
+	})
+
+	out := csv.NewWriter(w)
+	err := out.Write([]string{"line", "email", "error"})
+	if err != nil {
+		return err
+	}
+
+	for _, e := range rejects {
+		err = out.Write([]string{strconv.Itoa(e.Line), e.Email, e.Error})
+		if err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// readCSV читает CSV с заголовком, порядок колонок любой, лишние колонки игнорируются
+func readCSV(r io.Reader) ([]record, []int, []domain.ImportError, error) {
+	in := csv.NewReader(r)
+	in.FieldsPerRecord = -1
+	in.TrimLeadingSpace = true
+
+	header, err := in.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil, nil, errors.New("header is required")
+		}
+		return nil, nil, nil, fmt.Errorf("reading header error: %v", err)
+	}
+
+	index := map[string]int{}
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, name := range required {
+		if _, ok := index[name]; !ok {
+			return nil, nil, nil, fmt.Errorf("column %q is required", name)
+		}
+	}
+
+	field := func(values []string, name string) string {
+		i, ok := index[name]
+		if !ok || i >= len(values) {
+			return ""
+		}
+		return strings.TrimSpace(values[i])
+	}
+
+	records := []record{}
+	lines := []int{}
+	rejects := []domain.ImportError{}
+	for {
+		values, err := in.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if len(records)+len(rejects) == MAX_ROWS {
+			return nil, nil, nil, ErrTooManyRows
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rejects = append(rejects, reject(parseErr.StartLine, "", "Invalid CSV row"))
+			continue
+		}
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("reading row error: %v", err)
+		}
+
+		line, _ := in.FieldPos(0)
+		records = append(records, record{
+			Email:    field(values, "email"),
+			Password: field(values, "password"),
+			Name:     field(values, "name"),
+			Surname:  field(values, "surname"),
+			Birthday: field(values, "birthday"),
+		})
+		lines = append(lines, line)
+	}
+
+	return records, lines, rejects, nil
+}
+
+// readNDJSON читает по одному объекту JSON в строке, пустые строки пропускаются
+func readNDJSON(r io.Reader) ([]record, []int, []domain.ImportError, error) {
+	in := bufio.NewScanner(r)
+	in.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	records := []record{}
+	lines := []int{}
+	rejects := []domain.ImportError{}
+	for line := 1; in.Scan(); line++ {
+		text := strings.TrimSpace(in.Text())
+		if text == "" {
+			continue
+		}
+
+		if len(records)+len(rejects) == MAX_ROWS {
+			return nil, nil, nil, ErrTooManyRows
+		}
+
+		var rec record
+		err := json.Unmarshal([]byte(text), &rec)
+		if err != nil {
+			rejects = append(rejects, reject(line, "", "Invalid JSON"))
+			continue
+		}
+
+		records = append(records, rec)
+		lines = append(lines, line)
+	}
+
+	err := in.Err()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("reading line error: %v", err)
+	}
+
+	return records, lines, rejects, nil
+}
+
+// user переводит строку файла в пользователя без проверки логина и пароля
+// Дата рождения принимается в виде 2006-01-02 или RFC 3339
+func (rec record) user() (domain.User, error) {
+	user := domain.User{
+		FirstName: strings.TrimSpace(rec.Name),
+		LastName:  strings.TrimSpace(rec.Surname),
+		Login:     strings.TrimSpace(rec.Email),
+		Password:  rec.Password,
+	}
+
+	if len(user.FirstName) > MAX_FIELD || len(user.LastName) > MAX_FIELD || len(user.Login) > MAX_FIELD {
+		return user, fmt.Errorf("Fields must be no longer than %d characters", MAX_FIELD)
+	}
+
+	if rec.Birthday != "" {
+		birthday, err := time.Parse(time.DateOnly, rec.Birthday)
+		if err != nil {
+			birthday, err = time.Parse(time.RFC3339, rec.Birthday)
+		}
+		if err != nil {
+			return user, errors.New("Invalid birthday")
+		}
+		user.BirthDay = &birthday
+	}
+
+	return user, nil
+}
+
+// reject возвращает строку отчета
+func reject(line int, email, msg string) domain.ImportError {
+	return domain.ImportError{
+		Line:  line,
+		Email: email,
+		Error: msg,
+	}
+}
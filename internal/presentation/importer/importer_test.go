@@ -0,0 +1,100 @@
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тест разбора CSV: порядок колонок, номера строк, ошибки строк и повторы
+func TestParse_CSV(t *testing.T) {
+	file := "\ufeffSurname,Email,Password,Birthday\n" +
+		"Doe,john@example.com,Secret1,1990-05-17\n" +
+		"Roe,jane@example.com,Secret1,17.05.1990\n" +
+		"\n" +
+		"Poe,john@example.com,Secret2,\n" +
+		"Moe,\"bad@example.com,Secret1\n"
+
+	rows, rejects, err := Parse(strings.NewReader(file), domain.IMPORT_CSV)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+
+	birthday := time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, domain.ImportRow{
+		Line: 2,
+		User: domain.User{LastName: "Doe", Login: "john@example.com", Password: "Secret1", BirthDay: &birthday},
+	}, rows[0])
+
+	assert.Equal(t, []domain.ImportError{
+		{Line: 6, Email: "", Error: "Invalid CSV row"},
+		{Line: 3, Email: "jane@example.com", Error: "Invalid birthday"},
+		{Line: 5, Email: "john@example.com", Error: "Duplicate of line 2"},
+	}, rejects)
+}
+
+// Тест разбора NDJSON
+func TestParse_NDJSON(t *testing.T) {
+	file := `{"email":"john@example.com","password":"Secret1","name":"John","birthday":"1990-05-17T00:00:00Z"}
+
+not json
+{"email":"jane@example.com","password":"Secret1"}
+`
+	rows, rejects, err := Parse(strings.NewReader(file), domain.IMPORT_NDJSON)
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, 1, rows[0].Line)
+	assert.Equal(t, "John", rows[0].User.FirstName)
+	assert.NotNil(t, rows[0].User.BirthDay)
+	assert.Equal(t, 4, rows[1].Line)
+	assert.Equal(t, []domain.ImportError{{Line: 3, Error: "Invalid JSON"}}, rejects)
+}
+
+// Тест ошибок файла целиком
+func TestParse_Invalid(t *testing.T) {
+	_, _, err := Parse(strings.NewReader("email\njohn@example.com\n"), domain.IMPORT_CSV)
+	assert.EqualError(t, err, `column "password" is required`)
+
+	_, _, err = Parse(strings.NewReader(""), "xml")
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+
+	file := "email,password\n" + strings.Repeat("a@example.com,Secret1\n", MAX_ROWS+1)
+	_, _, err = Parse(strings.NewReader(file), domain.IMPORT_CSV)
+	assert.ErrorIs(t, err, ErrTooManyRows)
+}
+
+// Тест проверки строк и отчета в порядке строк файла
+func TestValidateReport(t *testing.T) {
+	rows := []domain.ImportRow{
+		{Line: 2, User: domain.User{Login: "john@example.com", Password: "Secret1"}},
+		{Line: 3, User: domain.User{Login: "jane", Password: "Secret1"}},
+	}
+
+	valid, rejects := Validate(rows, func(user *domain.User) error {
+		if !strings.Contains(user.Login, "@") {
+			return errors.New("Invalid email")
+		}
+		user.Password = "hash"
+		return nil
+	})
+	require.Len(t, valid, 1)
+	assert.Equal(t, "hash", valid[0].User.Password)
+
+	rejects = append(rejects, domain.ImportError{Line: 1, Error: "Invalid JSON"})
+	var report bytes.Buffer
+	require.NoError(t, WriteReport(&report, rejects))
+	assert.Equal(t, "line,email,error\n1,,Invalid JSON\n3,jane,Invalid email\n", report.String())
+}
+
+// Тест определения формата
+func TestFormat(t *testing.T) {
+	assert.Equal(t, domain.IMPORT_CSV, Format("text/csv; charset=utf-8", ""))
+	assert.Equal(t, domain.IMPORT_NDJSON, Format("", "users.ndjson"))
+	assert.Equal(t, "", Format("application/json", "users.json"))
+}
@@ -0,0 +1,247 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"user/internal/domain"
+)
+
+const (
+	// rsaBits - длина создаваемых ключей RSA
+	rsaBits = 2048
+	// ecSize - длина координаты и половины подписи P-256
+	ecSize = 32
+)
+
+var (
+	ErrUnsupportedAlg = errors.New("unsupported signing algorithm")
+	ErrInvalidToken   = errors.New("invalid jwt")
+)
+
+// header - заголовок JWS
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// GenerateKey создает закрытый ключ для алгоритма RS256 или ES256
+func GenerateKey(alg string) (crypto.Signer, error) {
+	switch alg {
+	case domain.ALG_RS256:
+		return rsa.GenerateKey(rand.Reader, rsaBits)
+	case domain.ALG_ES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlg, alg)
+}
+
+// MarshalKey кодирует закрытый ключ в PKCS #8
+func MarshalKey(key crypto.Signer) ([]byte, error) {
+	return x509.MarshalPKCS8PrivateKey(key)
+}
+
+// ParseKey разбирает закрытый ключ RSA или EC из PKCS #8
+func ParseKey(data []byte) (crypto.Signer, error) {
+	key, err := x509.ParsePKCS8PrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedAlg, key)
+}
+
+// PublicJwk возвращает открытую часть ключа в формате JWK
+func PublicJwk(kid, alg string, key crypto.Signer) (domain.Jwk, error) {
+	jwk := domain.Jwk{Kid: kid, Use: "sig", Alg: alg}
+
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		if alg != domain.ALG_RS256 {
+			break
+		}
+		jwk.Kty = "RSA"
+		jwk.N = encode(pub.N.Bytes())
+		jwk.E = encode(big.NewInt(int64(pub.E)).Bytes())
+		return jwk, nil
+	case *ecdsa.PublicKey:
+		if alg != domain.ALG_ES256 || pub.Curve != elliptic.P256() {
+			break
+		}
+		jwk.Kty = "EC"
+		jwk.Crv = "P-256"
+		jwk.X = encode(pub.X.FillBytes(make([]byte, ecSize)))
+		jwk.Y = encode(pub.Y.FillBytes(make([]byte, ecSize)))
+		return jwk, nil
+	}
+
+	return domain.Jwk{}, fmt.Errorf("%w: %s key %T", ErrUnsupportedAlg, alg, key.Public())
+}
+
+// Sign подписывает claims и возвращает JWT в компактной форме
+func Sign(key crypto.Signer, alg, kid string, claims any) (string, error) {
+	head, err := json.Marshal(header{Alg: alg, Kid: kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	input := encode(head) + "." + encode(payload)
+	digest := sha256.Sum256([]byte(input))
+
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		if alg != domain.ALG_RS256 {
+			return "", fmt.Errorf("%w: %s with rsa key", ErrUnsupportedAlg, alg)
+		}
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		if alg != domain.ALG_ES256 {
+			return "", fmt.Errorf("%w: %s with ec key", ErrUnsupportedAlg, alg)
+		}
+		// JWS хранит подпись ECDSA как r||s фиксированной длины, а не в DER
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, digest[:])
+		if err == nil {
+			signature = append(r.FillBytes(make([]byte, ecSize)), s.FillBytes(make([]byte, ecSize))...)
+		}
+	default:
+		return "", fmt.Errorf("%w: %T", ErrUnsupportedAlg, key)
+	}
+	if err != nil {
+		return "", fmt.Errorf("signing jwt error: %v", err)
+	}
+
+	return input + "." + encode(signature), nil
+}
+
+// Verify проверяет подпись JWT ключом из keys с тем же kid и разбирает утверждения в claims
+// Срок действия и получатель не проверяются, это дело вызывающего
+func Verify(token string, keys []domain.Jwk, claims any) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var head header
+	err := decodeJSON(parts[0], &head)
+	if err != nil {
+		return fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+
+	var jwk *domain.Jwk
+	for i := range keys {
+		if keys[i].Kid == head.Kid {
+			jwk = &keys[i]
+			break
+		}
+	}
+	// Алгоритм берется из ключа, заголовку токена он только должен соответствовать
+	if jwk == nil || jwk.Alg != head.Alg {
+		return fmt.Errorf("%w: unknown key %q", ErrInvalidToken, head.Kid)
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch jwk.Alg {
+	case domain.ALG_RS256:
+		pub, err := rsaPublicKey(*jwk)
+		if err != nil {
+			return err
+		}
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	case domain.ALG_ES256:
+		pub, err := ecPublicKey(*jwk)
+		if err != nil {
+			return err
+		}
+		if len(signature) != 2*ecSize {
+			return fmt.Errorf("%w: bad signature length", ErrInvalidToken)
+		}
+		r, s := new(big.Int).SetBytes(signature[:ecSize]), new(big.Int).SetBytes(signature[ecSize:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedAlg, jwk.Alg)
+	}
+
+	err = decodeJSON(parts[1], claims)
+	if err != nil {
+		return fmt.Errorf("%w: payload: %v", ErrInvalidToken, err)
+	}
+
+	return nil
+}
+
+// rsaPublicKey восстанавливает ключ RSA из JWK
+func rsaPublicKey(jwk domain.Jwk) (*rsa.PublicKey, error) {
+	n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+	e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+	if jwk.Kty != "RSA" || errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+		return nil, fmt.Errorf("%w: invalid rsa key", ErrInvalidToken)
+	}
+
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
+
+// ecPublicKey восстанавливает ключ P-256 из JWK
+func ecPublicKey(jwk domain.Jwk) (*ecdsa.PublicKey, error) {
+	x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+	y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if jwk.Kty != "EC" || jwk.Crv != "P-256" || errX != nil || errY != nil {
+		return nil, fmt.Errorf("%w: invalid ec key", ErrInvalidToken)
+	}
+
+	key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !key.Curve.IsOnCurve(key.X, key.Y) {
+		return nil, fmt.Errorf("%w: point is not on curve", ErrInvalidToken)
+	}
+
+	return key, nil
+}
+
+// encode кодирует данные в base64url без выравнивания
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeJSON декодирует часть JWT
+func decodeJSON(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,76 @@
+package jose
+
+import (
+	"strings"
+	"testing"
+	"user/internal/domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тест подписи: токен RS256 и ES256 проверяется опубликованным ключом, а чужим ключом или после изменения - нет
+func TestSignVerify(t *testing.T) {
+	claims := domain.IdTokenClaims{Issuer: "https://id.example.com", UserInfo: domain.UserInfo{Subject: "3"}, Audience: "client", Expiry: 2000000000, Nonce: "n"}
+
+	for _, alg := range []string{domain.ALG_RS256, domain.ALG_ES256} {
+		t.Run(alg, func(t *testing.T) {
+			key, err := GenerateKey(alg)
+			require.NoError(t, err)
+
+			// Ключ переживает сохранение в PKCS #8
+			data, err := MarshalKey(key)
+			require.NoError(t, err)
+			key, err = ParseKey(data)
+			require.NoError(t, err)
+
+			jwk, err := PublicJwk("k1", alg, key)
+			require.NoError(t, err)
+
+			other, err := GenerateKey(alg)
+			require.NoError(t, err)
+			otherJwk, err := PublicJwk("k1", alg, other)
+			require.NoError(t, err)
+
+			token, err := Sign(key, alg, "k1", claims)
+			require.NoError(t, err)
+			assert.Len(t, strings.Split(token, "."), 3)
+
+			var parsed domain.IdTokenClaims
+			require.NoError(t, Verify(token, []domain.Jwk{jwk}, &parsed))
+			assert.Equal(t, claims, parsed)
+
+			assert.ErrorIs(t, Verify(token, []domain.Jwk{otherJwk}, &parsed), ErrInvalidToken)
+			assert.ErrorIs(t, Verify(token, nil, &parsed), ErrInvalidToken)
+
+			parts := strings.Split(token, ".")
+			forged, err := Sign(key, alg, "k1", domain.IdTokenClaims{UserInfo: domain.UserInfo{Subject: "1"}})
+			require.NoError(t, err)
+			tampered := parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2]
+			assert.ErrorIs(t, Verify(tampered, []domain.Jwk{jwk}, &parsed), ErrInvalidToken)
+		})
+	}
+}
+
+// Тест алгоритмов: ключ не подписывает и не публикуется под чужим алгоритмом
+func TestAlgMismatch(t *testing.T) {
+	key, err := GenerateKey(domain.ALG_RS256)
+	require.NoError(t, err)
+
+	_, err = Sign(key, domain.ALG_ES256, "k1", domain.IdTokenClaims{})
+	assert.ErrorIs(t, err, ErrUnsupportedAlg)
+
+	_, err = PublicJwk("k1", domain.ALG_ES256, key)
+	assert.ErrorIs(t, err, ErrUnsupportedAlg)
+
+	_, err = GenerateKey("none")
+	assert.ErrorIs(t, err, ErrUnsupportedAlg)
+
+	// Токен с alg, отличным от ключа, отклоняется
+	jwk, err := PublicJwk("k1", domain.ALG_RS256, key)
+	require.NoError(t, err)
+	token, err := Sign(key, domain.ALG_RS256, "k1", domain.IdTokenClaims{})
+	require.NoError(t, err)
+	jwk.Alg = domain.ALG_ES256
+	assert.ErrorIs(t, Verify(token, []domain.Jwk{jwk}, &domain.IdTokenClaims{}), ErrInvalidToken)
+}
@@ -0,0 +1,2 @@
+-- Удаление таблицы sessions, если она существует
+DROP TABLE IF EXISTS sessions;
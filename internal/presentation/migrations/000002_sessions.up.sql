@@ -0,0 +1,13 @@
+-- Создание таблицы sessions
+CREATE TABLE sessions (
+    id                  SERIAL PRIMARY KEY,                                     -- Идентификатор сессии
+    user_id             INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE, -- Владелец сессии
+    access_hash         VARCHAR(64) NOT NULL UNIQUE,                            -- Хэш access токена
+    refresh_hash        VARCHAR(64) NOT NULL UNIQUE,                            -- Хэш refresh токена
+    access_expires_at   TIMESTAMPTZ NOT NULL,                                   -- Срок действия access токена
+    refresh_expires_at  TIMESTAMPTZ NOT NULL,                                   -- Срок действия refresh токена
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),                     -- Время входа
+    revoked_at          TIMESTAMPTZ                                             -- Время отзыва сессии
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
@@ -0,0 +1,2 @@
+-- Возврат колонки password к прежнему размеру
+ALTER TABLE users ALTER COLUMN password TYPE VARCHAR(255);
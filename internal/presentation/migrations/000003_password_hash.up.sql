@@ -0,0 +1,2 @@
+-- Расширение колонки password под хэши в формате PHC
+ALTER TABLE users ALTER COLUMN password TYPE TEXT;
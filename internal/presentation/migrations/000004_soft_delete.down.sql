@@ -0,0 +1,3 @@
+-- Удаление колонок мягкого удаления и признака администратора
+ALTER TABLE users DROP COLUMN IF EXISTS is_admin;
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,3 @@
+-- Мягкое удаление пользователей и признак администратора
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMPTZ;                    -- Время мягкого удаления
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;   -- Признак администратора
@@ -0,0 +1,8 @@
+-- Удаление индексов выборки и времени создания пользователя
+DROP INDEX IF EXISTS users_created_at_id_idx;
+DROP INDEX IF EXISTS users_birthday_id_idx;
+DROP INDEX IF EXISTS users_last_name_id_idx;
+DROP INDEX IF EXISTS users_first_name_id_idx;
+DROP INDEX IF EXISTS users_login_id_idx;
+DROP INDEX IF EXISTS users_login_prefix_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,10 @@
+-- Время создания пользователя
+ALTER TABLE users ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+-- Индексы для фильтрации и постраничной выборки пользователей
+CREATE INDEX users_login_prefix_idx ON users (login text_pattern_ops);
+CREATE INDEX users_login_id_idx ON users ((COALESCE(login, '')), id);
+CREATE INDEX users_first_name_id_idx ON users ((COALESCE(first_name, '')), id);
+CREATE INDEX users_last_name_id_idx ON users ((COALESCE(last_name, '')), id);
+CREATE INDEX users_birthday_id_idx ON users ((COALESCE(birthday, DATE '0001-01-01')), id);
+CREATE INDEX users_created_at_id_idx ON users (created_at, id);
@@ -0,0 +1,2 @@
+-- Удаление версии пользователя
+ALTER TABLE users DROP COLUMN IF EXISTS version;
@@ -0,0 +1,2 @@
+-- Версия пользователя для оптимистичных блокировок
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
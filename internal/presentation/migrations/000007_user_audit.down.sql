@@ -0,0 +1,2 @@
+-- Удаление журнала изменений пользователей
+DROP TABLE IF EXISTS user_audit;
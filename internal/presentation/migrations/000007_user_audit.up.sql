@@ -0,0 +1,13 @@
+-- Создание журнала изменений пользователей
+-- Внешнего ключа на users нет, чтобы журнал сохранялся после безвозвратного удаления
+CREATE TABLE user_audit (
+    id          BIGSERIAL PRIMARY KEY,                  -- Идентификатор записи
+    user_id     INTEGER NOT NULL,                       -- Измененный пользователь
+    actor_id    INTEGER,                                -- Пользователь, выполнивший изменение
+    request_id  VARCHAR(64) NOT NULL DEFAULT '',        -- Идентификатор запроса
+    action      VARCHAR(32) NOT NULL,                   -- Действие
+    diff        JSONB NOT NULL,                         -- Изменения полей
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()      -- Время изменения
+);
+
+CREATE INDEX user_audit_user_id_idx ON user_audit (user_id, id);
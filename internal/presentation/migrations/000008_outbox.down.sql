@@ -0,0 +1,2 @@
+-- Удаление таблицы исходящих событий
+DROP TABLE IF EXISTS outbox;
@@ -0,0 +1,15 @@
+-- Создание таблицы исходящих событий (transactional outbox)
+CREATE TABLE outbox (
+    id               BIGSERIAL PRIMARY KEY,                 -- Идентификатор события
+    event_type       VARCHAR(64) NOT NULL,                  -- Тип события (user.created, ...)
+    user_id          INTEGER NOT NULL,                      -- Пользователь, к которому относится событие
+    payload          JSONB NOT NULL,                        -- Данные события
+    request_id       VARCHAR(64) NOT NULL DEFAULT '',       -- Идентификатор запроса
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),    -- Время события
+    attempts         INTEGER NOT NULL DEFAULT 0,            -- Количество неудачных попыток доставки
+    next_attempt_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),    -- Время следующей попытки
+    last_error       TEXT,                                  -- Последняя ошибка доставки
+    published_at     TIMESTAMPTZ                            -- Время успешной доставки
+);
+
+CREATE INDEX outbox_pending_idx ON outbox (next_attempt_at, id) WHERE published_at IS NULL;
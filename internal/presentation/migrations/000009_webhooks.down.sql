@@ -0,0 +1,4 @@
+-- Удаление подписок на события и истории доставок
+DROP TABLE IF EXISTS webhook_attempts;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
@@ -0,0 +1,39 @@
+-- Создание подписок на события пользователей
+CREATE TABLE webhooks (
+    id          SERIAL PRIMARY KEY,                     -- Идентификатор подписки
+    url         TEXT NOT NULL,                          -- Адрес получателя
+    events      TEXT[] NOT NULL DEFAULT '{}',           -- Типы событий, пустой список - все события
+    secret      TEXT NOT NULL,                          -- Ключ подписи HMAC
+    owner_id    INTEGER,                                -- Пользователь, создавший подписку
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()      -- Время создания
+);
+
+-- Создание доставок событий подпискам
+CREATE TABLE webhook_deliveries (
+    id               BIGSERIAL PRIMARY KEY,                                     -- Идентификатор доставки
+    webhook_id       INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE, -- Подписка
+    event_id         BIGINT NOT NULL,                                           -- Событие из outbox
+    event_type       VARCHAR(64) NOT NULL,                                      -- Тип события
+    body             JSONB NOT NULL,                                            -- Тело запроса
+    status           VARCHAR(16) NOT NULL DEFAULT 'pending',                    -- pending, succeeded, dead
+    attempts         INTEGER NOT NULL DEFAULT 0,                                -- Количество неудачных попыток
+    next_attempt_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),                        -- Время следующей попытки
+    last_error       TEXT,                                                      -- Последняя ошибка
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),                        -- Время создания
+    delivered_at     TIMESTAMPTZ,                                               -- Время успешной доставки
+    UNIQUE (webhook_id, event_id)
+);
+
+CREATE INDEX webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at, id) WHERE status = 'pending';
+
+-- Создание истории попыток доставки
+CREATE TABLE webhook_attempts (
+    id            BIGSERIAL PRIMARY KEY,                                                 -- Идентификатор попытки
+    delivery_id   BIGINT NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,   -- Доставка
+    status_code   INTEGER,                                                               -- Код ответа получателя
+    error         TEXT,                                                                  -- Ошибка попытки
+    duration_ms   BIGINT NOT NULL,                                                       -- Длительность запроса
+    attempted_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()                                     -- Время попытки
+);
+
+CREATE INDEX webhook_attempts_delivery_id_idx ON webhook_attempts (delivery_id, id);
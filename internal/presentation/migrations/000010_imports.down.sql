@@ -0,0 +1,2 @@
+-- Удаление задач импорта, загруженные пользователи остаются
+DROP TABLE IF EXISTS imports;
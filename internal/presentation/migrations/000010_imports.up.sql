@@ -0,0 +1,16 @@
+-- Создание задач импорта пользователей
+CREATE TABLE imports (
+    id           SERIAL PRIMARY KEY,                     -- Идентификатор задачи
+    format       VARCHAR(16) NOT NULL,                   -- csv или ndjson
+    dry_run      BOOLEAN NOT NULL DEFAULT FALSE,         -- Проверка без записи
+    status       VARCHAR(16) NOT NULL DEFAULT 'running', -- running, done, failed
+    total        INTEGER NOT NULL DEFAULT 0,             -- Количество строк файла
+    created      INTEGER NOT NULL DEFAULT 0,             -- Созданные пользователи
+    updated      INTEGER NOT NULL DEFAULT 0,             -- Обновленные пользователи
+    failed       INTEGER NOT NULL DEFAULT 0,             -- Незагруженные строки
+    errors       JSONB NOT NULL DEFAULT '[]',            -- Отчет по незагруженным строкам
+    error        TEXT,                                   -- Ошибка, остановившая импорт
+    created_by   INTEGER,                                -- Пользователь, запустивший импорт
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),     -- Время запуска
+    finished_at  TIMESTAMPTZ                             -- Время завершения
+);
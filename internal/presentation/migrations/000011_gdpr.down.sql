@@ -0,0 +1,3 @@
+-- Удаление согласий и записей об удалении, обезличенные данные не восстанавливаются
+DROP TABLE IF EXISTS user_erasures;
+DROP TABLE IF EXISTS user_consents;
@@ -0,0 +1,21 @@
+-- Создание согласий пользователей на обработку данных
+CREATE TABLE user_consents (
+    user_id     INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,   -- Пользователь
+    purpose     VARCHAR(64) NOT NULL,                                       -- Цель обработки
+    granted     BOOLEAN NOT NULL,                                           -- Согласие дано или отозвано
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),                         -- Время последнего изменения
+    PRIMARY KEY (user_id, purpose)
+);
+
+-- Создание записей об удалении персональных данных
+-- Внешнего ключа на users нет, чтобы запись сохранялась после безвозвратного удаления
+-- Персональных данных запись не содержит
+CREATE TABLE user_erasures (
+    user_id        INTEGER PRIMARY KEY,                    -- Обезличенный пользователь
+    actor_id       INTEGER,                                -- Пользователь, выполнивший удаление
+    request_id     VARCHAR(64) NOT NULL DEFAULT '',        -- Идентификатор запроса
+    audit_entries  INTEGER NOT NULL DEFAULT 0,             -- Обезличенные записи журнала
+    sessions       INTEGER NOT NULL DEFAULT 0,             -- Удаленные сессии
+    consents       INTEGER NOT NULL DEFAULT 0,             -- Удаленные согласия
+    erased_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()      -- Время удаления
+);
@@ -0,0 +1,19 @@
+-- Отмена шифрования персональных данных
+-- Postgres не может расшифровать значения, откат возможен только после их расшифровки приложением
+DROP INDEX IF EXISTS users_key_id_idx;
+DROP INDEX IF EXISTS users_login_index_key;
+ALTER TABLE users DROP COLUMN IF EXISTS key_id;
+ALTER TABLE users DROP COLUMN IF EXISTS login_index;
+
+ALTER TABLE users
+    ALTER COLUMN first_name TYPE VARCHAR(255),
+    ALTER COLUMN last_name TYPE VARCHAR(255),
+    ALTER COLUMN birthday TYPE DATE USING birthday::date,
+    ALTER COLUMN login TYPE VARCHAR(255);
+ALTER TABLE users ADD CONSTRAINT users_login_key UNIQUE (login);
+
+CREATE INDEX users_login_prefix_idx ON users (login text_pattern_ops);
+CREATE INDEX users_login_id_idx ON users ((COALESCE(login, '')), id);
+CREATE INDEX users_first_name_id_idx ON users ((COALESCE(first_name, '')), id);
+CREATE INDEX users_last_name_id_idx ON users ((COALESCE(last_name, '')), id);
+CREATE INDEX users_birthday_id_idx ON users ((COALESCE(birthday, DATE '0001-01-01')), id);
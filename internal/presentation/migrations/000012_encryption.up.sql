@@ -0,0 +1,21 @@
+-- Шифрование персональных данных пользователей
+-- Индексы по открытым значениям теряют смысл, фильтры и сортировка по этим полям выполняются после расшифровки
+DROP INDEX IF EXISTS users_login_prefix_idx;
+DROP INDEX IF EXISTS users_login_id_idx;
+DROP INDEX IF EXISTS users_first_name_id_idx;
+DROP INDEX IF EXISTS users_last_name_id_idx;
+DROP INDEX IF EXISTS users_birthday_id_idx;
+
+-- Зашифрованные значения длиннее исходных, дата рождения тоже хранится строкой
+ALTER TABLE users
+    ALTER COLUMN first_name TYPE TEXT,
+    ALTER COLUMN last_name TYPE TEXT,
+    ALTER COLUMN birthday TYPE TEXT USING birthday::text,
+    ALTER COLUMN login TYPE TEXT;
+
+-- Уникальность логина переносится на слепой индекс: шифротексты одного email различаются
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_login_key;
+ALTER TABLE users ADD COLUMN login_index VARCHAR(64);   -- HMAC логина
+ALTER TABLE users ADD COLUMN key_id VARCHAR(32);        -- Ключ, которым зашифрована строка, NULL - строка еще не зашифрована
+CREATE UNIQUE INDEX users_login_index_key ON users (login_index);
+CREATE INDEX users_key_id_idx ON users (key_id);
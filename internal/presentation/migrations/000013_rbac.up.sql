@@ -0,0 +1,57 @@
+-- Создание ролей и прав доступа
+CREATE TABLE roles (
+    name         VARCHAR(32) PRIMARY KEY,        -- Название роли
+    description  TEXT NOT NULL DEFAULT ''         -- Описание роли
+);
+
+CREATE TABLE permissions (
+    name         VARCHAR(64) PRIMARY KEY,        -- Название права вида ресурс:действие:область
+    description  TEXT NOT NULL DEFAULT ''         -- Описание права
+);
+
+CREATE TABLE role_permissions (
+    role         VARCHAR(32) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,        -- Роль
+    permission   VARCHAR(64) NOT NULL REFERENCES permissions(name) ON DELETE CASCADE,  -- Право роли
+    PRIMARY KEY (role, permission)
+);
+
+-- Роль self не назначается: она есть у каждого пользователя
+CREATE TABLE user_roles (
+    user_id      INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,     -- Пользователь
+    role         VARCHAR(32) NOT NULL REFERENCES roles(name) ON DELETE CASCADE, -- Назначенная роль
+    granted_by   INTEGER REFERENCES users(id) ON DELETE SET NULL,             -- Кто назначил роль, NULL - миграция
+    granted_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),                          -- Время назначения
+    PRIMARY KEY (user_id, role)
+);
+CREATE INDEX user_roles_role_idx ON user_roles (role);
+
+INSERT INTO roles (name, description) VALUES
+    ('admin', 'Полный доступ'),
+    ('support', 'Просмотр любых пользователей'),
+    ('self', 'Доступ к своему профилю, есть у каждого пользователя');
+
+INSERT INTO permissions (name, description) VALUES
+    ('users:read:self', 'Чтение своего профиля, журнала и выгрузки данных'),
+    ('users:read:any', 'Чтение и поиск любых пользователей'),
+    ('users:write:self', 'Изменение своего профиля и согласий'),
+    ('users:write:any', 'Изменение любых пользователей'),
+    ('users:delete:self', 'Удаление своего профиля'),
+    ('users:delete:any', 'Удаление любых пользователей'),
+    ('users:manage', 'Восстановление, безвозвратное удаление, обезличивание, импорт и выгрузка'),
+    ('webhooks:manage', 'Управление подписками на события'),
+    ('roles:manage', 'Назначение и отзыв ролей');
+
+INSERT INTO role_permissions (role, permission)
+SELECT 'admin', name FROM permissions;
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('support', 'users:read:any'),
+    ('self', 'users:read:self'),
+    ('self', 'users:write:self'),
+    ('self', 'users:delete:self');
+
+-- Признак администратора переносится в роль admin
+INSERT INTO user_roles (user_id, role)
+SELECT id, 'admin' FROM users WHERE is_admin;
+
+ALTER TABLE users DROP COLUMN is_admin;
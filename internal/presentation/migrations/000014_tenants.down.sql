@@ -0,0 +1,19 @@
+-- Удаление организаций
+-- Откат возможен, только если email уникален среди всех организаций
+DELETE FROM role_permissions WHERE role = 'operator';
+DELETE FROM roles WHERE name = 'operator';
+DELETE FROM permissions WHERE name = 'organizations:manage';
+
+ALTER TABLE user_erasures DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE imports DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE webhooks DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE outbox DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE user_audit DROP COLUMN IF EXISTS tenant_id;
+
+DROP INDEX IF EXISTS users_tenant_created_at_id_idx;
+DROP INDEX IF EXISTS users_tenant_login_index_key;
+CREATE UNIQUE INDEX users_login_index_key ON users (login_index);
+ALTER TABLE users DROP COLUMN IF EXISTS tenant_id;
+
+DROP TABLE IF EXISTS memberships;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,53 @@
+-- Создание организаций (арендаторов)
+CREATE TABLE organizations (
+    id          SERIAL PRIMARY KEY,                     -- Идентификатор организации
+    slug        VARCHAR(64) NOT NULL UNIQUE,            -- Короткое имя организации
+    name        VARCHAR(255) NOT NULL,                  -- Название организации
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()      -- Время создания
+);
+
+-- Организация по умолчанию получает всех существующих пользователей
+INSERT INTO organizations (id, slug, name) VALUES (1, 'default', 'Default');
+SELECT setval(pg_get_serial_sequence('organizations', 'id'), 1);
+
+-- Членство дает пользователю доступ к пользователям другой организации
+-- Доступ к своей организации есть всегда, он в memberships не записывается
+CREATE TABLE memberships (
+    organization_id  INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE, -- Организация
+    user_id          INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,         -- Участник
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),                              -- Время добавления
+    PRIMARY KEY (organization_id, user_id)
+);
+CREATE INDEX memberships_user_id_idx ON memberships (user_id);
+
+-- Пользователь принадлежит одной организации, email уникален в ее пределах
+ALTER TABLE users ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1 REFERENCES organizations(id);
+ALTER TABLE users ALTER COLUMN tenant_id DROP DEFAULT;
+DROP INDEX IF EXISTS users_login_index_key;
+CREATE UNIQUE INDEX users_tenant_login_index_key ON users (tenant_id, login_index);
+CREATE INDEX users_tenant_created_at_id_idx ON users (tenant_id, created_at, id);
+
+-- Журнал, события, подписки, импорт и записи об удалении хранят организацию,
+-- чтобы они оставались в ее пределах и после безвозвратного удаления пользователя
+ALTER TABLE user_audit ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE user_audit ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE outbox ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE outbox ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE webhooks ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1 REFERENCES organizations(id) ON DELETE CASCADE;
+ALTER TABLE webhooks ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE imports ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1 REFERENCES organizations(id) ON DELETE CASCADE;
+ALTER TABLE imports ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE user_erasures ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE user_erasures ALTER COLUMN tenant_id DROP DEFAULT;
+
+-- Управление организациями доступно роли operator, роль admin действует в пределах своей организации
+INSERT INTO permissions (name, description) VALUES
+    ('organizations:manage', 'Создание организаций и управление членством');
+INSERT INTO roles (name, description) VALUES
+    ('operator', 'Управление организациями');
+INSERT INTO role_permissions (role, permission) VALUES
+    ('operator', 'organizations:manage');
+
+-- Администраторы, работавшие до появления организаций, управляют и организациями
+INSERT INTO user_roles (user_id, role)
+SELECT user_id, 'operator' FROM user_roles WHERE role = 'admin';
@@ -0,0 +1,3 @@
+-- Удаление подтверждения email
+DROP TABLE IF EXISTS email_verifications;
+ALTER TABLE users DROP COLUMN IF EXISTS email_verified_at;
@@ -0,0 +1,17 @@
+-- Время подтверждения email, NULL - email не подтвержден
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+
+-- Пользователи, созданные до появления подтверждения, считаются подтвержденными
+UPDATE users SET email_verified_at = created_at;
+
+-- Выданные токены подтверждения email
+-- Сам токен не хранится: он подписан, а строка нужна для однократного использования
+CREATE TABLE email_verifications (
+    id           SERIAL PRIMARY KEY,                                      -- Идентификатор токена, входит в подпись
+    user_id      INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,  -- Пользователь
+    login_index  VARCHAR(64) NOT NULL,                                     -- Слепой индекс email, на который отправлено письмо
+    expires_at   TIMESTAMPTZ NOT NULL,                                     -- Время истечения токена
+    used_at      TIMESTAMPTZ,                                              -- Время использования, токен действует один раз
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()                        -- Время выдачи
+);
+CREATE INDEX email_verifications_user_id_idx ON email_verifications (user_id);
@@ -0,0 +1,2 @@
+-- Удаление токенов сброса пароля
+DROP TABLE IF EXISTS password_resets;
@@ -0,0 +1,11 @@
+-- Выданные токены сброса пароля
+-- Токен хранится только хэшем, по утечке таблицы сбросить пароль нельзя
+CREATE TABLE password_resets (
+    id          SERIAL PRIMARY KEY,                                      -- Идентификатор
+    user_id     INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,  -- Пользователь
+    token_hash  VARCHAR(64) NOT NULL UNIQUE,                              -- SHA-256 токена
+    expires_at  TIMESTAMPTZ NOT NULL,                                     -- Время истечения токена
+    used_at     TIMESTAMPTZ,                                              -- Время использования, токен действует один раз
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()                        -- Время выдачи
+);
+CREATE INDEX password_resets_user_id_idx ON password_resets (user_id);
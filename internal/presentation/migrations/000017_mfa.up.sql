@@ -0,0 +1,31 @@
+-- TOTP второго фактора, строка без confirmed_at - начатое, но не подтвержденное подключение
+CREATE TABLE user_mfa (
+    user_id       INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,  -- Пользователь
+    secret        TEXT NOT NULL,                                               -- Секрет TOTP, зашифрован ключом из связки
+    key_id        VARCHAR(64) NOT NULL,                                        -- Ключ, которым зашифрован секрет
+    last_step     BIGINT NOT NULL DEFAULT 0,                                   -- Последний принятый шаг TOTP, код не принимается повторно
+    confirmed_at  TIMESTAMPTZ,                                                 -- Время подтверждения, NULL - MFA не включена
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()                           -- Время подключения
+);
+CREATE INDEX user_mfa_key_id_idx ON user_mfa (key_id);
+
+-- Одноразовые коды восстановления, хранятся хэшами
+CREATE TABLE mfa_recovery_codes (
+    id         SERIAL PRIMARY KEY,                                      -- Идентификатор
+    user_id    INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,  -- Пользователь
+    code_hash  VARCHAR(64) NOT NULL,                                     -- SHA-256 кода
+    used_at    TIMESTAMPTZ,                                              -- Время использования
+    UNIQUE (user_id, code_hash)
+);
+
+-- Первый шаг входа пользователя с MFA, пара токенов выдается после проверки кода
+CREATE TABLE mfa_challenges (
+    id          SERIAL PRIMARY KEY,                                      -- Идентификатор
+    user_id     INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,  -- Пользователь
+    token_hash  VARCHAR(64) NOT NULL UNIQUE,                              -- SHA-256 токена второго шага
+    attempts    INTEGER NOT NULL DEFAULT 0,                               -- Количество проверок кода
+    expires_at  TIMESTAMPTZ NOT NULL,                                     -- Время истечения
+    used_at     TIMESTAMPTZ,                                              -- Время успешного входа
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()                        -- Время выдачи
+);
+CREATE INDEX mfa_challenges_user_id_idx ON mfa_challenges (user_id);
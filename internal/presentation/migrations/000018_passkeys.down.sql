@@ -0,0 +1,3 @@
+-- Удаление ключей WebAuthn
+DROP TABLE IF EXISTS webauthn_challenges;
+DROP TABLE IF EXISTS webauthn_credentials;
@@ -0,0 +1,25 @@
+-- Ключи WebAuthn пользователей
+CREATE TABLE webauthn_credentials (
+    id             SERIAL PRIMARY KEY,                                      -- Идентификатор
+    user_id        INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,  -- Пользователь
+    credential_id  BYTEA NOT NULL UNIQUE,                                    -- Идентификатор ключа у аутентификатора
+    public_key     BYTEA NOT NULL,                                           -- Открытый ключ в формате COSE_Key
+    sign_count     BIGINT NOT NULL DEFAULT 0,                                -- Последний счетчик подписей
+    aaguid         BYTEA NOT NULL,                                           -- Модель аутентификатора
+    name           VARCHAR(64) NOT NULL,                                     -- Название, данное пользователем
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),                       -- Время регистрации
+    last_used_at   TIMESTAMPTZ                                               -- Время последнего входа
+);
+CREATE INDEX webauthn_credentials_user_id_idx ON webauthn_credentials (user_id);
+
+-- Challenge начатых церемоний WebAuthn, хранится хэшем и используется один раз
+CREATE TABLE webauthn_challenges (
+    id              SERIAL PRIMARY KEY,                                      -- Идентификатор
+    challenge_hash  VARCHAR(64) NOT NULL UNIQUE,                              -- SHA-256 challenge в base64url
+    kind            VARCHAR(16) NOT NULL,                                     -- registration или login
+    user_id         INTEGER REFERENCES users(id) ON DELETE CASCADE,           -- Пользователь регистрации, NULL для входа
+    tenant_id       INTEGER NOT NULL REFERENCES organizations(id),            -- Организация церемонии
+    expires_at      TIMESTAMPTZ NOT NULL,                                     -- Время истечения
+    used_at         TIMESTAMPTZ,                                              -- Время использования
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()                        -- Время выдачи
+);
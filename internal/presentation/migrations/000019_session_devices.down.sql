@@ -0,0 +1,9 @@
+-- Удаление устройства и активности сессий
+DROP INDEX IF EXISTS sessions_key_id_idx;
+
+ALTER TABLE sessions
+    DROP COLUMN IF EXISTS device,
+    DROP COLUMN IF EXISTS ip,
+    DROP COLUMN IF EXISTS user_agent,
+    DROP COLUMN IF EXISTS key_id,
+    DROP COLUMN IF EXISTS last_seen_at;
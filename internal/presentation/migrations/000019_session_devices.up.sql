@@ -0,0 +1,12 @@
+-- Устройство и последняя активность сессий
+-- Адрес и User-Agent - персональные данные, поэтому хранятся зашифрованными
+ALTER TABLE sessions
+    ADD COLUMN device        VARCHAR(128) NOT NULL DEFAULT '',  -- Браузер и система, определенные по User-Agent
+    ADD COLUMN ip            TEXT,                              -- Последний адрес клиента, зашифрован ключом из связки
+    ADD COLUMN user_agent    TEXT,                              -- Последний User-Agent клиента, зашифрован ключом из связки
+    ADD COLUMN key_id        VARCHAR(64),                       -- Ключ, которым зашифрованы адрес и User-Agent
+    ADD COLUMN last_seen_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(); -- Последний запрос, переносится из Redis не чаще SESSION_FLUSH_INTERVAL
+
+UPDATE sessions SET last_seen_at = created_at;
+
+CREATE INDEX sessions_key_id_idx ON sessions (key_id);
@@ -0,0 +1,6 @@
+-- Удаление провайдера OAuth2 / OpenID Connect
+DELETE FROM permissions WHERE name = 'oauth:clients:manage';
+DROP TABLE IF EXISTS oauth_signing_keys;
+DROP TABLE IF EXISTS oauth_tokens;
+DROP TABLE IF EXISTS oauth_codes;
+DROP TABLE IF EXISTS oauth_clients;
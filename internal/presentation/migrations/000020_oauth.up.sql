@@ -0,0 +1,67 @@
+-- Клиенты OAuth2 / OpenID Connect организации
+-- Секрет конфиденциального клиента хранится только хэшем, у публичного клиента секрета нет
+CREATE TABLE oauth_clients (
+    id             SERIAL PRIMARY KEY,                                                  -- Идентификатор
+    tenant_id      INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,     -- Организация клиента
+    client_id      VARCHAR(64) NOT NULL UNIQUE,                                         -- Публичный идентификатор клиента
+    secret_hash    VARCHAR(64),                                                         -- SHA-256 секрета, NULL - публичный клиент
+    name           VARCHAR(255) NOT NULL,                                               -- Название, показывается пользователю
+    redirect_uris  TEXT[] NOT NULL DEFAULT '{}',                                        -- Допустимые адреса возврата, сравниваются точно
+    grant_types    TEXT[] NOT NULL DEFAULT '{}',                                        -- Разрешенные типы грантов
+    scopes         TEXT[] NOT NULL DEFAULT '{}',                                        -- Разрешенные области доступа
+    id_token_alg   VARCHAR(8) NOT NULL DEFAULT 'RS256',                                 -- Алгоритм подписи ID токена
+    owner_id       INTEGER REFERENCES users(id) ON DELETE SET NULL,                     -- Кто зарегистрировал клиента
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()                                   -- Время регистрации
+);
+CREATE INDEX oauth_clients_tenant_id_idx ON oauth_clients (tenant_id);
+
+-- Коды авторизации, хранятся хэшем и обмениваются на токены один раз
+CREATE TABLE oauth_codes (
+    id              SERIAL PRIMARY KEY,                                                 -- Идентификатор
+    code_hash       VARCHAR(64) NOT NULL UNIQUE,                                        -- SHA-256 кода
+    client_id       INTEGER NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,    -- Клиент
+    user_id         INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,            -- Пользователь, разрешивший доступ
+    redirect_uri    TEXT NOT NULL,                                                      -- Адрес возврата запроса авторизации
+    scopes          TEXT[] NOT NULL,                                                    -- Выданные области доступа
+    nonce           TEXT NOT NULL DEFAULT '',                                           -- nonce клиента для ID токена
+    code_challenge  VARCHAR(128) NOT NULL DEFAULT '',                                   -- PKCE S256, пустое значение - без PKCE
+    expires_at      TIMESTAMPTZ NOT NULL,                                               -- Время истечения
+    used_at         TIMESTAMPTZ,                                                        -- Время обмена на токены
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()                                  -- Время выдачи
+);
+
+-- Токены доступа клиентов, хранятся хэшами, как и токены сессий
+-- Токен client_credentials выдается самому клиенту и не относится к пользователю
+CREATE TABLE oauth_tokens (
+    id                  SERIAL PRIMARY KEY,                                             -- Идентификатор
+    client_id           INTEGER NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE, -- Клиент
+    user_id             INTEGER REFERENCES users(id) ON DELETE CASCADE,                 -- Пользователь, NULL - токен клиента
+    code_id             INTEGER REFERENCES oauth_codes(id) ON DELETE SET NULL,          -- Код, по которому выдан токен
+    scopes              TEXT[] NOT NULL,                                                -- Области доступа
+    access_hash         VARCHAR(64) NOT NULL UNIQUE,                                    -- SHA-256 access токена
+    refresh_hash        VARCHAR(64) UNIQUE,                                             -- SHA-256 refresh токена, NULL - без обновления
+    access_expires_at   TIMESTAMPTZ NOT NULL,                                           -- Время истечения access токена
+    refresh_expires_at  TIMESTAMPTZ,                                                    -- Время истечения refresh токена
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),                             -- Время выдачи
+    revoked_at          TIMESTAMPTZ                                                     -- Время отзыва
+);
+CREATE INDEX oauth_tokens_user_id_idx ON oauth_tokens (user_id);
+CREATE INDEX oauth_tokens_code_id_idx ON oauth_tokens (code_id);
+
+-- Ключи подписи ID токенов
+-- Новый ключ подписывает токены, прежние публикуются в JWKS до expires_at, чтобы выданные ими токены можно было проверить
+CREATE TABLE oauth_signing_keys (
+    kid          VARCHAR(64) PRIMARY KEY,                                               -- Идентификатор ключа в JWKS
+    alg          VARCHAR(8) NOT NULL,                                                   -- RS256 или ES256
+    private_key  TEXT NOT NULL,                                                         -- Закрытый ключ PKCS #8, зашифрован ключом из связки
+    key_id       VARCHAR(64) NOT NULL,                                                  -- Ключ, которым зашифрован закрытый ключ
+    public_key   JSONB NOT NULL,                                                        -- Открытый ключ в формате JWK
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),                                    -- Время создания
+    expires_at   TIMESTAMPTZ                                                            -- Конец публикации после замены, NULL - действующий ключ
+);
+CREATE INDEX oauth_signing_keys_key_id_idx ON oauth_signing_keys (key_id);
+
+INSERT INTO permissions (name, description) VALUES
+    ('oauth:clients:manage', 'Регистрация клиентов OAuth2 и OpenID Connect');
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'oauth:clients:manage');
@@ -0,0 +1,4 @@
+-- Удаление параметра перезаписи паролей при импорте
+ALTER TABLE imports
+    DROP COLUMN IF EXISTS overwrite_password,
+    DROP COLUMN IF EXISTS passwords_kept;
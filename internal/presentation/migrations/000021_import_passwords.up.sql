@@ -0,0 +1,4 @@
+-- Повторный импорт сохраняет пароли существующих пользователей, если их перезапись не запрошена
+ALTER TABLE imports
+    ADD COLUMN overwrite_password BOOLEAN NOT NULL DEFAULT FALSE, -- Перезаписывать пароли существующих пользователей
+    ADD COLUMN passwords_kept     INTEGER NOT NULL DEFAULT 0;     -- Обновленные пользователи с прежним паролем
@@ -0,0 +1,2 @@
+-- Удаление токенов SCIM организаций
+ALTER TABLE organizations DROP COLUMN IF EXISTS scim_token_hash;
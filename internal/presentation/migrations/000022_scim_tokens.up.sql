@@ -0,0 +1,2 @@
+-- Токен SCIM привязан к организации, общий токен позволял выбрать любую организацию заголовком
+ALTER TABLE organizations ADD COLUMN scim_token_hash VARCHAR(64) UNIQUE; -- SHA-256 токена SCIM, NULL - SCIM отключен
@@ -0,0 +1,2 @@
+-- Удаление индекса доставленных событий
+DROP INDEX IF EXISTS outbox_published_idx;
@@ -0,0 +1,2 @@
+-- Индекс для удаления доставленных событий старше срока хранения
+CREATE INDEX outbox_published_idx ON outbox (published_at) WHERE published_at IS NOT NULL;
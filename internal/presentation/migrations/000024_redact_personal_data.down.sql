@@ -0,0 +1,2 @@
+-- Скрытые персональные данные в журнале, событиях и доставках webhook не восстанавливаются
+SELECT 1;
@@ -0,0 +1,16 @@
+-- Персональные данные хранятся только зашифрованными в users:
+-- в журнале их значения заменяются на [REDACTED], из событий outbox и доставок webhook они убираются
+UPDATE user_audit a SET diff = (
+    SELECT jsonb_object_agg(key, CASE WHEN key = ANY (ARRAY['name', 'surname', 'birthday', 'email']) THEN jsonb_build_object(
+        'old', CASE WHEN COALESCE(value->'old', 'null') IN ('null', '"[ERASED]"') THEN COALESCE(value->'old', 'null') ELSE '"[REDACTED]"' END,
+        'new', CASE WHEN COALESCE(value->'new', 'null') IN ('null', '"[ERASED]"') THEN COALESCE(value->'new', 'null') ELSE '"[REDACTED]"' END
+    ) ELSE value END)
+    FROM jsonb_each(a.diff)
+)
+WHERE a.diff ?| ARRAY['name', 'surname', 'birthday', 'email'];
+
+UPDATE outbox SET payload = payload - ARRAY['name', 'surname', 'birthday', 'email']
+WHERE payload ?| ARRAY['name', 'surname', 'birthday', 'email'];
+
+UPDATE webhook_deliveries SET body = jsonb_set(body, '{payload}', (body->'payload') - ARRAY['name', 'surname', 'birthday', 'email'])
+WHERE body->'payload' ?| ARRAY['name', 'surname', 'birthday', 'email'];
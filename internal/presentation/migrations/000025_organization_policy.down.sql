@@ -0,0 +1,2 @@
+-- Удаление политики организации для анонимных запросов
+ALTER TABLE organizations DROP COLUMN IF EXISTS allow_signup, DROP COLUMN IF EXISTS allow_login;
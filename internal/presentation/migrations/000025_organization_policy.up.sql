@@ -0,0 +1,7 @@
+-- Политика организации для анонимных запросов с X-Tenant-Id: регистрация и вход запрещены, пока их не разрешат явно
+ALTER TABLE organizations
+    ADD COLUMN allow_signup BOOLEAN NOT NULL DEFAULT FALSE,    -- Анонимная регистрация
+    ADD COLUMN allow_login  BOOLEAN NOT NULL DEFAULT FALSE;    -- Анонимный вход и восстановление доступа
+
+-- Организация по умолчанию обслуживает анонимные запросы и без заголовка
+UPDATE organizations SET allow_signup = TRUE, allow_login = TRUE WHERE id = 1;
@@ -0,0 +1,341 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	MERGE_PATCH = "application/merge-patch+json"
+	JSON_PATCH  = "application/json-patch+json"
+)
+
+var (
+	ErrInvalidPatch = errors.New("invalid patch")
+	ErrTestFailed   = errors.New("patch test operation failed")
+)
+
+// MergePatch применяет JSON Merge Patch (RFC 7396) к документу
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var target, p any
+	err := json.Unmarshal(doc, &target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid document: %v", err)
+	}
+
+	err = json.Unmarshal(patch, &p)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	return json.Marshal(mergeValue(target, p))
+}
+
+// mergeValue рекурсивно применяет merge patch к значению
+func mergeValue(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+			continue
+		}
+		t[key] = mergeValue(t[key], value)
+	}
+
+	return t
+}
+
+// operation - операция JSON Patch
+type operation struct {
+	Op    string           `json:"op"`
+	Path  *string          `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// JSONPatch применяет JSON Patch (RFC 6902) к документу
+// Операции применяются по порядку, при ошибке документ не меняется
+func JSONPatch(doc, patch []byte) ([]byte, error) {
+	var target any
+	err := json.Unmarshal(doc, &target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid document: %v", err)
+	}
+
+	var ops []operation
+	err = json.Unmarshal(patch, &ops)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	for i, op := range ops {
+		target, err = apply(target, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+// apply применяет одну операцию JSON Patch
+func apply(doc any, op operation) (any, error) {
+	if op.Path == nil {
+		return nil, fmt.Errorf("%w: path is required", ErrInvalidPatch)
+	}
+
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: value is required", ErrInvalidPatch)
+		}
+
+		var value any
+		err = json.Unmarshal(*op.Value, &value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+
+		switch op.Op {
+		case "add":
+			return add(doc, path, value)
+		case "replace":
+			doc, _, err = remove(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			return add(doc, path, value)
+		default:
+			current, err := get(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(current, value) {
+				return nil, ErrTestFailed
+			}
+			return doc, nil
+		}
+	case "remove":
+		doc, _, err = remove(doc, path)
+		return doc, err
+	case "move", "copy":
+		if op.From == nil {
+			return nil, fmt.Errorf("%w: from is required", ErrInvalidPatch)
+		}
+
+		from, err := parsePointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		var value any
+		if op.Op == "move" {
+			if isPrefix(from, path) && len(from) < len(path) {
+				return nil, fmt.Errorf("%w: cannot move value into its child", ErrInvalidPatch)
+			}
+			doc, value, err = remove(doc, from)
+		} else {
+			value, err = get(doc, from)
+			value = deepCopy(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return add(doc, path, value)
+	default:
+		return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidPatch, op.Op)
+	}
+}
+
+// parsePointer разбирает JSON Pointer (RFC 6901)
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: invalid pointer %q", ErrInvalidPatch, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// get возвращает значение по пути
+func get(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: path %q not found", ErrInvalidPatch, token)
+			}
+			doc = value
+		case []any:
+			i, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("%w: path %q not found", ErrInvalidPatch, token)
+		}
+	}
+
+	return doc, nil
+}
+
+// add добавляет значение по пути и возвращает измененный документ
+func add(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	last := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]any:
+		node[last] = value
+		return doc, nil
+	case []any:
+		i := len(node)
+		if last != "-" {
+			i, err = arrayIndex(last, len(node))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		node = append(node, nil)
+		copy(node[i+1:], node[i:])
+		node[i] = value
+		return replaceParent(doc, path[:len(path)-1], node)
+	default:
+		return nil, fmt.Errorf("%w: path %q not found", ErrInvalidPatch, last)
+	}
+}
+
+// remove удаляет значение по пути и возвращает измененный документ и удаленное значение
+func remove(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	last := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]any:
+		value, ok := node[last]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: path %q not found", ErrInvalidPatch, last)
+		}
+		delete(node, last)
+		return doc, value, nil
+	case []any:
+		i, err := arrayIndex(last, len(node)-1)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		value := node[i]
+		node = append(node[:i:i], node[i+1:]...)
+		doc, err = replaceParent(doc, path[:len(path)-1], node)
+		return doc, value, err
+	default:
+		return nil, nil, fmt.Errorf("%w: path %q not found", ErrInvalidPatch, last)
+	}
+}
+
+// replaceParent заменяет массив по пути, так как append может создать новый срез
+func replaceParent(doc any, path []string, node []any) (any, error) {
+	if len(path) == 0 {
+		return node, nil
+	}
+
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	last := path[len(path)-1]
+	switch p := parent.(type) {
+	case map[string]any:
+		p[last] = node
+	case []any:
+		i, err := arrayIndex(last, len(p)-1)
+		if err != nil {
+			return nil, err
+		}
+		p[i] = node
+	}
+
+	return doc, nil
+}
+
+// arrayIndex разбирает индекс массива и проверяет границы
+func arrayIndex(token string, last int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid index %q", ErrInvalidPatch, token)
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > last {
+		return 0, fmt.Errorf("%w: invalid index %q", ErrInvalidPatch, token)
+	}
+
+	return i, nil
+}
+
+// isPrefix сообщает, что путь prefix является началом пути path
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// deepCopy копирует значение, чтобы copy не создавал общих ссылок
+func deepCopy(value any) any {
+	data, _ := json.Marshal(value)
+	var c any
+	_ = json.Unmarshal(data, &c)
+	return c
+}
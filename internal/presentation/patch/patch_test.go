@@ -0,0 +1,78 @@
+package patch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Тест JSON Merge Patch на примерах из RFC 7396
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		doc, patch, expected string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":"foo"}`, `["c"]`, `["c"]`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+	}
+
+	for _, test := range tests {
+		res, err := MergePatch([]byte(test.doc), []byte(test.patch))
+		assert.NoError(t, err)
+		assert.JSONEq(t, test.expected, string(res))
+	}
+
+	_, err := MergePatch([]byte(`{}`), []byte(`{`))
+	assert.ErrorIs(t, err, ErrInvalidPatch)
+}
+
+// Тест JSON Patch на примерах из RFC 6902
+func TestJSONPatch(t *testing.T) {
+	tests := []struct {
+		doc, patch, expected string
+	}{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":"baz"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"foo":{"bar":1}}`, `[{"op":"copy","from":"/foo","path":"/baz"},{"op":"replace","path":"/baz/bar","value":2}]`, `{"foo":{"bar":1},"baz":{"bar":2}}`},
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"qux"}]`, `{"baz":"qux"}`},
+		{`{"/":9,"~1":10}`, `[{"op":"replace","path":"/~01","value":1}]`, `{"/":9,"~1":1}`},
+	}
+
+	for _, test := range tests {
+		res, err := JSONPatch([]byte(test.doc), []byte(test.patch))
+		assert.NoError(t, err, test.patch)
+		assert.JSONEq(t, test.expected, string(res))
+	}
+}
+
+// Тест ошибок JSON Patch
+func TestJSONPatch_Errors(t *testing.T) {
+	_, err := JSONPatch([]byte(`{"baz":"qux"}`), []byte(`[{"op":"test","path":"/baz","value":"bar"}]`))
+	assert.ErrorIs(t, err, ErrTestFailed)
+
+	tests := []string{
+		`{"op":"add","path":"/a"}`,
+		`[{"op":"add","path":"/a/b/c","value":1}]`,
+		`[{"op":"remove","path":"/missing"}]`,
+		`[{"op":"replace","path":"/missing","value":1}]`,
+		`[{"op":"unknown","path":"/a"}]`,
+		`[{"op":"add","path":"a","value":1}]`,
+		`[{"op":"move","from":"/a","path":"/a/b"}]`,
+	}
+
+	for _, patch := range tests {
+		_, err = JSONPatch([]byte(`{"a":{}}`), []byte(patch))
+		assert.ErrorIs(t, err, ErrInvalidPatch, patch)
+	}
+}
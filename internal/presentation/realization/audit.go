@@ -0,0 +1,153 @@
+package realization
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+	"user/internal/domain"
+	"user/internal/presentation/db"
+	"user/internal/presentation/logger"
+)
+
+// AuditService читает журнал изменений пользователей
+type AuditService struct {
+	db *db.DB
+}
+
+// NewAuditService создает новый экземпляр AuditService
+func NewAuditService(db *db.DB) *AuditService {
+	return &AuditService{
+		db: db,
+	}
+}
+
+// History возвращает страницу журнала пользователя, начиная с новых записей
+// cursor - идентификатор последней записи предыдущей страницы
+func (s *AuditService) History(ctx context.Context, userId domain.Id, cursor string, limit int) (*domain.AuditPage, error) {
+	var before uint64
+	if cursor != "" {
+		var err error
+		before, err = strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			return nil, domain.ErrInvalidCursor
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	limit = listLimit(limit)
+	logger.Logger.Debug("Getting user audit...")
+	rows, err := s.db.Db.QueryContext(ctx, `SELECT id, user_id, actor_id, request_id, action, diff, created_at FROM user_audit WHERE user_id = $1 AND tenant_id = $4 AND ($2 = 0 OR id < $2) ORDER BY id DESC LIMIT $3`, userId, before, limit+1, domain.TenantFrom(ctx))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting user audit error: %v", err))
+		return nil, fmt.Errorf("getting postgres user audit error: %v", err)
+	}
+	defer rows.Close()
+
+	page := &domain.AuditPage{
+		Entries: make([]domain.AuditEntry, 0, limit),
+	}
+	for rows.Next() {
+		if len(page.Entries) == limit {
+			page.NextCursor = strconv.FormatUint(page.Entries[limit-1].Id, 10)
+			break
+		}
+
+		var (
+			entry domain.AuditEntry
+			diff  []byte
+		)
+		err = rows.Scan(&entry.Id, &entry.UserId, &entry.ActorId, &entry.RequestId, &entry.Action, &diff, &entry.CreatedAt)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning user audit error: %v", err))
+			return nil, fmt.Errorf("scanning postgres user audit error: %v", err)
+		}
+
+		err = json.Unmarshal(diff, &entry.Diff)
+		if err != nil {
+			return nil, fmt.Errorf("user audit diff unmarshalling error: %v", err)
+		}
+
+		page.Entries = append(page.Entries, entry)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting user audit error: %v", err))
+		return nil, fmt.Errorf("getting postgres user audit error: %v", err)
+	}
+
+	return page, nil
+}
+
+// writeAudit добавляет запись в журнал в рамках транзакции изменения
+// Инициатор и идентификатор запроса берутся из контекста
+func writeAudit(ctx context.Context, tx *sql.Tx, userId domain.Id, action string, diff map[string]domain.FieldChange) error {
+	data, err := json.Marshal(diff)
+	if err != nil {
+		return fmt.Errorf("user audit diff marshalling error: %v", err)
+	}
+
+	actor := domain.ActorFrom(ctx)
+	_, err = tx.ExecContext(ctx, `INSERT INTO user_audit (user_id, tenant_id, actor_id, request_id, action, diff) VALUES ($1, $2, $3, $4, $5, $6)`, userId, domain.TenantFrom(ctx), actor.UserId, actor.RequestId, action, data)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Writing user audit error: %v", err))
+		return fmt.Errorf("writing postgres user audit error: %v", err)
+	}
+
+	return nil
+}
+
+// auditFields возвращает поля пользователя, изменения которых попадают в журнал
+// nil вместо пользователя означает его отсутствие
+func auditFields(user *domain.User) map[string]any {
+	if user == nil {
+		return map[string]any{}
+	}
+
+	fields := map[string]any{
+		"name":     user.FirstName,
+		"surname":  user.LastName,
+		"birthday": nil,
+		"email":    user.Login,
+		"password": user.Password,
+	}
+
+	if user.BirthDay != nil {
+		fields["birthday"] = user.BirthDay.Format(time.DateOnly)
+	}
+
+	return fields
+}
+
+// diffFields сравнивает поля до и после изменения
+// Значения в журнал не попадают, фиксируется только факт изменения: персональные данные
+// хранятся только зашифрованными в users, а пароль не хранится в открытом виде вовсе
+func diffFields(before, after map[string]any) map[string]domain.FieldChange {
+	diff := map[string]domain.FieldChange{}
+	for _, key := range []string{"name", "surname", "birthday", "email", "password"} {
+		oldValue, hadOld := before[key]
+		newValue, hasNew := after[key]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+
+		diff[key] = domain.FieldChange{Old: redact(oldValue, hadOld), New: redact(newValue, hasNew)}
+	}
+
+	return diff
+}
+
+// redact скрывает значение поля, пустое значение остается пустым
+func redact(value any, present bool) any {
+	if !present || value == nil {
+		return nil
+	}
+
+	return domain.REDACTED
+}
@@ -0,0 +1,41 @@
+package realization
+
+import (
+	"testing"
+	"time"
+
+	"user/internal/domain"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Тест сравнения полей: в журнал попадает только факт изменения
+func TestDiffFields(t *testing.T) {
+	birthday := time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC)
+	before := domain.User{FirstName: "John", LastName: "Doe", Login: "john@example.com", Password: "old-hash"}
+	after := before
+	after.LastName = "Smith"
+	after.BirthDay = &birthday
+	after.Password = "new-hash"
+
+	diff := diffFields(auditFields(&before), auditFields(&after))
+	assert.Equal(t, map[string]domain.FieldChange{
+		"surname":  {Old: domain.REDACTED, New: domain.REDACTED},
+		"birthday": {Old: nil, New: domain.REDACTED},
+		"password": {Old: domain.REDACTED, New: domain.REDACTED},
+	}, diff)
+}
+
+// Тест журнала создания и удаления пользователя
+func TestDiffFields_CreatePurge(t *testing.T) {
+	user := domain.User{FirstName: "John", Login: "john@example.com", Password: "hash"}
+
+	diff := diffFields(auditFields(nil), auditFields(&user))
+	assert.Equal(t, domain.FieldChange{Old: nil, New: domain.REDACTED}, diff["password"])
+	assert.Equal(t, domain.FieldChange{Old: nil, New: domain.REDACTED}, diff["email"])
+	assert.NotContains(t, diff, "birthday")
+
+	diff = diffFields(auditFields(&user), auditFields(nil))
+	assert.Equal(t, domain.FieldChange{Old: domain.REDACTED, New: nil}, diff["password"])
+	assert.Equal(t, domain.FieldChange{Old: domain.REDACTED, New: nil}, diff["name"])
+}
@@ -0,0 +1,408 @@
+package realization
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"user/internal/domain"
+	"user/internal/interfaces"
+	"user/internal/presentation/db"
+	"user/internal/presentation/logger"
+
+	"github.com/lib/pq"
+)
+
+const (
+	TOKEN_TYPE = "Bearer"
+
+	// MFA_CHALLENGE_TTL - время на ввод кода второго фактора после проверки пароля
+	MFA_CHALLENGE_TTL = 5 * time.Minute
+	// MFA_MAX_ATTEMPTS - количество попыток ввода кода на один вход
+	MFA_MAX_ATTEMPTS = 5
+)
+
+// AuthService управляет сессиями пользователей
+type AuthService struct {
+	db         *db.DB
+	hasher     interfaces.PasswordHasher
+	cipher     interfaces.FieldCipher
+	mfa        *MfaService
+	activity   interfaces.SessionCacheRepo
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+
+	// requireVerified - вход запрещен до подтверждения email
+	requireVerified bool
+
+	// dummyHash - хэш случайного пароля, с которым сверяется пароль неизвестного email
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+// NewAuthService создает новый экземпляр AuthService
+// hasher - алгоритм проверки паролей
+// cipher - шифрование персональных данных, пользователь ищется по слепому индексу email
+// mfa - проверка кодов второго фактора
+// activity - горячая копия активности сессий
+// accessTTL - время жизни access токена
+// refreshTTL - время жизни refresh токена
+// requireVerified - запрещать вход до подтверждения email
+func NewAuthService(db *db.DB, hasher interfaces.PasswordHasher, cipher interfaces.FieldCipher, mfa *MfaService, activity interfaces.SessionCacheRepo, accessTTL, refreshTTL time.Duration, requireVerified bool) *AuthService {
+	return &AuthService{
+		db:              db,
+		hasher:          hasher,
+		cipher:          cipher,
+		mfa:             mfa,
+		activity:        activity,
+		accessTTL:       accessTTL,
+		refreshTTL:      refreshTTL,
+		requireVerified: requireVerified,
+	}
+}
+
+// Login проверяет пароль пользователя организации tenant и открывает новую сессию
+// Email уникален только в пределах организации, поэтому пользователь ищется в ней
+// Хэш, созданный устаревшим алгоритмом, пересчитывается после успешной проверки
+// Пользователю с включенной MFA вместо токенов выдается первый шаг входа, токены выдает LoginMfa
+// Возвращает nil, если email или пароль не подошли,
+// и ErrEmailNotVerified, если пароль верный, но вход требует подтвержденного email
+func (s *AuthService) Login(ctx context.Context, tenant domain.Id, login, password string) (*domain.Tokens, *domain.MfaChallenge, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var (
+		userId   domain.Id
+		stored   string
+		verified bool
+		mfa      bool
+	)
+	logger.Logger.Debug("Logging in user...")
+	err := s.db.Db.QueryRowContext(ctx, `SELECT id, password, email_verified_at IS NOT NULL, EXISTS (SELECT 1 FROM user_mfa WHERE user_id = users.id AND confirmed_at IS NOT NULL) FROM users WHERE login_index = $1 AND tenant_id = $2 AND deleted_at IS NULL`, s.cipher.BlindIndex(login), tenant).Scan(&userId, &stored, &verified, &mfa)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.verifyDummy(password)
+			return nil, nil, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting user password error: %v", err))
+		return nil, nil, fmt.Errorf("getting postgres user password error: %v", err)
+	}
+
+	ok, err := s.hasher.Verify(password, stored)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Verifying password of user %d error: %v", userId, err))
+		return nil, nil, nil
+	}
+
+	if !ok {
+		return nil, nil, nil
+	}
+
+	if s.requireVerified && !verified {
+		return nil, nil, domain.ErrEmailNotVerified
+	}
+
+	if s.hasher.NeedsRehash(stored) {
+		s.rehash(ctx, userId, password)
+	}
+
+	if mfa {
+		challenge, err := s.challenge(ctx, userId)
+		return nil, challenge, err
+	}
+
+	tokens, err := s.openSession(ctx, userId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logger.Logger.Debug("The user has been logged in successful")
+	return tokens, nil, nil
+}
+
+// verifyDummy проверяет пароль по хэшу случайного пароля
+// Вход с неизвестным email занимает столько же времени, сколько с известным, и не выдает зарегистрированные адреса
+func (s *AuthService) verifyDummy(password string) {
+	s.dummyOnce.Do(func() {
+		secret, err := newToken()
+		if err == nil {
+			s.dummyHash, err = s.hasher.Hash(secret)
+		}
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Creating dummy password hash error: %v", err))
+		}
+	})
+
+	_, _ = s.hasher.Verify(password, s.dummyHash)
+}
+
+// LoginMfa проверяет код TOTP или код восстановления по токену первого шага и открывает новую сессию
+// На один токен дается MFA_MAX_ATTEMPTS попыток, после успешного входа токен недействителен
+// Возвращает nil, если токен неизвестен, просрочен, исчерпал попытки или код не подошел
+func (s *AuthService) LoginMfa(ctx context.Context, mfaToken, code string) (*domain.Tokens, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	tx, err := s.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Beginning transaction error: %v", err))
+		return nil, fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		challengeId domain.Id
+		userId      domain.Id
+	)
+	err = tx.QueryRowContext(ctx, `UPDATE mfa_challenges SET attempts = attempts + 1 WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW() AND attempts < $2 RETURNING id, user_id`, hashToken(mfaToken), MFA_MAX_ATTEMPTS).Scan(&challengeId, &userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting mfa challenge error: %v", err))
+		return nil, fmt.Errorf("getting postgres mfa challenge error: %v", err)
+	}
+
+	ok, err := s.mfa.check(ctx, tx, userId, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		_, err = tx.ExecContext(ctx, `UPDATE mfa_challenges SET used_at = NOW() WHERE id = $1`, challengeId)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Using mfa challenge error: %v", err))
+			return nil, fmt.Errorf("using postgres mfa challenge error: %v", err)
+		}
+	}
+
+	// Неудачная попытка тоже фиксируется, иначе код можно перебирать без ограничений
+	err = tx.Commit()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Committing transaction error: %v", err))
+		return nil, fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	if !ok {
+		logger.Logger.Warn(fmt.Sprintf("Invalid mfa code for user %d", userId))
+		return nil, nil
+	}
+
+	tokens, err := s.openSession(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Logger.Debug("The user has been logged in with mfa successful")
+	return tokens, nil
+}
+
+// Refresh меняет refresh токен на новую пару токенов
+// Старый refresh токен после этого недействителен
+// Возвращает nil, если токен неизвестен, отозван или просрочен
+func (s *AuthService) Refresh(refreshToken string) (*domain.Tokens, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	tokens, access, refresh, err := s.newTokens()
+	if err != nil {
+		return nil, err
+	}
+
+	var id domain.Id
+	now := time.Now()
+	logger.Logger.Debug("Refreshing session...")
+	err = s.db.Db.QueryRowContext(ctx, `UPDATE sessions SET access_hash = $2, refresh_hash = $3, access_expires_at = $4, refresh_expires_at = $5 WHERE refresh_hash = $1 AND revoked_at IS NULL AND refresh_expires_at > NOW() RETURNING id`, hashToken(refreshToken), access, refresh, now.Add(s.accessTTL), now.Add(s.refreshTTL)).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Refreshing session error: %v", err))
+		return nil, fmt.Errorf("refreshing postgres session error: %v", err)
+	}
+
+	logger.Logger.Debug(fmt.Sprintf("Session %d has been refreshed", id))
+	return tokens, nil
+}
+
+// Logout отзывает сессию, которой принадлежит access токен
+func (s *AuthService) Logout(accessToken string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	logger.Logger.Debug("Revoking session...")
+	_, err := s.db.Db.ExecContext(ctx, `UPDATE sessions SET revoked_at = NOW() WHERE access_hash = $1 AND revoked_at IS NULL`, hashToken(accessToken))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Revoking session error: %v", err))
+		return fmt.Errorf("revoking postgres session error: %v", err)
+	}
+
+	return nil
+}
+
+// Authenticate возвращает активную сессию по access токену вместе с ролями и правами пользователя,
+// его организацией и организациями, в которых он состоит
+// Права и сама сессия читаются из базы при каждом запросе, поэтому отзыв роли или сессии действует сразу
+// Возвращает nil, если токен неизвестен, отозван или просрочен
+func (s *AuthService) Authenticate(ctx context.Context, accessToken string) (*domain.Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var (
+		session domain.Session
+		tenants []int64
+	)
+	err := s.db.Db.QueryRowContext(ctx, `SELECT s.id, s.user_id, u.tenant_id, s.access_expires_at, s.refresh_expires_at, s.created_at, s.last_seen_at,
+		ARRAY(SELECT organization_id FROM memberships WHERE user_id = s.user_id ORDER BY organization_id),
+		ARRAY(SELECT role FROM user_roles WHERE user_id = s.user_id ORDER BY role),
+		ARRAY(SELECT DISTINCT permission FROM role_permissions WHERE role = $2 OR role IN (SELECT role FROM user_roles WHERE user_id = s.user_id) ORDER BY permission)
+		FROM sessions s JOIN users u ON u.id = s.user_id WHERE s.access_hash = $1 AND s.revoked_at IS NULL AND s.access_expires_at > NOW() AND u.deleted_at IS NULL`, hashToken(accessToken), domain.ROLE_SELF).Scan(&session.Id, &session.UserId, &session.TenantId, &session.AccessExpiresAt, &session.RefreshExpiresAt, &session.CreatedAt, &session.LastSeenAt, pq.Array(&tenants), pq.Array(&session.Roles), pq.Array(&session.Permissions))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting session error: %v", err))
+		return nil, fmt.Errorf("getting postgres session error: %v", err)
+	}
+
+	for _, tenant := range tenants {
+		session.Tenants = append(session.Tenants, domain.Id(tenant))
+	}
+
+	s.touch(ctx, &session)
+	return &session, nil
+}
+
+// touch отмечает запрос сессии в Redis при каждом запросе и в базе не чаще SESSION_FLUSH_INTERVAL
+// Ошибки не прерывают запрос, активность будет записана при следующем
+func (s *AuthService) touch(ctx context.Context, session *domain.Session) {
+	client := domain.ClientFrom(ctx)
+	now := time.Now()
+
+	err := s.activity.Touch(session.Id, domain.SessionActivity{LastSeenAt: now, Ip: client.Ip}, SESSION_ACTIVITY_TTL)
+	if err != nil {
+		logger.Logger.Warn(fmt.Sprintf("Touching session %d error: %v", session.Id, err))
+	}
+
+	if now.Sub(session.LastSeenAt) < SESSION_FLUSH_INTERVAL {
+		return
+	}
+
+	ip, userAgent, keyId, err := sealClient(s.cipher, client)
+	if err != nil {
+		logger.Logger.Warn(fmt.Sprintf("Flushing session %d activity error: %v", session.Id, err))
+		return
+	}
+
+	_, err = s.db.Db.ExecContext(ctx, `UPDATE sessions SET last_seen_at = $2, device = $3, ip = $4, user_agent = $5, key_id = $6 WHERE id = $1`, session.Id, now, deviceName(client.UserAgent), ip, userAgent, keyId)
+	if err != nil {
+		logger.Logger.Warn(fmt.Sprintf("Flushing session %d activity error: %v", session.Id, err))
+		return
+	}
+	session.LastSeenAt = now
+}
+
+// challenge выдает токен второго шага входа
+func (s *AuthService) challenge(ctx context.Context, userId domain.Id) (*domain.MfaChallenge, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.Db.ExecContext(ctx, `INSERT INTO mfa_challenges (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`, userId, hashToken(token), time.Now().Add(MFA_CHALLENGE_TTL))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Creating mfa challenge error: %v", err))
+		return nil, fmt.Errorf("creating postgres mfa challenge error: %v", err)
+	}
+
+	return &domain.MfaChallenge{
+		MfaRequired: true,
+		MfaToken:    token,
+		ExpiresIn:   int64(MFA_CHALLENGE_TTL.Seconds()),
+	}, nil
+}
+
+// openSession открывает новую сессию пользователя и возвращает ее токены
+// Устройство, адрес и User-Agent берутся из клиента запроса в контексте
+func (s *AuthService) openSession(ctx context.Context, userId domain.Id) (*domain.Tokens, error) {
+	tokens, access, refresh, err := s.newTokens()
+	if err != nil {
+		return nil, err
+	}
+
+	client := domain.ClientFrom(ctx)
+	ip, userAgent, keyId, err := sealClient(s.cipher, client)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	_, err = s.db.Db.ExecContext(ctx, `INSERT INTO sessions (user_id, access_hash, refresh_hash, access_expires_at, refresh_expires_at, device, ip, user_agent, key_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`, userId, access, refresh, now.Add(s.accessTTL), now.Add(s.refreshTTL), deviceName(client.UserAgent), ip, userAgent, keyId)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Creating session error: %v", err))
+		return nil, fmt.Errorf("creating postgres session error: %v", err)
+	}
+
+	return tokens, nil
+}
+
+// rehash пересчитывает хэш пароля текущим алгоритмом
+// Ошибка не прерывает вход, хэш будет пересчитан при следующем входе
+func (s *AuthService) rehash(ctx context.Context, userId domain.Id, password string) {
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Rehashing password error: %v", err))
+		return
+	}
+
+	_, err = s.db.Db.ExecContext(ctx, `UPDATE users SET password = $2 WHERE id = $1`, userId, hash)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Updating password hash error: %v", err))
+		return
+	}
+
+	logger.Logger.Debug(fmt.Sprintf("Password hash of user %d has been upgraded", userId))
+}
+
+// newTokens генерирует новую пару токенов и их хэши для хранения в базе
+func (s *AuthService) newTokens() (*domain.Tokens, string, string, error) {
+	access, err := newToken()
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	refresh, err := newToken()
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return &domain.Tokens{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    TOKEN_TYPE,
+		ExpiresIn:    int64(s.accessTTL.Seconds()),
+	}, hashToken(access), hashToken(refresh), nil
+}
+
+// newToken генерирует случайный непрозрачный токен
+func newToken() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("generating token error: %v", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken возвращает хэш токена, под которым он хранится в базе
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,47 @@
+package realization
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+	"user/internal/interfaces"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingHasher считает проверки паролей
+type countingHasher struct {
+	interfaces.PasswordHasher
+	verified int
+}
+
+func (h *countingHasher) Verify(pass, hash string) (bool, error) {
+	h.verified++
+	return h.PasswordHasher.Verify(pass, hash)
+}
+
+// Тест входа с неизвестным email: пароль все равно проверяется по хэшу, чтобы время ответа не выдавало адреса
+func TestLoginUnknownEmail(t *testing.T) {
+	users, mock, _ := newMockService(t)
+	hasher := &countingHasher{PasswordHasher: newTestHasher(t, ARGON2ID)}
+	s := NewAuthService(users.db, hasher, users.cipher, nil, newMemoryActivity(), time.Minute, time.Hour, false)
+
+	for range 2 {
+		mock.ExpectQuery(`FROM users WHERE login_index = \$1 AND tenant_id = \$2`).
+			WithArgs(users.cipher.BlindIndex("nobody@example.com"), domain.DEFAULT_TENANT).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "password", "verified", "mfa"}))
+
+		tokens, challenge, err := s.Login(context.Background(), domain.DEFAULT_TENANT, "nobody@example.com", "StrongPassword123!")
+		require.NoError(t, err)
+		assert.Nil(t, tokens)
+		assert.Nil(t, challenge)
+	}
+
+	assert.Equal(t, 2, hasher.verified)
+	assert.Equal(t, ARGON2ID, identifyHash(s.dummyHash))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,243 @@
+package realization
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"user/internal/domain"
+	"user/internal/presentation/logger"
+
+	"github.com/lib/pq"
+)
+
+// BatchGet возвращает активных пользователей по идентификаторам в порядке запроса
+// Сначала пользователи читаются из кэша одним MGET, промахи - одним запросом к Postgres
+// Повторы и ненайденные идентификаторы в результат не попадают
+func (s *UserService) BatchGet(ctx context.Context, ids []domain.Id) ([]domain.User, error) {
+	tenant := domain.TenantFrom(ctx)
+	ids = uniqueIds(ids)
+	cached, err := s.cache.GetByKeys(tenant, ids)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting Redis keys error: %v", err))
+		return nil, err
+	}
+
+	found := make(map[domain.Id]domain.User, len(ids))
+	misses := []int64{}
+	for _, id := range ids {
+		// Записи без версии остались в кэше с прошлых версий сервиса
+		if user, ok := cached[id]; ok && user.Version != 0 {
+			found[id] = *user
+			continue
+		}
+		misses = append(misses, int64(id))
+	}
+
+	if len(misses) > 0 {
+		users, err := s.selectActive(ctx, misses)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, user := range users {
+			found[user.Id] = user
+		}
+
+		err = s.cache.CreateKeys(tenant, users)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Creating Redis keys error: %v", err))
+		}
+	}
+
+	result := make([]domain.User, 0, len(found))
+	for _, id := range ids {
+		if user, ok := found[id]; ok {
+			result = append(result, user)
+		}
+	}
+
+	logger.Logger.Debug(fmt.Sprintf("%d of %d users have been got, %d from cache", len(result), len(ids), len(ids)-len(misses)))
+	return result, nil
+}
+
+// selectActive читает активных пользователей по списку идентификаторов одним запросом
+func (s *UserService) selectActive(ctx context.Context, ids []int64) ([]domain.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	rows, err := s.db.Db.QueryContext(ctx, `SELECT id, first_name, last_name, birthday, login, version, created_at, deleted_at FROM users WHERE id = ANY($1) AND deleted_at IS NULL AND tenant_id = $2`, pq.Array(ids), domain.TenantFrom(ctx))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting users error: %v", err))
+		return nil, fmt.Errorf("getting postgres users error: %v", err)
+	}
+	defer rows.Close()
+
+	users := []domain.User{}
+	for rows.Next() {
+		var row userRow
+		err = rows.Scan(row.dest()...)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning user error: %v", err))
+			return nil, fmt.Errorf("scanning postgres user error: %v", err)
+		}
+
+		user, err := row.decrypt(s.cipher)
+		if err != nil {
+			return nil, err
+		}
+
+		user.Password = "***"
+		users = append(users, *user)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting users error: %v", err))
+		return nil, fmt.Errorf("getting postgres users error: %v", err)
+	}
+
+	return users, nil
+}
+
+// Bulk создает и обновляет пользователей пакетом, результаты идут в порядке операций
+// Без atomic каждая операция выполняется в своей транзакции, ошибки не влияют на остальные
+// С atomic все операции выполняются в одной транзакции: при первой ошибке операции
+// транзакция откатывается, а остальные операции получают domain.ErrBulkAborted
+func (s *UserService) Bulk(ctx context.Context, items []domain.BulkItem, atomic bool) ([]domain.BulkResult, error) {
+	if atomic {
+		return s.bulkAtomic(ctx, items)
+	}
+
+	results := make([]domain.BulkResult, len(items))
+	for i, item := range items {
+		results[i] = s.bulkItem(ctx, item)
+	}
+
+	return results, nil
+}
+
+// bulkItem выполняет одну операцию пакета в отдельной транзакции
+func (s *UserService) bulkItem(ctx context.Context, item domain.BulkItem) domain.BulkResult {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return domain.BulkResult{Err: err}
+	}
+	defer tx.Rollback()
+
+	id, err := s.apply(ctx, tx, item)
+	if err != nil {
+		return domain.BulkResult{Err: err}
+	}
+
+	err = s.commit(tx)
+	if err != nil {
+		return domain.BulkResult{Err: err}
+	}
+
+	if item.Op == domain.BULK_UPDATE {
+		s.invalidate(ctx, id)
+	}
+
+	return domain.BulkResult{Id: id}
+}
+
+// bulkAtomic выполняет все операции пакета в одной транзакции
+func (s *UserService) bulkAtomic(ctx context.Context, items []domain.BulkItem) ([]domain.BulkResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	logger.Logger.Debug(fmt.Sprintf("Applying %d bulk operations...", len(items)))
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results := make([]domain.BulkResult, len(items))
+	for i, item := range items {
+		id, err := s.apply(ctx, tx, item)
+		if err == nil {
+			results[i].Id = id
+			continue
+		}
+
+		if !isItemError(err) {
+			return nil, err
+		}
+
+		for j := range results {
+			results[j] = domain.BulkResult{Err: domain.ErrBulkAborted}
+		}
+		results[i].Err = err
+		return results, nil
+	}
+
+	err = s.commit(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, item := range items {
+		if item.Op == domain.BULK_UPDATE {
+			s.invalidate(ctx, results[i].Id)
+		}
+	}
+
+	logger.Logger.Debug("Bulk operations have been applied successful")
+	return results, nil
+}
+
+// apply выполняет операцию пакета в транзакции tx
+// Занятый логин, отсутствие пользователя и несовпадение версии возвращаются ошибками domain
+func (s *UserService) apply(ctx context.Context, tx *sql.Tx, item domain.BulkItem) (domain.Id, error) {
+	switch item.Op {
+	case domain.BULK_CREATE:
+		id, err := s.create(ctx, tx, item.User)
+		if err != nil {
+			return 0, err
+		}
+		if id == nil {
+			return 0, domain.ErrLoginExists
+		}
+		return *id, nil
+	case domain.BULK_UPDATE:
+		found, err := s.update(ctx, tx, item.User)
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == NOT_UNIQUE_LOGIN {
+			return 0, domain.ErrLoginExists
+		}
+		if err != nil {
+			return 0, err
+		}
+		if !found {
+			return 0, domain.ErrUserNotFound
+		}
+		return item.User.Id, nil
+	}
+
+	return 0, fmt.Errorf("unknown bulk operation %q", item.Op)
+}
+
+// isItemError сообщает, что ошибка вызвана данными операции, а не инфраструктурой
+func isItemError(err error) bool {
+	return errors.Is(err, domain.ErrLoginExists) || errors.Is(err, domain.ErrUserNotFound) || errors.Is(err, domain.ErrVersionMismatch)
+}
+
+// uniqueIds убирает повторы, сохраняя порядок
+func uniqueIds(ids []domain.Id) []domain.Id {
+	seen := make(map[domain.Id]bool, len(ids))
+	unique := make([]domain.Id, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	return unique
+}
@@ -0,0 +1,197 @@
+package realization
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+	"user/internal/presentation/db"
+	"user/internal/presentation/logger"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryCache - кэш в памяти для тестов, ключи совпадают с ключами Redis
+type memoryCache struct {
+	users map[string]domain.User
+}
+
+func (c *memoryCache) CreateKey(tenant, id domain.Id, user domain.User) error {
+	c.users[cacheKey(tenant, id)] = user
+	return nil
+}
+
+func (c *memoryCache) GetByKey(tenant, id domain.Id) (*domain.User, error) {
+	user, ok := c.users[cacheKey(tenant, id)]
+	if !ok {
+		return nil, nil
+	}
+
+	return &user, nil
+}
+
+func (c *memoryCache) CreateKeys(tenant domain.Id, users []domain.User) error {
+	for _, user := range users {
+		c.users[cacheKey(tenant, user.Id)] = user
+	}
+
+	return nil
+}
+
+func (c *memoryCache) GetByKeys(tenant domain.Id, ids []domain.Id) (map[domain.Id]*domain.User, error) {
+	users := map[domain.Id]*domain.User{}
+	for _, id := range ids {
+		if user, ok := c.users[cacheKey(tenant, id)]; ok {
+			users[id] = &user
+		}
+	}
+
+	return users, nil
+}
+
+func (c *memoryCache) DelKey(tenant, id domain.Id) error {
+	delete(c.users, cacheKey(tenant, id))
+	return nil
+}
+
+func (c *memoryCache) Close() error {
+	return nil
+}
+
+func newMockService(t *testing.T) (*UserService, sqlmock.Sqlmock, *memoryCache) {
+	require.NoError(t, logger.NewLogger())
+
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = mockDB.Close()
+	})
+
+	cache := &memoryCache{users: map[string]domain.User{}}
+	return NewUserService(&db.DB{Db: mockDB}, cache, newTestKeyring(t), newTestHasher(t, BCRYPT)), mock, cache
+}
+
+// Тест чтения пачки: попадания берутся из кэша, промахи читаются одним запросом
+func TestBatchGet(t *testing.T) {
+	s, mock, cache := newMockService(t)
+	cache.users[cacheKey(domain.DEFAULT_TENANT, 1)] = domain.User{Id: 1, Login: "a@example.com", Version: 2}
+
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`WHERE id = ANY\(\$1\) AND deleted_at IS NULL`).
+		WithArgs(pq.Array([]int64{3, 2, 4}), domain.DEFAULT_TENANT).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "birthday", "login", "version", "created_at", "deleted_at"}).
+			AddRow(2, encrypt(t, s.cipher, ""), encrypt(t, s.cipher, ""), nil, encrypt(t, s.cipher, "b@example.com"), 1, created, nil).
+			AddRow(3, encrypt(t, s.cipher, ""), encrypt(t, s.cipher, ""), nil, encrypt(t, s.cipher, "c@example.com"), 1, created, nil))
+
+	users, err := s.BatchGet(context.Background(), []domain.Id{3, 1, 2, 3, 4})
+	require.NoError(t, err)
+	require.Len(t, users, 3)
+	assert.Equal(t, []domain.Id{3, 1, 2}, []domain.Id{users[0].Id, users[1].Id, users[2].Id})
+	assert.Equal(t, "***", users[0].Password)
+	assert.Equal(t, "c@example.com", users[0].Login)
+	assert.Contains(t, cache.users, cacheKey(domain.DEFAULT_TENANT, 2))
+	assert.Contains(t, cache.users, cacheKey(domain.DEFAULT_TENANT, 3))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест атомарного пакета: занятый логин откатывает транзакцию и отменяет остальные операции
+func TestBulk_Atomic(t *testing.T) {
+	s, mock, _ := newMockService(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO users`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version", "created_at"}).AddRow(1, 1, time.Now()))
+	mock.ExpectExec(`INSERT INTO user_audit`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO outbox`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`INSERT INTO users`).WillReturnError(&pq.Error{Code: NOT_UNIQUE_LOGIN})
+	mock.ExpectRollback()
+
+	results, err := s.Bulk(context.Background(), []domain.BulkItem{
+		{Op: domain.BULK_CREATE, User: domain.User{Login: "a@example.com"}},
+		{Op: domain.BULK_CREATE, User: domain.User{Login: "a@example.com"}},
+		{Op: domain.BULK_CREATE, User: domain.User{Login: "c@example.com"}},
+	}, true)
+	require.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, domain.ErrBulkAborted)
+	assert.ErrorIs(t, results[1].Err, domain.ErrLoginExists)
+	assert.ErrorIs(t, results[2].Err, domain.ErrBulkAborted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест пакета без atomic: операции выполняются в отдельных транзакциях
+func TestBulk_Independent(t *testing.T) {
+	s, mock, cache := newMockService(t)
+	cache.users[cacheKey(domain.DEFAULT_TENANT, 5)] = domain.User{Id: 5, Version: 1}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM users WHERE id = \$1 AND \(\$2 OR deleted_at IS NULL\) AND tenant_id = \$3 FOR UPDATE`).
+		WithArgs(domain.Id(7), false, domain.DEFAULT_TENANT).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FOR UPDATE`).
+		WithArgs(domain.Id(5), false, domain.DEFAULT_TENANT).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "birthday", "login", "password", "version", "created_at", "deleted_at"}).
+			AddRow(5, encrypt(t, s.cipher, ""), encrypt(t, s.cipher, ""), nil, encrypt(t, s.cipher, "e@example.com"), "hash", 1, time.Now(), nil))
+	mock.ExpectExec(`UPDATE users SET`).WillReturnResult(driver.RowsAffected(1))
+	// Пароль изменился, остальные сессии отзываются
+	mock.ExpectExec(`UPDATE sessions SET revoked_at`).WithArgs(domain.Id(5), nil).WillReturnResult(driver.RowsAffected(1))
+	mock.ExpectExec(`INSERT INTO user_audit`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO outbox`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	results, err := s.Bulk(context.Background(), []domain.BulkItem{
+		{Op: domain.BULK_UPDATE, User: domain.User{Id: 7, Login: "g@example.com"}},
+		{Op: domain.BULK_UPDATE, User: domain.User{Id: 5, Login: "f@example.com", Version: 1}},
+	}, false)
+	require.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, domain.ErrUserNotFound)
+	assert.NoError(t, results[1].Err)
+	assert.Equal(t, domain.Id(5), results[1].Id)
+	assert.NotContains(t, cache.users, cacheKey(domain.DEFAULT_TENANT, 5))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест чтения в организации: кэш другой организации не используется, запрос ограничен организацией
+func TestGet_Tenant(t *testing.T) {
+	s, mock, cache := newMockService(t)
+	cache.users[cacheKey(domain.DEFAULT_TENANT, 4)] = domain.User{Id: 4, Login: "a@example.com", Version: 1}
+
+	mock.ExpectQuery(`FROM users WHERE id = \$1 AND \(\$2 OR deleted_at IS NULL\) AND tenant_id = \$3`).
+		WithArgs(domain.Id(4), false, domain.Id(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	user, err := s.Get(domain.WithTenant(context.Background(), 2), 4, false)
+	require.NoError(t, err)
+	assert.Nil(t, user)
+
+	user, err = s.Get(context.Background(), 4, false)
+	require.NoError(t, err)
+	assert.Equal(t, "a@example.com", user.Login)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест чтения мимо кэша: устаревшая запись кэша заменяется состоянием из базы
+func TestGetFresh(t *testing.T) {
+	s, mock, cache := newMockService(t)
+	cache.users[cacheKey(domain.DEFAULT_TENANT, 4)] = domain.User{Id: 4, Login: "a@example.com", Version: 1}
+
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`FROM users WHERE id = \$1 AND \(\$2 OR deleted_at IS NULL\) AND tenant_id = \$3`).
+		WithArgs(domain.Id(4), false, domain.DEFAULT_TENANT).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "birthday", "login", "version", "created_at", "deleted_at"}).
+			AddRow(4, encrypt(t, s.cipher, ""), encrypt(t, s.cipher, ""), nil, encrypt(t, s.cipher, "b@example.com"), 2, created, nil))
+
+	user, err := s.GetFresh(context.Background(), 4)
+	require.NoError(t, err)
+	assert.Equal(t, "b@example.com", user.Login)
+	assert.Equal(t, uint64(2), user.Version)
+	assert.Equal(t, uint64(2), cache.users[cacheKey(domain.DEFAULT_TENANT, 4)].Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
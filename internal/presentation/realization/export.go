@@ -0,0 +1,118 @@
+package realization
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"user/internal/domain"
+	"user/internal/presentation/logger"
+)
+
+// EXPORT_FETCH - количество строк, читаемых из курсора за один FETCH
+const EXPORT_FETCH = 1000
+
+// Export читает пользователей по фильтру списка через курсор Postgres и передает их в write
+// Курсор и лимит фильтра не учитываются, выгружаются все подходящие пользователи
+// passes - количество проходов по выборке, все проходы видят один снимок данных (REPEATABLE READ),
+// это нужно для колоночных форматов, которые пишут поля по очереди
+// Общего таймаута нет, выгрузка прерывается отменой ctx
+func (s *UserService) Export(ctx context.Context, filter domain.UserFilter, passes int, write func(pass int, user domain.User) error) error {
+	filter = s.scope(ctx, filter)
+	logger.Logger.Debug("Exporting users...")
+	tx, err := s.db.Db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Beginning transaction error: %v", err))
+		return fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	query, args, err := buildExportQuery(filter)
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for pass := 0; pass < passes; pass++ {
+		_, err = tx.ExecContext(ctx, `DECLARE export_users NO SCROLL CURSOR FOR `+query, args...)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Declaring export cursor error: %v", err))
+			return fmt.Errorf("declaring postgres export cursor error: %v", err)
+		}
+
+		for {
+			n, err := s.fetchExport(ctx, tx, pass, write)
+			if err != nil {
+				return err
+			}
+
+			total += n
+			if n < EXPORT_FETCH {
+				break
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `CLOSE export_users`)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Closing export cursor error: %v", err))
+			return fmt.Errorf("closing postgres export cursor error: %v", err)
+		}
+	}
+
+	logger.Logger.Debug(fmt.Sprintf("%d users have been exported in %d passes", total, passes))
+	return nil
+}
+
+// fetchExport читает очередную пачку строк курсора и возвращает их количество
+func (s *UserService) fetchExport(ctx context.Context, tx *sql.Tx, pass int, write func(pass int, user domain.User) error) (int, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`FETCH %d FROM export_users`, EXPORT_FETCH))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Fetching export cursor error: %v", err))
+		return 0, fmt.Errorf("fetching postgres export cursor error: %v", err)
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		var row userRow
+		err = rows.Scan(row.dest()...)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning user error: %v", err))
+			return 0, fmt.Errorf("scanning postgres user error: %v", err)
+		}
+
+		user, err := row.decrypt(s.cipher)
+		if err != nil {
+			return 0, err
+		}
+
+		err = write(pass, *user)
+		if err != nil {
+			return 0, err
+		}
+		n++
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Fetching export cursor error: %v", err))
+		return 0, fmt.Errorf("fetching postgres export cursor error: %v", err)
+	}
+
+	return n, nil
+}
+
+// buildExportQuery собирает запрос выгрузки с условиями и сортировкой списка, без курсора и лимита
+func buildExportQuery(filter domain.UserFilter) (string, []any, error) {
+	where, args := buildFilter(filter)
+
+	column, _, order, err := sortOrder(filter.Sort)
+	if err != nil {
+		return "", nil, err
+	}
+
+	query := fmt.Sprintf(`SELECT id, first_name, last_name, birthday, login, version, created_at, deleted_at FROM users WHERE %s ORDER BY %s %s, id %s`,
+		strings.Join(where, " AND "), column.expr, order, order)
+
+	return query, args, nil
+}
@@ -0,0 +1,518 @@
+package realization
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+	"user/internal/domain"
+	"user/internal/interfaces"
+	"user/internal/presentation/db"
+	"user/internal/presentation/logger"
+
+	"github.com/lib/pq"
+)
+
+// GdprService выгружает все данные пользователя и обезличивает их по запросу на удаление
+type GdprService struct {
+	db     *db.DB
+	cache  interfaces.CacheRepo
+	cipher interfaces.FieldCipher
+}
+
+// NewGdprService создает новый экземпляр GdprService
+// cipher - шифрование персональных данных в users
+func NewGdprService(db *db.DB, cache interfaces.CacheRepo, cipher interfaces.FieldCipher) *GdprService {
+	return &GdprService{
+		db:     db,
+		cache:  cache,
+		cipher: cipher,
+	}
+}
+
+// Archive возвращает профиль, журнал, сессии и согласия пользователя, nil если пользователя нет
+// Все части читаются из одного снимка данных (REPEATABLE READ)
+// Мягко удаленные и обезличенные пользователи тоже выгружаются, для обезличенных добавляется запись об удалении
+func (s *GdprService) Archive(ctx context.Context, id domain.Id) (*domain.GdprArchive, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	logger.Logger.Debug("Archiving user data...")
+	tx, err := s.db.Db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Beginning transaction error: %v", err))
+		return nil, fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	var row userRow
+	err = tx.QueryRowContext(ctx, `SELECT id, first_name, last_name, birthday, login, version, created_at, deleted_at FROM users WHERE id = $1 AND tenant_id = $2`, id, domain.TenantFrom(ctx)).Scan(row.dest()...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting user error: %v", err))
+		return nil, fmt.Errorf("getting postgres user error: %v", err)
+	}
+
+	user, err := row.decrypt(s.cipher)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = "***"
+
+	archive := &domain.GdprArchive{
+		ExportedAt: time.Now().UTC(),
+		Profile:    *user,
+	}
+
+	archive.Audit, err = archiveAudit(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	archive.Sessions, err = archiveSessions(ctx, tx, s.cipher, id)
+	if err != nil {
+		return nil, err
+	}
+
+	archive.Consents, err = archiveConsents(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	archive.Erasure, err = erasure(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Logger.Debug("User data has been archived successful")
+	return archive, nil
+}
+
+// Erase обезличивает персональные данные пользователя в одной транзакции:
+// профиль заменяется заглушкой и помечается удаленным, значения в журнале заменяются на [ERASED],
+// из событий outbox и доставок webhook убираются персональные поля, сессии, согласия и роли удаляются
+// Остается запись об удалении без персональных данных, повторное удаление возвращает ErrAlreadyErased
+// Для безвозвратно удаленного пользователя обезличивается только журнал
+// Возвращает nil, если о пользователе ничего не хранится
+func (s *GdprService) Erase(ctx context.Context, id domain.Id) (*domain.Erasure, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	logger.Logger.Debug("Erasing user...")
+	tx, err := s.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Beginning transaction error: %v", err))
+		return nil, fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		version   uint64
+		deletedAt *time.Time
+		exists    = true
+	)
+	err = tx.QueryRowContext(ctx, `SELECT version FROM users WHERE id = $1 AND tenant_id = $2 FOR UPDATE`, id, domain.TenantFrom(ctx)).Scan(&version)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Logger.Error(fmt.Sprintf("Locking user error: %v", err))
+			return nil, fmt.Errorf("locking postgres user error: %v", err)
+		}
+		exists = false
+	}
+
+	previous, err := erasure(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if previous != nil {
+		return nil, domain.ErrAlreadyErased
+	}
+
+	if exists {
+		sealed, err := sealUser(s.cipher, &domain.User{Login: erasedLogin(id)})
+		if err != nil {
+			return nil, err
+		}
+
+		err = tx.QueryRowContext(ctx, `UPDATE users SET first_name = $2, last_name = $3, birthday = NULL, login = $4, login_index = $5, key_id = $6, password = '', deleted_at = COALESCE(deleted_at, NOW()), version = version + 1 WHERE id = $1 RETURNING version, deleted_at`, id, sealed.firstName, sealed.lastName, sealed.login, sealed.loginIndex, sealed.keyId).Scan(&version, &deletedAt)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Erasing user error: %v", err))
+			return nil, fmt.Errorf("erasing postgres user error: %v", err)
+		}
+	}
+
+	entries, total, err := eraseAudit(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists && total == 0 {
+		return nil, nil
+	}
+
+	fields := pq.Array(domain.PersonalFields)
+	_, err = tx.ExecContext(ctx, `UPDATE webhook_deliveries SET body = jsonb_set(body, '{payload}', (body->'payload') - $2::text[]) WHERE event_id IN (SELECT id FROM outbox WHERE user_id = $1)`, id, fields)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Erasing webhook deliveries error: %v", err))
+		return nil, fmt.Errorf("erasing postgres webhook deliveries error: %v", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE outbox SET payload = payload - $2::text[] WHERE user_id = $1`, id, fields)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Erasing outbox events error: %v", err))
+		return nil, fmt.Errorf("erasing postgres outbox events error: %v", err)
+	}
+
+	sessions, err := deleteAffected(ctx, tx, `DELETE FROM sessions WHERE user_id = $1`, id)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Deleting user sessions error: %v", err))
+		return nil, fmt.Errorf("deleting postgres sessions error: %v", err)
+	}
+
+	consents, err := deleteAffected(ctx, tx, `DELETE FROM user_consents WHERE user_id = $1`, id)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Deleting user consents error: %v", err))
+		return nil, fmt.Errorf("deleting postgres consents error: %v", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id = $1`, id)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Deleting user roles error: %v", err))
+		return nil, fmt.Errorf("deleting postgres user roles error: %v", err)
+	}
+
+	actor := domain.ActorFrom(ctx)
+	tombstone := &domain.Erasure{
+		UserId:       id,
+		ActorId:      actor.UserId,
+		RequestId:    actor.RequestId,
+		AuditEntries: entries,
+		Sessions:     sessions,
+		Consents:     consents,
+	}
+	err = tx.QueryRowContext(ctx, `INSERT INTO user_erasures (user_id, tenant_id, actor_id, request_id, audit_entries, sessions, consents) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING erased_at`, id, domain.TenantFrom(ctx), actor.UserId, actor.RequestId, entries, sessions, consents).Scan(&tombstone.ErasedAt)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Creating user erasure error: %v", err))
+		return nil, fmt.Errorf("creating postgres user erasure error: %v", err)
+	}
+
+	err = writeAudit(ctx, tx, id, domain.AUDIT_ERASE, map[string]domain.FieldChange{})
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		err = writeEvent(ctx, tx, domain.EVENT_USER_DELETED, domain.UserEventPayload{
+			Id:        id,
+			Version:   version,
+			DeletedAt: deletedAt,
+			Erased:    true,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Committing transaction error: %v", err))
+		return nil, fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	err = s.cache.DelKey(domain.TenantFrom(ctx), id)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Deleating Redis key error: %v", err))
+	}
+
+	logger.Logger.Debug("The user has been erased successful")
+	return tombstone, nil
+}
+
+// SetConsent сохраняет согласие активного пользователя с целью purpose
+// Изменение согласия записывается в журнал, false если пользователя нет
+func (s *GdprService) SetConsent(ctx context.Context, id domain.Id, purpose string, granted bool) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	logger.Logger.Debug("Setting user consent...")
+	tx, err := s.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Beginning transaction error: %v", err))
+		return false, fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	var previous sql.NullBool
+	err = tx.QueryRowContext(ctx, `SELECT c.granted FROM users u LEFT JOIN user_consents c ON c.user_id = u.id AND c.purpose = $2 WHERE u.id = $1 AND u.tenant_id = $3 AND u.deleted_at IS NULL FOR UPDATE OF u`, id, purpose, domain.TenantFrom(ctx)).Scan(&previous)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Locking user error: %v", err))
+		return false, fmt.Errorf("locking postgres user error: %v", err)
+	}
+
+	if previous.Valid && previous.Bool == granted {
+		return true, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO user_consents (user_id, purpose, granted) VALUES ($1, $2, $3) ON CONFLICT (user_id, purpose) DO UPDATE SET granted = EXCLUDED.granted, updated_at = NOW()`, id, purpose, granted)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Setting user consent error: %v", err))
+		return false, fmt.Errorf("setting postgres user consent error: %v", err)
+	}
+
+	change := domain.FieldChange{New: granted}
+	if previous.Valid {
+		change.Old = previous.Bool
+	}
+	err = writeAudit(ctx, tx, id, domain.AUDIT_CONSENT, map[string]domain.FieldChange{
+		"consent:" + purpose: change,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Committing transaction error: %v", err))
+		return false, fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	logger.Logger.Debug("User consent has been set successful")
+	return true, nil
+}
+
+// eraseAudit заменяет персональные данные в журнале пользователя
+// Возвращает количество измененных записей и всех записей пользователя
+func eraseAudit(ctx context.Context, tx *sql.Tx, userId domain.Id) (int, int, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id, diff FROM user_audit WHERE user_id = $1 AND tenant_id = $2 ORDER BY id FOR UPDATE`, userId, domain.TenantFrom(ctx))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting user audit error: %v", err))
+		return 0, 0, fmt.Errorf("getting postgres user audit error: %v", err)
+	}
+	defer rows.Close()
+
+	type erased struct {
+		id   domain.Id
+		diff []byte
+	}
+	var changed []erased
+	total := 0
+	for rows.Next() {
+		var (
+			id   domain.Id
+			data []byte
+			diff map[string]domain.FieldChange
+		)
+		err = rows.Scan(&id, &data)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning user audit error: %v", err))
+			return 0, 0, fmt.Errorf("scanning postgres user audit error: %v", err)
+		}
+		total++
+
+		err = json.Unmarshal(data, &diff)
+		if err != nil {
+			return 0, 0, fmt.Errorf("user audit diff unmarshalling error: %v", err)
+		}
+
+		if !eraseDiff(diff) {
+			continue
+		}
+
+		data, err = json.Marshal(diff)
+		if err != nil {
+			return 0, 0, fmt.Errorf("user audit diff marshalling error: %v", err)
+		}
+		changed = append(changed, erased{id: id, diff: data})
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting user audit error: %v", err))
+		return 0, 0, fmt.Errorf("getting postgres user audit error: %v", err)
+	}
+	rows.Close()
+
+	for _, entry := range changed {
+		_, err = tx.ExecContext(ctx, `UPDATE user_audit SET diff = $2 WHERE id = $1`, entry.id, entry.diff)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Erasing user audit error: %v", err))
+			return 0, 0, fmt.Errorf("erasing postgres user audit error: %v", err)
+		}
+	}
+
+	return len(changed), total, nil
+}
+
+// eraseDiff заменяет значения персональных полей на [ERASED] и сообщает, изменилось ли что-нибудь
+// Пустые значения остаются пустыми, чтобы по журналу было видно, какие поля были заполнены
+func eraseDiff(diff map[string]domain.FieldChange) bool {
+	erased := false
+	for key, change := range diff {
+		if !slices.Contains(domain.PersonalFields, key) {
+			continue
+		}
+
+		if change.Old != nil && change.Old != domain.ERASED {
+			change.Old = domain.ERASED
+			erased = true
+		}
+		if change.New != nil && change.New != domain.ERASED {
+			change.New = domain.ERASED
+			erased = true
+		}
+		diff[key] = change
+	}
+
+	return erased
+}
+
+// erasedLogin возвращает логин-заглушку обезличенного пользователя, логин остается уникальным
+func erasedLogin(id domain.Id) string {
+	return fmt.Sprintf("erased-%d@erased.invalid", id)
+}
+
+// deleteAffected выполняет удаление и возвращает количество удаленных строк
+func deleteAffected(ctx context.Context, tx *sql.Tx, query string, id domain.Id) (int, error) {
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+// erasure возвращает запись об удалении пользователя, nil если данные не обезличивались
+func erasure(ctx context.Context, tx *sql.Tx, userId domain.Id) (*domain.Erasure, error) {
+	var e domain.Erasure
+	err := tx.QueryRowContext(ctx, `SELECT user_id, actor_id, request_id, audit_entries, sessions, consents, erased_at FROM user_erasures WHERE user_id = $1 AND tenant_id = $2`, userId, domain.TenantFrom(ctx)).Scan(&e.UserId, &e.ActorId, &e.RequestId, &e.AuditEntries, &e.Sessions, &e.Consents, &e.ErasedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting user erasure error: %v", err))
+		return nil, fmt.Errorf("getting postgres user erasure error: %v", err)
+	}
+
+	return &e, nil
+}
+
+// archiveAudit читает весь журнал пользователя от старых записей к новым
+func archiveAudit(ctx context.Context, tx *sql.Tx, userId domain.Id) ([]domain.AuditEntry, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id, user_id, actor_id, request_id, action, diff, created_at FROM user_audit WHERE user_id = $1 AND tenant_id = $2 ORDER BY id`, userId, domain.TenantFrom(ctx))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting user audit error: %v", err))
+		return nil, fmt.Errorf("getting postgres user audit error: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []domain.AuditEntry{}
+	for rows.Next() {
+		var (
+			entry domain.AuditEntry
+			diff  []byte
+		)
+		err = rows.Scan(&entry.Id, &entry.UserId, &entry.ActorId, &entry.RequestId, &entry.Action, &diff, &entry.CreatedAt)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning user audit error: %v", err))
+			return nil, fmt.Errorf("scanning postgres user audit error: %v", err)
+		}
+
+		err = json.Unmarshal(diff, &entry.Diff)
+		if err != nil {
+			return nil, fmt.Errorf("user audit diff unmarshalling error: %v", err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting user audit error: %v", err))
+		return nil, fmt.Errorf("getting postgres user audit error: %v", err)
+	}
+
+	return entries, nil
+}
+
+// archiveSessions читает все сессии пользователя, включая отозванные, без хэшей токенов
+// Адреса и User-Agent расшифровываются, это тоже персональные данные
+func archiveSessions(ctx context.Context, tx *sql.Tx, c interfaces.FieldCipher, userId domain.Id) ([]domain.Session, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id, user_id, access_expires_at, refresh_expires_at, created_at, revoked_at, device, ip, user_agent, last_seen_at FROM sessions WHERE user_id = $1 ORDER BY id`, userId)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting user sessions error: %v", err))
+		return nil, fmt.Errorf("getting postgres sessions error: %v", err)
+	}
+	defer rows.Close()
+
+	sessions := []domain.Session{}
+	for rows.Next() {
+		var (
+			session       domain.Session
+			ip, userAgent sql.NullString
+		)
+		err = rows.Scan(&session.Id, &session.UserId, &session.AccessExpiresAt, &session.RefreshExpiresAt, &session.CreatedAt, &session.RevokedAt, &session.Device, &ip, &userAgent, &session.LastSeenAt)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning user session error: %v", err))
+			return nil, fmt.Errorf("scanning postgres session error: %v", err)
+		}
+
+		err = openClient(c, &session, ip, userAgent)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting user sessions error: %v", err))
+		return nil, fmt.Errorf("getting postgres sessions error: %v", err)
+	}
+
+	return sessions, nil
+}
+
+// archiveConsents читает согласия пользователя
+func archiveConsents(ctx context.Context, tx *sql.Tx, userId domain.Id) ([]domain.Consent, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT purpose, granted, updated_at FROM user_consents WHERE user_id = $1 ORDER BY purpose`, userId)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting user consents error: %v", err))
+		return nil, fmt.Errorf("getting postgres consents error: %v", err)
+	}
+	defer rows.Close()
+
+	consents := []domain.Consent{}
+	for rows.Next() {
+		var consent domain.Consent
+		err = rows.Scan(&consent.Purpose, &consent.Granted, &consent.UpdatedAt)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning user consent error: %v", err))
+			return nil, fmt.Errorf("scanning postgres consent error: %v", err)
+		}
+
+		consents = append(consents, consent)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting user consents error: %v", err))
+		return nil, fmt.Errorf("getting postgres consents error: %v", err)
+	}
+
+	return consents, nil
+}
@@ -0,0 +1,91 @@
+package realization
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тест обезличивания журнала: персональные поля заменяются, остальные остаются
+func TestEraseDiff(t *testing.T) {
+	diff := map[string]domain.FieldChange{
+		"name":       {Old: "John", New: "Jack"},
+		"birthday":   {Old: nil, New: "1990-05-17"},
+		"password":   {Old: domain.REDACTED, New: domain.REDACTED},
+		"deleted_at": {Old: nil, New: "2024-01-01T00:00:00Z"},
+	}
+
+	assert.True(t, eraseDiff(diff))
+	assert.Equal(t, map[string]domain.FieldChange{
+		"name":       {Old: domain.ERASED, New: domain.ERASED},
+		"birthday":   {Old: nil, New: domain.ERASED},
+		"password":   {Old: domain.REDACTED, New: domain.REDACTED},
+		"deleted_at": {Old: nil, New: "2024-01-01T00:00:00Z"},
+	}, diff)
+
+	assert.False(t, eraseDiff(diff))
+}
+
+// Тест удаления: профиль и журнал обезличиваются, ключ кэша удаляется, остается запись об удалении
+func TestErase(t *testing.T) {
+	users, mock, cache := newMockService(t)
+	s := NewGdprService(users.db, cache, users.cipher)
+	cache.users[cacheKey(domain.DEFAULT_TENANT, 3)] = domain.User{Id: 3, Login: "john@example.com", Version: 2}
+
+	erasedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT version FROM users WHERE id = \$1 AND tenant_id = \$2 FOR UPDATE`).
+		WithArgs(domain.Id(3), domain.DEFAULT_TENANT).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+	mock.ExpectQuery(`FROM user_erasures`).WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectQuery(`UPDATE users SET first_name = \$2`).
+		WithArgs(domain.Id(3), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), users.cipher.BlindIndex("erased-3@erased.invalid"), "test").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "deleted_at"}).AddRow(3, erasedAt))
+	mock.ExpectQuery(`SELECT id, diff FROM user_audit`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "diff"}).
+			AddRow(1, []byte(`{"email":{"old":null,"new":"john@example.com"}}`)).
+			AddRow(2, []byte(`{"deleted_at":{"old":null,"new":"2024-01-01T00:00:00Z"}}`)))
+	mock.ExpectExec(`UPDATE user_audit SET diff`).
+		WithArgs(domain.Id(1), []byte(`{"email":{"old":null,"new":"[ERASED]"}}`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE webhook_deliveries`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`UPDATE outbox`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM sessions`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM user_consents`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM user_roles`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO user_erasures`).
+		WithArgs(domain.Id(3), domain.DEFAULT_TENANT, nil, "", 1, 2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"erased_at"}).AddRow(erasedAt))
+	mock.ExpectExec(`INSERT INTO user_audit`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO outbox`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	erasure, err := s.Erase(context.Background(), 3)
+	require.NoError(t, err)
+	assert.Equal(t, &domain.Erasure{UserId: 3, AuditEntries: 1, Sessions: 2, Consents: 1, ErasedAt: erasedAt}, erasure)
+	assert.NotContains(t, cache.users, cacheKey(domain.DEFAULT_TENANT, 3))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест повторного удаления
+func TestErase_Already(t *testing.T) {
+	users, mock, cache := newMockService(t)
+	s := NewGdprService(users.db, cache, users.cipher)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FOR UPDATE`).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+	mock.ExpectQuery(`FROM user_erasures`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "actor_id", "request_id", "audit_entries", "sessions", "consents", "erased_at"}).
+			AddRow(3, nil, "", 1, 0, 0, time.Now()))
+	mock.ExpectRollback()
+
+	_, err := s.Erase(context.Background(), 3)
+	assert.ErrorIs(t, err, domain.ErrAlreadyErased)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,246 @@
+package realization
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"user/internal/interfaces"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	ARGON2ID = "argon2id"
+	BCRYPT   = "bcrypt"
+	SHA256   = "sha256"
+)
+
+var (
+	ErrUnknownHash = errors.New("unknown password hash format")
+)
+
+// Argon2idHasher хэширует пароли алгоритмом argon2id
+// Хэш хранится в формате PHC: $argon2id$v=19$m=...,t=...,p=...$соль$хэш
+type Argon2idHasher struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	saltLen uint32
+	keyLen  uint32
+}
+
+// NewArgon2idHasher создает новый экземпляр Argon2idHasher
+// time - количество проходов
+// memory - объем памяти в KiB
+// threads - степень параллелизма
+func NewArgon2idHasher(time, memory uint32, threads uint8) *Argon2idHasher {
+	return &Argon2idHasher{
+		time:    time,
+		memory:  memory,
+		threads: threads,
+		saltLen: 16,
+		keyLen:  32,
+	}
+}
+
+// Hash возвращает хэш пароля со случайной солью
+func (h *Argon2idHasher) Hash(pass string) (string, error) {
+	salt := make([]byte, h.saltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", fmt.Errorf("generating salt error: %v", err)
+	}
+
+	key := argon2.IDKey([]byte(pass), salt, h.time, h.memory, h.threads, h.keyLen)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", ARGON2ID, argon2.Version, h.memory, h.time, h.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify проверяет пароль с параметрами, сохраненными в самом хэше
+func (h *Argon2idHasher) Verify(pass, hash string) (bool, error) {
+	params, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey([]byte(pass), salt, params.time, params.memory, params.threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// NeedsRehash сообщает, что хэш создан другим алгоритмом или с другими параметрами
+func (h *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return true
+	}
+
+	return params.time != h.time || params.memory != h.memory || params.threads != h.threads ||
+		uint32(len(salt)) != h.saltLen || uint32(len(key)) != h.keyLen
+}
+
+// parseArgon2id разбирает хэш argon2id в формате PHC
+func parseArgon2id(hash string) (*Argon2idHasher, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != ARGON2ID {
+		return nil, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, nil, nil, fmt.Errorf("unsupported argon2 version: %s", parts[2])
+	}
+
+	var params Argon2idHasher
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid argon2 params: %v", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid argon2 salt: %v", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid argon2 key: %v", err)
+	}
+
+	return &params, salt, key, nil
+}
+
+// BcryptHasher хэширует пароли алгоритмом bcrypt
+// Хэш хранится в модульном формате crypt: $2a$cost$...
+type BcryptHasher struct {
+	cost int
+}
+
+// NewBcryptHasher создает новый экземпляр BcryptHasher
+// cost - стоимость хэширования
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{
+		cost: cost,
+	}
+}
+
+// Hash возвращает хэш пароля
+func (h *BcryptHasher) Hash(pass string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), h.cost)
+	if err != nil {
+		return "", fmt.Errorf("bcrypt hashing error: %v", err)
+	}
+
+	return string(hash), nil
+}
+
+// Verify проверяет пароль по хэшу bcrypt
+func (h *BcryptHasher) Verify(pass, hash string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return false, ErrUnknownHash
+	}
+
+	return true, nil
+}
+
+// NeedsRehash сообщает, что хэш создан другим алгоритмом или с другой стоимостью
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.cost
+}
+
+// SHA256Hasher проверяет устаревшие хэши - hex SHA-256 без соли
+// Используется только для проверки, новые хэши им не создаются
+type SHA256Hasher struct{}
+
+// Hash возвращает hex SHA-256 пароля
+func (SHA256Hasher) Hash(pass string) (string, error) {
+	hash := sha256.Sum256([]byte(pass))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// Verify проверяет пароль по hex SHA-256
+func (h SHA256Hasher) Verify(pass, hash string) (bool, error) {
+	other, _ := h.Hash(pass)
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(other)) == 1, nil
+}
+
+// NeedsRehash всегда требует перехэширования
+func (SHA256Hasher) NeedsRehash(string) bool {
+	return true
+}
+
+// PasswordHasher создает хэши текущим алгоритмом и проверяет хэши любого известного формата
+type PasswordHasher struct {
+	active  string
+	hashers map[string]interfaces.PasswordHasher
+}
+
+// NewPasswordHasher создает новый экземпляр PasswordHasher
+// active - алгоритм для новых хэшей (argon2id или bcrypt)
+func NewPasswordHasher(active string, argonHasher *Argon2idHasher, bcryptHasher *BcryptHasher) (*PasswordHasher, error) {
+	if active != ARGON2ID && active != BCRYPT {
+		return nil, fmt.Errorf("unknown password hasher: %s", active)
+	}
+
+	return &PasswordHasher{
+		active: active,
+		hashers: map[string]interfaces.PasswordHasher{
+			ARGON2ID: argonHasher,
+			BCRYPT:   bcryptHasher,
+			SHA256:   SHA256Hasher{},
+		},
+	}, nil
+}
+
+// Hash возвращает хэш пароля текущим алгоритмом
+func (h *PasswordHasher) Hash(pass string) (string, error) {
+	return h.hashers[h.active].Hash(pass)
+}
+
+// Verify проверяет пароль алгоритмом, которым был создан хэш
+func (h *PasswordHasher) Verify(pass, hash string) (bool, error) {
+	hasher, ok := h.hashers[identifyHash(hash)]
+	if !ok {
+		return false, ErrUnknownHash
+	}
+
+	return hasher.Verify(pass, hash)
+}
+
+// NeedsRehash сообщает, что хэш создан не текущим алгоритмом или устаревшими параметрами
+func (h *PasswordHasher) NeedsRehash(hash string) bool {
+	algo := identifyHash(hash)
+	if algo != h.active {
+		return true
+	}
+
+	return h.hashers[algo].NeedsRehash(hash)
+}
+
+// identifyHash определяет алгоритм по формату хэша
+func identifyHash(hash string) string {
+	switch {
+	case strings.HasPrefix(hash, "$"+ARGON2ID+"$"):
+		return ARGON2ID
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return BCRYPT
+	case len(hash) == sha256.Size*2:
+		_, err := hex.DecodeString(hash)
+		if err == nil {
+			return SHA256
+		}
+	}
+
+	return ""
+}
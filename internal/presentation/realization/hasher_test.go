@@ -0,0 +1,77 @@
+package realization
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHasher(t *testing.T, active string) *PasswordHasher {
+	hasher, err := NewPasswordHasher(active, NewArgon2idHasher(1, 8*1024, 1), NewBcryptHasher(4))
+	assert.NoError(t, err)
+	return hasher
+}
+
+// Тест хэширования и проверки пароля каждым алгоритмом
+func TestPasswordHasher_HashVerify(t *testing.T) {
+	for _, algo := range []string{ARGON2ID, BCRYPT} {
+		t.Run(algo, func(t *testing.T) {
+			hasher := newTestHasher(t, algo)
+
+			hash, err := hasher.Hash("StrongPassword123!")
+			assert.NoError(t, err)
+			assert.Equal(t, algo, identifyHash(hash))
+			assert.False(t, hasher.NeedsRehash(hash))
+
+			ok, err := hasher.Verify("StrongPassword123!", hash)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+
+			ok, err = hasher.Verify("WrongPassword123!", hash)
+			assert.NoError(t, err)
+			assert.False(t, ok)
+		})
+	}
+}
+
+// Тест проверки устаревшего хэша SHA-256
+func TestPasswordHasher_LegacySHA256(t *testing.T) {
+	hasher := newTestHasher(t, ARGON2ID)
+	legacy, _ := SHA256Hasher{}.Hash("StrongPassword123!")
+
+	ok, err := hasher.Verify("StrongPassword123!", legacy)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, hasher.NeedsRehash(legacy))
+}
+
+// Тест перехэширования при смене алгоритма и параметров
+func TestPasswordHasher_NeedsRehash(t *testing.T) {
+	argon := newTestHasher(t, ARGON2ID)
+	bcrypt := newTestHasher(t, BCRYPT)
+
+	hash, err := bcrypt.Hash("StrongPassword123!")
+	assert.NoError(t, err)
+	assert.True(t, argon.NeedsRehash(hash))
+
+	ok, err := argon.Verify("StrongPassword123!", hash)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	stronger, err := NewPasswordHasher(ARGON2ID, NewArgon2idHasher(2, 8*1024, 1), NewBcryptHasher(4))
+	assert.NoError(t, err)
+	hash, err = argon.Hash("StrongPassword123!")
+	assert.NoError(t, err)
+	assert.True(t, stronger.NeedsRehash(hash))
+}
+
+// Тест неизвестного формата хэша
+func TestPasswordHasher_UnknownFormat(t *testing.T) {
+	hasher := newTestHasher(t, ARGON2ID)
+
+	_, err := hasher.Verify("StrongPassword123!", "plain")
+	assert.ErrorIs(t, err, ErrUnknownHash)
+
+	_, err = NewPasswordHasher("md5", nil, nil)
+	assert.Error(t, err)
+}
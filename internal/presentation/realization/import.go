@@ -0,0 +1,356 @@
+package realization
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"user/internal/domain"
+	"user/internal/interfaces"
+	"user/internal/presentation/db"
+	"user/internal/presentation/logger"
+
+	"github.com/lib/pq"
+)
+
+// IMPORT_BATCH - количество строк, загружаемых одним COPY в одной транзакции
+const IMPORT_BATCH = 500
+
+// ImportService загружает пользователей из файлов импорта и хранит задачи с отчетами
+type ImportService struct {
+	db     *db.DB
+	cache  interfaces.CacheRepo
+	cipher interfaces.FieldCipher
+}
+
+// NewImportService создает новый экземпляр ImportService
+// cipher - шифрование персональных данных в users
+func NewImportService(db *db.DB, cache interfaces.CacheRepo, cipher interfaces.FieldCipher) *ImportService {
+	return &ImportService{
+		db:     db,
+		cache:  cache,
+		cipher: cipher,
+	}
+}
+
+// Create сохраняет задачу импорта в статусе running от имени инициатора из контекста
+func (s *ImportService) Create(ctx context.Context, job domain.ImportJob) (*domain.Id, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var id domain.Id
+	err := s.db.Db.QueryRowContext(ctx, `INSERT INTO imports (tenant_id, format, dry_run, overwrite_password, created_by) VALUES ($1, $2, $3, $4, $5) RETURNING id`, domain.TenantFrom(ctx), job.Format, job.DryRun, job.OverwritePassword, domain.ActorFrom(ctx).UserId).Scan(&id)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Creating import error: %v", err))
+		return nil, fmt.Errorf("creating postgres import error: %v", err)
+	}
+
+	return &id, nil
+}
+
+// Get возвращает задачу импорта вместе с отчетом, nil если задача не найдена
+func (s *ImportService) Get(ctx context.Context, id domain.Id) (*domain.ImportJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var (
+		job     domain.ImportJob
+		errMsg  sql.NullString
+		rejects []byte
+	)
+	err := s.db.Db.QueryRowContext(ctx, `SELECT id, format, dry_run, overwrite_password, status, total, created, updated, passwords_kept, failed, errors, error, created_by, created_at, finished_at FROM imports WHERE id = $1 AND tenant_id = $2`, id, domain.TenantFrom(ctx)).Scan(&job.Id, &job.Format, &job.DryRun, &job.OverwritePassword, &job.Status, &job.Total, &job.Created, &job.Updated, &job.PasswordsKept, &job.Failed, &rejects, &errMsg, &job.CreatedBy, &job.CreatedAt, &job.FinishedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting import error: %v", err))
+		return nil, fmt.Errorf("getting postgres import error: %v", err)
+	}
+
+	err = json.Unmarshal(rejects, &job.Errors)
+	if err != nil {
+		return nil, fmt.Errorf("import errors unmarshalling error: %v", err)
+	}
+	job.Error = errMsg.String
+
+	return &job, nil
+}
+
+// Run загружает строки пачками по IMPORT_BATCH и сохраняет итог задачи
+// Из задачи берутся идентификатор и параметры DryRun и OverwritePassword
+// rejected - строки, не прошедшие проверку до загрузки, они попадают в отчет
+// При DryRun каждая пачка выполняется и откатывается, поэтому отчет совпадает с настоящим импортом
+// Ошибка инфраструктуры останавливает импорт, уже загруженные пачки остаются
+func (s *ImportService) Run(ctx context.Context, params domain.ImportJob, rows []domain.ImportRow, rejected []domain.ImportError) (*domain.ImportJob, error) {
+	id := params.Id
+	logger.Logger.Info(fmt.Sprintf("Import %d has been started, %d rows", id, len(rows)))
+	job := domain.ImportJob{
+		Id:     id,
+		Status: domain.IMPORT_DONE,
+		Total:  len(rows) + len(rejected),
+		Errors: append([]domain.ImportError{}, rejected...),
+	}
+
+	for start := 0; start < len(rows); start += IMPORT_BATCH {
+		batch := rows[start:min(start+IMPORT_BATCH, len(rows))]
+		result, rejects, err := s.batch(ctx, batch, params.DryRun, params.OverwritePassword)
+		if err != nil {
+			job.Status = domain.IMPORT_FAILED
+			job.Error = fmt.Sprintf("Import stopped at line %d: %v", batch[0].Line, err)
+			break
+		}
+
+		job.Created += result.Created
+		job.Updated += result.Updated
+		job.PasswordsKept += result.PasswordsKept
+		job.Errors = append(job.Errors, rejects...)
+	}
+	job.Failed = len(job.Errors)
+
+	err := s.finish(ctx, &job)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Logger.Info(fmt.Sprintf("Import %d has been finished: %s, created %d, updated %d, passwords kept %d, failed %d", id, job.Status, job.Created, job.Updated, job.PasswordsKept, job.Failed))
+	return s.Get(ctx, id)
+}
+
+// finish сохраняет итог задачи
+func (s *ImportService) finish(ctx context.Context, job *domain.ImportJob) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	rejects, err := json.Marshal(job.Errors)
+	if err != nil {
+		return fmt.Errorf("import errors marshalling error: %v", err)
+	}
+
+	_, err = s.db.Db.ExecContext(ctx, `UPDATE imports SET status = $2, total = $3, created = $4, updated = $5, passwords_kept = $6, failed = $7, errors = $8, error = NULLIF($9, ''), finished_at = NOW() WHERE id = $1`, job.Id, job.Status, job.Total, job.Created, job.Updated, job.PasswordsKept, job.Failed, rejects, job.Error)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Finishing import error: %v", err))
+		return fmt.Errorf("finishing postgres import error: %v", err)
+	}
+
+	return nil
+}
+
+// batch загружает пачку строк через COPY во временную таблицу и одну вставку с ON CONFLICT
+// Пользователи сопоставляются по слепому индексу логина, мягко удаленные пользователи не обновляются
+// Существующим пользователям пароль из файла записывается только при overwritePassword,
+// после смены пароля их сессии отзываются, при смене email подтверждение сбрасывается
+// Для каждого созданного и обновленного пользователя пишутся журнал и событие
+// Возвращает счетчики пачки для задачи
+func (s *ImportService) batch(ctx context.Context, rows []domain.ImportRow, dryRun, overwritePassword bool) (*domain.ImportJob, []domain.ImportError, error) {
+	// COPY и запись журнала для пачки занимают больше обычных 5 секунд
+	ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+
+	tx, err := s.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Beginning transaction error: %v", err))
+		return nil, nil, fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `CREATE TEMP TABLE import_users (line INTEGER, first_name TEXT, last_name TEXT, birthday TEXT, login TEXT, login_index VARCHAR(64), key_id VARCHAR(32), password VARCHAR(255)) ON COMMIT DROP`)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Creating import table error: %v", err))
+		return nil, nil, fmt.Errorf("creating postgres import table error: %v", err)
+	}
+
+	err = s.copyRows(ctx, tx, rows)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	before, err := s.lockImported(ctx, tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Логин перезаписывается вместе с остальными полями, чтобы вся строка была на активном ключе
+	// Повторный импорт не должен сбрасывать пароли, которые пользователи сменили после первой загрузки
+	rowsRes, err := tx.QueryContext(ctx, `INSERT INTO users (tenant_id, first_name, last_name, birthday, login, login_index, key_id, password)
+		SELECT $1, first_name, last_name, birthday, login, login_index, key_id, password FROM import_users ORDER BY line
+		ON CONFLICT (tenant_id, login_index) DO UPDATE SET first_name = EXCLUDED.first_name, last_name = EXCLUDED.last_name, birthday = EXCLUDED.birthday, login = EXCLUDED.login, key_id = EXCLUDED.key_id,
+			password = CASE WHEN $2 THEN EXCLUDED.password ELSE users.password END,
+			email_verified_at = CASE WHEN users.login_index = EXCLUDED.login_index THEN users.email_verified_at END,
+			version = users.version + 1
+		WHERE users.deleted_at IS NULL
+		RETURNING id, first_name, last_name, birthday, login, version, created_at, deleted_at, password`, domain.TenantFrom(ctx), overwritePassword)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Importing users error: %v", err))
+		return nil, nil, fmt.Errorf("importing postgres users error: %v", err)
+	}
+
+	saved := []domain.User{}
+	for rowsRes.Next() {
+		var row userRow
+		err = rowsRes.Scan(row.dest(&row.user.Password)...)
+		if err != nil {
+			rowsRes.Close()
+			logger.Logger.Error(fmt.Sprintf("Scanning imported user error: %v", err))
+			return nil, nil, fmt.Errorf("scanning postgres imported user error: %v", err)
+		}
+
+		user, err := row.decrypt(s.cipher)
+		if err != nil {
+			rowsRes.Close()
+			return nil, nil, err
+		}
+		saved = append(saved, *user)
+	}
+	rowsRes.Close()
+
+	err = rowsRes.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Importing users error: %v", err))
+		return nil, nil, fmt.Errorf("importing postgres users error: %v", err)
+	}
+
+	result := &domain.ImportJob{}
+	updatedIds := []domain.Id{}
+	for i := range saved {
+		user := &saved[i]
+		old, ok := before[user.Login]
+		if !ok {
+			diff := diffFields(auditFields(nil), auditFields(user))
+			err = writeAudit(ctx, tx, user.Id, domain.AUDIT_CREATE, diff)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			err = writeEvent(ctx, tx, domain.EVENT_USER_CREATED, eventPayload(user, nil))
+			if err != nil {
+				return nil, nil, err
+			}
+
+			result.Created++
+			continue
+		}
+
+		// После смены пароля остальные устройства должны войти заново
+		if user.Password != old.Password {
+			_, err = revokeOtherSessions(ctx, tx, user.Id)
+			if err != nil {
+				return nil, nil, err
+			}
+		} else if !overwritePassword {
+			result.PasswordsKept++
+		}
+
+		diff := diffFields(auditFields(old), auditFields(user))
+		err = writeAudit(ctx, tx, user.Id, domain.AUDIT_UPDATE, diff)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = writeEvent(ctx, tx, domain.EVENT_USER_UPDATED, eventPayload(user, diff))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		result.Updated++
+		updatedIds = append(updatedIds, user.Id)
+	}
+
+	// Строки без результата совпали с мягко удаленными пользователями
+	rejects := []domain.ImportError{}
+	for _, row := range rows {
+		if old, ok := before[row.User.Login]; ok && old.DeletedAt != nil {
+			rejects = append(rejects, domain.ImportError{
+				Line:  row.Line,
+				Email: row.User.Login,
+				Error: fmt.Sprintf("User with email %s is deleted", row.User.Login),
+			})
+		}
+	}
+
+	if dryRun {
+		return result, rejects, nil
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Committing transaction error: %v", err))
+		return nil, nil, fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	for _, id := range updatedIds {
+		err = s.cache.DelKey(domain.TenantFrom(ctx), id)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Deleating Redis key error: %v", err))
+		}
+	}
+
+	return result, rejects, nil
+}
+
+// copyRows шифрует строки и загружает их во временную таблицу import_users через COPY
+func (s *ImportService) copyRows(ctx context.Context, tx *sql.Tx, rows []domain.ImportRow) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("import_users", "line", "first_name", "last_name", "birthday", "login", "login_index", "key_id", "password"))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Preparing copy error: %v", err))
+		return fmt.Errorf("preparing postgres copy error: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, row := range rows {
+		sealed, err := sealUser(s.cipher, &row.User)
+		if err != nil {
+			return err
+		}
+
+		_, err = stmt.ExecContext(ctx, row.Line, sealed.firstName, sealed.lastName, sealed.birthday, sealed.login, sealed.loginIndex, sealed.keyId, row.User.Password)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Copying user error: %v", err))
+			return fmt.Errorf("copying postgres user error: %v", err)
+		}
+	}
+
+	_, err = stmt.ExecContext(ctx)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Copying users error: %v", err))
+		return fmt.Errorf("copying postgres users error: %v", err)
+	}
+
+	return nil
+}
+
+// lockImported блокирует существующих пользователей организации с логинами из пачки
+// и возвращает их состояние до импорта для журнала по расшифрованному логину
+func (s *ImportService) lockImported(ctx context.Context, tx *sql.Tx) (map[string]*domain.User, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT u.id, u.first_name, u.last_name, u.birthday, u.login, u.version, u.created_at, u.deleted_at, u.password FROM users u JOIN import_users i ON i.login_index = u.login_index WHERE u.tenant_id = $1 FOR UPDATE OF u`, domain.TenantFrom(ctx))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Locking imported users error: %v", err))
+		return nil, fmt.Errorf("locking postgres imported users error: %v", err)
+	}
+	defer rows.Close()
+
+	users := map[string]*domain.User{}
+	for rows.Next() {
+		var row userRow
+		err = rows.Scan(row.dest(&row.user.Password)...)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning imported user error: %v", err))
+			return nil, fmt.Errorf("scanning postgres imported user error: %v", err)
+		}
+
+		user, err := row.decrypt(s.cipher)
+		if err != nil {
+			return nil, err
+		}
+		users[user.Login] = user
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Locking imported users error: %v", err))
+		return nil, fmt.Errorf("locking postgres imported users error: %v", err)
+	}
+
+	return users, nil
+}
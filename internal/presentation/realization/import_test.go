@@ -0,0 +1,70 @@
+package realization
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тест повторного импорта: пароль существующего пользователя сохраняется без overwrite_password,
+// а при перезаписи его сессии отзываются
+func TestImport_Password(t *testing.T) {
+	users, mock, cache := newMockService(t)
+	s := NewImportService(users.db, cache, users.cipher)
+
+	stored, err := users.hasher.Hash("StrongPassword123!")
+	require.NoError(t, err)
+	imported, err := users.hasher.Hash("ImportedPassword123!")
+	require.NoError(t, err)
+
+	run := func(overwrite bool, returned string, kept int, expect func()) {
+		userRows := func(password string) *sqlmock.Rows {
+			return sqlmock.NewRows([]string{"id", "first_name", "last_name", "birthday", "login", "version", "created_at", "deleted_at", "password"}).
+				AddRow(5, encrypt(t, s.cipher, "John"), encrypt(t, s.cipher, ""), nil, encrypt(t, s.cipher, "john@example.com"), 2, time.Now(), nil, password)
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`CREATE TEMP TABLE import_users`).WillReturnResult(driver.ResultNoRows)
+		copyStmt := mock.ExpectPrepare(`COPY`)
+		copyStmt.ExpectExec().WithArgs(2, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), imported).WillReturnResult(driver.RowsAffected(1))
+		copyStmt.ExpectExec().WillReturnResult(driver.RowsAffected(0))
+		mock.ExpectQuery(`FOR UPDATE OF u`).WithArgs(domain.DEFAULT_TENANT).WillReturnRows(userRows(stored))
+		mock.ExpectQuery(`INSERT INTO users`).WithArgs(domain.DEFAULT_TENANT, overwrite).WillReturnRows(userRows(returned))
+		expect()
+		mock.ExpectExec(`INSERT INTO outbox`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectExec(`UPDATE imports SET`).
+			WithArgs(domain.Id(1), domain.IMPORT_DONE, 1, 0, 1, kept, 0, sqlmock.AnyArg(), "").
+			WillReturnResult(driver.RowsAffected(1))
+		mock.ExpectQuery(`FROM imports`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "format", "dry_run", "overwrite_password", "status", "total", "created", "updated", "passwords_kept", "failed", "errors", "error", "created_by", "created_at", "finished_at"}).
+				AddRow(1, "csv", false, overwrite, domain.IMPORT_DONE, 1, 0, 1, kept, 0, []byte(`[]`), nil, nil, time.Now(), time.Now()))
+
+		row := domain.ImportRow{Line: 2, User: domain.User{FirstName: "Jack", Login: "john@example.com", Password: imported}}
+		job, err := s.Run(context.Background(), domain.ImportJob{Id: 1, OverwritePassword: overwrite}, []domain.ImportRow{row}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 1, job.Updated)
+		assert.Equal(t, kept, job.PasswordsKept)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	}
+
+	run(false, stored, 1, func() {
+		mock.ExpectExec(`INSERT INTO user_audit`).
+			WithArgs(domain.Id(5), domain.DEFAULT_TENANT, nil, "", domain.AUDIT_UPDATE, []byte(`{}`)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	})
+
+	run(true, imported, 0, func() {
+		mock.ExpectExec(`UPDATE sessions SET revoked_at`).WithArgs(domain.Id(5), nil).WillReturnResult(driver.RowsAffected(2))
+		mock.ExpectExec(`INSERT INTO user_audit`).
+			WithArgs(domain.Id(5), domain.DEFAULT_TENANT, nil, "", domain.AUDIT_UPDATE, []byte(`{"password":{"old":"[REDACTED]","new":"[REDACTED]"}}`)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	})
+}
@@ -0,0 +1,299 @@
+package realization
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// KEY_SIZE - размер ключей шифрования и ключа слепого индекса в байтах (AES-256)
+	KEY_SIZE = 32
+
+	// ENVELOPE_VERSION - версия формата зашифрованного значения
+	ENVELOPE_VERSION = "v1"
+)
+
+var (
+	ErrUnknownKey      = errors.New("unknown encryption key")
+	ErrInvalidEnvelope = errors.New("invalid encrypted value")
+	ErrInvalidKeyring  = errors.New("invalid keyring")
+)
+
+var (
+	// keyIdRe - допустимый идентификатор ключа, двоеточие разделяет части значения
+	keyIdRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+	envelopeEncoding = base64.RawStdEncoding
+)
+
+// Keyring шифрует поля конвертным шифрованием на локальных ключах
+// Каждое значение шифруется своим случайным ключом данных (AES-256-GCM),
+// ключ данных шифруется ключом из связки, идентификатор которого хранится в значении:
+//
+//	v1:<ключ>:<зашифрованный ключ данных>:<nonce и шифротекст>
+//
+// При смене активного ключа достаточно перешифровать ключ данных, сами данные не меняются
+type Keyring struct {
+	keys   map[string]cipher.AEAD
+	active string
+	index  []byte
+}
+
+// NewKeyring создает связку ключей
+// keys - ключи по идентификаторам, старые ключи нужны до окончания перешифрования
+// active - ключ, которым шифруются новые значения
+// indexKey - ключ HMAC слепого индекса, он не меняется при ротации
+func NewKeyring(keys map[string][]byte, active string, indexKey []byte) (*Keyring, error) {
+	if active == "" {
+		return nil, fmt.Errorf("%w: active key is required", ErrInvalidKeyring)
+	}
+
+	if len(indexKey) < KEY_SIZE {
+		return nil, fmt.Errorf("%w: blind index key must be at least %d bytes", ErrInvalidKeyring, KEY_SIZE)
+	}
+
+	ring := &Keyring{
+		keys:   make(map[string]cipher.AEAD, len(keys)),
+		active: active,
+		index:  indexKey,
+	}
+	for id, key := range keys {
+		if !keyIdRe.MatchString(id) {
+			return nil, fmt.Errorf("%w: invalid key id %q", ErrInvalidKeyring, id)
+		}
+
+		if len(key) != KEY_SIZE {
+			return nil, fmt.Errorf("%w: key %q must be %d bytes", ErrInvalidKeyring, id, KEY_SIZE)
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		ring.keys[id] = aead
+	}
+
+	if _, ok := ring.keys[active]; !ok {
+		return nil, fmt.Errorf("%w: active key %q is not loaded", ErrInvalidKeyring, active)
+	}
+
+	return ring, nil
+}
+
+// ParseKeys разбирает ключи из строки вида "id1:base64,id2:base64"
+func ParseKeys(str string) (map[string][]byte, error) {
+	keys := map[string][]byte{}
+	for _, pair := range strings.Split(str, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: key must be written as id:base64", ErrInvalidKeyring)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("%w: key %q is not base64", ErrInvalidKeyring, id)
+		}
+		keys[strings.TrimSpace(id)] = key
+	}
+
+	return keys, nil
+}
+
+// ReadKeys читает ключи из каталога: имя файла - идентификатор ключа, содержимое - ключ в base64
+func ReadKeys(dir string) (map[string][]byte, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading keys directory error: %v", err)
+	}
+
+	keys := map[string][]byte{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("reading key file error: %v", err)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("%w: key %q is not base64", ErrInvalidKeyring, entry.Name())
+		}
+		keys[entry.Name()] = key
+	}
+
+	return keys, nil
+}
+
+// Encrypt шифрует значение новым ключом данных, который шифруется активным ключом
+func (k *Keyring) Encrypt(plain string) (string, error) {
+	dataKey := make([]byte, KEY_SIZE)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return "", fmt.Errorf("generating data key error: %v", err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := gcmSeal(aead, []byte(plain), nil)
+	if err != nil {
+		return "", err
+	}
+
+	wrapped, err := gcmSeal(k.keys[k.active], dataKey, []byte(k.active))
+	if err != nil {
+		return "", err
+	}
+
+	return envelope(k.active, wrapped, data), nil
+}
+
+// Decrypt расшифровывает значение
+func (k *Keyring) Decrypt(value string) (string, error) {
+	_, dataKey, data, err := k.unwrap(value)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	plain, err := gcmOpen(aead, data, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plain), nil
+}
+
+// Rewrap перешифровывает ключ данных активным ключом
+// Значение, уже зашифрованное активным ключом, возвращается без изменений
+func (k *Keyring) Rewrap(value string) (string, error) {
+	id, dataKey, data, err := k.unwrap(value)
+	if err != nil {
+		return "", err
+	}
+
+	if id == k.active {
+		return value, nil
+	}
+
+	wrapped, err := gcmSeal(k.keys[k.active], dataKey, []byte(k.active))
+	if err != nil {
+		return "", err
+	}
+
+	return envelope(k.active, wrapped, data), nil
+}
+
+// BlindIndex возвращает HMAC-SHA256 значения в hex
+func (k *Keyring) BlindIndex(value string) string {
+	mac := hmac.New(sha256.New, k.index)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ActiveKey возвращает идентификатор активного ключа
+func (k *Keyring) ActiveKey() string {
+	return k.active
+}
+
+// unwrap разбирает значение и расшифровывает его ключ данных
+// Возвращает идентификатор ключа, ключ данных и зашифрованные данные
+func (k *Keyring) unwrap(value string) (string, []byte, []byte, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 4 || parts[0] != ENVELOPE_VERSION {
+		return "", nil, nil, ErrInvalidEnvelope
+	}
+
+	id := parts[1]
+	kek, ok := k.keys[id]
+	if !ok {
+		return "", nil, nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+
+	wrapped, err := envelopeEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, ErrInvalidEnvelope
+	}
+
+	data, err := envelopeEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", nil, nil, ErrInvalidEnvelope
+	}
+
+	dataKey, err := gcmOpen(kek, wrapped, []byte(id))
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	return id, dataKey, data, nil
+}
+
+// envelope собирает зашифрованное значение
+func envelope(id string, wrapped, data []byte) string {
+	return strings.Join([]string{ENVELOPE_VERSION, id, envelopeEncoding.EncodeToString(wrapped), envelopeEncoding.EncodeToString(data)}, ":")
+}
+
+// newAEAD создает AES-GCM на ключе
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher error: %v", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher error: %v", err)
+	}
+
+	return aead, nil
+}
+
+// gcmSeal шифрует данные со случайным nonce, nonce записывается перед шифротекстом
+func gcmSeal(aead cipher.AEAD, plain, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("generating nonce error: %v", err)
+	}
+
+	return aead.Seal(nonce, nonce, plain, additional), nil
+}
+
+// gcmOpen расшифровывает данные, записанные gcmSeal
+func gcmOpen(aead cipher.AEAD, data, additional []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, ErrInvalidEnvelope
+	}
+
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additional)
+	if err != nil {
+		return nil, ErrInvalidEnvelope
+	}
+
+	return plain, nil
+}
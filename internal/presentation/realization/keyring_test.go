@@ -0,0 +1,127 @@
+package realization
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"user/internal/interfaces"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestKeyring создает связку с одним ключом test
+func newTestKeyring(t *testing.T) *Keyring {
+	ring, err := NewKeyring(map[string][]byte{"test": bytes.Repeat([]byte{1}, KEY_SIZE)}, "test", bytes.Repeat([]byte{2}, KEY_SIZE))
+	require.NoError(t, err)
+	return ring
+}
+
+// encrypt шифрует значение для строк sqlmock
+func encrypt(t *testing.T, c interfaces.FieldCipher, value string) string {
+	encrypted, err := c.Encrypt(value)
+	require.NoError(t, err)
+	return encrypted
+}
+
+// Тест шифрования: одинаковые значения дают разные шифротексты и расшифровываются обратно
+func TestKeyring_EncryptDecrypt(t *testing.T) {
+	ring := newTestKeyring(t)
+
+	first := encrypt(t, ring, "john@example.com")
+	second := encrypt(t, ring, "john@example.com")
+	assert.NotEqual(t, first, second)
+	assert.True(t, strings.HasPrefix(first, "v1:test:"))
+	assert.NotContains(t, first, "john")
+
+	plain, err := ring.Decrypt(first)
+	require.NoError(t, err)
+	assert.Equal(t, "john@example.com", plain)
+
+	_, err = ring.Decrypt("john@example.com")
+	assert.ErrorIs(t, err, ErrInvalidEnvelope)
+
+	// Подмена данных обнаруживается при расшифровке
+	parts := strings.Split(first, ":")
+	parts[3] = parts[3][:len(parts[3])-2] + "AA"
+	_, err = ring.Decrypt(strings.Join(parts, ":"))
+	assert.ErrorIs(t, err, ErrInvalidEnvelope)
+}
+
+// Тест ротации: после смены активного ключа значение перешифровывается без изменения данных
+func TestKeyring_Rewrap(t *testing.T) {
+	old := newTestKeyring(t)
+	value := encrypt(t, old, "John")
+
+	keys := map[string][]byte{
+		"test": bytes.Repeat([]byte{1}, KEY_SIZE),
+		"next": bytes.Repeat([]byte{3}, KEY_SIZE),
+	}
+	ring, err := NewKeyring(keys, "next", bytes.Repeat([]byte{2}, KEY_SIZE))
+	require.NoError(t, err)
+
+	rotated, err := ring.Rewrap(value)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(rotated, "v1:next:"))
+	assert.Equal(t, strings.Split(value, ":")[3], strings.Split(rotated, ":")[3])
+
+	plain, err := ring.Decrypt(rotated)
+	require.NoError(t, err)
+	assert.Equal(t, "John", plain)
+
+	same, err := ring.Rewrap(rotated)
+	require.NoError(t, err)
+	assert.Equal(t, rotated, same)
+
+	// Без старого ключа значение расшифровать нельзя
+	_, err = old.Decrypt(rotated)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// Слепой индекс не зависит от ключей шифрования
+	assert.Equal(t, old.BlindIndex("john@example.com"), ring.BlindIndex("john@example.com"))
+	assert.NotEqual(t, ring.BlindIndex("john@example.com"), ring.BlindIndex("jane@example.com"))
+}
+
+// Тест проверки связки ключей
+func TestNewKeyring_Invalid(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, KEY_SIZE)
+	index := bytes.Repeat([]byte{2}, KEY_SIZE)
+
+	tests := []struct {
+		name   string
+		keys   map[string][]byte
+		active string
+		index  []byte
+	}{
+		{"No active", map[string][]byte{"a": key}, "", index},
+		{"Active not loaded", map[string][]byte{"a": key}, "b", index},
+		{"Short key", map[string][]byte{"a": key[:16]}, "a", index},
+		{"Invalid id", map[string][]byte{"a:b": key}, "a:b", index},
+		{"Short index key", map[string][]byte{"a": key}, "a", index[:8]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewKeyring(tt.keys, tt.active, tt.index)
+			assert.ErrorIs(t, err, ErrInvalidKeyring)
+		})
+	}
+}
+
+// Тест разбора ключей из переменной окружения
+func TestParseKeys(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, KEY_SIZE))
+
+	keys, err := ParseKeys("k1:" + key + ", k2:" + key)
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+	assert.Len(t, keys["k2"], KEY_SIZE)
+
+	_, err = ParseKeys("k1")
+	assert.ErrorIs(t, err, ErrInvalidKeyring)
+
+	_, err = ParseKeys("k1:???")
+	assert.ErrorIs(t, err, ErrInvalidKeyring)
+}
@@ -0,0 +1,241 @@
+package realization
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"user/internal/domain"
+	"user/internal/presentation/logger"
+)
+
+const (
+	DEFAULT_LIMIT = 20
+	MAX_LIMIT     = 100
+)
+
+// sortColumn описывает выражение сортировки и тип его значения в курсоре
+type sortColumn struct {
+	expr string
+	cast string
+}
+
+// sortColumns - поля сортировки, выражения совпадают с индексами
+// Персональные данные в users зашифрованы, поэтому сортировки по ним нет
+var sortColumns = map[string]sortColumn{
+	"id":         {expr: "id", cast: "bigint"},
+	"created_at": {expr: "created_at", cast: "timestamptz"},
+}
+
+// cursor - позиция последней выданной записи
+type cursor struct {
+	Sort  string    `json:"s"`
+	Value string    `json:"v"`
+	Id    domain.Id `json:"id"`
+}
+
+// List возвращает страницу пользователей по фильтру
+// Постраничная выборка идет по ключу (поле сортировки, id), поэтому не зависит от смещения
+// Выборка ограничена организацией из контекста
+func (s *UserService) List(ctx context.Context, filter domain.UserFilter) (*domain.UserPage, error) {
+	filter = s.scope(ctx, filter)
+	query, args, err := buildListQuery(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	logger.Logger.Debug("Listing users...")
+	rows, err := s.db.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Listing users error: %v", err))
+		return nil, fmt.Errorf("listing postgres users error: %v", err)
+	}
+	defer rows.Close()
+
+	limit := listLimit(filter.Limit)
+	page := &domain.UserPage{
+		Users: make([]domain.User, 0, limit),
+	}
+
+	var last cursor
+	for rows.Next() {
+		if len(page.Users) == limit {
+			page.NextCursor = encodeCursor(last)
+			break
+		}
+
+		var (
+			row     userRow
+			sortKey string
+		)
+		err = rows.Scan(row.dest(&sortKey)...)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning user error: %v", err))
+			return nil, fmt.Errorf("scanning postgres user error: %v", err)
+		}
+
+		user, err := row.decrypt(s.cipher)
+		if err != nil {
+			return nil, err
+		}
+
+		user.Password = "***"
+		page.Users = append(page.Users, *user)
+		last = cursor{Sort: filter.Sort, Value: sortKey, Id: user.Id}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Listing users error: %v", err))
+		return nil, fmt.Errorf("listing postgres users error: %v", err)
+	}
+
+	logger.Logger.Debug("The users have been listed successful")
+	return page, nil
+}
+
+// Count возвращает количество пользователей по фильтру, курсор, лимит и смещение не учитываются
+func (s *UserService) Count(ctx context.Context, filter domain.UserFilter) (int, error) {
+	filter = s.scope(ctx, filter)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	where, args := buildFilter(filter)
+	var count int
+	err := s.db.Db.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM users WHERE %s`, strings.Join(where, " AND ")), args...).Scan(&count)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Counting users error: %v", err))
+		return 0, fmt.Errorf("counting postgres users error: %v", err)
+	}
+
+	return count, nil
+}
+
+// scope ограничивает фильтр организацией из контекста и вычисляет слепой индекс точного email
+func (s *UserService) scope(ctx context.Context, filter domain.UserFilter) domain.UserFilter {
+	filter.TenantId = domain.TenantFrom(ctx)
+	filter.LoginIndex = ""
+	if filter.Login != "" {
+		filter.LoginIndex = s.cipher.BlindIndex(filter.Login)
+	}
+
+	return filter
+}
+
+// buildListQuery собирает запрос выборки пользователей по фильтру
+func buildListQuery(filter domain.UserFilter) (string, []any, error) {
+	where, args := buildFilter(filter)
+
+	column, cmp, order, err := sortOrder(filter.Sort)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if filter.Cursor != "" {
+		c, err := decodeCursor(filter.Cursor)
+		if err != nil || c.Sort != filter.Sort {
+			return "", nil, domain.ErrInvalidCursor
+		}
+
+		args = append(args, c.Value, c.Id)
+		where = append(where, fmt.Sprintf("(%s, id) %s ($%d::%s, $%d)", column.expr, cmp, len(args)-1, column.cast, len(args)))
+	}
+
+	args = append(args, listLimit(filter.Limit)+1)
+	query := fmt.Sprintf(`SELECT id, first_name, last_name, birthday, login, version, created_at, deleted_at, (%s)::text FROM users WHERE %s ORDER BY %s %s, id %s LIMIT $%d`,
+		column.expr, strings.Join(where, " AND "), column.expr, order, order, len(args))
+
+	if filter.Offset > 0 && filter.Cursor == "" {
+		args = append(args, filter.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	return query, args, nil
+}
+
+// sortOrder возвращает поле сортировки, оператор сравнения для курсора и направление
+// sort - название поля, префикс "-" задает обратный порядок, пустая строка означает id
+func sortOrder(sort string) (sortColumn, string, string, error) {
+	field, desc := strings.CutPrefix(sort, "-")
+	if field == "" {
+		field = "id"
+	}
+
+	column, ok := sortColumns[field]
+	if !ok {
+		return sortColumn{}, "", "", domain.ErrInvalidSort
+	}
+
+	if desc {
+		return column, "<", "DESC", nil
+	}
+
+	return column, ">", "ASC", nil
+}
+
+// buildFilter возвращает условия выборки пользователей и их аргументы
+// Точный email ищется по уникальному индексу (tenant_id, login_index)
+func buildFilter(filter domain.UserFilter) ([]string, []any) {
+	where := []string{"tenant_id = $1"}
+	args := []any{filter.TenantId}
+
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+
+	if !filter.WithDeleted {
+		where = append(where, "deleted_at IS NULL")
+	}
+
+	if filter.Login != "" {
+		add("login_index = $%d", filter.LoginIndex)
+	}
+
+	if filter.CreatedFrom != nil {
+		add("created_at >= $%d", *filter.CreatedFrom)
+	}
+
+	if filter.CreatedTo != nil {
+		add("created_at <= $%d", *filter.CreatedTo)
+	}
+
+	return where, args
+}
+
+// listLimit ограничивает размер страницы
+func listLimit(limit int) int {
+	if limit <= 0 {
+		return DEFAULT_LIMIT
+	}
+
+	return min(limit, MAX_LIMIT)
+}
+
+// encodeCursor кодирует позицию в непрозрачную строку
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor разбирает строку курсора
+func decodeCursor(str string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return nil, err
+	}
+
+	var c cursor
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
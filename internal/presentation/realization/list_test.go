@@ -0,0 +1,121 @@
+package realization
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тест сборки запроса с фильтрами
+func TestBuildListQuery_Filters(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	query, args, err := buildListQuery(domain.UserFilter{
+		TenantId:    2,
+		CreatedFrom: &from,
+		Sort:        "-created_at",
+		Limit:       10,
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, query, "WHERE tenant_id = $1 AND deleted_at IS NULL")
+	assert.Contains(t, query, "created_at >= $2")
+	assert.Contains(t, query, "ORDER BY created_at DESC, id DESC LIMIT $3")
+	assert.Equal(t, []any{domain.Id(2), from, 11}, args)
+}
+
+// Тест условия по курсору
+func TestBuildListQuery_Cursor(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cur := encodeCursor(cursor{Sort: "created_at", Value: created.Format(time.RFC3339Nano), Id: 7})
+	query, args, err := buildListQuery(domain.UserFilter{TenantId: domain.DEFAULT_TENANT, Sort: "created_at", Cursor: cur})
+	assert.NoError(t, err)
+	assert.Contains(t, query, "(created_at, id) > ($2::timestamptz, $3)")
+	assert.Equal(t, []any{domain.DEFAULT_TENANT, created.Format(time.RFC3339Nano), domain.Id(7), DEFAULT_LIMIT + 1}, args)
+}
+
+// Тест ошибок сортировки и курсора
+func TestBuildListQuery_Invalid(t *testing.T) {
+	_, _, err := buildListQuery(domain.UserFilter{Sort: "password"})
+	assert.ErrorIs(t, err, domain.ErrInvalidSort)
+
+	// Персональные данные зашифрованы, сортировки по ним нет
+	_, _, err = buildListQuery(domain.UserFilter{Sort: "-email"})
+	assert.ErrorIs(t, err, domain.ErrInvalidSort)
+
+	_, _, err = buildListQuery(domain.UserFilter{Cursor: "not a cursor"})
+	assert.ErrorIs(t, err, domain.ErrInvalidCursor)
+
+	cur := encodeCursor(cursor{Sort: "id", Value: "7", Id: 7})
+	_, _, err = buildListQuery(domain.UserFilter{Sort: "-id", Cursor: cur})
+	assert.ErrorIs(t, err, domain.ErrInvalidCursor)
+}
+
+// Тест запроса выгрузки: фильтры и сортировка списка без курсора и лимита
+func TestBuildExportQuery(t *testing.T) {
+	to := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	query, args, err := buildExportQuery(domain.UserFilter{
+		TenantId:  2,
+		CreatedTo: &to,
+		Sort:      "-created_at",
+		Cursor:    "ignored",
+		Limit:     10,
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, query, "WHERE tenant_id = $1 AND deleted_at IS NULL")
+	assert.Contains(t, query, "created_at <= $2")
+	assert.True(t, strings.HasSuffix(query, "ORDER BY created_at DESC, id DESC"))
+	assert.NotContains(t, query, "password")
+	assert.Equal(t, []any{domain.Id(2), to}, args)
+
+	_, _, err = buildExportQuery(domain.UserFilter{Sort: "password"})
+	assert.ErrorIs(t, err, domain.ErrInvalidSort)
+}
+
+// Тест точного email: поиск и подсчет идут по слепому индексу без расшифровки выборки
+func TestCount_Login(t *testing.T) {
+	s, mock, _ := newMockService(t)
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM users WHERE tenant_id = \$1 AND login_index = \$2$`).
+		WithArgs(domain.DEFAULT_TENANT, s.cipher.BlindIndex("john@example.com")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	count, err := s.Count(context.Background(), domain.UserFilter{Login: "john@example.com", WithDeleted: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	query, args, err := buildListQuery(domain.UserFilter{TenantId: domain.DEFAULT_TENANT, Login: "john@example.com", LoginIndex: "index", Offset: 40, Limit: 20})
+	assert.NoError(t, err)
+	assert.Contains(t, query, "deleted_at IS NULL AND login_index = $2")
+	assert.True(t, strings.HasSuffix(query, "LIMIT $3 OFFSET $4"))
+	assert.Equal(t, []any{domain.DEFAULT_TENANT, "index", 21, 40}, args)
+}
+
+// Тест страницы списка: выборка и сортировка выполняются Postgres, курсор хранит ключ последней записи
+func TestList(t *testing.T) {
+	s, mock, _ := newMockService(t)
+
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	empty := encrypt(t, s.cipher, "")
+	mock.ExpectQuery(`FROM users WHERE tenant_id = \$1 AND deleted_at IS NULL ORDER BY created_at DESC, id DESC LIMIT \$2$`).
+		WithArgs(domain.DEFAULT_TENANT, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "birthday", "login", "version", "created_at", "deleted_at", "created_at"}).
+			AddRow(3, empty, empty, nil, encrypt(t, s.cipher, "c@example.com"), 1, created, nil, "2024-01-03").
+			AddRow(2, empty, empty, nil, encrypt(t, s.cipher, "b@example.com"), 1, created, nil, "2024-01-02"))
+
+	page, err := s.List(context.Background(), domain.UserFilter{Sort: "-created_at", Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, page.Users, 1)
+	assert.Equal(t, "c@example.com", page.Users[0].Login)
+	assert.Equal(t, "***", page.Users[0].Password)
+
+	c, err := decodeCursor(page.NextCursor)
+	require.NoError(t, err)
+	assert.Equal(t, &cursor{Sort: "-created_at", Value: "2024-01-03", Id: 3}, c)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,336 @@
+package realization
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"user/internal/domain"
+	"user/internal/interfaces"
+	"user/internal/presentation/db"
+	"user/internal/presentation/logger"
+)
+
+const (
+	// LOCKOUT_FREE_ATTEMPTS - количество неудачных попыток учетной записи без задержки
+	LOCKOUT_FREE_ATTEMPTS = 2
+	// LOCKOUT_BASE_DELAY - задержка после первой попытки сверх бесплатных, дальше она удваивается
+	LOCKOUT_BASE_DELAY = time.Second
+	// LOCKOUT_MAX_DELAY - наибольшая задержка между попытками до блокировки
+	LOCKOUT_MAX_DELAY = time.Minute
+)
+
+// LockoutService защищает вход от перебора паролей
+// Попытки считаются в Redis отдельно для учетной записи и для адреса клиента до проверки пароля,
+// успешный вход убирает свою попытку из счетчиков, поэтому в них остаются только неудачные,
+// после LOCKOUT_FREE_ATTEMPTS попыток учетной записи каждая следующая возможна только после растущей задержки,
+// попытка сверх maxAttempts блокирует учетную запись, а сверх maxIpAttempts - адрес на duration
+// Учетная запись определяется слепым индексом email, поэтому email не попадает в Redis
+type LockoutService struct {
+	db            *db.DB
+	counters      interfaces.CounterRepo
+	cipher        interfaces.FieldCipher
+	maxAttempts   int64
+	maxIpAttempts int64
+	duration      time.Duration
+}
+
+// NewLockoutService создает новый экземпляр LockoutService
+// counters - счетчики попыток в Redis
+// cipher - шифрование персональных данных, учетная запись определяется по слепому индексу email
+// maxAttempts - количество неудачных попыток учетной записи до блокировки
+// maxIpAttempts - количество неудачных попыток с одного адреса до блокировки
+// duration - время блокировки, за это же время сбрасываются счетчики попыток
+func NewLockoutService(db *db.DB, counters interfaces.CounterRepo, cipher interfaces.FieldCipher, maxAttempts, maxIpAttempts int, duration time.Duration) *LockoutService {
+	return &LockoutService{
+		db:            db,
+		counters:      counters,
+		cipher:        cipher,
+		maxAttempts:   int64(maxAttempts),
+		maxIpAttempts: int64(maxIpAttempts),
+		duration:      duration,
+	}
+}
+
+// accountKey возвращает ключ учетной записи организации
+func (s *LockoutService) accountKey(tenant domain.Id, index, kind string) string {
+	return fmt.Sprintf("tenant:%d:login:%s:%s", tenant, index, kind)
+}
+
+// ipKey возвращает ключ адреса клиента, адрес блокируется во всех организациях сразу
+func ipKey(ip, kind string) string {
+	return fmt.Sprintf("ip:%s:%s", ip, kind)
+}
+
+// lockoutDelay возвращает задержку перед следующей попыткой после failures неудачных попыток
+func lockoutDelay(failures int64) time.Duration {
+	if failures <= LOCKOUT_FREE_ATTEMPTS {
+		return 0
+	}
+
+	delay := LOCKOUT_BASE_DELAY
+	for i := int64(LOCKOUT_FREE_ATTEMPTS + 1); i < failures && delay < LOCKOUT_MAX_DELAY; i++ {
+		delay *= 2
+	}
+
+	return min(delay, LOCKOUT_MAX_DELAY)
+}
+
+// Attempt учитывает попытку входа до проверки пароля и возвращает, сколько осталось до окончания задержки или блокировки
+// Счетчики увеличиваются до проверки пароля, поэтому каждая из параллельных попыток получает свой номер
+// и попытка сверх maxAttempts или maxIpAttempts отклоняется, даже если предыдущие еще не завершились
+// Попытка считается неудачной, пока вход не подтвержден вызовом Succeed
+func (s *LockoutService) Attempt(ctx context.Context, login, ip string) (time.Duration, error) {
+	return s.attempt(ctx, s.cipher.BlindIndex(login), ip)
+}
+
+// AttemptMfa учитывает попытку ввода кода второго фактора так же, как попытку ввода пароля
+// Учетная запись и ее организация берутся из первого шага входа, неизвестный токен учитывается только для адреса
+func (s *LockoutService) AttemptMfa(ctx context.Context, mfaToken, ip string) (time.Duration, error) {
+	ctx, index, err := s.mfaAccount(ctx, mfaToken)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.attempt(ctx, index, ip)
+}
+
+// attempt учитывает попытку входа в учетную запись со слепым индексом index, пустой index - только для адреса
+// Блокировка учетной записи записывается в журнал пользователя, если такой пользователь есть,
+// блокировка адреса не относится к одному пользователю и записывается только в лог
+func (s *LockoutService) attempt(ctx context.Context, index, ip string) (time.Duration, error) {
+	tenant := domain.TenantFrom(ctx)
+
+	keys := []string{ipKey(ip, "lock")}
+	if index != "" {
+		keys = append(keys, s.accountKey(tenant, index, "lock"), s.accountKey(tenant, index, "delay"))
+	}
+
+	wait, err := s.counters.TTL(keys...)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Checking login lockout error: %v", err))
+		return 0, err
+	}
+
+	if wait > 0 {
+		return wait, nil
+	}
+
+	failures, err := s.counters.Incr(ipKey(ip, "failures"), s.duration)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Counting login attempt error: %v", err))
+		return 0, err
+	}
+
+	if failures > s.maxIpAttempts {
+		err = s.counters.Mark(ipKey(ip, "lock"), s.duration)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Locking address error: %v", err))
+			return 0, err
+		}
+
+		_, err = s.counters.Del(ipKey(ip, "failures"))
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Resetting failed logins error: %v", err))
+			return 0, err
+		}
+		logger.Logger.Warn(fmt.Sprintf("Address %s has been locked for %s after %d failed logins", ip, s.duration, s.maxIpAttempts))
+		return s.duration, nil
+	}
+
+	if index == "" {
+		return 0, nil
+	}
+
+	failures, err = s.counters.Incr(s.accountKey(tenant, index, "failures"), s.duration)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Counting login attempt error: %v", err))
+		return 0, err
+	}
+
+	if failures > s.maxAttempts {
+		err = s.lock(ctx, index)
+		if err != nil {
+			return 0, err
+		}
+		return s.duration, nil
+	}
+
+	// Задержка перед следующей попыткой ставится заранее и снимается, если эта попытка окажется успешной
+	if delay := lockoutDelay(failures); delay > 0 {
+		err = s.counters.Mark(s.accountKey(tenant, index, "delay"), delay)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Delaying login error: %v", err))
+			return 0, err
+		}
+	}
+
+	return 0, nil
+}
+
+// mfaAccount возвращает контекст с организацией и слепой индекс email пользователя токена первого шага входа
+// Для неизвестного токена индекс пустой
+func (s *LockoutService) mfaAccount(ctx context.Context, mfaToken string) (context.Context, string, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var (
+		tenant domain.Id
+		index  string
+	)
+	err := s.db.Db.QueryRowContext(queryCtx, `SELECT u.tenant_id, u.login_index FROM mfa_challenges c JOIN users u ON u.id = c.user_id WHERE c.token_hash = $1`, hashToken(mfaToken)).Scan(&tenant, &index)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ctx, "", nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting mfa challenge account error: %v", err))
+		return ctx, "", fmt.Errorf("getting postgres mfa challenge account error: %v", err)
+	}
+
+	return domain.WithTenant(ctx, tenant), index, nil
+}
+
+// lock блокирует учетную запись и начинает отсчет попыток заново после блокировки
+func (s *LockoutService) lock(ctx context.Context, index string) error {
+	tenant := domain.TenantFrom(ctx)
+
+	err := s.counters.Mark(s.accountKey(tenant, index, "lock"), s.duration)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Locking account error: %v", err))
+		return err
+	}
+
+	_, err = s.counters.Del(s.accountKey(tenant, index, "failures"), s.accountKey(tenant, index, "delay"))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Resetting failed logins error: %v", err))
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var id domain.Id
+	err = s.db.Db.QueryRowContext(ctx, `SELECT id FROM users WHERE login_index = $1 AND tenant_id = $2 AND deleted_at IS NULL`, index, tenant).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Logger.Warn(fmt.Sprintf("Unknown account has been locked for %s", s.duration))
+			return nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting locked user error: %v", err))
+		return fmt.Errorf("getting postgres locked user error: %v", err)
+	}
+
+	err = s.audit(ctx, id, false, true)
+	if err != nil {
+		return err
+	}
+
+	logger.Logger.Warn(fmt.Sprintf("User %d has been locked for %s after %d failed logins", id, s.duration, s.maxAttempts))
+	return nil
+}
+
+// Succeed сбрасывает счетчик и задержку учетной записи и убирает попытку из счетчика адреса
+// Вызывается только после выдачи сессии: верный пароль без второго фактора остается неудачной попыткой
+func (s *LockoutService) Succeed(ctx context.Context, login, ip string) error {
+	return s.succeed(ctx, s.cipher.BlindIndex(login), ip)
+}
+
+// SucceedMfa - Succeed для учетной записи токена первого шага входа
+func (s *LockoutService) SucceedMfa(ctx context.Context, mfaToken, ip string) error {
+	ctx, index, err := s.mfaAccount(ctx, mfaToken)
+	if err != nil {
+		return err
+	}
+
+	return s.succeed(ctx, index, ip)
+}
+
+// succeed сбрасывает счетчики учетной записи со слепым индексом index и убирает попытку адреса
+func (s *LockoutService) succeed(ctx context.Context, index, ip string) error {
+	err := s.counters.Decr(ipKey(ip, "failures"))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Resetting failed logins error: %v", err))
+		return err
+	}
+
+	if index == "" {
+		return nil
+	}
+
+	tenant := domain.TenantFrom(ctx)
+	_, err = s.counters.Del(s.accountKey(tenant, index, "failures"), s.accountKey(tenant, index, "delay"))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Resetting failed logins error: %v", err))
+		return err
+	}
+
+	return nil
+}
+
+// Unlock снимает блокировку и задержку с учетной записи пользователя организации и сбрасывает счетчик попыток
+// Снятие действующей блокировки записывается в журнал пользователя
+// Возвращает false, если пользователь не найден
+func (s *LockoutService) Unlock(ctx context.Context, id domain.Id) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	tenant := domain.TenantFrom(ctx)
+
+	var index string
+	err := s.db.Db.QueryRowContext(ctx, `SELECT login_index FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`, id, tenant).Scan(&index)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting user to unlock error: %v", err))
+		return false, fmt.Errorf("getting postgres user to unlock error: %v", err)
+	}
+
+	locked, err := s.counters.Del(s.accountKey(tenant, index, "lock"))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Unlocking account error: %v", err))
+		return false, err
+	}
+
+	_, err = s.counters.Del(s.accountKey(tenant, index, "failures"), s.accountKey(tenant, index, "delay"))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Resetting failed logins error: %v", err))
+		return false, err
+	}
+
+	if locked == 0 {
+		return true, nil
+	}
+
+	err = s.audit(ctx, id, true, false)
+	if err != nil {
+		return false, err
+	}
+
+	logger.Logger.Info(fmt.Sprintf("User %d has been unlocked by %s", id, actorName(domain.ActorFrom(ctx))))
+	return true, nil
+}
+
+// audit записывает изменение блокировки в журнал пользователя
+func (s *LockoutService) audit(ctx context.Context, id domain.Id, old, new bool) error {
+	tx, err := s.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Beginning transaction error: %v", err))
+		return fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = writeAudit(ctx, tx, id, domain.AUDIT_LOCKOUT, map[string]domain.FieldChange{"locked": {Old: old, New: new}})
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Committing transaction error: %v", err))
+		return fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	return nil
+}
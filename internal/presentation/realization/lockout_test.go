@@ -0,0 +1,230 @@
+package realization
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryCounters - счетчики в памяти для тестов, время жизни не истекает само
+type memoryCounters struct {
+	values map[string]int64
+	ttls   map[string]time.Duration
+}
+
+func (c *memoryCounters) Incr(key string, ttl time.Duration) (int64, error) {
+	c.values[key]++
+	if c.values[key] == 1 {
+		c.ttls[key] = ttl
+	}
+
+	return c.values[key], nil
+}
+
+func (c *memoryCounters) Decr(key string) error {
+	if _, ok := c.values[key]; ok {
+		c.values[key]--
+	}
+
+	return nil
+}
+
+func (c *memoryCounters) Mark(key string, ttl time.Duration) error {
+	c.values[key] = 1
+	c.ttls[key] = ttl
+	return nil
+}
+
+func (c *memoryCounters) TTL(keys ...string) (time.Duration, error) {
+	var ttl time.Duration
+	for _, key := range keys {
+		if _, ok := c.values[key]; ok && c.ttls[key] > ttl {
+			ttl = c.ttls[key]
+		}
+	}
+
+	return ttl, nil
+}
+
+func (c *memoryCounters) Del(keys ...string) (int64, error) {
+	var count int64
+	for _, key := range keys {
+		if _, ok := c.values[key]; ok {
+			delete(c.values, key)
+			delete(c.ttls, key)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func newTestLockout(t *testing.T, maxAttempts, maxIpAttempts int) (*LockoutService, sqlmock.Sqlmock) {
+	users, mock, _ := newMockService(t)
+	counters := &memoryCounters{values: map[string]int64{}, ttls: map[string]time.Duration{}}
+
+	return NewLockoutService(users.db, counters, users.cipher, maxAttempts, maxIpAttempts, 15*time.Minute), mock
+}
+
+// Тест задержки: первые попытки без задержки, дальше она удваивается до предела
+func TestLockoutDelay(t *testing.T) {
+	tests := map[int64]time.Duration{
+		1:  0,
+		2:  0,
+		3:  time.Second,
+		4:  2 * time.Second,
+		5:  4 * time.Second,
+		20: LOCKOUT_MAX_DELAY,
+	}
+
+	for failures, delay := range tests {
+		assert.Equal(t, delay, lockoutDelay(failures), "failures %d", failures)
+	}
+}
+
+// Тест блокировки учетной записи: задержки, блокировка с записью в журнал и снятие администратором
+func TestLockoutAccount(t *testing.T) {
+	s, mock := newTestLockout(t, 4, 100)
+	ctx := domain.WithTenant(context.Background(), 2)
+	index := s.cipher.BlindIndex("john@example.com")
+
+	// Задержка ставится до проверки пароля, поэтому третья попытка сразу закрывает четвертую
+	for i, wait := range []time.Duration{0, 0, 0, time.Second} {
+		got, err := s.Attempt(ctx, "john@example.com", "10.0.0.1")
+		require.NoError(t, err)
+		assert.Equal(t, wait, got, "attempt %d", i+1)
+	}
+
+	_, _ = s.counters.Del(s.accountKey(2, index, "delay"))
+	wait, err := s.Attempt(ctx, "john@example.com", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+
+	// Попытка сверх лимита отклоняется и блокирует учетную запись
+	_, _ = s.counters.Del(s.accountKey(2, index, "delay"))
+	mock.ExpectQuery(`SELECT id FROM users WHERE login_index = \$1 AND tenant_id = \$2 AND deleted_at IS NULL`).
+		WithArgs(index, domain.Id(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO user_audit`).
+		WithArgs(domain.Id(7), domain.Id(2), nil, "", domain.AUDIT_LOCKOUT, []byte(`{"locked":{"old":false,"new":true}}`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	wait, err = s.Attempt(ctx, "john@example.com", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, wait)
+
+	// Успешный вход не снимает блокировку, а блокировка действует только в своей организации
+	require.NoError(t, s.Succeed(ctx, "john@example.com", "10.0.0.1"))
+	wait, err = s.Attempt(ctx, "john@example.com", "10.0.0.2")
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, wait)
+
+	wait, err = s.Attempt(domain.WithTenant(context.Background(), 3), "john@example.com", "10.0.0.2")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+
+	mock.ExpectQuery(`SELECT login_index FROM users WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL`).
+		WithArgs(domain.Id(7), domain.Id(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"login_index"}).AddRow(index))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO user_audit`).
+		WithArgs(domain.Id(7), domain.Id(2), nil, "", domain.AUDIT_LOCKOUT, []byte(`{"locked":{"old":true,"new":false}}`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	ok, err := s.Unlock(ctx, 7)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// Успешный вход сбрасывает счетчик, поэтому следующие попытки снова без задержки
+	for range 3 {
+		wait, err = s.Attempt(ctx, "john@example.com", "10.0.0.1")
+		require.NoError(t, err)
+		assert.Zero(t, wait)
+		require.NoError(t, s.Succeed(ctx, "john@example.com", "10.0.0.1"))
+	}
+
+	// Снятие отсутствующей блокировки не попадает в журнал
+	mock.ExpectQuery(`SELECT login_index FROM users`).
+		WithArgs(domain.Id(7), domain.Id(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"login_index"}).AddRow(index))
+	ok, err = s.Unlock(ctx, 7)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	mock.ExpectQuery(`SELECT login_index FROM users`).
+		WithArgs(domain.Id(8), domain.Id(2)).
+		WillReturnError(sql.ErrNoRows)
+	ok, err = s.Unlock(ctx, 8)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест блокировки адреса: попытки с одного адреса по разным учетным записям считаются вместе, успешные не считаются
+func TestLockoutAddress(t *testing.T) {
+	s, mock := newTestLockout(t, 5, 3)
+	ctx := context.Background()
+
+	wait, err := s.Attempt(ctx, "ok@example.com", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+	require.NoError(t, s.Succeed(ctx, "ok@example.com", "10.0.0.1"))
+
+	for _, login := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		wait, err = s.Attempt(ctx, login, "10.0.0.1")
+		require.NoError(t, err)
+		assert.Zero(t, wait)
+	}
+
+	wait, err = s.Attempt(ctx, "d@example.com", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, wait)
+
+	wait, err = s.Attempt(ctx, "d@example.com", "10.0.0.2")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест попыток второго фактора: считаются для учетной записи первого шага в ее организации
+func TestLockoutMfa(t *testing.T) {
+	s, mock := newTestLockout(t, 1, 100)
+	index := s.cipher.BlindIndex("john@example.com")
+
+	account := func() {
+		mock.ExpectQuery(`SELECT u.tenant_id, u.login_index FROM mfa_challenges c JOIN users u ON u.id = c.user_id WHERE c.token_hash = \$1`).
+			WithArgs(hashToken("mfa")).
+			WillReturnRows(sqlmock.NewRows([]string{"tenant_id", "login_index"}).AddRow(2, index))
+	}
+
+	// Пароль верный, но сессия не выдана: попытка остается в счетчике учетной записи
+	ctx := domain.WithTenant(context.Background(), 2)
+	wait, err := s.Attempt(ctx, "john@example.com", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+
+	account()
+	mock.ExpectQuery(`SELECT id FROM users WHERE login_index = \$1 AND tenant_id = \$2`).
+		WithArgs(index, domain.Id(2)).
+		WillReturnError(sql.ErrNoRows)
+	wait, err = s.AttemptMfa(context.Background(), "mfa", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, wait)
+
+	// Неизвестный токен учитывается только для адреса
+	mock.ExpectQuery(`SELECT u.tenant_id, u.login_index FROM mfa_challenges`).
+		WithArgs(hashToken("unknown")).
+		WillReturnError(sql.ErrNoRows)
+	wait, err = s.AttemptMfa(context.Background(), "unknown", "10.0.0.2")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,146 @@
+package realization
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"user/internal/domain"
+)
+
+// MemoryMailer хранит письма в памяти, используется в тестах
+type MemoryMailer struct {
+	mu    sync.Mutex
+	mails []domain.Mail
+}
+
+// NewMemoryMailer создает новый экземпляр MemoryMailer
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+// Send сохраняет письмо
+func (m *MemoryMailer) Send(_ context.Context, mail domain.Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.mails = append(m.mails, mail)
+	return nil
+}
+
+// Mails возвращает копию отправленных писем
+func (m *MemoryMailer) Mails() []domain.Mail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]domain.Mail(nil), m.mails...)
+}
+
+// FileMailer сохраняет каждое письмо файлом .eml в каталоге
+// Файлы открываются почтовым клиентом, что удобно при локальной разработке
+type FileMailer struct {
+	from string
+	dir  string
+}
+
+// NewFileMailer создает новый экземпляр FileMailer
+// from - адрес отправителя
+// dir - каталог писем, создается при отсутствии
+func NewFileMailer(from, dir string) (*FileMailer, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("creating mail directory error: %v", err)
+	}
+
+	return &FileMailer{
+		from: from,
+		dir:  dir,
+	}, nil
+}
+
+// Send записывает письмо в новый файл
+func (m *FileMailer) Send(_ context.Context, mail domain.Mail) error {
+	data, err := message(m.from, mail, time.Now())
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(m.dir, fmt.Sprintf("%d-*.eml", time.Now().UnixNano()))
+	if err != nil {
+		return fmt.Errorf("creating mail file error: %v", err)
+	}
+	defer file.Close()
+
+	_, err = file.Write(data)
+	if err != nil {
+		return fmt.Errorf("writing mail file error: %v", err)
+	}
+
+	return nil
+}
+
+// SMTPMailer отправляет письма через SMTP сервер
+// Для локальной разработки подходит перехватчик писем вроде Mailpit
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer создает новый экземпляр SMTPMailer
+// host, port - адрес SMTP сервера
+// user, password - учетные данные, без пользователя письма отправляются без авторизации
+// from - адрес отправителя
+func NewSMTPMailer(host, port, user, password, from string) *SMTPMailer {
+	mailer := &SMTPMailer{
+		addr: net.JoinHostPort(host, port),
+		from: from,
+	}
+
+	if user != "" {
+		mailer.auth = smtp.PlainAuth("", user, password, host)
+	}
+
+	return mailer
+}
+
+// Send отправляет письмо
+func (m *SMTPMailer) Send(_ context.Context, mail domain.Mail) error {
+	data, err := message(m.from, mail, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = smtp.SendMail(m.addr, m.auth, m.from, []string{mail.To}, data)
+	if err != nil {
+		return fmt.Errorf("sending mail error: %v", err)
+	}
+
+	return nil
+}
+
+// message собирает текстовое письмо в формате RFC 5322
+// Адреса с переводом строки отклоняются, чтобы в письмо нельзя было дописать заголовки
+func message(from string, mail domain.Mail, date time.Time) ([]byte, error) {
+	if strings.ContainsAny(from+mail.To, "\r\n") {
+		return nil, fmt.Errorf("invalid mail address")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", mail.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", mail.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(mail.Body, "\n", "\r\n"))
+
+	return buf.Bytes(), nil
+}
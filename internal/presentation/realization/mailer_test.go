@@ -0,0 +1,65 @@
+package realization
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMail() domain.Mail {
+	return domain.Mail{
+		To:      "john@example.com",
+		Subject: "Подтверждение",
+		Body:    "line 1\nline 2",
+	}
+}
+
+// Тест сохранения писем в памяти
+func TestMemoryMailer(t *testing.T) {
+	mailer := NewMemoryMailer()
+
+	assert.NoError(t, mailer.Send(context.Background(), testMail()))
+	assert.Equal(t, []domain.Mail{testMail()}, mailer.Mails())
+}
+
+// Тест записи писем файлами .eml
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mails")
+	mailer, err := NewFileMailer("noreply@example.com", dir)
+	require.NoError(t, err)
+
+	assert.NoError(t, mailer.Send(context.Background(), testMail()))
+	assert.NoError(t, mailer.Send(context.Background(), testMail()))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "To: john@example.com\r\n")
+	assert.True(t, strings.HasSuffix(string(data), "\r\n\r\nline 1\r\nline 2"))
+}
+
+// Тест сборки письма: тема кодируется, переводы строки в адресе отклоняются
+func TestMessage(t *testing.T) {
+	date := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	data, err := message("noreply@example.com", testMail(), date)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "From: noreply@example.com\r\n")
+	assert.Contains(t, string(data), "Subject: =?utf-8?q?")
+	assert.Contains(t, string(data), "Date: Tue, 02 Jan 2024 03:04:05 +0000\r\n")
+
+	mail := testMail()
+	mail.To = "john@example.com\r\nBcc: eve@example.com"
+	_, err = message("noreply@example.com", mail, date)
+	assert.Error(t, err)
+}
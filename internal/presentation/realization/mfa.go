@@ -0,0 +1,311 @@
+package realization
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"user/internal/domain"
+	"user/internal/interfaces"
+	"user/internal/presentation/db"
+	"user/internal/presentation/logger"
+)
+
+// MfaService управляет вторым фактором пользователей: TOTP и кодами восстановления
+// Секрет TOTP хранится зашифрованным ключом из связки, коды восстановления - хэшами
+type MfaService struct {
+	db     *db.DB
+	cipher interfaces.FieldCipher
+	issuer string
+	now    func() time.Time
+}
+
+// NewMfaService создает новый экземпляр MfaService
+// cipher - шифрование секретов TOTP
+// issuer - название сервиса в приложении-аутентификаторе
+// now - часы для проверки кодов, nil - системное время
+func NewMfaService(db *db.DB, cipher interfaces.FieldCipher, issuer string, now func() time.Time) *MfaService {
+	if now == nil {
+		now = time.Now
+	}
+
+	return &MfaService{
+		db:     db,
+		cipher: cipher,
+		issuer: issuer,
+		now:    now,
+	}
+}
+
+// Enroll выдает активному пользователю организации новый секрет TOTP
+// Неподтвержденный секрет заменяется, MFA включается только после Confirm
+// Возвращает nil, если пользователя нет, и ErrMfaAlreadyEnabled, если MFA уже включена
+func (s *MfaService) Enroll(ctx context.Context, id domain.Id) (*domain.TotpEnrollment, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var login string
+	err := s.db.Db.QueryRowContext(ctx, `SELECT login FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`, id, domain.TenantFrom(ctx)).Scan(&login)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting user to enroll mfa error: %v", err))
+		return nil, fmt.Errorf("getting postgres user to enroll mfa error: %v", err)
+	}
+
+	login, err = s.cipher.Decrypt(login)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := newTotpSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := s.cipher.Encrypt(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.Db.ExecContext(ctx, `INSERT INTO user_mfa (user_id, secret, key_id) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, key_id = EXCLUDED.key_id, last_step = 0, created_at = NOW() WHERE user_mfa.confirmed_at IS NULL`, id, sealed, s.cipher.ActiveKey())
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Enrolling mfa error: %v", err))
+		return nil, fmt.Errorf("enrolling postgres mfa error: %v", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("enrolling postgres mfa error: %v", err)
+	}
+
+	if n == 0 {
+		return nil, domain.ErrMfaAlreadyEnabled
+	}
+
+	logger.Logger.Info(fmt.Sprintf("MFA enrollment of user %d has been started by %s", id, actorName(domain.ActorFrom(ctx))))
+	return &domain.TotpEnrollment{
+		Secret: secret,
+		Uri:    totpUri(s.issuer, login, secret),
+	}, nil
+}
+
+// Confirm включает MFA по первому коду из приложения и выдает новые коды восстановления
+// Возвращает ErrMfaNotEnrolled без начатого подключения, ErrMfaAlreadyEnabled для включенной MFA
+// и ErrInvalidCode, если код не подошел
+func (s *MfaService) Confirm(ctx context.Context, id domain.Id, code string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	tx, err := s.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Beginning transaction error: %v", err))
+		return nil, fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		sealed    string
+		confirmed *time.Time
+	)
+	err = tx.QueryRowContext(ctx, `SELECT m.secret, m.confirmed_at FROM user_mfa m JOIN users u ON u.id = m.user_id WHERE m.user_id = $1 AND u.tenant_id = $2 AND u.deleted_at IS NULL FOR UPDATE OF m`, id, domain.TenantFrom(ctx)).Scan(&sealed, &confirmed)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrMfaNotEnrolled
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting mfa error: %v", err))
+		return nil, fmt.Errorf("getting postgres mfa error: %v", err)
+	}
+
+	if confirmed != nil {
+		return nil, domain.ErrMfaAlreadyEnabled
+	}
+
+	secret, err := s.cipher.Decrypt(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := matchTotp(secret, code, s.now(), 0)
+	if !ok {
+		return nil, domain.ErrInvalidCode
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE user_mfa SET confirmed_at = NOW(), last_step = $2 WHERE user_id = $1`, id, step)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Confirming mfa error: %v", err))
+		return nil, fmt.Errorf("confirming postgres mfa error: %v", err)
+	}
+
+	codes, err := s.replaceRecoveryCodes(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeAudit(ctx, tx, id, domain.AUDIT_MFA, map[string]domain.FieldChange{
+		"mfa": {Old: false, New: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Committing transaction error: %v", err))
+		return nil, fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	logger.Logger.Info(fmt.Sprintf("MFA of user %d has been enabled by %s", id, actorName(domain.ActorFrom(ctx))))
+	return codes, nil
+}
+
+// Disable отключает MFA пользователя организации и удаляет коды восстановления
+// Пустой code отключает MFA без проверки, это нужно администратору для пользователя, потерявшего устройство
+// Начатое, но не подтвержденное подключение удаляется без проверки кода
+// Возвращает false, если MFA не включена, и ErrInvalidCode, если код не подошел
+func (s *MfaService) Disable(ctx context.Context, id domain.Id, code string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	tx, err := s.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Beginning transaction error: %v", err))
+		return false, fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	var enabled bool
+	err = tx.QueryRowContext(ctx, `SELECT m.confirmed_at IS NOT NULL FROM user_mfa m JOIN users u ON u.id = m.user_id WHERE m.user_id = $1 AND u.tenant_id = $2 FOR UPDATE OF m`, id, domain.TenantFrom(ctx)).Scan(&enabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting mfa error: %v", err))
+		return false, fmt.Errorf("getting postgres mfa error: %v", err)
+	}
+
+	if enabled && code != "" {
+		ok, err := s.check(ctx, tx, id, code)
+		if err != nil {
+			return false, err
+		}
+
+		if !ok {
+			return false, domain.ErrInvalidCode
+		}
+	}
+
+	for _, query := range []string{
+		`DELETE FROM user_mfa WHERE user_id = $1`,
+		`DELETE FROM mfa_recovery_codes WHERE user_id = $1`,
+		`DELETE FROM mfa_challenges WHERE user_id = $1`,
+	} {
+		_, err = tx.ExecContext(ctx, query, id)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Disabling mfa error: %v", err))
+			return false, fmt.Errorf("disabling postgres mfa error: %v", err)
+		}
+	}
+
+	if enabled {
+		err = writeAudit(ctx, tx, id, domain.AUDIT_MFA, map[string]domain.FieldChange{
+			"mfa": {Old: true, New: false},
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Committing transaction error: %v", err))
+		return false, fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	if enabled {
+		logger.Logger.Info(fmt.Sprintf("MFA of user %d has been disabled by %s", id, actorName(domain.ActorFrom(ctx))))
+	}
+	return enabled, nil
+}
+
+// check проверяет код TOTP или код восстановления пользователя с включенной MFA в транзакции tx
+// Шаг принятого кода TOTP запоминается, код восстановления погашается
+// Возвращает false, если MFA не включена или код не подошел
+func (s *MfaService) check(ctx context.Context, tx *sql.Tx, userId domain.Id, code string) (bool, error) {
+	var (
+		sealed   string
+		lastStep int64
+	)
+	err := tx.QueryRowContext(ctx, `SELECT secret, last_step FROM user_mfa WHERE user_id = $1 AND confirmed_at IS NOT NULL FOR UPDATE`, userId).Scan(&sealed, &lastStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting mfa error: %v", err))
+		return false, fmt.Errorf("getting postgres mfa error: %v", err)
+	}
+
+	if isTotpCode(code) {
+		secret, err := s.cipher.Decrypt(sealed)
+		if err != nil {
+			return false, err
+		}
+
+		step, ok := matchTotp(secret, code, s.now(), lastStep)
+		if !ok {
+			return false, nil
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE user_mfa SET last_step = $2 WHERE user_id = $1`, userId, step)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Updating mfa step error: %v", err))
+			return false, fmt.Errorf("updating postgres mfa step error: %v", err)
+		}
+		return true, nil
+	}
+
+	result, err := tx.ExecContext(ctx, `UPDATE mfa_recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userId, hashRecoveryCode(code))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Using recovery code error: %v", err))
+		return false, fmt.Errorf("using postgres recovery code error: %v", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("using postgres recovery code error: %v", err)
+	}
+
+	if n == 0 {
+		return false, nil
+	}
+
+	logger.Logger.Info(fmt.Sprintf("Recovery code of user %d has been used", userId))
+	return true, nil
+}
+
+// replaceRecoveryCodes заменяет коды восстановления пользователя новыми и возвращает их
+func (s *MfaService) replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userId domain.Id) ([]string, error) {
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userId)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Deleting recovery codes error: %v", err))
+		return nil, fmt.Errorf("deleting postgres recovery codes error: %v", err)
+	}
+
+	for _, code := range codes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userId, hashRecoveryCode(code))
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Creating recovery code error: %v", err))
+			return nil, fmt.Errorf("creating postgres recovery code error: %v", err)
+		}
+	}
+
+	return codes, nil
+}
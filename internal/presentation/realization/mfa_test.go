@@ -0,0 +1,143 @@
+package realization
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mfaNow - фиксированное время проверки кодов в тестах
+var mfaNow = time.Unix(1111111109, 0)
+
+// mfaSecret - секрет из контрольных значений RFC 6238, код в момент mfaNow - 081804
+var mfaSecret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func newTestMfa(t *testing.T) (*MfaService, *AuthService, sqlmock.Sqlmock) {
+	users, mock, _ := newMockService(t)
+	mfa := NewMfaService(users.db, users.cipher, "User", func() time.Time { return mfaNow })
+
+	return mfa, NewAuthService(users.db, nil, users.cipher, mfa, newMemoryActivity(), time.Minute, time.Hour, false), mock
+}
+
+// Тест подключения: секрет шифруется, ссылка содержит email, включенная MFA не перезаписывается
+func TestEnrollTotp(t *testing.T) {
+	s, _, mock := newTestMfa(t)
+
+	for _, affected := range []int64{1, 0} {
+		mock.ExpectQuery(`SELECT login FROM users WHERE id = \$1 AND tenant_id = \$2`).
+			WithArgs(domain.Id(3), domain.DEFAULT_TENANT).
+			WillReturnRows(sqlmock.NewRows([]string{"login"}).AddRow(encrypt(t, s.cipher, "john@example.com")))
+		mock.ExpectExec(`INSERT INTO user_mfa .* ON CONFLICT \(user_id\) DO UPDATE .* WHERE user_mfa.confirmed_at IS NULL`).
+			WithArgs(domain.Id(3), sqlmock.AnyArg(), "test").
+			WillReturnResult(sqlmock.NewResult(0, affected))
+	}
+
+	enrollment, err := s.Enroll(context.Background(), 3)
+	require.NoError(t, err)
+	assert.Len(t, enrollment.Secret, 32)
+	assert.Contains(t, enrollment.Uri, "otpauth://totp/User:john@example.com?")
+	assert.Contains(t, enrollment.Uri, "secret="+enrollment.Secret)
+
+	_, err = s.Enroll(context.Background(), 3)
+	assert.ErrorIs(t, err, domain.ErrMfaAlreadyEnabled)
+
+	mock.ExpectQuery(`SELECT login FROM users`).WillReturnRows(sqlmock.NewRows([]string{"login"}))
+	enrollment, err = s.Enroll(context.Background(), 4)
+	assert.NoError(t, err)
+	assert.Nil(t, enrollment)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест подтверждения: неверный код отклоняется, верный включает MFA и выдает коды восстановления
+func TestConfirmTotp(t *testing.T) {
+	s, _, mock := newTestMfa(t)
+	sealed := encrypt(t, s.cipher, mfaSecret)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT m.secret, m.confirmed_at FROM user_mfa m JOIN users u`).
+		WithArgs(domain.Id(3), domain.DEFAULT_TENANT).
+		WillReturnRows(sqlmock.NewRows([]string{"secret", "confirmed_at"}).AddRow(sealed, nil))
+	mock.ExpectRollback()
+	_, err := s.Confirm(context.Background(), 3, "000000")
+	assert.ErrorIs(t, err, domain.ErrInvalidCode)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT m.secret, m.confirmed_at FROM user_mfa m JOIN users u`).
+		WillReturnRows(sqlmock.NewRows([]string{"secret", "confirmed_at"}).AddRow(sealed, nil))
+	mock.ExpectExec(`UPDATE user_mfa SET confirmed_at = NOW\(\), last_step = \$2 WHERE user_id = \$1`).
+		WithArgs(domain.Id(3), mfaNow.Unix()/TOTP_PERIOD).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM mfa_recovery_codes WHERE user_id = \$1`).WillReturnResult(sqlmock.NewResult(0, 0))
+	for range RECOVERY_CODES {
+		mock.ExpectExec(`INSERT INTO mfa_recovery_codes`).WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	mock.ExpectExec(`INSERT INTO user_audit`).
+		WithArgs(domain.Id(3), domain.DEFAULT_TENANT, nil, "", domain.AUDIT_MFA, []byte(`{"mfa":{"old":false,"new":true}}`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	codes, err := s.Confirm(context.Background(), 3, "081804")
+	require.NoError(t, err)
+	assert.Len(t, codes, RECOVERY_CODES)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT m.secret, m.confirmed_at FROM user_mfa m JOIN users u`).
+		WillReturnRows(sqlmock.NewRows([]string{"secret", "confirmed_at"}).AddRow(sealed, mfaNow))
+	mock.ExpectRollback()
+	_, err = s.Confirm(context.Background(), 3, "081804")
+	assert.ErrorIs(t, err, domain.ErrMfaAlreadyEnabled)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест второго шага входа: неверный код тратит попытку, код восстановления открывает сессию
+func TestLoginMfa(t *testing.T) {
+	s, auth, mock := newTestMfa(t)
+	sealed := encrypt(t, s.cipher, mfaSecret)
+	step := mfaNow.Unix() / TOTP_PERIOD
+
+	// Код уже принятого шага повторно не принимается
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE mfa_challenges SET attempts = attempts \+ 1 WHERE token_hash = \$1 .* AND attempts < \$2 RETURNING id, user_id`).
+		WithArgs(hashToken("challenge"), MFA_MAX_ATTEMPTS).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(7, 3))
+	mock.ExpectQuery(`SELECT secret, last_step FROM user_mfa WHERE user_id = \$1 AND confirmed_at IS NOT NULL FOR UPDATE`).
+		WithArgs(domain.Id(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"secret", "last_step"}).AddRow(sealed, step))
+	mock.ExpectCommit()
+	tokens, err := auth.LoginMfa(context.Background(), "challenge", "081804")
+	require.NoError(t, err)
+	assert.Nil(t, tokens)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE mfa_challenges SET attempts`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(7, 3))
+	mock.ExpectQuery(`SELECT secret, last_step FROM user_mfa`).
+		WillReturnRows(sqlmock.NewRows([]string{"secret", "last_step"}).AddRow(sealed, step))
+	mock.ExpectExec(`UPDATE mfa_recovery_codes SET used_at = NOW\(\) WHERE user_id = \$1 AND code_hash = \$2 AND used_at IS NULL`).
+		WithArgs(domain.Id(3), hashRecoveryCode("abcde-fghij")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE mfa_challenges SET used_at = NOW\(\) WHERE id = \$1`).
+		WithArgs(domain.Id(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`INSERT INTO sessions`).
+		WithArgs(domain.Id(3), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "Unknown device", sqlmock.AnyArg(), sqlmock.AnyArg(), "test").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	tokens, err = auth.LoginMfa(context.Background(), "challenge", "ABCDE-FGHIJ")
+	require.NoError(t, err)
+	require.NotNil(t, tokens)
+	assert.NotEmpty(t, tokens.AccessToken)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE mfa_challenges SET attempts`).WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}))
+	mock.ExpectRollback()
+	tokens, err = auth.LoginMfa(context.Background(), "challenge", "081804")
+	assert.NoError(t, err)
+	assert.Nil(t, tokens)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
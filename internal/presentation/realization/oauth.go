@@ -0,0 +1,535 @@
+package realization
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"user/internal/domain"
+	"user/internal/interfaces"
+	"user/internal/presentation/db"
+	"user/internal/presentation/jose"
+	"user/internal/presentation/logger"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// OAUTH_CODE_TTL - время на обмен кода авторизации на токены
+	OAUTH_CODE_TTL = time.Minute
+	// OAUTH_ID_TOKEN_TTL - время жизни ID токена
+	OAUTH_ID_TOKEN_TTL = time.Hour
+)
+
+// rowQuerier - база или транзакция, из которой читается одна строка
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// OAuthService - провайдер OAuth2 / OpenID Connect поверх пользователей организаций
+// Коды авторизации, access и refresh токены непрозрачны и хранятся хэшами, как токены сессий,
+// поэтому удаление клиента или пользователя отзывает их сразу
+// ID токены подписываются ключами RS256 и ES256, которые ротируются и публикуются в JWKS
+type OAuthService struct {
+	db          *db.DB
+	cipher      interfaces.FieldCipher
+	issuer      string
+	accessTTL   time.Duration
+	refreshTTL  time.Duration
+	keyRotation time.Duration
+}
+
+// NewOAuthService создает новый экземпляр OAuthService
+// cipher - шифрование закрытых ключей подписи и персональных данных в утверждениях
+// issuer - внешний адрес сервиса, на нем публикуются discovery и JWKS
+// accessTTL - время жизни access токена
+// refreshTTL - время жизни refresh токена
+// keyRotation - возраст ключа подписи, после которого он заменяется
+func NewOAuthService(db *db.DB, cipher interfaces.FieldCipher, issuer string, accessTTL, refreshTTL, keyRotation time.Duration) *OAuthService {
+	return &OAuthService{
+		db:          db,
+		cipher:      cipher,
+		issuer:      strings.TrimSuffix(issuer, "/"),
+		accessTTL:   accessTTL,
+		refreshTTL:  refreshTTL,
+		keyRotation: keyRotation,
+	}
+}
+
+// Configuration возвращает документ discovery
+func (s *OAuthService) Configuration() domain.OpenIdConfiguration {
+	return domain.OpenIdConfiguration{
+		Issuer:                            s.issuer,
+		AuthorizationEndpoint:             s.issuer + "/oauth/authorize",
+		TokenEndpoint:                     s.issuer + "/oauth/token",
+		UserinfoEndpoint:                  s.issuer + "/oauth/userinfo",
+		JwksUri:                           s.issuer + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{domain.GRANT_AUTHORIZATION_CODE, domain.GRANT_CLIENT_CREDENTIALS, domain.GRANT_REFRESH_TOKEN},
+		SubjectTypesSupported:             []string{"public"},
+		IdTokenSigningAlgValuesSupported:  OAuthKeyAlgs,
+		ScopesSupported:                   domain.UserScopes,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		ClaimsSupported:                   []string{"sub", "name", "given_name", "family_name", "birthdate", "email", "email_verified"},
+		CodeChallengeMethodsSupported:     []string{domain.PKCE_S256},
+	}
+}
+
+// Client возвращает клиента по публичному идентификатору в любой организации
+func (s *OAuthService) Client(ctx context.Context, clientId string) (*domain.OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	client, err := scanOAuthClient(s.db.Db.QueryRowContext(ctx, `SELECT `+OAUTH_CLIENT_COLUMNS+` FROM oauth_clients WHERE client_id = $1`, clientId))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting oauth client error: %v", err))
+		return nil, fmt.Errorf("getting postgres oauth client error: %v", err)
+	}
+
+	return client, nil
+}
+
+// Authorize проверяет запрос авторизации и выдает код пользователю из контекста
+// Адрес возврата сравнивается с зарегистрированными точно, публичный клиент обязан передать PKCE
+// Код выдается только пользователю организации клиента
+func (s *OAuthService) Authorize(ctx context.Context, req domain.OAuthAuthorizeRequest) (string, error) {
+	client, err := s.Client(ctx, req.ClientId)
+	if err != nil {
+		return "", err
+	}
+
+	if client == nil || !slices.Contains(client.RedirectUris, req.RedirectUri) {
+		return "", &domain.OAuthError{Code: domain.OAUTH_INVALID_REQUEST, Description: "Unknown client or redirect_uri"}
+	}
+
+	if req.ResponseType != "code" {
+		return "", &domain.OAuthError{Code: domain.OAUTH_UNSUPPORTED_RESPONSE_TYPE, Description: "Only response_type=code is supported"}
+	}
+
+	if !client.Allows(domain.GRANT_AUTHORIZATION_CODE) {
+		return "", &domain.OAuthError{Code: domain.OAUTH_UNAUTHORIZED_CLIENT, Description: "Authorization code grant is not allowed for the client"}
+	}
+
+	scopes, err := grantScopes(client, req.Scope, client.Scopes)
+	if err != nil {
+		return "", err
+	}
+
+	if req.CodeChallenge == "" && client.Public {
+		return "", &domain.OAuthError{Code: domain.OAUTH_INVALID_REQUEST, Description: "PKCE is required for public clients"}
+	}
+
+	// Вычисленный S256 challenge - 32 байта в base64url, то есть ровно 43 символа
+	if req.CodeChallenge != "" && (req.CodeChallengeMethod != domain.PKCE_S256 || len(req.CodeChallenge) != 43) {
+		return "", &domain.OAuthError{Code: domain.OAUTH_INVALID_REQUEST, Description: "Only S256 code_challenge is supported"}
+	}
+
+	userId := domain.ActorFrom(ctx).UserId
+	if userId == nil {
+		return "", &domain.OAuthError{Code: domain.OAUTH_ACCESS_DENIED, Description: "User is not authenticated"}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var member bool
+	err = s.db.Db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)`, *userId, client.TenantId).Scan(&member)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Checking oauth user tenant error: %v", err))
+		return "", fmt.Errorf("checking postgres user tenant error: %v", err)
+	}
+
+	if !member {
+		return "", &domain.OAuthError{Code: domain.OAUTH_ACCESS_DENIED, Description: "User does not belong to the client organization"}
+	}
+
+	code, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.db.Db.ExecContext(ctx, `INSERT INTO oauth_codes (code_hash, client_id, user_id, redirect_uri, scopes, nonce, code_challenge, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, hashToken(code), client.Id, *userId, req.RedirectUri, pq.Array(scopes), req.Nonce, req.CodeChallenge, time.Now().Add(OAUTH_CODE_TTL))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Creating oauth code error: %v", err))
+		return "", fmt.Errorf("creating postgres oauth code error: %v", err)
+	}
+
+	logger.Logger.Info(fmt.Sprintf("User %d has authorized oauth client %d with scopes %v", *userId, client.Id, scopes))
+	return code, nil
+}
+
+// Token проверяет клиента и выдает токены по гранту запроса
+func (s *OAuthService) Token(ctx context.Context, req domain.OAuthTokenRequest) (*domain.OAuthTokens, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	client, err := s.authenticateClient(ctx, req.ClientId, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.GrantType {
+	case domain.GRANT_AUTHORIZATION_CODE, domain.GRANT_REFRESH_TOKEN, domain.GRANT_CLIENT_CREDENTIALS:
+	default:
+		return nil, &domain.OAuthError{Code: domain.OAUTH_UNSUPPORTED_GRANT_TYPE, Description: fmt.Sprintf("Unsupported grant_type %q", req.GrantType)}
+	}
+
+	// Публичный клиент не может подтвердить, что он - это он, поэтому токены для себя не получает
+	if !client.Allows(req.GrantType) || (client.Public && req.GrantType == domain.GRANT_CLIENT_CREDENTIALS) {
+		return nil, &domain.OAuthError{Code: domain.OAUTH_UNAUTHORIZED_CLIENT, Description: fmt.Sprintf("Grant %s is not allowed for the client", req.GrantType)}
+	}
+
+	tx, err := s.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Beginning transaction error: %v", err))
+		return nil, fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	var tokens *domain.OAuthTokens
+	switch req.GrantType {
+	case domain.GRANT_AUTHORIZATION_CODE:
+		tokens, err = s.exchangeCode(ctx, tx, client, req)
+	case domain.GRANT_REFRESH_TOKEN:
+		tokens, err = s.refresh(ctx, tx, client, req)
+	case domain.GRANT_CLIENT_CREDENTIALS:
+		tokens, err = s.clientCredentials(ctx, tx, client, req)
+	}
+
+	// Ошибку протокола тоже фиксируем: использованный код должен остаться использованным
+	var oauthErr *domain.OAuthError
+	if err != nil && !errors.As(err, &oauthErr) {
+		return nil, err
+	}
+
+	commitErr := tx.Commit()
+	if commitErr != nil {
+		logger.Logger.Error(fmt.Sprintf("Committing transaction error: %v", commitErr))
+		return nil, fmt.Errorf("committing postgres transaction error: %v", commitErr)
+	}
+
+	if err != nil {
+		logger.Logger.Warn(fmt.Sprintf("OAuth client %d token request rejected: %v", client.Id, err))
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// authenticateClient проверяет секрет конфиденциального клиента, публичный клиент передает только идентификатор
+func (s *OAuthService) authenticateClient(ctx context.Context, clientId, secret string) (*domain.OAuthClient, error) {
+	var stored sql.NullString
+	client, err := scanOAuthClient(s.db.Db.QueryRowContext(ctx, `SELECT `+OAUTH_CLIENT_COLUMNS+`, secret_hash FROM oauth_clients WHERE client_id = $1`, clientId), &stored)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &domain.OAuthError{Code: domain.OAUTH_INVALID_CLIENT, Description: "Client authentication failed"}
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting oauth client error: %v", err))
+		return nil, fmt.Errorf("getting postgres oauth client error: %v", err)
+	}
+
+	if client.Public && secret == "" {
+		return client, nil
+	}
+
+	if !stored.Valid || subtle.ConstantTimeCompare([]byte(stored.String), []byte(hashToken(secret))) != 1 {
+		logger.Logger.Warn(fmt.Sprintf("Invalid secret of oauth client %d", client.Id))
+		return nil, &domain.OAuthError{Code: domain.OAUTH_INVALID_CLIENT, Description: "Client authentication failed"}
+	}
+
+	return client, nil
+}
+
+// exchangeCode меняет код авторизации на токены
+// Код действует один раз: повторное предъявление отзывает все токены, выданные по нему
+func (s *OAuthService) exchangeCode(ctx context.Context, tx *sql.Tx, client *domain.OAuthClient, req domain.OAuthTokenRequest) (*domain.OAuthTokens, error) {
+	var (
+		codeId        domain.Id
+		userId        domain.Id
+		redirectUri   string
+		scopes        []string
+		nonce         string
+		codeChallenge string
+		used          bool
+	)
+	err := tx.QueryRowContext(ctx, `SELECT c.id, c.user_id, c.redirect_uri, c.scopes, c.nonce, c.code_challenge, c.used_at IS NOT NULL FROM oauth_codes c JOIN users u ON u.id = c.user_id WHERE c.code_hash = $1 AND c.client_id = $2 AND c.expires_at > NOW() AND u.deleted_at IS NULL FOR UPDATE OF c`, hashToken(req.Code), client.Id).Scan(&codeId, &userId, &redirectUri, pq.Array(&scopes), &nonce, &codeChallenge, &used)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &domain.OAuthError{Code: domain.OAUTH_INVALID_GRANT, Description: "Invalid or expired code"}
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting oauth code error: %v", err))
+		return nil, fmt.Errorf("getting postgres oauth code error: %v", err)
+	}
+
+	if used {
+		_, err = tx.ExecContext(ctx, `UPDATE oauth_tokens SET revoked_at = NOW() WHERE code_id = $1 AND revoked_at IS NULL`, codeId)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Revoking oauth tokens error: %v", err))
+			return nil, fmt.Errorf("revoking postgres oauth tokens error: %v", err)
+		}
+
+		logger.Logger.Warn(fmt.Sprintf("OAuth code %d of client %d has been replayed, its tokens have been revoked", codeId, client.Id))
+		return nil, &domain.OAuthError{Code: domain.OAUTH_INVALID_GRANT, Description: "Invalid or expired code"}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE oauth_codes SET used_at = NOW() WHERE id = $1`, codeId)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Using oauth code error: %v", err))
+		return nil, fmt.Errorf("using postgres oauth code error: %v", err)
+	}
+
+	if req.RedirectUri != redirectUri {
+		return nil, &domain.OAuthError{Code: domain.OAUTH_INVALID_GRANT, Description: "redirect_uri does not match the authorization request"}
+	}
+
+	if !verifyPkce(codeChallenge, req.CodeVerifier) {
+		return nil, &domain.OAuthError{Code: domain.OAUTH_INVALID_GRANT, Description: "Invalid code_verifier"}
+	}
+
+	return s.issue(ctx, tx, client, &userId, &codeId, scopes, nonce)
+}
+
+// refresh меняет refresh токен на новую пару токенов, прежняя пара перестает действовать
+// Области доступа можно только сузить
+func (s *OAuthService) refresh(ctx context.Context, tx *sql.Tx, client *domain.OAuthClient, req domain.OAuthTokenRequest) (*domain.OAuthTokens, error) {
+	var (
+		tokenId domain.Id
+		userId  domain.Id
+		granted []string
+	)
+	err := tx.QueryRowContext(ctx, `SELECT t.id, t.user_id, t.scopes FROM oauth_tokens t JOIN users u ON u.id = t.user_id WHERE t.refresh_hash = $1 AND t.client_id = $2 AND t.revoked_at IS NULL AND t.refresh_expires_at > NOW() AND u.deleted_at IS NULL FOR UPDATE OF t`, hashToken(req.RefreshToken), client.Id).Scan(&tokenId, &userId, pq.Array(&granted))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &domain.OAuthError{Code: domain.OAUTH_INVALID_GRANT, Description: "Invalid or expired refresh token"}
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting oauth refresh token error: %v", err))
+		return nil, fmt.Errorf("getting postgres oauth refresh token error: %v", err)
+	}
+
+	scopes, err := grantScopes(&domain.OAuthClient{Scopes: granted}, req.Scope, granted)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, access, refresh, err := s.newTokens(scopes, true)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `UPDATE oauth_tokens SET access_hash = $2, refresh_hash = $3, access_expires_at = $4, refresh_expires_at = $5, scopes = $6 WHERE id = $1`, tokenId, access, refresh, now.Add(s.accessTTL), now.Add(s.refreshTTL), pq.Array(scopes))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Refreshing oauth token error: %v", err))
+		return nil, fmt.Errorf("refreshing postgres oauth token error: %v", err)
+	}
+
+	if slices.Contains(scopes, domain.SCOPE_OPENID) {
+		tokens.IdToken, err = s.idToken(ctx, tx, client, userId, scopes, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tokens, nil
+}
+
+// clientCredentials выдает токен самому конфиденциальному клиенту, без пользователя и refresh токена
+func (s *OAuthService) clientCredentials(ctx context.Context, tx *sql.Tx, client *domain.OAuthClient, req domain.OAuthTokenRequest) (*domain.OAuthTokens, error) {
+	allowed := slices.DeleteFunc(slices.Clone(client.Scopes), func(scope string) bool {
+		return slices.Contains(domain.UserScopes, scope)
+	})
+
+	scopes, err := grantScopes(&domain.OAuthClient{Scopes: allowed}, req.Scope, allowed)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issue(ctx, tx, client, nil, nil, scopes, "")
+}
+
+// issue сохраняет новые токены клиента
+// Токены пользователя получают refresh токен, если клиенту разрешен refresh_token, и ID токен по области openid
+func (s *OAuthService) issue(ctx context.Context, tx *sql.Tx, client *domain.OAuthClient, userId, codeId *domain.Id, scopes []string, nonce string) (*domain.OAuthTokens, error) {
+	withRefresh := userId != nil && client.Allows(domain.GRANT_REFRESH_TOKEN)
+	tokens, access, refresh, err := s.newTokens(scopes, withRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		refreshHash      *string
+		refreshExpiresAt *time.Time
+	)
+	now := time.Now()
+	if withRefresh {
+		expiresAt := now.Add(s.refreshTTL)
+		refreshHash, refreshExpiresAt = &refresh, &expiresAt
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO oauth_tokens (client_id, user_id, code_id, scopes, access_hash, refresh_hash, access_expires_at, refresh_expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, client.Id, userId, codeId, pq.Array(scopes), access, refreshHash, now.Add(s.accessTTL), refreshExpiresAt)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Creating oauth token error: %v", err))
+		return nil, fmt.Errorf("creating postgres oauth token error: %v", err)
+	}
+
+	if userId != nil && slices.Contains(scopes, domain.SCOPE_OPENID) {
+		tokens.IdToken, err = s.idToken(ctx, tx, client, *userId, scopes, nonce)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tokens, nil
+}
+
+// newTokens генерирует access и, если нужно, refresh токен и их хэши
+func (s *OAuthService) newTokens(scopes []string, withRefresh bool) (*domain.OAuthTokens, string, string, error) {
+	access, err := newToken()
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	tokens := &domain.OAuthTokens{
+		AccessToken: access,
+		TokenType:   TOKEN_TYPE,
+		ExpiresIn:   int64(s.accessTTL.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}
+
+	if !withRefresh {
+		return tokens, hashToken(access), "", nil
+	}
+
+	tokens.RefreshToken, err = newToken()
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return tokens, hashToken(access), hashToken(tokens.RefreshToken), nil
+}
+
+// idToken подписывает ID токен пользователя для клиента алгоритмом клиента
+// Утверждения о пользователе в нем те же, что отдает /oauth/userinfo
+func (s *OAuthService) idToken(ctx context.Context, tx *sql.Tx, client *domain.OAuthClient, userId domain.Id, scopes []string, nonce string) (string, error) {
+	info, err := s.userInfo(ctx, tx, userId, scopes)
+	if err != nil {
+		return "", err
+	}
+
+	if info == nil {
+		return "", fmt.Errorf("user %d not found", userId)
+	}
+
+	kid, key, err := s.signingKey(ctx, client.IdTokenAlg)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	token, err := jose.Sign(key, client.IdTokenAlg, kid, domain.IdTokenClaims{
+		Issuer:   s.issuer,
+		Audience: client.ClientId,
+		IssuedAt: now.Unix(),
+		Expiry:   now.Add(OAUTH_ID_TOKEN_TTL).Unix(),
+		Nonce:    nonce,
+		UserInfo: *info,
+	})
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Signing id token error: %v", err))
+		return "", err
+	}
+
+	return token, nil
+}
+
+// UserInfo возвращает утверждения о пользователе по access токену с областью openid
+// Возвращает nil, если токен неизвестен, отозван, просрочен, выдан клиенту без пользователя или без openid
+func (s *OAuthService) UserInfo(ctx context.Context, accessToken string) (*domain.UserInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var (
+		userId sql.NullInt64
+		scopes []string
+	)
+	err := s.db.Db.QueryRowContext(ctx, `SELECT user_id, scopes FROM oauth_tokens WHERE access_hash = $1 AND revoked_at IS NULL AND access_expires_at > NOW()`, hashToken(accessToken)).Scan(&userId, pq.Array(&scopes))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting oauth token error: %v", err))
+		return nil, fmt.Errorf("getting postgres oauth token error: %v", err)
+	}
+
+	if !userId.Valid || !slices.Contains(scopes, domain.SCOPE_OPENID) {
+		return nil, nil
+	}
+
+	return s.userInfo(ctx, s.db.Db, domain.Id(userId.Int64), scopes)
+}
+
+// userInfo читает и расшифровывает активного пользователя и оставляет утверждения, доступные по scopes
+func (s *OAuthService) userInfo(ctx context.Context, q rowQuerier, userId domain.Id, scopes []string) (*domain.UserInfo, error) {
+	var (
+		row      userRow
+		verified bool
+	)
+	err := q.QueryRowContext(ctx, `SELECT id, first_name, last_name, birthday, login, version, created_at, deleted_at, email_verified_at IS NOT NULL FROM users WHERE id = $1 AND deleted_at IS NULL`, userId).Scan(row.dest(&verified)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting oauth user error: %v", err))
+		return nil, fmt.Errorf("getting postgres user error: %v", err)
+	}
+
+	user, err := row.decrypt(s.cipher)
+	if err != nil {
+		return nil, err
+	}
+
+	info := domain.UserInfoFrom(*user, verified, scopes)
+	return &info, nil
+}
+
+// grantScopes возвращает запрошенные области доступа или defaults, если они не запрошены
+// Все запрошенные области должны быть разрешены клиенту
+func grantScopes(client *domain.OAuthClient, requested string, defaults []string) ([]string, error) {
+	scopes := domain.ParseScope(requested)
+	if len(scopes) == 0 {
+		return slices.Clone(defaults), nil
+	}
+
+	for _, scope := range scopes {
+		if !slices.Contains(client.Scopes, scope) {
+			return nil, &domain.OAuthError{Code: domain.OAUTH_INVALID_SCOPE, Description: fmt.Sprintf("Scope %q is not allowed", scope)}
+		}
+	}
+
+	return scopes, nil
+}
+
+// verifyPkce проверяет code_verifier по code_challenge S256
+// Код, выданный без PKCE, принимается только без code_verifier
+func verifyPkce(challenge, verifier string) bool {
+	if challenge == "" {
+		return verifier == ""
+	}
+
+	// RFC 7636: code_verifier - от 43 до 128 символов
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(hash[:])), []byte(challenge)) == 1
+}
@@ -0,0 +1,191 @@
+package realization
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"user/internal/domain"
+	"user/internal/presentation/db"
+	"user/internal/presentation/logger"
+
+	"github.com/lib/pq"
+)
+
+// OAUTH_CLIENT_COLUMNS - поля клиента в порядке scanOAuthClient
+const OAUTH_CLIENT_COLUMNS = `id, client_id, secret_hash IS NULL, name, redirect_uris, grant_types, scopes, id_token_alg, owner_id, created_at, tenant_id`
+
+// OAuthClientService регистрирует клиентов OAuth2 организации
+// Секрет клиента показывается один раз, в базе хранится только его хэш
+type OAuthClientService struct {
+	db *db.DB
+}
+
+// NewOAuthClientService создает новый экземпляр OAuthClientService
+func NewOAuthClientService(db *db.DB) *OAuthClientService {
+	return &OAuthClientService{
+		db: db,
+	}
+}
+
+// Create регистрирует клиента со случайным идентификатором
+// Конфиденциальный клиент получает секрет, публичный входит только с PKCE
+func (s *OAuthClientService) Create(ctx context.Context, client domain.OAuthClient) (*domain.OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	clientId, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var secretHash *string
+	if !client.Public {
+		client.Secret, err = newToken()
+		if err != nil {
+			return nil, err
+		}
+		hash := hashToken(client.Secret)
+		secretHash = &hash
+	}
+
+	client.ClientId = clientId
+	client.OwnerId = domain.ActorFrom(ctx).UserId
+
+	logger.Logger.Debug("Creating oauth client...")
+	err = s.db.Db.QueryRowContext(ctx, `INSERT INTO oauth_clients (tenant_id, client_id, secret_hash, name, redirect_uris, grant_types, scopes, id_token_alg, owner_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id, created_at`, domain.TenantFrom(ctx), client.ClientId, secretHash, client.Name, pq.Array(client.RedirectUris), pq.Array(client.GrantTypes), pq.Array(client.Scopes), client.IdTokenAlg, client.OwnerId).Scan(&client.Id, &client.CreatedAt)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Creating oauth client error: %v", err))
+		return nil, fmt.Errorf("creating postgres oauth client error: %v", err)
+	}
+
+	logger.Logger.Info(fmt.Sprintf("OAuth client %d has been registered by %s", client.Id, actorName(domain.ActorFrom(ctx))))
+	return &client, nil
+}
+
+// Get возвращает клиента организации без секрета
+func (s *OAuthClientService) Get(ctx context.Context, id domain.Id) (*domain.OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	client, err := scanOAuthClient(s.db.Db.QueryRowContext(ctx, `SELECT `+OAUTH_CLIENT_COLUMNS+` FROM oauth_clients WHERE id = $1 AND tenant_id = $2`, id, domain.TenantFrom(ctx)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting oauth client error: %v", err))
+		return nil, fmt.Errorf("getting postgres oauth client error: %v", err)
+	}
+
+	return client, nil
+}
+
+// List возвращает всех клиентов организации без секретов
+func (s *OAuthClientService) List(ctx context.Context) ([]domain.OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	rows, err := s.db.Db.QueryContext(ctx, `SELECT `+OAUTH_CLIENT_COLUMNS+` FROM oauth_clients WHERE tenant_id = $1 ORDER BY id`, domain.TenantFrom(ctx))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting oauth clients error: %v", err))
+		return nil, fmt.Errorf("getting postgres oauth clients error: %v", err)
+	}
+	defer rows.Close()
+
+	clients := []domain.OAuthClient{}
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning oauth client error: %v", err))
+			return nil, fmt.Errorf("scanning postgres oauth client error: %v", err)
+		}
+		clients = append(clients, *client)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting oauth clients error: %v", err))
+		return nil, fmt.Errorf("getting postgres oauth clients error: %v", err)
+	}
+
+	return clients, nil
+}
+
+// Update меняет настройки клиента организации, идентификатор, тип и секрет клиента не меняются
+// Уже выданные токены остаются действительными до истечения или отзыва
+func (s *OAuthClientService) Update(ctx context.Context, client domain.OAuthClient) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	res, err := s.db.Db.ExecContext(ctx, `UPDATE oauth_clients SET name = $3, redirect_uris = $4, grant_types = $5, scopes = $6, id_token_alg = $7 WHERE id = $1 AND tenant_id = $2`, client.Id, domain.TenantFrom(ctx), client.Name, pq.Array(client.RedirectUris), pq.Array(client.GrantTypes), pq.Array(client.Scopes), client.IdTokenAlg)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Updating oauth client error: %v", err))
+		return false, fmt.Errorf("updating postgres oauth client error: %v", err)
+	}
+
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return false, nil
+	}
+
+	logger.Logger.Info(fmt.Sprintf("OAuth client %d has been updated by %s", client.Id, actorName(domain.ActorFrom(ctx))))
+	return true, nil
+}
+
+// Delete удаляет клиента организации, коды и токены удаляются каскадно
+func (s *OAuthClientService) Delete(ctx context.Context, id domain.Id) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	res, err := s.db.Db.ExecContext(ctx, `DELETE FROM oauth_clients WHERE id = $1 AND tenant_id = $2`, id, domain.TenantFrom(ctx))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Deleting oauth client error: %v", err))
+		return false, fmt.Errorf("deleting postgres oauth client error: %v", err)
+	}
+
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return false, nil
+	}
+
+	logger.Logger.Info(fmt.Sprintf("OAuth client %d has been deleted by %s", id, actorName(domain.ActorFrom(ctx))))
+	return true, nil
+}
+
+// RotateSecret выдает конфиденциальному клиенту организации новый секрет
+// Возвращает nil, если клиента нет или он публичный
+func (s *OAuthClientService) RotateSecret(ctx context.Context, id domain.Id) (*domain.OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	secret, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := scanOAuthClient(s.db.Db.QueryRowContext(ctx, `UPDATE oauth_clients SET secret_hash = $3 WHERE id = $1 AND tenant_id = $2 AND secret_hash IS NOT NULL RETURNING `+OAUTH_CLIENT_COLUMNS, id, domain.TenantFrom(ctx), hashToken(secret)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Rotating oauth client secret error: %v", err))
+		return nil, fmt.Errorf("rotating postgres oauth client secret error: %v", err)
+	}
+
+	client.Secret = secret
+	logger.Logger.Info(fmt.Sprintf("Secret of oauth client %d has been rotated by %s", id, actorName(domain.ActorFrom(ctx))))
+	return client, nil
+}
+
+// scanOAuthClient читает клиента из строки с полями OAUTH_CLIENT_COLUMNS
+// extra - приемники колонок, выбранных после них
+func scanOAuthClient(row interface{ Scan(...any) error }, extra ...any) (*domain.OAuthClient, error) {
+	var client domain.OAuthClient
+	err := row.Scan(append([]any{&client.Id, &client.ClientId, &client.Public, &client.Name, pq.Array(&client.RedirectUris), pq.Array(&client.GrantTypes), pq.Array(&client.Scopes), &client.IdTokenAlg, &client.OwnerId, &client.CreatedAt, &client.TenantId}, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client, nil
+}
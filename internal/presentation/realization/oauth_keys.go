@@ -0,0 +1,212 @@
+package realization
+
+import (
+	"context"
+	"crypto"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"user/internal/domain"
+	"user/internal/presentation/jose"
+	"user/internal/presentation/logger"
+)
+
+const (
+	// OAUTH_KEY_PUBLISH_DELAY - сколько новый ключ публикуется в JWKS до начала подписи
+	// За это время клиенты, закэшировавшие JWKS, успевают его обновить
+	OAUTH_KEY_PUBLISH_DELAY = time.Hour
+	// OAUTH_KEY_CHECK_INTERVAL - наибольший период проверки возраста ключей
+	OAUTH_KEY_CHECK_INTERVAL = time.Hour
+)
+
+// OAuthKeyAlgs - алгоритмы ключей подписи, ключ каждого алгоритма ротируется отдельно
+var OAuthKeyAlgs = []string{domain.ALG_RS256, domain.ALG_ES256}
+
+// RunKeyRotation заменяет ключи подписи старше keyRotation до отмены контекста
+func (s *OAuthService) RunKeyRotation(ctx context.Context) {
+	logger.Logger.Info("OAuth key rotation has been started")
+	ticker := time.NewTicker(min(s.keyRotation, OAUTH_KEY_CHECK_INTERVAL))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Logger.Info("OAuth key rotation has been stopped")
+			return
+		case <-ticker.C:
+		}
+
+		err := s.RotateKeys(ctx)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Rotating oauth keys error: %v", err))
+		}
+	}
+}
+
+// RotateKeys создает ключ каждого алгоритма, если действующего ключа нет или он старше keyRotation,
+// и удаляет ключи, срок публикации которых истек
+// Прежний ключ подписывает, пока новый не опубликован OAUTH_KEY_PUBLISH_DELAY,
+// и остается в JWKS, пока не истекут подписанные им ID токены
+// Вызывается при запуске до приема запросов, чтобы ключи подписи были всегда
+func (s *OAuthService) RotateKeys(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+
+	for _, alg := range OAuthKeyAlgs {
+		err := s.rotateKey(ctx, alg)
+		if err != nil {
+			return err
+		}
+	}
+
+	res, err := s.db.Db.ExecContext(ctx, `DELETE FROM oauth_signing_keys WHERE expires_at < NOW()`)
+	if err != nil {
+		return fmt.Errorf("deleting postgres expired signing keys error: %v", err)
+	}
+
+	if n, _ := res.RowsAffected(); n > 0 {
+		logger.Logger.Info(fmt.Sprintf("%d expired signing keys have been removed from JWKS", n))
+	}
+	return nil
+}
+
+// rotateKey заменяет действующий ключ алгоритма alg, если он старше keyRotation
+func (s *OAuthService) rotateKey(ctx context.Context, alg string) error {
+	tx, err := s.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	var createdAt time.Time
+	err = tx.QueryRowContext(ctx, `SELECT created_at FROM oauth_signing_keys WHERE alg = $1 AND expires_at IS NULL ORDER BY created_at DESC LIMIT 1 FOR UPDATE`, alg).Scan(&createdAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("getting postgres signing key error: %v", err)
+	}
+
+	if err == nil && time.Since(createdAt) < s.keyRotation {
+		return nil
+	}
+
+	key, err := jose.GenerateKey(alg)
+	if err != nil {
+		return err
+	}
+
+	kid, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	jwk, err := jose.PublicJwk(kid, alg, key)
+	if err != nil {
+		return err
+	}
+
+	public, err := json.Marshal(jwk)
+	if err != nil {
+		return err
+	}
+
+	der, err := jose.MarshalKey(key)
+	if err != nil {
+		return err
+	}
+
+	private, err := s.cipher.Encrypt(base64.StdEncoding.EncodeToString(der))
+	if err != nil {
+		return fmt.Errorf("encrypting signing key error: %v", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE oauth_signing_keys SET expires_at = $2 WHERE alg = $1 AND expires_at IS NULL`, alg, time.Now().Add(OAUTH_KEY_PUBLISH_DELAY+OAUTH_ID_TOKEN_TTL))
+	if err != nil {
+		return fmt.Errorf("retiring postgres signing key error: %v", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO oauth_signing_keys (kid, alg, private_key, key_id, public_key) VALUES ($1, $2, $3, $4, $5)`, kid, alg, private, s.cipher.ActiveKey(), public)
+	if err != nil {
+		return fmt.Errorf("creating postgres signing key error: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	logger.Logger.Info(fmt.Sprintf("Signing key %s %s has been created", alg, kid))
+	return nil
+}
+
+// Keys возвращает ключи, опубликованные в JWKS, начиная с новых
+func (s *OAuthService) Keys(ctx context.Context) ([]domain.Jwk, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	rows, err := s.db.Db.QueryContext(ctx, `SELECT public_key FROM oauth_signing_keys WHERE expires_at IS NULL OR expires_at > NOW() ORDER BY created_at DESC`)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting signing keys error: %v", err))
+		return nil, fmt.Errorf("getting postgres signing keys error: %v", err)
+	}
+	defer rows.Close()
+
+	keys := []domain.Jwk{}
+	for rows.Next() {
+		var (
+			data []byte
+			jwk  domain.Jwk
+		)
+		err = rows.Scan(&data)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning signing key error: %v", err))
+			return nil, fmt.Errorf("scanning postgres signing key error: %v", err)
+		}
+
+		err = json.Unmarshal(data, &jwk)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Decoding signing key error: %v", err))
+			return nil, fmt.Errorf("decoding signing key error: %v", err)
+		}
+		keys = append(keys, jwk)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting signing keys error: %v", err))
+		return nil, fmt.Errorf("getting postgres signing keys error: %v", err)
+	}
+
+	return keys, nil
+}
+
+// signingKey возвращает ключ, которым подписываются ID токены алгоритма alg
+// Это новейший ключ, опубликованный дольше OAUTH_KEY_PUBLISH_DELAY, а если такого нет - новейший ключ
+func (s *OAuthService) signingKey(ctx context.Context, alg string) (string, crypto.Signer, error) {
+	var kid, private string
+	err := s.db.Db.QueryRowContext(ctx, `SELECT kid, private_key FROM oauth_signing_keys WHERE alg = $1 AND (expires_at IS NULL OR expires_at > NOW()) ORDER BY created_at <= $2 DESC, created_at DESC LIMIT 1`, alg, time.Now().Add(-OAUTH_KEY_PUBLISH_DELAY)).Scan(&kid, &private)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting %s signing key error: %v", alg, err))
+		return "", nil, fmt.Errorf("getting postgres signing key error: %v", err)
+	}
+
+	encoded, err := s.cipher.Decrypt(private)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Decrypting signing key %s error: %v", kid, err))
+		return "", nil, fmt.Errorf("decrypting signing key error: %v", err)
+	}
+
+	der, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, fmt.Errorf("decoding signing key error: %v", err)
+	}
+
+	key, err := jose.ParseKey(der)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Parsing signing key %s error: %v", kid, err))
+		return "", nil, fmt.Errorf("parsing signing key error: %v", err)
+	}
+
+	return kid, key, nil
+}
@@ -0,0 +1,233 @@
+package realization
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+	"user/internal/presentation/jose"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Пример из RFC 7636, приложение B
+const (
+	testCodeVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testCodeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+var oauthClientColumns = []string{"id", "client_id", "public", "name", "redirect_uris", "grant_types", "scopes", "id_token_alg", "owner_id", "created_at", "tenant_id"}
+
+func newTestOAuth(t *testing.T) (*OAuthService, sqlmock.Sqlmock) {
+	users, mock, _ := newMockService(t)
+
+	return NewOAuthService(users.db, users.cipher, "https://id.example.com", time.Hour, 24*time.Hour, 30*24*time.Hour), mock
+}
+
+// oauthClientRow возвращает строку клиента для sqlmock, secret - хэш секрета или nil для публичного клиента
+func oauthClientRow(public bool, grants string, secret any) *sqlmock.Rows {
+	return sqlmock.NewRows(append(oauthClientColumns, "secret_hash")).
+		AddRow(5, "client", public, "App", "{https://app.example.com/cb}", grants, "{openid,profile,email,api}", domain.ALG_ES256, nil, time.Now(), domain.DEFAULT_TENANT, secret)
+}
+
+// oauthCode возвращает код ошибки протокола
+func oauthCode(err error) string {
+	var oauthErr *domain.OAuthError
+	if errors.As(err, &oauthErr) {
+		return oauthErr.Code
+	}
+	return ""
+}
+
+// Тест PKCE: принимается только верный code_verifier, код без PKCE принимается только без него
+func TestVerifyPkce(t *testing.T) {
+	assert.True(t, verifyPkce(testCodeChallenge, testCodeVerifier))
+	assert.False(t, verifyPkce(testCodeChallenge, testCodeVerifier[1:]+"a"))
+	assert.False(t, verifyPkce(testCodeChallenge, ""))
+	assert.False(t, verifyPkce("", testCodeVerifier))
+	assert.True(t, verifyPkce("", ""))
+}
+
+// Тест областей доступа: без запроса выдаются области по умолчанию, чужая область отклоняется
+func TestGrantScopes(t *testing.T) {
+	client := &domain.OAuthClient{Scopes: []string{domain.SCOPE_OPENID, domain.SCOPE_EMAIL}}
+
+	scopes, err := grantScopes(client, "", client.Scopes)
+	require.NoError(t, err)
+	assert.Equal(t, client.Scopes, scopes)
+
+	scopes, err = grantScopes(client, " email  email ", client.Scopes)
+	require.NoError(t, err)
+	assert.Equal(t, []string{domain.SCOPE_EMAIL}, scopes)
+
+	_, err = grantScopes(client, "openid profile", client.Scopes)
+	assert.Equal(t, domain.OAUTH_INVALID_SCOPE, oauthCode(err))
+}
+
+// Тест утверждений о пользователе: profile и email добавляют только свои утверждения
+func TestUserInfoFrom(t *testing.T) {
+	birthday := time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC)
+	user := domain.User{Id: 3, FirstName: "John", LastName: "Doe", BirthDay: &birthday, Login: "john@example.com"}
+
+	assert.Equal(t, domain.UserInfo{Subject: "3"}, domain.UserInfoFrom(user, true, []string{domain.SCOPE_OPENID}))
+
+	info := domain.UserInfoFrom(user, false, domain.UserScopes)
+	assert.Equal(t, "John Doe", info.Name)
+	assert.Equal(t, "1990-05-17", info.Birthdate)
+	assert.Equal(t, "john@example.com", info.Email)
+	require.NotNil(t, info.EmailVerified)
+	assert.False(t, *info.EmailVerified)
+}
+
+// Тест запроса авторизации: неизвестный адрес возврата, публичный клиент без PKCE и анонимный пользователь отклоняются
+func TestAuthorizeErrors(t *testing.T) {
+	s, mock := newTestOAuth(t)
+	req := domain.OAuthAuthorizeRequest{ResponseType: "code", ClientId: "client", RedirectUri: "https://app.example.com/cb"}
+
+	mock.ExpectQuery(`FROM oauth_clients WHERE client_id = \$1`).WithArgs("client").
+		WillReturnRows(sqlmock.NewRows(oauthClientColumns).AddRow(5, "client", true, "App", "{https://app.example.com/cb}", "{authorization_code}", "{openid}", domain.ALG_RS256, nil, time.Now(), domain.DEFAULT_TENANT))
+	_, err := s.Authorize(context.Background(), domain.OAuthAuthorizeRequest{ResponseType: "code", ClientId: "client", RedirectUri: "https://evil.example.com/cb"})
+	assert.Equal(t, domain.OAUTH_INVALID_REQUEST, oauthCode(err))
+
+	mock.ExpectQuery(`FROM oauth_clients WHERE client_id = \$1`).WithArgs("client").
+		WillReturnRows(sqlmock.NewRows(oauthClientColumns).AddRow(5, "client", true, "App", "{https://app.example.com/cb}", "{authorization_code}", "{openid}", domain.ALG_RS256, nil, time.Now(), domain.DEFAULT_TENANT))
+	_, err = s.Authorize(context.Background(), req)
+	assert.Equal(t, domain.OAUTH_INVALID_REQUEST, oauthCode(err))
+
+	req.CodeChallenge, req.CodeChallengeMethod = testCodeChallenge, domain.PKCE_S256
+	mock.ExpectQuery(`FROM oauth_clients WHERE client_id = \$1`).WithArgs("client").
+		WillReturnRows(sqlmock.NewRows(oauthClientColumns).AddRow(5, "client", true, "App", "{https://app.example.com/cb}", "{authorization_code}", "{openid}", domain.ALG_RS256, nil, time.Now(), domain.DEFAULT_TENANT))
+	_, err = s.Authorize(context.Background(), req)
+	assert.Equal(t, domain.OAUTH_ACCESS_DENIED, oauthCode(err))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест client_credentials: токен выдается без refresh и ID токена, области пользователя не выдаются
+func TestTokenClientCredentials(t *testing.T) {
+	s, mock := newTestOAuth(t)
+
+	mock.ExpectQuery(`FROM oauth_clients WHERE client_id = \$1`).WithArgs("client").
+		WillReturnRows(oauthClientRow(false, "{client_credentials}", hashToken("secret")))
+	_, err := s.Token(context.Background(), domain.OAuthTokenRequest{GrantType: domain.GRANT_CLIENT_CREDENTIALS, ClientId: "client", ClientSecret: "wrong"})
+	assert.Equal(t, domain.OAUTH_INVALID_CLIENT, oauthCode(err))
+
+	mock.ExpectQuery(`FROM oauth_clients WHERE client_id = \$1`).WithArgs("client").
+		WillReturnRows(oauthClientRow(false, "{client_credentials}", hashToken("secret")))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO oauth_tokens`).
+		WithArgs(domain.Id(5), nil, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), nil, sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	tokens, err := s.Token(context.Background(), domain.OAuthTokenRequest{GrantType: domain.GRANT_CLIENT_CREDENTIALS, ClientId: "client", ClientSecret: "secret"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, tokens.AccessToken)
+	assert.Empty(t, tokens.RefreshToken)
+	assert.Empty(t, tokens.IdToken)
+	assert.Equal(t, "api", tokens.Scope)
+	assert.Equal(t, int64(3600), tokens.ExpiresIn)
+
+	mock.ExpectQuery(`FROM oauth_clients WHERE client_id = \$1`).WithArgs("client").
+		WillReturnRows(oauthClientRow(false, "{client_credentials}", hashToken("secret")))
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+	_, err = s.Token(context.Background(), domain.OAuthTokenRequest{GrantType: domain.GRANT_CLIENT_CREDENTIALS, ClientId: "client", ClientSecret: "secret", Scope: "openid"})
+	assert.Equal(t, domain.OAUTH_INVALID_SCOPE, oauthCode(err))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест обмена кода: публичный клиент с верным code_verifier получает токены и подписанный ID токен с nonce
+func TestTokenExchangeCode(t *testing.T) {
+	s, mock := newTestOAuth(t)
+
+	key, err := jose.GenerateKey(domain.ALG_ES256)
+	require.NoError(t, err)
+	der, err := jose.MarshalKey(key)
+	require.NoError(t, err)
+	jwk, err := jose.PublicJwk("k1", domain.ALG_ES256, key)
+	require.NoError(t, err)
+
+	mock.ExpectQuery(`FROM oauth_clients WHERE client_id = \$1`).WithArgs("client").
+		WillReturnRows(oauthClientRow(true, "{authorization_code,refresh_token}", nil))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM oauth_codes c JOIN users u ON u.id = c.user_id WHERE c.code_hash = \$1 AND c.client_id = \$2`).
+		WithArgs(hashToken("code"), domain.Id(5)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "redirect_uri", "scopes", "nonce", "code_challenge", "used"}).
+			AddRow(7, 3, "https://app.example.com/cb", "{openid,email}", "n-1", testCodeChallenge, false))
+	mock.ExpectExec(`UPDATE oauth_codes SET used_at = NOW\(\) WHERE id = \$1`).WithArgs(domain.Id(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO oauth_tokens`).
+		WithArgs(domain.Id(5), domain.Id(3), domain.Id(7), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`FROM users WHERE id = \$1 AND deleted_at IS NULL`).WithArgs(domain.Id(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "birthday", "login", "version", "created_at", "deleted_at", "verified"}).
+			AddRow(3, encrypt(t, s.cipher, "John"), encrypt(t, s.cipher, "Doe"), nil, encrypt(t, s.cipher, "john@example.com"), 1, time.Now(), nil, true))
+	mock.ExpectQuery(`SELECT kid, private_key FROM oauth_signing_keys WHERE alg = \$1`).WithArgs(domain.ALG_ES256, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"kid", "private_key"}).AddRow("k1", encrypt(t, s.cipher, base64.StdEncoding.EncodeToString(der))))
+	mock.ExpectCommit()
+
+	tokens, err := s.Token(context.Background(), domain.OAuthTokenRequest{GrantType: domain.GRANT_AUTHORIZATION_CODE, ClientId: "client", Code: "code", RedirectUri: "https://app.example.com/cb", CodeVerifier: testCodeVerifier})
+	require.NoError(t, err)
+	assert.NotEmpty(t, tokens.RefreshToken)
+	assert.Equal(t, "openid email", tokens.Scope)
+
+	var claims domain.IdTokenClaims
+	require.NoError(t, jose.Verify(tokens.IdToken, []domain.Jwk{jwk}, &claims))
+	assert.Equal(t, "https://id.example.com", claims.Issuer)
+	assert.Equal(t, "client", claims.Audience)
+	assert.Equal(t, "n-1", claims.Nonce)
+	assert.Equal(t, "3", claims.Subject)
+	assert.Equal(t, "john@example.com", claims.Email)
+	assert.Empty(t, claims.Name)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест повторного предъявления кода: выданные по нему токены отзываются, а код не меняется на токены
+func TestTokenCodeReplay(t *testing.T) {
+	s, mock := newTestOAuth(t)
+
+	mock.ExpectQuery(`FROM oauth_clients WHERE client_id = \$1`).WithArgs("client").
+		WillReturnRows(oauthClientRow(true, "{authorization_code}", nil))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM oauth_codes c`).
+		WithArgs(hashToken("code"), domain.Id(5)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "redirect_uri", "scopes", "nonce", "code_challenge", "used"}).
+			AddRow(7, 3, "https://app.example.com/cb", "{openid}", "", testCodeChallenge, true))
+	mock.ExpectExec(`UPDATE oauth_tokens SET revoked_at = NOW\(\) WHERE code_id = \$1 AND revoked_at IS NULL`).WithArgs(domain.Id(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	_, err := s.Token(context.Background(), domain.OAuthTokenRequest{GrantType: domain.GRANT_AUTHORIZATION_CODE, ClientId: "client", Code: "code", RedirectUri: "https://app.example.com/cb", CodeVerifier: testCodeVerifier})
+	assert.Equal(t, domain.OAUTH_INVALID_GRANT, oauthCode(err))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест userinfo: токен без openid не дает утверждений, с openid - только утверждения своих областей
+func TestUserInfo(t *testing.T) {
+	s, mock := newTestOAuth(t)
+
+	mock.ExpectQuery(`SELECT user_id, scopes FROM oauth_tokens WHERE access_hash = \$1`).WithArgs(hashToken("api")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "scopes"}).AddRow(3, "{profile}"))
+	info, err := s.UserInfo(context.Background(), "api")
+	require.NoError(t, err)
+	assert.Nil(t, info)
+
+	mock.ExpectQuery(`SELECT user_id, scopes FROM oauth_tokens WHERE access_hash = \$1`).WithArgs(hashToken("token")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "scopes"}).AddRow(3, "{openid,profile}"))
+	mock.ExpectQuery(`FROM users WHERE id = \$1 AND deleted_at IS NULL`).WithArgs(domain.Id(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "birthday", "login", "version", "created_at", "deleted_at", "verified"}).
+			AddRow(3, encrypt(t, s.cipher, "John"), encrypt(t, s.cipher, "Doe"), nil, encrypt(t, s.cipher, "john@example.com"), 1, time.Now(), nil, true))
+	info, err = s.UserInfo(context.Background(), "token")
+	require.NoError(t, err)
+
+	data, err := json.Marshal(info)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"sub":"3","name":"John Doe","given_name":"John","family_name":"Doe"}`, string(data))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
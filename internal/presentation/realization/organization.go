@@ -0,0 +1,300 @@
+package realization
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"user/internal/domain"
+	"user/internal/presentation/db"
+	"user/internal/presentation/logger"
+
+	"github.com/lib/pq"
+)
+
+// OrganizationService управляет организациями и членством пользователей в них
+// Организации не зависят от организации запроса: ими управляет роль operator
+type OrganizationService struct {
+	db *db.DB
+}
+
+// NewOrganizationService создает новый экземпляр OrganizationService
+func NewOrganizationService(db *db.DB) *OrganizationService {
+	return &OrganizationService{
+		db: db,
+	}
+}
+
+// Create создает организацию, ErrSlugExists если короткое имя занято
+func (s *OrganizationService) Create(ctx context.Context, org domain.Organization) (*domain.Organization, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	err := s.db.Db.QueryRowContext(ctx, `INSERT INTO organizations (slug, name, allow_signup, allow_login) VALUES ($1, $2, $3, $4) RETURNING id, created_at`, org.Slug, org.Name, org.AllowSignup, org.AllowLogin).Scan(&org.Id, &org.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == NOT_UNIQUE_LOGIN {
+			return nil, domain.ErrSlugExists
+		}
+		logger.Logger.Error(fmt.Sprintf("Creating organization error: %v", err))
+		return nil, fmt.Errorf("creating postgres organization error: %v", err)
+	}
+
+	logger.Logger.Info(fmt.Sprintf("Organization %d (%s) has been created by %s", org.Id, org.Slug, actorName(domain.ActorFrom(ctx))))
+	return &org, nil
+}
+
+// List возвращает все организации
+func (s *OrganizationService) List(ctx context.Context) ([]domain.Organization, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	rows, err := s.db.Db.QueryContext(ctx, `SELECT id, slug, name, created_at, allow_signup, allow_login FROM organizations ORDER BY id`)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting organizations error: %v", err))
+		return nil, fmt.Errorf("getting postgres organizations error: %v", err)
+	}
+	defer rows.Close()
+
+	orgs := []domain.Organization{}
+	for rows.Next() {
+		var org domain.Organization
+		err = rows.Scan(&org.Id, &org.Slug, &org.Name, &org.CreatedAt, &org.AllowSignup, &org.AllowLogin)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning organization error: %v", err))
+			return nil, fmt.Errorf("scanning postgres organization error: %v", err)
+		}
+		orgs = append(orgs, org)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting organizations error: %v", err))
+		return nil, fmt.Errorf("getting postgres organizations error: %v", err)
+	}
+
+	return orgs, nil
+}
+
+// Exists сообщает, что организация существует
+func (s *OrganizationService) Exists(ctx context.Context, id domain.Id) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var exists bool
+	err := s.db.Db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM organizations WHERE id = $1)`, id).Scan(&exists)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting organization error: %v", err))
+		return false, fmt.Errorf("getting postgres organization error: %v", err)
+	}
+
+	return exists, nil
+}
+
+// Get возвращает организацию, nil если ее нет
+func (s *OrganizationService) Get(ctx context.Context, id domain.Id) (*domain.Organization, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var org domain.Organization
+	err := s.db.Db.QueryRowContext(ctx, `SELECT id, slug, name, created_at, allow_signup, allow_login FROM organizations WHERE id = $1`, id).Scan(&org.Id, &org.Slug, &org.Name, &org.CreatedAt, &org.AllowSignup, &org.AllowLogin)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting organization error: %v", err))
+		return nil, fmt.Errorf("getting postgres organization error: %v", err)
+	}
+
+	return &org, nil
+}
+
+// SetPolicy задает политику организации для анонимных запросов, nil если организации нет
+func (s *OrganizationService) SetPolicy(ctx context.Context, id domain.Id, allowSignup, allowLogin bool) (*domain.Organization, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var org domain.Organization
+	err := s.db.Db.QueryRowContext(ctx, `UPDATE organizations SET allow_signup = $2, allow_login = $3 WHERE id = $1 RETURNING id, slug, name, created_at, allow_signup, allow_login`, id, allowSignup, allowLogin).Scan(&org.Id, &org.Slug, &org.Name, &org.CreatedAt, &org.AllowSignup, &org.AllowLogin)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Setting organization policy error: %v", err))
+		return nil, fmt.Errorf("setting postgres organization policy error: %v", err)
+	}
+
+	logger.Logger.Info(fmt.Sprintf("Policy of organization %d has been set to signup %t, login %t by %s", id, allowSignup, allowLogin, actorName(domain.ActorFrom(ctx))))
+	return &org, nil
+}
+
+// Members возвращает участников организации, nil если организации нет
+// Пользователи самой организации в участники не входят
+func (s *OrganizationService) Members(ctx context.Context, id domain.Id) ([]domain.Membership, error) {
+	exists, err := s.Exists(ctx, id)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	rows, err := s.db.Db.QueryContext(ctx, `SELECT organization_id, user_id, created_at FROM memberships WHERE organization_id = $1 ORDER BY user_id`, id)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting organization members error: %v", err))
+		return nil, fmt.Errorf("getting postgres organization members error: %v", err)
+	}
+	defer rows.Close()
+
+	members := []domain.Membership{}
+	for rows.Next() {
+		var member domain.Membership
+		err = rows.Scan(&member.OrganizationId, &member.UserId, &member.CreatedAt)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning organization member error: %v", err))
+			return nil, fmt.Errorf("scanning postgres organization member error: %v", err)
+		}
+		members = append(members, member)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting organization members error: %v", err))
+		return nil, fmt.Errorf("getting postgres organization members error: %v", err)
+	}
+
+	return members, nil
+}
+
+// AddMember дает активному пользователю любой организации доступ к организации id
+// Повторное добавление ничего не меняет, false если организации или пользователя нет
+func (s *OrganizationService) AddMember(ctx context.Context, id, userId domain.Id) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	tx, err := s.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Beginning transaction error: %v", err))
+		return false, fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM organizations WHERE id = $1) AND EXISTS (SELECT 1 FROM users WHERE id = $2 AND deleted_at IS NULL)`, id, userId).Scan(&exists)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting organization member error: %v", err))
+		return false, fmt.Errorf("getting postgres organization member error: %v", err)
+	}
+
+	if !exists {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO memberships (organization_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, id, userId)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Adding organization member error: %v", err))
+		return false, fmt.Errorf("adding postgres organization member error: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Committing transaction error: %v", err))
+		return false, fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	logger.Logger.Info(fmt.Sprintf("User %d has been added to organization %d by %s", userId, id, actorName(domain.ActorFrom(ctx))))
+	return true, nil
+}
+
+// RemoveMember забирает у пользователя доступ к организации, false если он не участник
+func (s *OrganizationService) RemoveMember(ctx context.Context, id, userId domain.Id) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	res, err := s.db.Db.ExecContext(ctx, `DELETE FROM memberships WHERE organization_id = $1 AND user_id = $2`, id, userId)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Removing organization member error: %v", err))
+		return false, fmt.Errorf("removing postgres organization member error: %v", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("removing postgres organization member error: %v", err)
+	}
+
+	if n > 0 {
+		logger.Logger.Info(fmt.Sprintf("User %d has been removed from organization %d by %s", userId, id, actorName(domain.ActorFrom(ctx))))
+	}
+	return n > 0, nil
+}
+
+// RotateScimToken выдает организации новый токен SCIM, прежний перестает действовать
+// Хранится только хэш токена, пустая строка если организации нет
+func (s *OrganizationService) RotateScimToken(ctx context.Context, id domain.Id) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	res, err := s.db.Db.ExecContext(ctx, `UPDATE organizations SET scim_token_hash = $2 WHERE id = $1`, id, hashToken(token))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Rotating scim token error: %v", err))
+		return "", fmt.Errorf("rotating postgres scim token error: %v", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return "", fmt.Errorf("rotating postgres scim token error: %v", err)
+	}
+
+	if n == 0 {
+		return "", nil
+	}
+
+	logger.Logger.Info(fmt.Sprintf("SCIM token of organization %d has been rotated by %s", id, actorName(domain.ActorFrom(ctx))))
+	return token, nil
+}
+
+// RevokeScimToken отключает SCIM организации, false если организации нет
+func (s *OrganizationService) RevokeScimToken(ctx context.Context, id domain.Id) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	res, err := s.db.Db.ExecContext(ctx, `UPDATE organizations SET scim_token_hash = NULL WHERE id = $1`, id)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Revoking scim token error: %v", err))
+		return false, fmt.Errorf("revoking postgres scim token error: %v", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("revoking postgres scim token error: %v", err)
+	}
+
+	if n > 0 {
+		logger.Logger.Info(fmt.Sprintf("SCIM token of organization %d has been revoked by %s", id, actorName(domain.ActorFrom(ctx))))
+	}
+	return n > 0, nil
+}
+
+// ScimTenant возвращает организацию, которой выдан токен SCIM, nil если токен неверный
+func (s *OrganizationService) ScimTenant(ctx context.Context, token string) (*domain.Id, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var id domain.Id
+	err := s.db.Db.QueryRowContext(ctx, `SELECT id FROM organizations WHERE scim_token_hash = $1`, hashToken(token)).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting scim token error: %v", err))
+		return nil, fmt.Errorf("getting postgres scim token error: %v", err)
+	}
+
+	return &id, nil
+}
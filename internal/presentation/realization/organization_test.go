@@ -0,0 +1,119 @@
+package realization
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тест создания организации с занятым коротким именем
+func TestOrganizationCreate_SlugExists(t *testing.T) {
+	users, mock, _ := newMockService(t)
+	s := NewOrganizationService(users.db)
+
+	mock.ExpectQuery(`INSERT INTO organizations`).
+		WithArgs("acme", "Acme", false, false).
+		WillReturnError(&pq.Error{Code: NOT_UNIQUE_LOGIN})
+
+	org, err := s.Create(context.Background(), domain.Organization{Slug: "acme", Name: "Acme"})
+	assert.ErrorIs(t, err, domain.ErrSlugExists)
+	assert.Nil(t, org)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест политики организации для анонимных запросов
+func TestOrganizationPolicy(t *testing.T) {
+	users, mock, _ := newMockService(t)
+	s := NewOrganizationService(users.db)
+
+	columns := []string{"id", "slug", "name", "created_at", "allow_signup", "allow_login"}
+	mock.ExpectQuery(`UPDATE organizations SET allow_signup = \$2, allow_login = \$3 WHERE id = \$1 RETURNING`).
+		WithArgs(domain.Id(2), true, false).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(2, "acme", "Acme", time.Now(), true, false))
+	org, err := s.SetPolicy(context.Background(), 2, true, false)
+	require.NoError(t, err)
+	require.NotNil(t, org)
+	assert.True(t, org.Allows(domain.POLICY_SIGNUP))
+	assert.False(t, org.Allows(domain.POLICY_LOGIN))
+
+	mock.ExpectQuery(`UPDATE organizations SET allow_signup`).
+		WithArgs(domain.Id(5), true, true).
+		WillReturnRows(sqlmock.NewRows(columns))
+	org, err = s.SetPolicy(context.Background(), 5, true, true)
+	require.NoError(t, err)
+	assert.Nil(t, org)
+
+	mock.ExpectQuery(`SELECT id, slug, name, created_at, allow_signup, allow_login FROM organizations WHERE id = \$1`).
+		WithArgs(domain.Id(3)).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(3, "beta", "Beta", time.Now(), false, false))
+	org, err = s.Get(context.Background(), 3)
+	require.NoError(t, err)
+	require.NotNil(t, org)
+	assert.False(t, org.Allows(domain.POLICY_SIGNUP))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест добавления участника: без организации или пользователя членство не создается
+func TestAddMember_NotFound(t *testing.T) {
+	users, mock, _ := newMockService(t)
+	s := NewOrganizationService(users.db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM organizations WHERE id = \$1\) AND EXISTS \(SELECT 1 FROM users WHERE id = \$2 AND deleted_at IS NULL\)`).
+		WithArgs(domain.Id(2), domain.Id(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectRollback()
+
+	ok, err := s.AddMember(context.Background(), 2, 7)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест токена SCIM: хранится только хэш, по токену находится его организация
+func TestScimToken(t *testing.T) {
+	users, mock, _ := newMockService(t)
+	s := NewOrganizationService(users.db)
+
+	var hash string
+	mock.ExpectExec(`UPDATE organizations SET scim_token_hash = \$2 WHERE id = \$1`).
+		WithArgs(domain.Id(2), matcherFunc(func(v any) bool {
+			hash, _ = v.(string)
+			return len(hash) == 64
+		})).
+		WillReturnResult(driver.RowsAffected(1))
+	token, err := s.RotateScimToken(context.Background(), 2)
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+	assert.Equal(t, hashToken(token), hash)
+
+	mock.ExpectQuery(`SELECT id FROM organizations WHERE scim_token_hash = \$1`).
+		WithArgs(hashToken(token)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	tenant, err := s.ScimTenant(context.Background(), token)
+	require.NoError(t, err)
+	require.NotNil(t, tenant)
+	assert.Equal(t, domain.Id(2), *tenant)
+
+	mock.ExpectQuery(`SELECT id FROM organizations WHERE scim_token_hash = \$1`).
+		WithArgs(hashToken("other")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	tenant, err = s.ScimTenant(context.Background(), "other")
+	require.NoError(t, err)
+	assert.Nil(t, tenant)
+
+	// Токен нельзя выдать несуществующей организации
+	mock.ExpectExec(`UPDATE organizations SET scim_token_hash`).WillReturnResult(driver.RowsAffected(0))
+	token, err = s.RotateScimToken(context.Background(), 9)
+	require.NoError(t, err)
+	assert.Empty(t, token)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
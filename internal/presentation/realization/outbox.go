@@ -0,0 +1,253 @@
+package realization
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+	"user/internal/domain"
+	"user/internal/interfaces"
+	"user/internal/presentation/db"
+	"user/internal/presentation/logger"
+)
+
+// writeEvent добавляет событие в outbox в рамках транзакции изменения
+func writeEvent(ctx context.Context, tx *sql.Tx, eventType string, payload domain.UserEventPayload) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("event payload marshalling error: %v", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO outbox (event_type, tenant_id, user_id, payload, request_id) VALUES ($1, $2, $3, $4, $5)`, eventType, domain.TenantFrom(ctx), payload.Id, data, domain.ActorFrom(ctx).RequestId)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Writing outbox event error: %v", err))
+		return fmt.Errorf("writing postgres outbox event error: %v", err)
+	}
+
+	return nil
+}
+
+// eventPayload возвращает данные события по пользователю
+// changed - изменения из журнала, их ключи попадают в список измененных полей
+func eventPayload(user *domain.User, changed map[string]domain.FieldChange) domain.UserEventPayload {
+	payload := domain.UserEventPayload{
+		Id:        user.Id,
+		Version:   user.Version,
+		DeletedAt: user.DeletedAt,
+	}
+
+	for field := range changed {
+		payload.Changed = append(payload.Changed, field)
+	}
+	sort.Strings(payload.Changed)
+
+	return payload
+}
+
+// OutboxRelay доставляет события из outbox через EventPublisher
+// Событие отмечается доставленным только после успешной публикации,
+// неудачные попытки повторяются с экспоненциальной задержкой
+type OutboxRelay struct {
+	db         *db.DB
+	publisher  interfaces.EventPublisher
+	interval   time.Duration
+	batchSize  int
+	timeout    time.Duration
+	lease      time.Duration
+	retention  time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// NewOutboxRelay создает новый экземпляр OutboxRelay
+// interval - период опроса outbox
+// batchSize - количество событий, забираемых за один проход
+// timeout - ограничение времени публикации одного события
+// retention - сколько хранятся доставленные события
+func NewOutboxRelay(db *db.DB, publisher interfaces.EventPublisher, interval time.Duration, batchSize int, timeout, retention time.Duration) *OutboxRelay {
+	return &OutboxRelay{
+		db:        db,
+		publisher: publisher,
+		interval:  interval,
+		batchSize: batchSize,
+		timeout:   timeout,
+		// Аренда покрывает публикацию всей пачки, даже если каждое событие отправляется по таймауту
+		lease:      timeout*time.Duration(batchSize) + time.Minute,
+		retention:  retention,
+		minBackoff: time.Second,
+		maxBackoff: time.Hour,
+	}
+}
+
+// Run опрашивает outbox до отмены контекста
+func (r *OutboxRelay) Run(ctx context.Context) {
+	logger.Logger.Info("Outbox relay has been started")
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	var swept time.Time
+	for {
+		if time.Since(swept) >= OUTBOX_SWEEP_INTERVAL {
+			n, err := r.Sweep(ctx)
+			if err != nil {
+				logger.Logger.Error(fmt.Sprintf("Sweeping outbox error: %v", err))
+			} else if n > 0 {
+				logger.Logger.Info(fmt.Sprintf("%d published outbox events have been removed", n))
+			}
+			swept = time.Now()
+		}
+
+		for {
+			n, err := r.RelayBatch(ctx)
+			if err != nil {
+				logger.Logger.Error(fmt.Sprintf("Relaying outbox error: %v", err))
+			}
+
+			// Полная пачка означает, что в outbox могут остаться события
+			if err != nil || n < r.batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Logger.Info("Outbox relay has been stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// OUTBOX_SWEEP_INTERVAL - как часто удаляются устаревшие доставленные события
+const OUTBOX_SWEEP_INTERVAL = time.Hour
+
+// RelayBatch доставляет одну пачку готовых к отправке событий и возвращает их количество
+// События захватываются одним запросом: next_attempt_at сдвигается на время аренды,
+// если экземпляр сервиса упадет во время публикации, событие снова станет готовым к отправке по ее истечении,
+// поэтому несколько экземпляров сервиса не мешают друг другу, а строки не остаются заблокированными на время публикации
+// Результаты публикации записываются второй короткой транзакцией
+func (r *OutboxRelay) RelayBatch(ctx context.Context) (int, error) {
+	batch, err := r.claim(ctx)
+	if err != nil || len(batch) == 0 {
+		return 0, err
+	}
+
+	results := make([]error, len(batch))
+	for i, p := range batch {
+		results[i] = r.publish(ctx, p.event)
+		if results[i] != nil {
+			logger.Logger.Warn(fmt.Sprintf("Publishing event %d error: %v", p.event.Id, results[i]))
+		}
+	}
+
+	tx, err := r.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	for i, p := range batch {
+		if results[i] == nil {
+			_, err = tx.ExecContext(ctx, `UPDATE outbox SET published_at = NOW(), last_error = NULL WHERE id = $1`, p.event.Id)
+			if err != nil {
+				return 0, fmt.Errorf("marking postgres outbox event error: %v", err)
+			}
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE outbox SET attempts = attempts + 1, next_attempt_at = $2, last_error = $3 WHERE id = $1`, p.event.Id, time.Now().Add(backoff(p.attempts, r.minBackoff, r.maxBackoff)), results[i].Error())
+		if err != nil {
+			return 0, fmt.Errorf("rescheduling postgres outbox event error: %v", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	return len(batch), nil
+}
+
+// publish публикует одно событие, ограничивая его отправку таймаутом
+func (r *OutboxRelay) publish(ctx context.Context, event domain.Event) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	return r.publisher.Publish(ctx, event)
+}
+
+// Sweep удаляет доставленные события старше retention и возвращает их количество
+// Строки удаляются пачками, чтобы не держать долгую блокировку таблицы
+func (r *OutboxRelay) Sweep(ctx context.Context) (int64, error) {
+	var total int64
+	for {
+		res, err := r.db.Db.ExecContext(ctx, `DELETE FROM outbox WHERE id IN (SELECT id FROM outbox WHERE published_at < $1 ORDER BY id LIMIT $2)`, time.Now().Add(-r.retention), r.batchSize)
+		if err != nil {
+			return total, fmt.Errorf("sweeping postgres outbox error: %v", err)
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, fmt.Errorf("sweeping postgres outbox error: %v", err)
+		}
+
+		total += n
+		if n < int64(r.batchSize) {
+			return total, nil
+		}
+	}
+}
+
+// pendingEvent - захваченное событие outbox с числом прошлых попыток
+type pendingEvent struct {
+	event    domain.Event
+	attempts int
+}
+
+// claim захватывает пачку готовых к отправке событий на время аренды
+// Строки блокируются с SKIP LOCKED только на время захвата
+func (r *OutboxRelay) claim(ctx context.Context) ([]pendingEvent, error) {
+	rows, err := r.db.Db.QueryContext(ctx, `WITH claimed AS (SELECT id FROM outbox WHERE published_at IS NULL AND next_attempt_at <= NOW() ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED) UPDATE outbox o SET next_attempt_at = $2 FROM claimed WHERE o.id = claimed.id RETURNING o.id, o.event_type, o.tenant_id, o.user_id, o.payload, o.request_id, o.created_at, o.attempts`, r.batchSize, time.Now().Add(r.lease))
+	if err != nil {
+		return nil, fmt.Errorf("claiming postgres outbox events error: %v", err)
+	}
+	defer rows.Close()
+
+	batch := []pendingEvent{}
+	for rows.Next() {
+		var p pendingEvent
+		err = rows.Scan(&p.event.Id, &p.event.Type, &p.event.TenantId, &p.event.UserId, &p.event.Payload, &p.event.RequestId, &p.event.OccurredAt, &p.attempts)
+		if err != nil {
+			return nil, fmt.Errorf("scanning postgres outbox event error: %v", err)
+		}
+		batch = append(batch, p)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("claiming postgres outbox events error: %v", err)
+	}
+
+	// RETURNING не сохраняет порядок выборки, а события публикуются в порядке записи
+	sort.Slice(batch, func(i, j int) bool {
+		return batch[i].event.Id < batch[j].event.Id
+	})
+
+	return batch, nil
+}
+
+// backoff возвращает задержку перед следующей попыткой: minDelay * 2^attempts, но не больше maxDelay
+func backoff(attempts int, minDelay, maxDelay time.Duration) time.Duration {
+	delay := minDelay
+	for i := 0; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxDelay {
+			return maxDelay
+		}
+	}
+
+	return delay
+}
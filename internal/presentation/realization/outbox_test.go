@@ -0,0 +1,115 @@
+package realization
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тест экспоненциальной задержки повторов
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, backoff(0, time.Second, time.Hour))
+	assert.Equal(t, 8*time.Second, backoff(3, time.Second, time.Hour))
+	assert.Equal(t, time.Hour, backoff(20, time.Second, time.Hour))
+	assert.Equal(t, time.Hour, backoff(1000, time.Second, time.Hour))
+}
+
+// Тест данных события пользователя
+func TestEventPayload(t *testing.T) {
+	user := domain.User{Id: 3, FirstName: "John", Login: "john@example.com", Password: "hash", Version: 2}
+
+	payload := eventPayload(&user, map[string]domain.FieldChange{
+		"surname": {Old: "Doe", New: "Smith"},
+		"email":   {Old: "a@example.com", New: "john@example.com"},
+	})
+	assert.Equal(t, domain.Id(3), payload.Id)
+	assert.Equal(t, uint64(2), payload.Version)
+	assert.Equal(t, []string{"email", "surname"}, payload.Changed)
+
+	// Персональные данные в событие не попадают
+	data, err := json.Marshal(payload)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "John")
+	assert.NotContains(t, string(data), "john@example.com")
+}
+
+// selectivePublisher отклоняет события с указанными идентификаторами
+type selectivePublisher struct {
+	*MemoryPublisher
+	fail map[domain.Id]bool
+}
+
+func (p selectivePublisher) Publish(ctx context.Context, event domain.Event) error {
+	if p.fail[event.Id] {
+		return errors.New("broker is unavailable")
+	}
+	return p.MemoryPublisher.Publish(ctx, event)
+}
+
+// Тест пачки outbox: события захватываются арендой до публикации, результаты записываются отдельной транзакцией
+func TestRelayBatch(t *testing.T) {
+	users, mock, _ := newMockService(t)
+	publisher := selectivePublisher{MemoryPublisher: NewMemoryPublisher(), fail: map[domain.Id]bool{4: true}}
+	relay := NewOutboxRelay(users.db, publisher, time.Second, 10, time.Second, time.Hour)
+
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`WITH claimed AS \(SELECT id FROM outbox WHERE published_at IS NULL AND next_attempt_at <= NOW\(\) ORDER BY id LIMIT \$1 FOR UPDATE SKIP LOCKED\) UPDATE outbox o SET next_attempt_at = \$2`).
+		WithArgs(10, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event_type", "tenant_id", "user_id", "payload", "request_id", "created_at", "attempts"}).
+			AddRow(4, domain.EVENT_USER_UPDATED, 1, 3, []byte(`{}`), "", created, 2).
+			AddRow(2, domain.EVENT_USER_CREATED, 1, 3, []byte(`{}`), "", created, 0))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE outbox SET published_at = NOW\(\)`).WithArgs(domain.Id(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE outbox SET attempts = attempts \+ 1`).WithArgs(domain.Id(4), sqlmock.AnyArg(), "broker is unavailable").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	n, err := relay.RelayBatch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	require.Len(t, publisher.Events(), 1)
+	assert.Equal(t, domain.Id(2), publisher.Events()[0].Id)
+
+	// Пустая пачка не открывает транзакцию
+	mock.ExpectQuery(`WITH claimed AS`).WillReturnRows(sqlmock.NewRows([]string{"id", "event_type", "tenant_id", "user_id", "payload", "request_id", "created_at", "attempts"}))
+	n, err = relay.RelayBatch(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест аренды outbox: захват покрывает публикацию всей пачки по таймауту
+func TestRelayLease(t *testing.T) {
+	users, _, _ := newMockService(t)
+	relay := NewOutboxRelay(users.db, NewMemoryPublisher(), time.Second, 100, 10*time.Second, time.Hour)
+	assert.Equal(t, 1000*time.Second+time.Minute, relay.lease)
+}
+
+// Тест удаления доставленных событий пачками
+func TestSweep(t *testing.T) {
+	users, mock, _ := newMockService(t)
+	relay := NewOutboxRelay(users.db, NewMemoryPublisher(), time.Second, 2, time.Second, time.Hour)
+
+	before := time.Now().Add(-time.Hour)
+	olderThanRetention := matcherFunc(func(v any) bool {
+		at, ok := v.(time.Time)
+		return ok && !at.After(before.Add(time.Second)) && at.After(before.Add(-time.Minute))
+	})
+	mock.ExpectExec(`DELETE FROM outbox WHERE id IN \(SELECT id FROM outbox WHERE published_at < \$1 ORDER BY id LIMIT \$2\)`).
+		WithArgs(olderThanRetention, 2).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM outbox`).WithArgs(olderThanRetention, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	n, err := relay.Sweep(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,441 @@
+package realization
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"user/internal/domain"
+	"user/internal/interfaces"
+	"user/internal/presentation/db"
+	"user/internal/presentation/logger"
+	"user/internal/presentation/webauthn"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// PASSKEY_TIMEOUT - время на церемонию WebAuthn
+	PASSKEY_TIMEOUT = 5 * time.Minute
+
+	CHALLENGE_REGISTRATION = "registration"
+	CHALLENGE_LOGIN        = "login"
+)
+
+// PasskeyService регистрирует ключи WebAuthn пользователей и открывает сессии по ним
+// Challenge церемонии хранится хэшем в webauthn_challenges и используется один раз,
+// счетчик подписей ключа должен расти с каждым входом, иначе ключ считается скопированным
+type PasskeyService struct {
+	db     *db.DB
+	cipher interfaces.FieldCipher
+	auth   *AuthService
+	rp     webauthn.RelyingParty
+}
+
+// NewPasskeyService создает новый экземпляр PasskeyService
+// cipher - шифрование персональных данных, email и имя показываются в аутентификаторе
+// auth - открытие сессий после входа
+// rp - домен и допустимые источники страниц
+func NewPasskeyService(db *db.DB, cipher interfaces.FieldCipher, auth *AuthService, rp webauthn.RelyingParty) *PasskeyService {
+	return &PasskeyService{
+		db:     db,
+		cipher: cipher,
+		auth:   auth,
+		rp:     rp,
+	}
+}
+
+// BeginRegistration выдает параметры регистрации ключа активному пользователю организации
+// Уже зарегистрированные ключи пользователя передаются в excludeCredentials
+// Возвращает nil, если пользователя нет
+func (s *PasskeyService) BeginRegistration(ctx context.Context, id domain.Id) (*domain.PasskeyCreationOptions, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var row userRow
+	err := s.db.Db.QueryRowContext(ctx, `SELECT first_name, last_name, login FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`, id, domain.TenantFrom(ctx)).Scan(&row.firstName, &row.lastName, &row.login)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting user to register passkey error: %v", err))
+		return nil, fmt.Errorf("getting postgres user to register passkey error: %v", err)
+	}
+
+	login, err := s.cipher.Decrypt(row.login)
+	if err != nil {
+		return nil, err
+	}
+
+	firstName, err := s.cipher.Decrypt(row.firstName)
+	if err != nil {
+		return nil, err
+	}
+
+	lastName, err := s.cipher.Decrypt(row.lastName)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Db.QueryContext(ctx, `SELECT credential_id FROM webauthn_credentials WHERE user_id = $1 ORDER BY id`, id)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting passkeys error: %v", err))
+		return nil, fmt.Errorf("getting postgres passkeys error: %v", err)
+	}
+	defer rows.Close()
+
+	exclude := []domain.PasskeyDescriptor{}
+	for rows.Next() {
+		var credentialId []byte
+		err = rows.Scan(&credentialId)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning passkey error: %v", err))
+			return nil, fmt.Errorf("scanning postgres passkey error: %v", err)
+		}
+		exclude = append(exclude, domain.PasskeyDescriptor{Type: "public-key", Id: credentialId})
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting passkeys error: %v", err))
+		return nil, fmt.Errorf("getting postgres passkeys error: %v", err)
+	}
+
+	challenge, err := s.challenge(ctx, CHALLENGE_REGISTRATION, &id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.PasskeyCreationOptions{
+		Challenge:    challenge,
+		RelyingParty: domain.PasskeyRelyingParty{Id: s.rp.Id, Name: s.rp.Name},
+		User: domain.PasskeyUser{
+			Id:          userHandle(id),
+			Name:        login,
+			DisplayName: strings.TrimSpace(firstName + " " + lastName),
+		},
+		PubKeyCredParams: []domain.PasskeyCredentialParam{
+			{Type: "public-key", Alg: webauthn.ALG_ES256},
+			{Type: "public-key", Alg: webauthn.ALG_EDDSA},
+			{Type: "public-key", Alg: webauthn.ALG_RS256},
+		},
+		Timeout:            PASSKEY_TIMEOUT.Milliseconds(),
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: map[string]string{
+			"residentKey":      "required",
+			"userVerification": "required",
+		},
+		Attestation: "none",
+	}, nil
+}
+
+// FinishRegistration проверяет ответ аутентификатора на challenge пользователя и сохраняет ключ
+// Регистрация записывается в журнал
+// Возвращает ErrInvalidPasskey, если ответ не прошел проверку или ключ уже зарегистрирован
+func (s *PasskeyService) FinishRegistration(ctx context.Context, id domain.Id, name string, attestation domain.PasskeyAttestation) (*domain.Passkey, error) {
+	challenge, err := s.clientChallenge(attestation.Response.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := s.rp.VerifyRegistration(challenge, attestation.Response.ClientDataJSON, attestation.Response.AttestationObject)
+	if err != nil {
+		logger.Logger.Warn(fmt.Sprintf("Passkey registration of user %d rejected: %v", id, err))
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidPasskey, err)
+	}
+
+	if !bytes.Equal(credential.Id, attestation.RawId) {
+		return nil, fmt.Errorf("%w: credential id mismatch", domain.ErrInvalidPasskey)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	tx, err := s.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Beginning transaction error: %v", err))
+		return nil, fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	ok, err := s.useChallenge(ctx, tx, challenge, CHALLENGE_REGISTRATION, &id, domain.TenantFrom(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown or expired challenge", domain.ErrInvalidPasskey)
+	}
+
+	passkey := &domain.Passkey{
+		Name:      name,
+		SignCount: credential.SignCount,
+	}
+	err = tx.QueryRowContext(ctx, `INSERT INTO webauthn_credentials (user_id, credential_id, public_key, sign_count, aaguid, name) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`, id, credential.Id, credential.PublicKey, credential.SignCount, credential.Aaguid, name).Scan(&passkey.Id, &passkey.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == NOT_UNIQUE_LOGIN {
+			return nil, fmt.Errorf("%w: passkey already registered", domain.ErrInvalidPasskey)
+		}
+		logger.Logger.Error(fmt.Sprintf("Creating passkey error: %v", err))
+		return nil, fmt.Errorf("creating postgres passkey error: %v", err)
+	}
+
+	err = writeAudit(ctx, tx, id, domain.AUDIT_PASSKEY, map[string]domain.FieldChange{
+		"passkey": {Old: nil, New: name},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Committing transaction error: %v", err))
+		return nil, fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	logger.Logger.Info(fmt.Sprintf("Passkey %d of user %d has been registered by %s", passkey.Id, id, actorName(domain.ActorFrom(ctx))))
+	return passkey, nil
+}
+
+// List возвращает ключи пользователя организации в порядке регистрации
+func (s *PasskeyService) List(ctx context.Context, id domain.Id) ([]domain.Passkey, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	rows, err := s.db.Db.QueryContext(ctx, `SELECT c.id, c.name, c.sign_count, c.created_at, c.last_used_at FROM webauthn_credentials c JOIN users u ON u.id = c.user_id WHERE c.user_id = $1 AND u.tenant_id = $2 ORDER BY c.id`, id, domain.TenantFrom(ctx))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting passkeys error: %v", err))
+		return nil, fmt.Errorf("getting postgres passkeys error: %v", err)
+	}
+	defer rows.Close()
+
+	passkeys := []domain.Passkey{}
+	for rows.Next() {
+		var passkey domain.Passkey
+		err = rows.Scan(&passkey.Id, &passkey.Name, &passkey.SignCount, &passkey.CreatedAt, &passkey.LastUsedAt)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning passkey error: %v", err))
+			return nil, fmt.Errorf("scanning postgres passkey error: %v", err)
+		}
+		passkeys = append(passkeys, passkey)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting passkeys error: %v", err))
+		return nil, fmt.Errorf("getting postgres passkeys error: %v", err)
+	}
+
+	return passkeys, nil
+}
+
+// Revoke удаляет ключ пользователя организации и записывает удаление в журнал
+// Возвращает false, если у пользователя нет такого ключа
+func (s *PasskeyService) Revoke(ctx context.Context, id, passkey domain.Id) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	tx, err := s.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Beginning transaction error: %v", err))
+		return false, fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	var name string
+	err = tx.QueryRowContext(ctx, `DELETE FROM webauthn_credentials c USING users u WHERE u.id = c.user_id AND c.id = $1 AND c.user_id = $2 AND u.tenant_id = $3 RETURNING c.name`, passkey, id, domain.TenantFrom(ctx)).Scan(&name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Deleting passkey error: %v", err))
+		return false, fmt.Errorf("deleting postgres passkey error: %v", err)
+	}
+
+	err = writeAudit(ctx, tx, id, domain.AUDIT_PASSKEY, map[string]domain.FieldChange{
+		"passkey": {Old: name, New: nil},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Committing transaction error: %v", err))
+		return false, fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	logger.Logger.Info(fmt.Sprintf("Passkey %d of user %d has been revoked by %s", passkey, id, actorName(domain.ActorFrom(ctx))))
+	return true, nil
+}
+
+// BeginLogin выдает параметры входа по ключу в организации запроса
+// Ключи сохранены в аутентификаторе вместе с пользователем, поэтому email не нужен
+func (s *PasskeyService) BeginLogin(ctx context.Context) (*domain.PasskeyRequestOptions, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	challenge, err := s.challenge(ctx, CHALLENGE_LOGIN, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.PasskeyRequestOptions{
+		Challenge:        challenge,
+		RelyingPartyId:   s.rp.Id,
+		Timeout:          PASSKEY_TIMEOUT.Milliseconds(),
+		UserVerification: "required",
+	}, nil
+}
+
+// FinishLogin проверяет подпись аутентификатора и открывает сессию владельца ключа
+// Ключ с подтверждением личности (флаг UV) сам является вторым фактором, поэтому код TOTP после него не запрашивается
+// Ответ без подтверждения личности отклоняется
+// Возвращает nil, если ключ неизвестен, challenge неверный или подпись не прошла проверку,
+// и ErrEmailNotVerified, если вход требует подтвержденного email
+func (s *PasskeyService) FinishLogin(ctx context.Context, assertion domain.PasskeyAssertion) (*domain.Tokens, error) {
+	challenge, err := s.clientChallenge(assertion.Response.ClientDataJSON)
+	if err != nil {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	tx, err := s.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Beginning transaction error: %v", err))
+		return nil, fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		passkeyId domain.Id
+		userId    domain.Id
+		publicKey []byte
+		signCount uint32
+		verified  bool
+	)
+	err = tx.QueryRowContext(ctx, `SELECT c.id, c.user_id, c.public_key, c.sign_count, u.email_verified_at IS NOT NULL FROM webauthn_credentials c JOIN users u ON u.id = c.user_id
+		WHERE c.credential_id = $1 AND u.tenant_id = $2 AND u.deleted_at IS NULL FOR UPDATE OF c`, []byte(assertion.RawId), domain.TenantFrom(ctx)).Scan(&passkeyId, &userId, &publicKey, &signCount, &verified)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting passkey error: %v", err))
+		return nil, fmt.Errorf("getting postgres passkey error: %v", err)
+	}
+
+	if len(assertion.Response.UserHandle) != 0 && !bytes.Equal(assertion.Response.UserHandle, userHandle(userId)) {
+		logger.Logger.Warn(fmt.Sprintf("Passkey %d login rejected: user handle mismatch", passkeyId))
+		return nil, nil
+	}
+
+	count, err := s.rp.VerifyAssertion(challenge, publicKey, signCount, assertion.Response.ClientDataJSON, assertion.Response.AuthenticatorData, assertion.Response.Signature)
+	if err != nil {
+		if errors.Is(err, webauthn.ErrSignCount) {
+			logger.Logger.Warn(fmt.Sprintf("Passkey %d of user %d may be cloned: sign count %d has not increased", passkeyId, userId, signCount))
+			return nil, nil
+		}
+		logger.Logger.Warn(fmt.Sprintf("Passkey %d login rejected: %v", passkeyId, err))
+		return nil, nil
+	}
+
+	ok, err := s.useChallenge(ctx, tx, challenge, CHALLENGE_LOGIN, nil, domain.TenantFrom(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, nil
+	}
+
+	if s.auth.requireVerified && !verified {
+		return nil, domain.ErrEmailNotVerified
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE webauthn_credentials SET sign_count = $2, last_used_at = NOW() WHERE id = $1`, passkeyId, count)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Updating passkey error: %v", err))
+		return nil, fmt.Errorf("updating postgres passkey error: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Committing transaction error: %v", err))
+		return nil, fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	tokens, err := s.auth.openSession(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Logger.Debug(fmt.Sprintf("User %d has been logged in with passkey %d", userId, passkeyId))
+	return tokens, nil
+}
+
+// challenge выдает новый challenge церемонии kind в организации запроса
+func (s *PasskeyService) challenge(ctx context.Context, kind string, userId *domain.Id) ([]byte, error) {
+	challenge := make([]byte, 32)
+	_, err := rand.Read(challenge)
+	if err != nil {
+		return nil, fmt.Errorf("generating passkey challenge error: %v", err)
+	}
+
+	_, err = s.db.Db.ExecContext(ctx, `INSERT INTO webauthn_challenges (challenge_hash, kind, user_id, tenant_id, expires_at) VALUES ($1, $2, $3, $4, $5)`, hashChallenge(challenge), kind, userId, domain.TenantFrom(ctx), time.Now().Add(PASSKEY_TIMEOUT))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Creating passkey challenge error: %v", err))
+		return nil, fmt.Errorf("creating postgres passkey challenge error: %v", err)
+	}
+
+	return challenge, nil
+}
+
+// useChallenge отмечает challenge использованным, false если он неизвестен, просрочен или выдан для другой церемонии
+func (s *PasskeyService) useChallenge(ctx context.Context, tx *sql.Tx, challenge []byte, kind string, userId *domain.Id, tenant domain.Id) (bool, error) {
+	result, err := tx.ExecContext(ctx, `UPDATE webauthn_challenges SET used_at = NOW() WHERE challenge_hash = $1 AND kind = $2 AND user_id IS NOT DISTINCT FROM $3 AND tenant_id = $4 AND used_at IS NULL AND expires_at > NOW()`, hashChallenge(challenge), kind, userId, tenant)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Using passkey challenge error: %v", err))
+		return false, fmt.Errorf("using postgres passkey challenge error: %v", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("using postgres passkey challenge error: %v", err)
+	}
+
+	return n == 1, nil
+}
+
+// clientChallenge возвращает challenge из клиентских данных
+func (s *PasskeyService) clientChallenge(clientDataJSON []byte) ([]byte, error) {
+	clientData, err := webauthn.ParseClientData(clientDataJSON)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidPasskey, err)
+	}
+
+	challenge, err := base64.RawURLEncoding.DecodeString(clientData.Challenge)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid challenge", domain.ErrInvalidPasskey)
+	}
+
+	return challenge, nil
+}
+
+// hashChallenge возвращает хэш challenge, под которым он хранится в базе
+func hashChallenge(challenge []byte) string {
+	return hashToken(base64.RawURLEncoding.EncodeToString(challenge))
+}
+
+// userHandle возвращает идентификатор пользователя, сохраняемый в аутентификаторе
+func userHandle(id domain.Id) []byte {
+	return []byte(strconv.FormatUint(id, 10))
+}
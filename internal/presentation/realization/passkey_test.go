@@ -0,0 +1,123 @@
+package realization
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+	"user/internal/presentation/webauthn"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRp = webauthn.RelyingParty{Id: "example.com", Name: "Example", Origins: []string{"https://example.com"}}
+
+func newTestPasskeys(t *testing.T) (*PasskeyService, sqlmock.Sqlmock) {
+	users, mock, _ := newMockService(t)
+	auth := NewAuthService(users.db, nil, users.cipher, nil, newMemoryActivity(), time.Minute, time.Hour, false)
+
+	return NewPasskeyService(users.db, users.cipher, auth, testRp), mock
+}
+
+// Тест регистрации: ответ аутентификатора на выданный challenge сохраняет ключ и пишет журнал
+func TestPasskeyRegistration(t *testing.T) {
+	s, mock := newTestPasskeys(t)
+	authenticator := webauthn.NewAuthenticator("https://example.com")
+
+	mock.ExpectQuery(`SELECT first_name, last_name, login FROM users WHERE id = \$1 AND tenant_id = \$2`).
+		WithArgs(domain.Id(3), domain.DEFAULT_TENANT).
+		WillReturnRows(sqlmock.NewRows([]string{"first_name", "last_name", "login"}).AddRow(encrypt(t, s.cipher, "John"), encrypt(t, s.cipher, "Doe"), encrypt(t, s.cipher, "john@example.com")))
+	mock.ExpectQuery(`SELECT credential_id FROM webauthn_credentials WHERE user_id = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"credential_id"}).AddRow([]byte{9}))
+	mock.ExpectExec(`INSERT INTO webauthn_challenges`).
+		WithArgs(sqlmock.AnyArg(), CHALLENGE_REGISTRATION, sqlmock.AnyArg(), domain.DEFAULT_TENANT, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	options, err := s.BeginRegistration(context.Background(), 3)
+	require.NoError(t, err)
+	assert.Equal(t, "John Doe", options.User.DisplayName)
+	assert.Equal(t, domain.Base64URL("3"), options.User.Id)
+	assert.Equal(t, []domain.PasskeyDescriptor{{Type: "public-key", Id: []byte{9}}}, options.ExcludeCredentials)
+
+	attestation, err := authenticator.Create(*options)
+	require.NoError(t, err)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE webauthn_challenges SET used_at = NOW\(\) WHERE challenge_hash = \$1 AND kind = \$2 AND user_id IS NOT DISTINCT FROM \$3 AND tenant_id = \$4`).
+		WithArgs(hashChallenge(options.Challenge), CHALLENGE_REGISTRATION, sqlmock.AnyArg(), domain.DEFAULT_TENANT).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO webauthn_credentials`).
+		WithArgs(domain.Id(3), []byte(attestation.RawId), sqlmock.AnyArg(), uint32(1), sqlmock.AnyArg(), "Laptop").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(5, time.Now()))
+	mock.ExpectExec(`INSERT INTO user_audit`).
+		WithArgs(domain.Id(3), domain.DEFAULT_TENANT, nil, "", domain.AUDIT_PASSKEY, []byte(`{"passkey":{"old":null,"new":"Laptop"}}`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	passkey, err := s.FinishRegistration(context.Background(), 3, "Laptop", *attestation)
+	require.NoError(t, err)
+	assert.Equal(t, domain.Id(5), passkey.Id)
+
+	// Ответ с чужого источника отклоняется без обращения к базе
+	authenticator.Origin = "https://evil.com"
+	attestation, err = authenticator.Create(*options)
+	require.NoError(t, err)
+	_, err = s.FinishRegistration(context.Background(), 3, "Laptop", *attestation)
+	assert.ErrorIs(t, err, domain.ErrInvalidPasskey)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест входа: подпись проверяется сохраненным ключом, счетчик должен расти
+func TestPasskeyLogin(t *testing.T) {
+	s, mock := newTestPasskeys(t)
+	authenticator := webauthn.NewAuthenticator("https://example.com")
+
+	attestation, err := authenticator.Create(domain.PasskeyCreationOptions{
+		Challenge:    []byte("register"),
+		RelyingParty: domain.PasskeyRelyingParty{Id: testRp.Id},
+		User:         domain.PasskeyUser{Id: userHandle(3)},
+	})
+	require.NoError(t, err)
+	credential, err := testRp.VerifyRegistration([]byte("register"), attestation.Response.ClientDataJSON, attestation.Response.AttestationObject)
+	require.NoError(t, err)
+
+	login := func(storedCount uint32, expect func()) (*domain.Tokens, error) {
+		assertion, err := authenticator.Get(domain.PasskeyRequestOptions{Challenge: []byte("login"), RelyingPartyId: testRp.Id})
+		require.NoError(t, err)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT c.id, c.user_id, c.public_key, c.sign_count, u.email_verified_at IS NOT NULL FROM webauthn_credentials c JOIN users u`).
+			WithArgs(credential.Id, domain.DEFAULT_TENANT).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "public_key", "sign_count", "verified"}).AddRow(5, 3, credential.PublicKey, storedCount, true))
+		expect()
+		return s.FinishLogin(context.Background(), *assertion)
+	}
+
+	tokens, err := login(1, func() {
+		mock.ExpectExec(`UPDATE webauthn_challenges SET used_at`).
+			WithArgs(hashChallenge([]byte("login")), CHALLENGE_LOGIN, nil, domain.DEFAULT_TENANT).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE webauthn_credentials SET sign_count = \$2, last_used_at = NOW\(\) WHERE id = \$1`).
+			WithArgs(domain.Id(5), uint32(2)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectExec(`INSERT INTO sessions`).WillReturnResult(sqlmock.NewResult(1, 1))
+	})
+	require.NoError(t, err)
+	require.NotNil(t, tokens)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Счетчик не вырос: ключ мог быть скопирован
+	tokens, err = login(10, func() { mock.ExpectRollback() })
+	assert.NoError(t, err)
+	assert.Nil(t, tokens)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Без подтверждения личности ключ не заменяет второй фактор, сессия не открывается
+	authenticator.SkipVerification = true
+	tokens, err = login(2, func() { mock.ExpectRollback() })
+	assert.NoError(t, err)
+	assert.Nil(t, tokens)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,202 @@
+package realization
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"user/internal/domain"
+	"user/internal/interfaces"
+	"user/internal/presentation/db"
+	"user/internal/presentation/logger"
+)
+
+const (
+	// RESET_SUBJECT - тема письма со ссылкой сброса пароля
+	RESET_SUBJECT = "Reset your password"
+
+	// RESET_SEND_TIMEOUT - время на отправку письма сброса после ответа клиенту
+	RESET_SEND_TIMEOUT = time.Second * 30
+)
+
+// PasswordResetService сбрасывает забытые пароли по одноразовым токенам из письма
+// Токен - случайная строка, в базе хранится только его хэш
+type PasswordResetService struct {
+	db     *db.DB
+	cache  interfaces.CacheRepo
+	cipher interfaces.FieldCipher
+	mailer interfaces.Mailer
+	ttl    time.Duration
+	link   string
+
+	// sending - письма, которые еще отправляются
+	sending sync.WaitGroup
+}
+
+// NewPasswordResetService создает новый экземпляр PasswordResetService
+// cache - кэш пользователей, после сброса ключ пользователя удаляется
+// cipher - шифрование персональных данных, email сравнивается по слепому индексу
+// mailer - отправка писем
+// ttl - время жизни токена
+// link - начало ссылки в письме, токен дописывается в конец
+func NewPasswordResetService(db *db.DB, cache interfaces.CacheRepo, cipher interfaces.FieldCipher, mailer interfaces.Mailer, ttl time.Duration, link string) *PasswordResetService {
+	return &PasswordResetService{
+		db:     db,
+		cache:  cache,
+		cipher: cipher,
+		mailer: mailer,
+		ttl:    ttl,
+		link:   link,
+	}
+}
+
+// Forgot выдает токен сброса активному пользователю организации и отправляет его на email
+// Если такого пользователя нет, письмо не отправляется и ошибка не возвращается,
+// чтобы по ответу нельзя было узнать, зарегистрирован ли email
+// Письмо отправляется в фоне: по времени ответа тоже нельзя отличить известный email, ошибки отправки пишутся в лог
+func (s *PasswordResetService) Forgot(ctx context.Context, login string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var id domain.Id
+	err := s.db.Db.QueryRowContext(ctx, `SELECT id FROM users WHERE login_index = $1 AND tenant_id = $2 AND deleted_at IS NULL`, s.cipher.BlindIndex(login), domain.TenantFrom(ctx)).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting user to reset password error: %v", err))
+		return fmt.Errorf("getting postgres user to reset password error: %v", err)
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	expires := time.Now().Add(s.ttl).Truncate(time.Second)
+	_, err = s.db.Db.ExecContext(ctx, `INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`, id, hashToken(token), expires)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Creating password reset error: %v", err))
+		return fmt.Errorf("creating postgres password reset error: %v", err)
+	}
+
+	mail := domain.Mail{
+		To:      login,
+		Subject: RESET_SUBJECT,
+		Body:    fmt.Sprintf("Reset your password by following the link:\n\n%s%s\n\nThe link is valid until %s.\nIf you did not request a password reset, ignore this email.\n", s.link, token, expires.UTC().Format(time.RFC1123)),
+	}
+
+	s.sending.Add(1)
+	go s.send(context.WithoutCancel(ctx), id, mail)
+	return nil
+}
+
+// send отправляет письмо сброса пользователю id
+func (s *PasswordResetService) send(ctx context.Context, id domain.Id, mail domain.Mail) {
+	defer s.sending.Done()
+
+	ctx, cancel := context.WithTimeout(ctx, RESET_SEND_TIMEOUT)
+	defer cancel()
+
+	err := s.mailer.Send(ctx, mail)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Sending password reset email to user %d error: %v", id, err))
+		return
+	}
+
+	logger.Logger.Info(fmt.Sprintf("Password reset email has been sent to user %d", id))
+}
+
+// Wait дожидается отправки писем, начатых до вызова
+func (s *PasswordResetService) Wait() {
+	s.sending.Wait()
+}
+
+// Reset устанавливает пароль с хэшем password по токену, записывает сброс в журнал и событие изменения пользователя
+// Версия пользователя растет, как при любом изменении, ключ кэша удаляется
+// Все сессии пользователя и остальные его токены сброса отзываются
+// Возвращает ErrInvalidToken, если токен неизвестен, просрочен или уже использован
+func (s *PasswordResetService) Reset(ctx context.Context, token, password string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	tx, err := s.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Beginning transaction error: %v", err))
+		return fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	var userId domain.Id
+	err = tx.QueryRowContext(ctx, `UPDATE password_resets SET used_at = NOW() WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW() RETURNING user_id`, hashToken(token)).Scan(&userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.ErrInvalidToken
+		}
+		logger.Logger.Error(fmt.Sprintf("Using password reset error: %v", err))
+		return fmt.Errorf("using postgres password reset error: %v", err)
+	}
+
+	var (
+		row    userRow
+		tenant domain.Id
+	)
+	err = tx.QueryRowContext(ctx, `UPDATE users SET password = $2, version = version + 1 WHERE id = $1 AND deleted_at IS NULL
+		RETURNING id, first_name, last_name, birthday, login, version, created_at, deleted_at, tenant_id`, userId, password).Scan(row.dest(&tenant)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.ErrInvalidToken
+		}
+		logger.Logger.Error(fmt.Sprintf("Resetting user password error: %v", err))
+		return fmt.Errorf("resetting postgres user password error: %v", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE password_resets SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL`, userId)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Revoking password resets error: %v", err))
+		return fmt.Errorf("revoking postgres password resets error: %v", err)
+	}
+
+	sessions, err := tx.ExecContext(ctx, `UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userId)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Revoking sessions error: %v", err))
+		return fmt.Errorf("revoking postgres sessions error: %v", err)
+	}
+
+	user, err := row.decrypt(s.cipher)
+	if err != nil {
+		return err
+	}
+
+	// Сброс приходит без сессии, журнал и событие пишутся в организации пользователя
+	ctx = domain.WithTenant(ctx, tenant)
+	diff := map[string]domain.FieldChange{
+		"password": {Old: domain.REDACTED, New: domain.REDACTED},
+	}
+	err = writeAudit(ctx, tx, userId, domain.AUDIT_PASSWORD_RESET, diff)
+	if err != nil {
+		return err
+	}
+
+	err = writeEvent(ctx, tx, domain.EVENT_USER_UPDATED, eventPayload(user, diff))
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Committing transaction error: %v", err))
+		return fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	err = s.cache.DelKey(tenant, userId)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Deleating Redis key error: %v", err))
+	}
+
+	revoked, _ := sessions.RowsAffected()
+	logger.Logger.Info(fmt.Sprintf("Password of user %d has been reset, %d sessions revoked", userId, revoked))
+	return nil
+}
@@ -0,0 +1,100 @@
+package realization
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// matcherFunc - аргумент sqlmock, проверяемый функцией
+type matcherFunc func(v any) bool
+
+func (f matcherFunc) Match(v driver.Value) bool {
+	return f(v)
+}
+
+func newTestPasswordReset(t *testing.T) (*PasswordResetService, sqlmock.Sqlmock, *MemoryMailer) {
+	users, mock, cache := newMockService(t)
+	mailer := NewMemoryMailer()
+
+	return NewPasswordResetService(users.db, cache, users.cipher, mailer, time.Hour, "https://example.com/reset?token="), mock, mailer
+}
+
+// Тест запроса сброса: неизвестный email не получает письма, известный получает токен, хранящийся хэшем
+func TestForgot(t *testing.T) {
+	s, mock, mailer := newTestPasswordReset(t)
+
+	mock.ExpectQuery(`SELECT id FROM users WHERE login_index = \$1 AND tenant_id = \$2`).
+		WithArgs(s.cipher.BlindIndex("nobody@example.com"), domain.DEFAULT_TENANT).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	require.NoError(t, s.Forgot(context.Background(), "nobody@example.com"))
+	assert.Empty(t, mailer.Mails())
+
+	var stored string
+	mock.ExpectQuery(`SELECT id FROM users WHERE login_index = \$1 AND tenant_id = \$2`).
+		WithArgs(s.cipher.BlindIndex("john@example.com"), domain.DEFAULT_TENANT).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectExec(`INSERT INTO password_resets`).
+		WithArgs(domain.Id(3), matcherFunc(func(v any) bool { stored, _ = v.(string); return true }), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	require.NoError(t, s.Forgot(context.Background(), "john@example.com"))
+
+	s.Wait()
+	sent := mailer.Mails()
+	require.Len(t, sent, 1)
+	assert.Equal(t, RESET_SUBJECT, sent[0].Subject)
+	_, rest, ok := strings.Cut(sent[0].Body, "https://example.com/reset?token=")
+	require.True(t, ok)
+	token, _, _ := strings.Cut(rest, "\n")
+	assert.Equal(t, hashToken(token), stored)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест сброса: пароль и версия меняются, сессии отзываются, сброс пишется в журнал и outbox организации пользователя,
+// ключ кэша удаляется
+func TestReset(t *testing.T) {
+	s, mock, _ := newTestPasswordReset(t)
+	cache := s.cache.(*memoryCache)
+	cache.users[cacheKey(2, 3)] = domain.User{Id: 3, Version: 4}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE password_resets SET used_at = NOW\(\) WHERE token_hash = \$1 AND used_at IS NULL AND expires_at > NOW\(\) RETURNING user_id`).
+		WithArgs(hashToken("token")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(3))
+	mock.ExpectQuery(`UPDATE users SET password = \$2, version = version \+ 1 WHERE id = \$1 AND deleted_at IS NULL`).
+		WithArgs(domain.Id(3), "hash").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "birthday", "login", "version", "created_at", "deleted_at", "tenant_id"}).
+			AddRow(3, encrypt(t, s.cipher, "John"), encrypt(t, s.cipher, ""), nil, encrypt(t, s.cipher, "john@example.com"), 5, time.Now(), nil, 2))
+	mock.ExpectExec(`UPDATE password_resets SET used_at = NOW\(\) WHERE user_id = \$1 AND used_at IS NULL`).
+		WithArgs(domain.Id(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE sessions SET revoked_at = NOW\(\) WHERE user_id = \$1 AND revoked_at IS NULL`).
+		WithArgs(domain.Id(3)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO user_audit`).
+		WithArgs(domain.Id(3), domain.Id(2), nil, "", domain.AUDIT_PASSWORD_RESET, []byte(`{"password":{"old":"[REDACTED]","new":"[REDACTED]"}}`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO outbox`).
+		WithArgs(domain.EVENT_USER_UPDATED, domain.Id(2), domain.Id(3), matcherFunc(func(v any) bool {
+			payload, _ := v.([]byte)
+			return strings.Contains(string(payload), `"version":5`) && strings.Contains(string(payload), `"changed":["password"]`)
+		}), "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, s.Reset(context.Background(), "token", "hash"))
+	assert.NotContains(t, cache.users, cacheKey(2, 3))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE password_resets`).WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectRollback()
+	assert.ErrorIs(t, s.Reset(context.Background(), "token", "hash"), domain.ErrInvalidToken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
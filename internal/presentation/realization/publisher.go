@@ -0,0 +1,167 @@
+package realization
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"user/internal/domain"
+	"user/internal/interfaces"
+)
+
+// MemoryPublisher хранит события в памяти, используется в тестах
+type MemoryPublisher struct {
+	mu     sync.Mutex
+	events []domain.Event
+}
+
+// NewMemoryPublisher создает новый экземпляр MemoryPublisher
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+// Publish сохраняет событие
+func (p *MemoryPublisher) Publish(_ context.Context, event domain.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.events = append(p.events, event)
+	return nil
+}
+
+// Events возвращает копию полученных событий
+func (p *MemoryPublisher) Events() []domain.Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]domain.Event(nil), p.events...)
+}
+
+// WriterPublisher пишет события построчно в формате JSON (NDJSON)
+type WriterPublisher struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterPublisher создает публикатор, пишущий события в w
+func NewWriterPublisher(w io.Writer) *WriterPublisher {
+	return &WriterPublisher{
+		w: w,
+	}
+}
+
+// NewStdoutPublisher создает публикатор, пишущий события в stdout
+func NewStdoutPublisher() *WriterPublisher {
+	return NewWriterPublisher(os.Stdout)
+}
+
+// NewFilePublisher создает публикатор, дописывающий события в файл
+// path - путь к файлу, файл создается при отсутствии
+func NewFilePublisher(path string) (*WriterPublisher, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening events file error: %v", err)
+	}
+
+	return NewWriterPublisher(file), nil
+}
+
+// Publish записывает событие одной строкой
+func (p *WriterPublisher) Publish(_ context.Context, event domain.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("event marshalling error: %v", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, err = p.w.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("writing event error: %v", err)
+	}
+
+	return nil
+}
+
+// HTTPPublisher отправляет события POST запросом на адрес webhook
+type HTTPPublisher struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPPublisher создает новый экземпляр HTTPPublisher
+// url - адрес получателя событий
+// timeout - время ожидания ответа
+func NewHTTPPublisher(url string, timeout time.Duration) *HTTPPublisher {
+	return &HTTPPublisher{
+		url: url,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+// Publish отправляет событие, любой ответ кроме 2xx считается ошибкой
+// Идентификатор события передается в заголовке X-Event-Id для дедупликации
+func (p *HTTPPublisher) Publish(ctx context.Context, event domain.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("event marshalling error: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("creating webhook request error: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Id", strconv.FormatUint(event.Id, 10))
+	req.Header.Set("X-Event-Type", event.Type)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending webhook error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// MultiPublisher публикует событие во все публикаторы по очереди
+// Ошибка любого из них приводит к повтору события целиком,
+// поэтому публикаторы должны быть идемпотентны по идентификатору события
+type MultiPublisher struct {
+	publishers []interfaces.EventPublisher
+}
+
+// NewMultiPublisher создает новый экземпляр MultiPublisher
+func NewMultiPublisher(publishers ...interfaces.EventPublisher) *MultiPublisher {
+	return &MultiPublisher{
+		publishers: publishers,
+	}
+}
+
+// Publish публикует событие во все публикаторы и объединяет их ошибки
+func (p *MultiPublisher) Publish(ctx context.Context, event domain.Event) error {
+	var errs []error
+	for _, publisher := range p.publishers {
+		err := publisher.Publish(ctx, event)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
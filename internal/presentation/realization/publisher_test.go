@@ -0,0 +1,85 @@
+package realization
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testEvent() domain.Event {
+	return domain.Event{
+		Id:         7,
+		Type:       domain.EVENT_USER_CREATED,
+		UserId:     3,
+		Payload:    json.RawMessage(`{"id":3}`),
+		RequestId:  "req",
+		OccurredAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+// Тест публикации событий в память
+func TestMemoryPublisher(t *testing.T) {
+	publisher := NewMemoryPublisher()
+
+	err := publisher.Publish(context.Background(), testEvent())
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.Event{testEvent()}, publisher.Events())
+}
+
+// Тест записи событий построчно
+func TestWriterPublisher(t *testing.T) {
+	var buf bytes.Buffer
+	publisher := NewWriterPublisher(&buf)
+
+	assert.NoError(t, publisher.Publish(context.Background(), testEvent()))
+	assert.NoError(t, publisher.Publish(context.Background(), testEvent()))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	assert.Len(t, lines, 2)
+
+	var event domain.Event
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &event))
+	assert.Equal(t, testEvent(), event)
+}
+
+// Тест отправки события webhook
+func TestHTTPPublisher(t *testing.T) {
+	var body []byte
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	err := NewHTTPPublisher(srv.URL, time.Second).Publish(context.Background(), testEvent())
+	assert.NoError(t, err)
+	assert.Equal(t, "7", header.Get("X-Event-Id"))
+	assert.Equal(t, domain.EVENT_USER_CREATED, header.Get("X-Event-Type"))
+
+	var event domain.Event
+	assert.NoError(t, json.Unmarshal(body, &event))
+	assert.Equal(t, testEvent(), event)
+}
+
+// Тест ошибки при ответе webhook не 2xx
+func TestHTTPPublisher_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	err := NewHTTPPublisher(srv.URL, time.Second).Publish(context.Background(), testEvent())
+	assert.Error(t, err)
+}
@@ -0,0 +1,238 @@
+package realization
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"user/internal/domain"
+	"user/internal/presentation/db"
+	"user/internal/presentation/logger"
+
+	"github.com/lib/pq"
+)
+
+// RoleService управляет ролями пользователей
+// Назначение и отзыв роли записываются в журнал пользователя
+type RoleService struct {
+	db *db.DB
+}
+
+// NewRoleService создает новый экземпляр RoleService
+func NewRoleService(db *db.DB) *RoleService {
+	return &RoleService{
+		db: db,
+	}
+}
+
+// Roles возвращает все роли с их правами
+func (s *RoleService) Roles(ctx context.Context) ([]domain.Role, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	logger.Logger.Debug("Getting roles...")
+	rows, err := s.db.Db.QueryContext(ctx, `SELECT r.name, r.description, ARRAY(SELECT permission FROM role_permissions WHERE role = r.name ORDER BY permission) FROM roles r ORDER BY r.name`)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting roles error: %v", err))
+		return nil, fmt.Errorf("getting postgres roles error: %v", err)
+	}
+	defer rows.Close()
+
+	roles := []domain.Role{}
+	for rows.Next() {
+		var role domain.Role
+		err = rows.Scan(&role.Name, &role.Description, pq.Array(&role.Permissions))
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning role error: %v", err))
+			return nil, fmt.Errorf("scanning postgres role error: %v", err)
+		}
+		roles = append(roles, role)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting roles error: %v", err))
+		return nil, fmt.Errorf("getting postgres roles error: %v", err)
+	}
+
+	return roles, nil
+}
+
+// UserRoles возвращает назначенные роли активного пользователя, nil если пользователя нет
+// Роль self не возвращается: она есть у каждого пользователя
+func (s *RoleService) UserRoles(ctx context.Context, id domain.Id) ([]domain.UserRole, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	logger.Logger.Debug("Getting user roles...")
+	var exists bool
+	err := s.db.Db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)`, id, domain.TenantFrom(ctx)).Scan(&exists)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting user error: %v", err))
+		return nil, fmt.Errorf("getting postgres user error: %v", err)
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	rows, err := s.db.Db.QueryContext(ctx, `SELECT role, granted_by, granted_at FROM user_roles WHERE user_id = $1 ORDER BY role`, id)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting user roles error: %v", err))
+		return nil, fmt.Errorf("getting postgres user roles error: %v", err)
+	}
+	defer rows.Close()
+
+	roles := []domain.UserRole{}
+	for rows.Next() {
+		var role domain.UserRole
+		err = rows.Scan(&role.Role, &role.GrantedBy, &role.GrantedAt)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning user role error: %v", err))
+			return nil, fmt.Errorf("scanning postgres user role error: %v", err)
+		}
+		roles = append(roles, role)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting user roles error: %v", err))
+		return nil, fmt.Errorf("getting postgres user roles error: %v", err)
+	}
+
+	return roles, nil
+}
+
+// Assign назначает роль активному пользователю, false если пользователя нет
+// Повторное назначение ничего не меняет, неизвестная роль и роль self возвращают ErrUnknownRole
+func (s *RoleService) Assign(ctx context.Context, id domain.Id, role string) (bool, error) {
+	return s.change(ctx, id, role, true)
+}
+
+// Revoke отзывает роль у активного пользователя, false если пользователя нет
+// Отзыв последней роли с правом roles:manage возвращает ErrLastRoleManager
+func (s *RoleService) Revoke(ctx context.Context, id domain.Id, role string) (bool, error) {
+	return s.change(ctx, id, role, false)
+}
+
+// change назначает или отзывает роль в одной транзакции с записью в журнал
+func (s *RoleService) change(ctx context.Context, id domain.Id, role string, granted bool) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	if role == domain.ROLE_SELF {
+		return false, domain.ErrUnknownRole
+	}
+
+	logger.Logger.Debug("Changing user role...")
+	tx, err := s.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Beginning transaction error: %v", err))
+		return false, fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	var held bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM user_roles WHERE user_id = u.id AND role = $2) FROM users u WHERE u.id = $1 AND u.tenant_id = $3 AND u.deleted_at IS NULL FOR UPDATE`, id, role, domain.TenantFrom(ctx)).Scan(&held)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Locking user error: %v", err))
+		return false, fmt.Errorf("locking postgres user error: %v", err)
+	}
+
+	var known bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)`, role).Scan(&known)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting role error: %v", err))
+		return false, fmt.Errorf("getting postgres role error: %v", err)
+	}
+
+	if !known {
+		return false, domain.ErrUnknownRole
+	}
+
+	if held == granted {
+		return true, nil
+	}
+
+	actor := domain.ActorFrom(ctx)
+	if granted {
+		_, err = tx.ExecContext(ctx, `INSERT INTO user_roles (user_id, role, granted_by) VALUES ($1, $2, $3)`, id, role, actor.UserId)
+	} else {
+		err = s.checkManagers(ctx, tx, id, role)
+		if err != nil {
+			return false, err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id = $1 AND role = $2`, id, role)
+	}
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Changing user role error: %v", err))
+		return false, fmt.Errorf("changing postgres user role error: %v", err)
+	}
+
+	err = writeAudit(ctx, tx, id, domain.AUDIT_ROLE, map[string]domain.FieldChange{
+		"role:" + role: {Old: held, New: granted},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Committing transaction error: %v", err))
+		return false, fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	if granted {
+		logger.Logger.Info(fmt.Sprintf("Role %s has been granted to user %d by %s", role, id, actorName(actor)))
+	} else {
+		logger.Logger.Info(fmt.Sprintf("Role %s has been revoked from user %d by %s", role, id, actorName(actor)))
+	}
+	return true, nil
+}
+
+// checkManagers проверяет, что после отзыва роли в организации останется активный пользователь с правом roles:manage
+// Строки таких пользователей блокируются, чтобы два одновременных отзыва не сняли право у всех
+func (s *RoleService) checkManagers(ctx context.Context, tx *sql.Tx, id domain.Id, role string) error {
+	var managing bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM role_permissions WHERE role = $1 AND permission = $2)`, role, domain.PERM_ROLES_MANAGE).Scan(&managing)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting role permissions error: %v", err))
+		return fmt.Errorf("getting postgres role permissions error: %v", err)
+	}
+
+	if !managing {
+		return nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT u.id FROM users u JOIN user_roles ur ON ur.user_id = u.id JOIN role_permissions rp ON rp.role = ur.role WHERE rp.permission = $3 AND u.tenant_id = $4 AND u.deleted_at IS NULL AND NOT (ur.user_id = $1 AND ur.role = $2) FOR UPDATE OF u`, id, role, domain.PERM_ROLES_MANAGE, domain.TenantFrom(ctx))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting role managers error: %v", err))
+		return fmt.Errorf("getting postgres role managers error: %v", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		err = rows.Err()
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Getting role managers error: %v", err))
+			return fmt.Errorf("getting postgres role managers error: %v", err)
+		}
+		return domain.ErrLastRoleManager
+	}
+
+	return nil
+}
+
+// actorName возвращает инициатора изменения для лога
+func actorName(actor domain.Actor) string {
+	if actor.UserId == nil {
+		return "system"
+	}
+
+	return fmt.Sprintf("user %d", *actor.UserId)
+}
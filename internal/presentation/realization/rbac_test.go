@@ -0,0 +1,65 @@
+package realization
+
+import (
+	"context"
+	"testing"
+
+	"user/internal/domain"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тест назначения роли: роль сохраняется вместе с инициатором и записью в журнале
+func TestRoleAssign(t *testing.T) {
+	users, mock, _ := newMockService(t)
+	s := NewRoleService(users.db)
+
+	actor := domain.Id(1)
+	ctx := domain.WithActor(context.Background(), domain.Actor{UserId: &actor, RequestId: "req"})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM users u WHERE u.id = \$1 AND u.tenant_id = \$3 AND u.deleted_at IS NULL FOR UPDATE`).
+		WithArgs(domain.Id(3), domain.ROLE_SUPPORT, domain.DEFAULT_TENANT).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`FROM roles WHERE name = \$1`).
+		WithArgs(domain.ROLE_SUPPORT).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectExec(`INSERT INTO user_roles`).
+		WithArgs(domain.Id(3), domain.ROLE_SUPPORT, &actor).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO user_audit`).
+		WithArgs(domain.Id(3), domain.DEFAULT_TENANT, &actor, "req", domain.AUDIT_ROLE, []byte(`{"role:support":{"old":false,"new":true}}`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	ok, err := s.Assign(ctx, 3, domain.ROLE_SUPPORT)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	_, err = s.Assign(ctx, 3, domain.ROLE_SELF)
+	assert.ErrorIs(t, err, domain.ErrUnknownRole)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест отзыва роли: последнего пользователя, управляющего ролями, лишить права нельзя
+func TestRoleRevoke_LastManager(t *testing.T) {
+	users, mock, _ := newMockService(t)
+	s := NewRoleService(users.db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FOR UPDATE`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`FROM roles`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`FROM role_permissions WHERE role = \$1 AND permission = \$2`).
+		WithArgs(domain.ROLE_ADMIN, domain.PERM_ROLES_MANAGE).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`NOT \(ur.user_id = \$1 AND ur.role = \$2\) FOR UPDATE OF u`).
+		WithArgs(domain.Id(1), domain.ROLE_ADMIN, domain.PERM_ROLES_MANAGE, domain.DEFAULT_TENANT).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	_, err := s.Revoke(context.Background(), 1, domain.ROLE_ADMIN)
+	assert.ErrorIs(t, err, domain.ErrLastRoleManager)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
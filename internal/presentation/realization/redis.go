@@ -5,23 +5,26 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"strconv"
 	"time"
 	"user/internal/domain"
+	"user/internal/interfaces"
 	"user/internal/presentation/logger"
 
 	"github.com/go-redis/redis/v8"
 )
 
 // RedisRepo представляет репозиторий для работы с Redis
+// Пользователи хранятся зашифрованным JSON
 type RedisRepo struct {
-	db *redis.Client
+	db     *redis.Client
+	cipher interfaces.FieldCipher
 }
 
 // NewConnectRedis создает новое подключение к Redis
 // addr - адрес Redis сервера
 // pass - пароль для подключения к Redis
-func NewConnectRedis(addr, port, pass string) *RedisRepo {
+// cipher - шифрование значений ключей
+func NewConnectRedis(addr, port, pass string, cipher interfaces.FieldCipher) *RedisRepo {
 	r := redis.NewClient(&redis.Options{
 		Addr:     fmt.Sprintf("%s:%s", addr, port),
 		Password: pass,
@@ -30,23 +33,30 @@ func NewConnectRedis(addr, port, pass string) *RedisRepo {
 
 	logger.Logger.Info("Redis connection create")
 	return &RedisRepo{
-		db: r,
+		db:     r,
+		cipher: cipher,
 	}
 }
 
+// cacheKey возвращает ключ пользователя в пространстве имен организации
+// Пользователь одной организации не может быть прочитан из кэша в другой
+func cacheKey(tenant, id domain.Id) string {
+	return fmt.Sprintf("tenant:%d:user:%d", tenant, id)
+}
+
 // CreateKey создает новый ключ в Redis
-// id - идентификатор ключа
-// text - текст песни
-func (r *RedisRepo) CreateKey(id domain.Id, user domain.User) error {
+// tenant - организация пользователя
+// id - идентификатор пользователя
+func (r *RedisRepo) CreateKey(tenant, id domain.Id, user domain.User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 
-	userJson, err := json.Marshal(user)
+	value, err := r.seal(user)
 	if err != nil {
-		return errors.New("object marshaling error")
+		return err
 	}
 
-	res := r.db.Set(ctx, strconv.FormatUint(id, 10), userJson, 0)
+	res := r.db.Set(ctx, cacheKey(tenant, id), value, 0)
 	_, err = res.Result()
 
 	if err != nil {
@@ -57,12 +67,13 @@ func (r *RedisRepo) CreateKey(id domain.Id, user domain.User) error {
 	return nil
 }
 
-// GetText получает значение ключа из Redis по идентификатору
-// id - идентификатор ключа
-func (r *RedisRepo) GetByKey(id domain.Id) (*domain.User, error) {
+// GetByKey получает пользователя организации из Redis по идентификатору
+// tenant - организация пользователя
+// id - идентификатор пользователя
+func (r *RedisRepo) GetByKey(tenant, id domain.Id) (*domain.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
-	res := r.db.Get(ctx, strconv.FormatUint(id, 10))
+	res := r.db.Get(ctx, cacheKey(tenant, id))
 
 	err := res.Err()
 	if err != nil {
@@ -72,22 +83,124 @@ func (r *RedisRepo) GetByKey(id domain.Id) (*domain.User, error) {
 		return nil, fmt.Errorf("getting redis key error: %v", err)
 	}
 
+	user, err := r.open(res.Val())
+	if err != nil {
+		// Значение на удаленном ключе или из версии без шифрования считается промахом и будет перезаписано
+		logger.Logger.Warn(fmt.Sprintf("key: %d can't be read: %v", id, err))
+		return nil, nil
+	}
+	logger.Logger.Debug(fmt.Sprintf("key: %d was got", id))
+
+	return user, nil
+}
+
+// CreateKeys создает ключи для нескольких пользователей одним конвейером
+// tenant - организация пользователей
+// users - пользователи, ключом служит их идентификатор
+func (r *RedisRepo) CreateKeys(tenant domain.Id, users []domain.User) error {
+	if len(users) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	pipe := r.db.Pipeline()
+	for _, user := range users {
+		value, err := r.seal(user)
+		if err != nil {
+			return err
+		}
+		pipe.Set(ctx, cacheKey(tenant, user.Id), value, 0)
+	}
+
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("creating Redis keys error: %v", err)
+	}
+
+	logger.Logger.Debug(fmt.Sprintf("%d keys were created", len(users)))
+	return nil
+}
+
+// GetByKeys получает значения нескольких ключей командой MGET
+// tenant - организация пользователей
+// ids - идентификаторы пользователей
+func (r *RedisRepo) GetByKeys(tenant domain.Id, ids []domain.Id) (map[domain.Id]*domain.User, error) {
+	users := make(map[domain.Id]*domain.User, len(ids))
+	if len(ids) == 0 {
+		return users, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = cacheKey(tenant, id)
+	}
+
+	values, err := r.db.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("getting redis keys error: %v", err)
+	}
+
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		user, err := r.open(str)
+		if err != nil {
+			logger.Logger.Warn(fmt.Sprintf("key: %d can't be read: %v", ids[i], err))
+			continue
+		}
+		users[ids[i]] = user
+	}
+
+	logger.Logger.Debug(fmt.Sprintf("%d of %d keys were got", len(users), len(ids)))
+	return users, nil
+}
+
+// seal переводит пользователя в JSON и шифрует его
+func (r *RedisRepo) seal(user domain.User) (string, error) {
+	userJson, err := json.Marshal(user)
+	if err != nil {
+		return "", errors.New("object marshaling error")
+	}
+
+	value, err := r.cipher.Encrypt(string(userJson))
+	if err != nil {
+		return "", fmt.Errorf("object encrypting error: %v", err)
+	}
+
+	return value, nil
+}
+
+// open расшифровывает значение ключа и разбирает пользователя
+func (r *RedisRepo) open(value string) (*domain.User, error) {
+	userJson, err := r.cipher.Decrypt(value)
+	if err != nil {
+		return nil, fmt.Errorf("object decrypting error: %v", err)
+	}
+
 	var user domain.User
-	err = json.Unmarshal([]byte(res.Val()), &user)
+	err = json.Unmarshal([]byte(userJson), &user)
 	if err != nil {
 		return nil, errors.New("object unmurshalling error")
 	}
-	logger.Logger.Debug(fmt.Sprintf("key: %d was got", id))
 
 	return &user, nil
 }
 
-// DelKey удаляет ключ из Redis
-// id - идентификатор ключа
-func (r *RedisRepo) DelKey(id domain.Id) error {
+// DelKey удаляет ключ пользователя организации из Redis
+// tenant - организация пользователя
+// id - идентификатор пользователя
+func (r *RedisRepo) DelKey(tenant, id domain.Id) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
-	err := r.db.Del(ctx, strconv.FormatUint(id, 10)).Err()
+	err := r.db.Del(ctx, cacheKey(tenant, id)).Err()
 
 	if err != nil {
 		return fmt.Errorf("deleating redis key error: %v", err)
@@ -97,6 +210,166 @@ func (r *RedisRepo) DelKey(id domain.Id) error {
 	return nil
 }
 
+// activityKey возвращает ключ последней активности сессии
+func activityKey(id domain.Id) string {
+	return fmt.Sprintf("session:%d:activity", id)
+}
+
+// Touch записывает последний запрос сессии в Redis
+// Значение содержит адрес клиента, поэтому хранится зашифрованным
+func (r *RedisRepo) Touch(id domain.Id, activity domain.SessionActivity, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	data, err := json.Marshal(activity)
+	if err != nil {
+		return errors.New("object marshaling error")
+	}
+
+	value, err := r.cipher.Encrypt(string(data))
+	if err != nil {
+		return fmt.Errorf("object encrypting error: %v", err)
+	}
+
+	err = r.db.Set(ctx, activityKey(id), value, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("creating redis session activity error: %v", err)
+	}
+
+	return nil
+}
+
+// Activity получает последние запросы сессий командой MGET
+func (r *RedisRepo) Activity(ids []domain.Id) (map[domain.Id]domain.SessionActivity, error) {
+	activity := make(map[domain.Id]domain.SessionActivity, len(ids))
+	if len(ids) == 0 {
+		return activity, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = activityKey(id)
+	}
+
+	values, err := r.db.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("getting redis session activity error: %v", err)
+	}
+
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		data, err := r.cipher.Decrypt(str)
+		if err != nil {
+			logger.Logger.Warn(fmt.Sprintf("session activity %d can't be read: %v", ids[i], err))
+			continue
+		}
+
+		var a domain.SessionActivity
+		err = json.Unmarshal([]byte(data), &a)
+		if err != nil {
+			logger.Logger.Warn(fmt.Sprintf("session activity %d can't be read: %v", ids[i], err))
+			continue
+		}
+		activity[ids[i]] = a
+	}
+
+	return activity, nil
+}
+
+// incrWithTtl увеличивает счетчик и выставляет время жизни новому счетчику или счетчику, оставшемуся без него
+// Один скрипт не оставляет ключ без времени жизни, если соединение оборвется между командами
+var incrWithTtl = redis.NewScript(`local value = redis.call("INCR", KEYS[1]) if value == 1 or redis.call("PTTL", KEYS[1]) == -1 then redis.call("PEXPIRE", KEYS[1], ARGV[1]) end return value`)
+
+// Incr увеличивает счетчик в Redis и возвращает новое значение
+// Время жизни выставляется при создании счетчика, поэтому окно не продлевается новыми попытками
+func (r *RedisRepo) Incr(key string, ttl time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	value, err := incrWithTtl.Run(ctx, r.db, []string{key}, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("incrementing redis key error: %v", err)
+	}
+
+	return value, nil
+}
+
+// decrExisting уменьшает счетчик, только если он есть: DECR создал бы ключ без времени жизни
+var decrExisting = redis.NewScript(`if redis.call("EXISTS", KEYS[1]) == 1 then return redis.call("DECR", KEYS[1]) end return 0`)
+
+// Decr уменьшает существующий счетчик в Redis
+func (r *RedisRepo) Decr(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	err := decrExisting.Run(ctx, r.db, []string{key}).Err()
+	if err != nil {
+		return fmt.Errorf("decrementing redis key error: %v", err)
+	}
+
+	return nil
+}
+
+// Mark создает в Redis ключ, который удалится через ttl
+func (r *RedisRepo) Mark(key string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	err := r.db.Set(ctx, key, 1, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("creating redis mark error: %v", err)
+	}
+
+	return nil
+}
+
+// TTL возвращает наибольшее оставшееся время жизни ключей одним конвейером
+// Отсутствующие ключи и ключи без времени жизни не учитываются
+func (r *RedisRepo) TTL(keys ...string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	pipe := r.db.Pipeline()
+	results := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		results[i] = pipe.PTTL(ctx, key)
+	}
+
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("getting redis keys ttl error: %v", err)
+	}
+
+	var ttl time.Duration
+	for _, res := range results {
+		if res.Val() > ttl {
+			ttl = res.Val()
+		}
+	}
+
+	return ttl, nil
+}
+
+// Del удаляет ключи из Redis
+func (r *RedisRepo) Del(keys ...string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	count, err := r.db.Del(ctx, keys...).Result()
+	if err != nil {
+		return 0, fmt.Errorf("deleting redis keys error: %v", err)
+	}
+
+	return count, nil
+}
+
 func (r *RedisRepo) Close() error {
 	logger.Logger.Info("Redis connection was closed")
 	return r.db.Close()
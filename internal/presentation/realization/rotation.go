@@ -0,0 +1,403 @@
+package realization
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+	"user/internal/interfaces"
+	"user/internal/presentation/db"
+	"user/internal/presentation/logger"
+)
+
+// KeyRotator перешифровывает персональные данные в users, секреты TOTP в user_mfa,
+// адреса клиентов в sessions и закрытые ключи подписи в oauth_signing_keys активным ключом
+// Строки на старых ключах получают новый зашифрованный ключ данных, сами данные не меняются,
+// открытые строки, оставшиеся с версии без шифрования, шифруются и получают слепой индекс
+// Версия пользователя при этом не меняется: данные остаются прежними
+type KeyRotator struct {
+	db        *db.DB
+	cipher    interfaces.FieldCipher
+	interval  time.Duration
+	batchSize int
+}
+
+// NewKeyRotator создает новый экземпляр KeyRotator
+// interval - период проверки строк на старых ключах
+// batchSize - количество строк, перешифровываемых в одной транзакции
+func NewKeyRotator(db *db.DB, cipher interfaces.FieldCipher, interval time.Duration, batchSize int) *KeyRotator {
+	return &KeyRotator{
+		db:        db,
+		cipher:    cipher,
+		interval:  interval,
+		batchSize: batchSize,
+	}
+}
+
+// Backfill шифрует все открытые строки и возвращает их количество
+// Вызывается при запуске до приема запросов: по открытым строкам не работают вход и проверка уникальности email
+func (r *KeyRotator) Backfill(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := r.RotateBatch(ctx, true)
+		if err != nil {
+			return total, err
+		}
+
+		total += n
+		if n < r.batchSize {
+			break
+		}
+	}
+
+	if total > 0 {
+		logger.Logger.Info(fmt.Sprintf("%d plaintext users have been encrypted", total))
+	}
+	return total, nil
+}
+
+// Run перешифровывает строки на неактивных ключах до отмены контекста
+// Старый ключ можно убрать из связки, когда в users, user_mfa, sessions и oauth_signing_keys не останется строк с его key_id
+func (r *KeyRotator) Run(ctx context.Context) {
+	logger.Logger.Info(fmt.Sprintf("Key rotator has been started, active key %s", r.cipher.ActiveKey()))
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		total := 0
+		for {
+			n, err := r.RotateBatch(ctx, false)
+			if err != nil {
+				logger.Logger.Error(fmt.Sprintf("Rotating user keys error: %v", err))
+			}
+
+			total += n
+			// Полная пачка означает, что на старых ключах могут остаться строки
+			if err != nil || n < r.batchSize {
+				break
+			}
+		}
+
+		for {
+			n, err := r.RotateMfaBatch(ctx)
+			if err != nil {
+				logger.Logger.Error(fmt.Sprintf("Rotating mfa keys error: %v", err))
+			}
+
+			if err != nil || n < r.batchSize {
+				break
+			}
+		}
+
+		for {
+			n, err := r.RotateSessionBatch(ctx)
+			if err != nil {
+				logger.Logger.Error(fmt.Sprintf("Rotating session keys error: %v", err))
+			}
+
+			if err != nil || n < r.batchSize {
+				break
+			}
+		}
+
+		for {
+			n, err := r.RotateSigningKeyBatch(ctx)
+			if err != nil {
+				logger.Logger.Error(fmt.Sprintf("Rotating signing key encryption error: %v", err))
+			}
+
+			if err != nil || n < r.batchSize {
+				break
+			}
+		}
+
+		if total > 0 {
+			logger.Logger.Info(fmt.Sprintf("%d users have been moved to key %s", total, r.cipher.ActiveKey()))
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Logger.Info("Key rotator has been stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RotateBatch перешифровывает одну пачку строк и возвращает их количество
+// plaintext - обрабатывать открытые строки вместо строк на старых ключах
+// Строки блокируются с SKIP LOCKED, поэтому ротация не ждет изменений пользователей и других экземпляров
+func (r *KeyRotator) RotateBatch(ctx context.Context, plaintext bool) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+
+	tx, err := r.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	var rows *sql.Rows
+	if plaintext {
+		rows, err = tx.QueryContext(ctx, `SELECT id, first_name, last_name, birthday, login FROM users WHERE key_id IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`, r.batchSize)
+	} else {
+		rows, err = tx.QueryContext(ctx, `SELECT id, first_name, last_name, birthday, login FROM users WHERE key_id <> $1 ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED`, r.cipher.ActiveKey(), r.batchSize)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("getting postgres users to rotate error: %v", err)
+	}
+
+	batch := []userRow{}
+	for rows.Next() {
+		var row userRow
+		err = rows.Scan(&row.user.Id, &row.firstName, &row.lastName, &row.birthday, &row.login)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scanning postgres user to rotate error: %v", err)
+		}
+		batch = append(batch, row)
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return 0, fmt.Errorf("getting postgres users to rotate error: %v", err)
+	}
+
+	for _, row := range batch {
+		sealed, err := r.reseal(row, plaintext)
+		if err != nil {
+			return 0, fmt.Errorf("rotating user %d error: %v", row.user.Id, err)
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE users SET first_name = $2, last_name = $3, birthday = $4, login = $5, login_index = $6, key_id = $7 WHERE id = $1`, row.user.Id, sealed.firstName, sealed.lastName, sealed.birthday, sealed.login, sealed.loginIndex, sealed.keyId)
+		if err != nil {
+			return 0, fmt.Errorf("rotating postgres user %d error: %v", row.user.Id, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	return len(batch), nil
+}
+
+// RotateMfaBatch перешифровывает пачку секретов TOTP на неактивных ключах и возвращает их количество
+func (r *KeyRotator) RotateMfaBatch(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+
+	tx, err := r.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT user_id, secret FROM user_mfa WHERE key_id <> $1 ORDER BY user_id LIMIT $2 FOR UPDATE SKIP LOCKED`, r.cipher.ActiveKey(), r.batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("getting postgres mfa secrets to rotate error: %v", err)
+	}
+
+	secrets := map[int64]string{}
+	for rows.Next() {
+		var (
+			userId int64
+			secret string
+		)
+		err = rows.Scan(&userId, &secret)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scanning postgres mfa secret to rotate error: %v", err)
+		}
+		secrets[userId] = secret
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return 0, fmt.Errorf("getting postgres mfa secrets to rotate error: %v", err)
+	}
+
+	for userId, secret := range secrets {
+		sealed, err := r.cipher.Rewrap(secret)
+		if err != nil {
+			return 0, fmt.Errorf("rotating mfa secret of user %d error: %v", userId, err)
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE user_mfa SET secret = $2, key_id = $3 WHERE user_id = $1`, userId, sealed, r.cipher.ActiveKey())
+		if err != nil {
+			return 0, fmt.Errorf("rotating postgres mfa secret of user %d error: %v", userId, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	return len(secrets), nil
+}
+
+// RotateSessionBatch перешифровывает пачку адресов и User-Agent сессий на неактивных ключах и возвращает их количество
+func (r *KeyRotator) RotateSessionBatch(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+
+	tx, err := r.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, ip, user_agent FROM sessions WHERE key_id <> $1 ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED`, r.cipher.ActiveKey(), r.batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("getting postgres sessions to rotate error: %v", err)
+	}
+
+	clients := map[int64][2]string{}
+	for rows.Next() {
+		var (
+			id            int64
+			ip, userAgent string
+		)
+		err = rows.Scan(&id, &ip, &userAgent)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scanning postgres session to rotate error: %v", err)
+		}
+		clients[id] = [2]string{ip, userAgent}
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return 0, fmt.Errorf("getting postgres sessions to rotate error: %v", err)
+	}
+
+	for id, client := range clients {
+		ip, err := r.cipher.Rewrap(client[0])
+		if err != nil {
+			return 0, fmt.Errorf("rotating ip of session %d error: %v", id, err)
+		}
+
+		userAgent, err := r.cipher.Rewrap(client[1])
+		if err != nil {
+			return 0, fmt.Errorf("rotating user agent of session %d error: %v", id, err)
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE sessions SET ip = $2, user_agent = $3, key_id = $4 WHERE id = $1`, id, ip, userAgent, r.cipher.ActiveKey())
+		if err != nil {
+			return 0, fmt.Errorf("rotating postgres session %d error: %v", id, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	return len(clients), nil
+}
+
+// RotateSigningKeyBatch перешифровывает пачку закрытых ключей подписи OAuth на неактивных ключах и возвращает их количество
+func (r *KeyRotator) RotateSigningKeyBatch(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+
+	tx, err := r.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT kid, private_key FROM oauth_signing_keys WHERE key_id <> $1 ORDER BY kid LIMIT $2 FOR UPDATE SKIP LOCKED`, r.cipher.ActiveKey(), r.batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("getting postgres signing keys to rotate error: %v", err)
+	}
+
+	keys := map[string]string{}
+	for rows.Next() {
+		var kid, private string
+		err = rows.Scan(&kid, &private)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scanning postgres signing key to rotate error: %v", err)
+		}
+		keys[kid] = private
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return 0, fmt.Errorf("getting postgres signing keys to rotate error: %v", err)
+	}
+
+	for kid, private := range keys {
+		sealed, err := r.cipher.Rewrap(private)
+		if err != nil {
+			return 0, fmt.Errorf("rotating signing key %s error: %v", kid, err)
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE oauth_signing_keys SET private_key = $2, key_id = $3 WHERE kid = $1`, kid, sealed, r.cipher.ActiveKey())
+		if err != nil {
+			return 0, fmt.Errorf("rotating postgres signing key %s error: %v", kid, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	return len(keys), nil
+}
+
+// reseal переводит поля строки на активный ключ
+// Открытые значения шифруются, зашифрованные получают новый зашифрованный ключ данных
+func (r *KeyRotator) reseal(row userRow, plaintext bool) (*sealedUser, error) {
+	convert := r.cipher.Rewrap
+	if plaintext {
+		convert = r.cipher.Encrypt
+	}
+
+	var err error
+	sealed := &sealedUser{
+		keyId: r.cipher.ActiveKey(),
+	}
+
+	sealed.firstName, err = convert(row.firstName)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed.lastName, err = convert(row.lastName)
+	if err != nil {
+		return nil, err
+	}
+
+	if row.birthday.Valid {
+		birthday, err := convert(row.birthday.String)
+		if err != nil {
+			return nil, err
+		}
+		sealed.birthday = &birthday
+	}
+
+	sealed.login, err = convert(row.login)
+	if err != nil {
+		return nil, err
+	}
+
+	login := row.login
+	if !plaintext {
+		login, err = r.cipher.Decrypt(row.login)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sealed.loginIndex = r.cipher.BlindIndex(login)
+
+	return sealed, nil
+}
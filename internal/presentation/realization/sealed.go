@@ -0,0 +1,118 @@
+package realization
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"user/internal/domain"
+	"user/internal/interfaces"
+	"user/internal/presentation/logger"
+)
+
+// sealedUser - персональные данные пользователя в том виде, в котором они хранятся в users
+// Все поля строки шифруются одним ключом, его идентификатор дублируется в key_id для ротации
+type sealedUser struct {
+	firstName  string
+	lastName   string
+	birthday   *string
+	login      string
+	loginIndex string
+	keyId      string
+}
+
+// sealUser шифрует персональные данные пользователя активным ключом
+// Слепой индекс логина сохраняет уникальность и поиск по email
+func sealUser(c interfaces.FieldCipher, user *domain.User) (*sealedUser, error) {
+	var err error
+	sealed := &sealedUser{
+		loginIndex: c.BlindIndex(user.Login),
+		keyId:      c.ActiveKey(),
+	}
+
+	sealed.firstName, err = c.Encrypt(user.FirstName)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting user error: %v", err)
+	}
+
+	sealed.lastName, err = c.Encrypt(user.LastName)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting user error: %v", err)
+	}
+
+	if user.BirthDay != nil {
+		birthday, err := c.Encrypt(user.BirthDay.Format(time.DateOnly))
+		if err != nil {
+			return nil, fmt.Errorf("encrypting user error: %v", err)
+		}
+		sealed.birthday = &birthday
+	}
+
+	sealed.login, err = c.Encrypt(user.Login)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting user error: %v", err)
+	}
+
+	return sealed, nil
+}
+
+// userRow - строка users, прочитанная вместе с зашифрованными персональными данными
+type userRow struct {
+	user      domain.User
+	firstName string
+	lastName  string
+	birthday  sql.NullString
+	login     string
+}
+
+// dest возвращает приемники для колонок id, first_name, last_name, birthday, login, version, created_at, deleted_at
+// extra - приемники колонок, выбранных после них
+func (r *userRow) dest(extra ...any) []any {
+	return append([]any{&r.user.Id, &r.firstName, &r.lastName, &r.birthday, &r.login, &r.user.Version, &r.user.CreatedAt, &r.user.DeletedAt}, extra...)
+}
+
+// decrypt расшифровывает персональные данные строки и возвращает пользователя
+func (r *userRow) decrypt(c interfaces.FieldCipher) (*domain.User, error) {
+	user, err := r.open(c)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Decrypting user %d error: %v", r.user.Id, err))
+		return nil, fmt.Errorf("decrypting user %d error: %v", r.user.Id, err)
+	}
+
+	return user, nil
+}
+
+// open расшифровывает поля строки по одному
+func (r *userRow) open(c interfaces.FieldCipher) (*domain.User, error) {
+	user := r.user
+
+	var err error
+	user.FirstName, err = c.Decrypt(r.firstName)
+	if err != nil {
+		return nil, err
+	}
+
+	user.LastName, err = c.Decrypt(r.lastName)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Login, err = c.Decrypt(r.login)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.birthday.Valid {
+		birthday, err := c.Decrypt(r.birthday.String)
+		if err != nil {
+			return nil, err
+		}
+
+		date, err := time.Parse(time.DateOnly, birthday)
+		if err != nil {
+			return nil, err
+		}
+		user.BirthDay = &date
+	}
+
+	return &user, nil
+}
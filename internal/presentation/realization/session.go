@@ -0,0 +1,224 @@
+package realization
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"user/internal/domain"
+	"user/internal/interfaces"
+	"user/internal/presentation/db"
+	"user/internal/presentation/logger"
+)
+
+const (
+	// SESSION_ACTIVITY_TTL - время жизни горячей копии активности сессии в Redis
+	SESSION_ACTIVITY_TTL = 10 * time.Minute
+	// SESSION_FLUSH_INTERVAL - как часто активность сессии переносится в базу
+	// Должен быть меньше SESSION_ACTIVITY_TTL, чтобы копия в Redis не исчезала раньше переноса
+	SESSION_FLUSH_INTERVAL = 5 * time.Minute
+)
+
+// SessionService показывает и отзывает активные сессии пользователей
+// Сессии хранятся в базе, последняя активность берется из горячей копии в Redis, если она свежее
+type SessionService struct {
+	db       *db.DB
+	activity interfaces.SessionCacheRepo
+	cipher   interfaces.FieldCipher
+}
+
+// NewSessionService создает новый экземпляр SessionService
+// activity - горячая копия активности сессий
+// cipher - шифрование адресов и User-Agent клиентов
+func NewSessionService(db *db.DB, activity interfaces.SessionCacheRepo, cipher interfaces.FieldCipher) *SessionService {
+	return &SessionService{
+		db:       db,
+		activity: activity,
+		cipher:   cipher,
+	}
+}
+
+// List возвращает неотозванные и непросроченные сессии пользователя организации
+// Сессия, которой выполнен запрос, отмечается как текущая
+func (s *SessionService) List(ctx context.Context, id domain.Id) ([]domain.Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	rows, err := s.db.Db.QueryContext(ctx, `SELECT s.id, s.user_id, s.access_expires_at, s.refresh_expires_at, s.created_at, s.device, s.ip, s.user_agent, s.last_seen_at FROM sessions s JOIN users u ON u.id = s.user_id WHERE s.user_id = $1 AND u.tenant_id = $2 AND s.revoked_at IS NULL AND s.refresh_expires_at > NOW() ORDER BY s.id`, id, domain.TenantFrom(ctx))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting user sessions error: %v", err))
+		return nil, fmt.Errorf("getting postgres sessions error: %v", err)
+	}
+	defer rows.Close()
+
+	sessions := []domain.Session{}
+	for rows.Next() {
+		var (
+			session       domain.Session
+			ip, userAgent sql.NullString
+		)
+		err = rows.Scan(&session.Id, &session.UserId, &session.AccessExpiresAt, &session.RefreshExpiresAt, &session.CreatedAt, &session.Device, &ip, &userAgent, &session.LastSeenAt)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning user session error: %v", err))
+			return nil, fmt.Errorf("scanning postgres session error: %v", err)
+		}
+
+		err = openClient(s.cipher, &session, ip, userAgent)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting user sessions error: %v", err))
+		return nil, fmt.Errorf("getting postgres sessions error: %v", err)
+	}
+
+	ids := make([]domain.Id, len(sessions))
+	for i, session := range sessions {
+		ids[i] = session.Id
+	}
+
+	// Без Redis список строится по базе, активность в ней отстает не больше чем на SESSION_FLUSH_INTERVAL
+	activity, err := s.activity.Activity(ids)
+	if err != nil {
+		logger.Logger.Warn(fmt.Sprintf("Getting session activity error: %v", err))
+	}
+
+	current := domain.ActorFrom(ctx).SessionId
+	for i := range sessions {
+		if a, ok := activity[sessions[i].Id]; ok && a.LastSeenAt.After(sessions[i].LastSeenAt) {
+			sessions[i].LastSeenAt = a.LastSeenAt
+			sessions[i].Ip = a.Ip
+		}
+		sessions[i].Current = current != nil && *current == sessions[i].Id
+	}
+
+	slices.SortStableFunc(sessions, func(a, b domain.Session) int {
+		return b.LastSeenAt.Compare(a.LastSeenAt)
+	})
+
+	return sessions, nil
+}
+
+// Revoke отзывает сессию пользователя организации
+// Возвращает false, если у пользователя нет такой активной сессии
+func (s *SessionService) Revoke(ctx context.Context, id, session domain.Id) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	res, err := s.db.Db.ExecContext(ctx, `UPDATE sessions s SET revoked_at = NOW() FROM users u WHERE u.id = s.user_id AND s.id = $1 AND s.user_id = $2 AND u.tenant_id = $3 AND s.revoked_at IS NULL`, session, id, domain.TenantFrom(ctx))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Revoking session error: %v", err))
+		return false, fmt.Errorf("revoking postgres session error: %v", err)
+	}
+
+	revoked, _ := res.RowsAffected()
+	if revoked == 0 {
+		return false, nil
+	}
+
+	logger.Logger.Info(fmt.Sprintf("Session %d of user %d has been revoked by %s", session, id, actorName(domain.ActorFrom(ctx))))
+	return true, nil
+}
+
+// RevokeAll отзывает все сессии пользователя организации, включая сессию запроса
+func (s *SessionService) RevokeAll(ctx context.Context, id domain.Id) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	res, err := s.db.Db.ExecContext(ctx, `UPDATE sessions s SET revoked_at = NOW() FROM users u WHERE u.id = s.user_id AND s.user_id = $1 AND u.tenant_id = $2 AND s.revoked_at IS NULL`, id, domain.TenantFrom(ctx))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Revoking user sessions error: %v", err))
+		return 0, fmt.Errorf("revoking postgres sessions error: %v", err)
+	}
+
+	revoked, _ := res.RowsAffected()
+	logger.Logger.Info(fmt.Sprintf("%d sessions of user %d have been revoked by %s", revoked, id, actorName(domain.ActorFrom(ctx))))
+	return revoked, nil
+}
+
+// revokeOtherSessions отзывает сессии пользователя, кроме сессии, которой выполнен запрос
+// Если запрос выполнен другим пользователем, отзываются все сессии
+func revokeOtherSessions(ctx context.Context, tx *sql.Tx, userId domain.Id) (int64, error) {
+	res, err := tx.ExecContext(ctx, `UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL AND id IS DISTINCT FROM $2`, userId, domain.ActorFrom(ctx).SessionId)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Revoking other sessions error: %v", err))
+		return 0, fmt.Errorf("revoking postgres sessions error: %v", err)
+	}
+
+	revoked, _ := res.RowsAffected()
+	return revoked, nil
+}
+
+// sealClient шифрует адрес и User-Agent клиента и возвращает идентификатор ключа
+func sealClient(c interfaces.FieldCipher, client domain.Client) (string, string, string, error) {
+	ip, err := c.Encrypt(client.Ip)
+	if err != nil {
+		return "", "", "", fmt.Errorf("encrypting session ip error: %v", err)
+	}
+
+	userAgent, err := c.Encrypt(client.UserAgent)
+	if err != nil {
+		return "", "", "", fmt.Errorf("encrypting session user agent error: %v", err)
+	}
+
+	return ip, userAgent, c.ActiveKey(), nil
+}
+
+// openClient расшифровывает адрес и User-Agent сессии
+// У сессий, открытых до их появления, значений нет
+func openClient(c interfaces.FieldCipher, session *domain.Session, ip, userAgent sql.NullString) error {
+	if ip.Valid {
+		value, err := c.Decrypt(ip.String)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Decrypting session %d ip error: %v", session.Id, err))
+			return fmt.Errorf("decrypting session ip error: %v", err)
+		}
+		session.Ip = value
+	}
+
+	if userAgent.Valid {
+		value, err := c.Decrypt(userAgent.String)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Decrypting session %d user agent error: %v", session.Id, err))
+			return fmt.Errorf("decrypting session user agent error: %v", err)
+		}
+		session.UserAgent = value
+	}
+
+	return nil
+}
+
+// browsers и systems - признаки в User-Agent, более частные раньше общих
+var (
+	browsers = [][2]string{{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"}, {"Safari/", "Safari"}, {"curl/", "curl"}}
+	systems  = [][2]string{{"Windows", "Windows"}, {"Android", "Android"}, {"iPhone", "iOS"}, {"iPad", "iPadOS"}, {"Mac OS X", "macOS"}, {"Linux", "Linux"}}
+)
+
+// deviceName возвращает браузер и систему по User-Agent, например "Chrome on Windows"
+func deviceName(userAgent string) string {
+	find := func(signs [][2]string) string {
+		for _, sign := range signs {
+			if strings.Contains(userAgent, sign[0]) {
+				return sign[1]
+			}
+		}
+		return ""
+	}
+
+	browser, system := find(browsers), find(systems)
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "" || system != "":
+		return cmp.Or(browser, system)
+	}
+
+	return "Unknown device"
+}
@@ -0,0 +1,166 @@
+package realization
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryActivity - горячая копия активности сессий в памяти для тестов
+type memoryActivity struct {
+	sessions map[domain.Id]domain.SessionActivity
+}
+
+func newMemoryActivity() *memoryActivity {
+	return &memoryActivity{sessions: map[domain.Id]domain.SessionActivity{}}
+}
+
+func (a *memoryActivity) Touch(id domain.Id, activity domain.SessionActivity, _ time.Duration) error {
+	a.sessions[id] = activity
+	return nil
+}
+
+func (a *memoryActivity) Activity(ids []domain.Id) (map[domain.Id]domain.SessionActivity, error) {
+	activity := map[domain.Id]domain.SessionActivity{}
+	for _, id := range ids {
+		if v, ok := a.sessions[id]; ok {
+			activity[id] = v
+		}
+	}
+
+	return activity, nil
+}
+
+// Тест определения устройства по User-Agent
+func TestDeviceName(t *testing.T) {
+	tests := map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36":               "Chrome on Windows",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0": "Edge on Windows",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/604.1":     "Safari on iOS",
+		"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0":                                                        "Firefox on Linux",
+		"curl/8.4.0": "curl",
+		"":           "Unknown device",
+	}
+
+	for userAgent, device := range tests {
+		assert.Equal(t, device, deviceName(userAgent), userAgent)
+	}
+}
+
+// Тест списка сессий: адрес и User-Agent расшифровываются, свежая активность берется из Redis
+func TestListSessions(t *testing.T) {
+	users, mock, _ := newMockService(t)
+	activity := newMemoryActivity()
+	s := NewSessionService(users.db, activity, users.cipher)
+
+	current := domain.Id(2)
+	ctx := domain.WithActor(context.Background(), domain.Actor{SessionId: &current})
+	seen := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	activity.sessions[1] = domain.SessionActivity{LastSeenAt: seen.Add(time.Hour), Ip: "10.0.0.9"}
+	activity.sessions[2] = domain.SessionActivity{LastSeenAt: seen.Add(-time.Hour), Ip: "10.0.0.8"}
+
+	mock.ExpectQuery(`SELECT s.id, s.user_id, s.access_expires_at, s.refresh_expires_at, s.created_at, s.device, s.ip, s.user_agent, s.last_seen_at FROM sessions s JOIN users u ON u.id = s.user_id WHERE s.user_id = \$1 AND u.tenant_id = \$2 AND s.revoked_at IS NULL AND s.refresh_expires_at > NOW\(\)`).
+		WithArgs(domain.Id(3), domain.DEFAULT_TENANT).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "access_expires_at", "refresh_expires_at", "created_at", "device", "ip", "user_agent", "last_seen_at"}).
+			AddRow(1, 3, seen, seen, seen, "Firefox on Linux", encrypt(t, users.cipher, "10.0.0.1"), encrypt(t, users.cipher, "Firefox/121.0"), seen).
+			AddRow(2, 3, seen, seen, seen, "Chrome on Windows", encrypt(t, users.cipher, "10.0.0.2"), encrypt(t, users.cipher, "Chrome/120.0"), seen).
+			AddRow(3, 3, seen, seen, seen.Add(-time.Hour), "", nil, nil, seen.Add(-time.Hour)))
+
+	sessions, err := s.List(ctx, 3)
+	require.NoError(t, err)
+	require.Len(t, sessions, 3)
+
+	// Активность из Redis свежее базы только у первой сессии
+	assert.Equal(t, domain.Id(1), sessions[0].Id)
+	assert.Equal(t, "10.0.0.9", sessions[0].Ip)
+	assert.Equal(t, "Firefox/121.0", sessions[0].UserAgent)
+	assert.Equal(t, seen.Add(time.Hour), sessions[0].LastSeenAt)
+	assert.False(t, sessions[0].Current)
+
+	assert.Equal(t, domain.Id(2), sessions[1].Id)
+	assert.Equal(t, "10.0.0.2", sessions[1].Ip)
+	assert.True(t, sessions[1].Current)
+
+	// Сессия, открытая до появления адресов
+	assert.Equal(t, domain.Id(3), sessions[2].Id)
+	assert.Empty(t, sessions[2].Ip)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест отзыва сессий: чужая или уже отозванная сессия не найдена
+func TestRevokeSessions(t *testing.T) {
+	users, mock, _ := newMockService(t)
+	s := NewSessionService(users.db, newMemoryActivity(), users.cipher)
+	ctx := domain.WithTenant(context.Background(), 2)
+
+	mock.ExpectExec(`UPDATE sessions s SET revoked_at = NOW\(\) FROM users u WHERE u.id = s.user_id AND s.id = \$1 AND s.user_id = \$2 AND u.tenant_id = \$3 AND s.revoked_at IS NULL`).
+		WithArgs(domain.Id(5), domain.Id(3), domain.Id(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	ok, err := s.Revoke(ctx, 3, 5)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	mock.ExpectExec(`UPDATE sessions s SET revoked_at`).
+		WithArgs(domain.Id(6), domain.Id(3), domain.Id(2)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	ok, err = s.Revoke(ctx, 3, 6)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	mock.ExpectExec(`UPDATE sessions s SET revoked_at = NOW\(\) FROM users u WHERE u.id = s.user_id AND s.user_id = \$1 AND u.tenant_id = \$2 AND s.revoked_at IS NULL`).
+		WithArgs(domain.Id(3), domain.Id(2)).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	revoked, err := s.RevokeAll(ctx, 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), revoked)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест активности: Redis отмечается при каждом запросе, база - не чаще SESSION_FLUSH_INTERVAL
+func TestTouchSession(t *testing.T) {
+	users, mock, _ := newMockService(t)
+	activity := newMemoryActivity()
+	auth := NewAuthService(users.db, nil, users.cipher, nil, activity, time.Minute, time.Hour, false)
+	ctx := domain.WithClient(context.Background(), domain.Client{Ip: "10.0.0.1", UserAgent: "Firefox/121.0 (X11; Linux x86_64)"})
+
+	session := &domain.Session{Id: 5, LastSeenAt: time.Now()}
+	auth.touch(ctx, session)
+	assert.Equal(t, "10.0.0.1", activity.sessions[5].Ip)
+
+	mock.ExpectExec(`UPDATE sessions SET last_seen_at = \$2, device = \$3, ip = \$4, user_agent = \$5, key_id = \$6 WHERE id = \$1`).
+		WithArgs(domain.Id(5), sqlmock.AnyArg(), "Firefox on Linux", sqlmock.AnyArg(), sqlmock.AnyArg(), "test").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	session.LastSeenAt = time.Now().Add(-SESSION_FLUSH_INTERVAL)
+	auth.touch(ctx, session)
+	assert.WithinDuration(t, time.Now(), session.LastSeenAt, time.Second)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест смены пароля: отзываются все сессии, кроме сессии запроса
+func TestRevokeOtherSessions(t *testing.T) {
+	users, mock, _ := newMockService(t)
+
+	session := domain.Id(4)
+	for _, current := range []*domain.Id{nil, &session} {
+		ctx := domain.WithActor(context.Background(), domain.Actor{SessionId: current})
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE sessions SET revoked_at = NOW\(\) WHERE user_id = \$1 AND revoked_at IS NULL AND id IS DISTINCT FROM \$2`).
+			WithArgs(domain.Id(3), current).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectRollback()
+
+		tx, err := users.db.Db.BeginTx(ctx, nil)
+		require.NoError(t, err)
+		revoked, err := revokeOtherSessions(ctx, tx, 3)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), revoked)
+		require.NoError(t, tx.Rollback())
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,125 @@
+package realization
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TOTP_DIGITS = 6
+	// TOTP_PERIOD - длительность шага TOTP в секундах
+	TOTP_PERIOD = 30
+	// TOTP_SKEW - допустимое расхождение часов пользователя в шагах
+	TOTP_SKEW = 1
+	// TOTP_SECRET_SIZE - длина секрета в байтах, RFC 4226 рекомендует 160 бит
+	TOTP_SECRET_SIZE = 20
+
+	RECOVERY_CODES     = 10
+	RECOVERY_CODE_SIZE = 10
+)
+
+// totpEncoding - base32 без выравнивания, в таком виде секрет принимают приложения
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTotpSecret генерирует случайный секрет TOTP в base32
+func newTotpSecret() (string, error) {
+	buf := make([]byte, TOTP_SECRET_SIZE)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("generating totp secret error: %v", err)
+	}
+
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+// totpUri возвращает ссылку otpauth:// для добавления секрета в приложение
+func totpUri(issuer, account, secret string) string {
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {strconv.Itoa(TOTP_DIGITS)},
+		"period":    {strconv.Itoa(TOTP_PERIOD)},
+	}
+
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + query.Encode()
+}
+
+// totpCode возвращает код шага step по RFC 6238
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// matchTotp проверяет код в пределах TOTP_SKEW шагов от now и возвращает совпавший шаг
+// Шаги до lastStep включительно не принимаются, поэтому перехваченный код нельзя использовать повторно
+func matchTotp(secret string, code string, now time.Time, lastStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	step := now.Unix() / TOTP_PERIOD
+	for i := int64(-TOTP_SKEW); i <= TOTP_SKEW; i++ {
+		candidate := step + i
+		if candidate > lastStep && hmac.Equal([]byte(totpCode(key, candidate)), []byte(code)) {
+			return candidate, true
+		}
+	}
+
+	return 0, false
+}
+
+// isTotpCode проверяет, похож ли код на код TOTP, а не на код восстановления
+func isTotpCode(code string) bool {
+	if len(code) != TOTP_DIGITS {
+		return false
+	}
+
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newRecoveryCodes генерирует коды восстановления вида xxxxx-xxxxx
+func newRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, RECOVERY_CODES)
+	for range RECOVERY_CODES {
+		buf := make([]byte, 7)
+		_, err := rand.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("generating recovery code error: %v", err)
+		}
+
+		code := strings.ToLower(totpEncoding.EncodeToString(buf))[:RECOVERY_CODE_SIZE]
+		codes = append(codes, code[:RECOVERY_CODE_SIZE/2]+"-"+code[RECOVERY_CODE_SIZE/2:])
+	}
+
+	return codes, nil
+}
+
+// hashRecoveryCode возвращает хэш кода восстановления без учета регистра и дефисов
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashToken(code)
+}
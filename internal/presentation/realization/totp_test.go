@@ -0,0 +1,83 @@
+package realization
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тест кодов по контрольным значениям RFC 6238 (SHA1, последние 6 цифр)
+func TestTotpCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.code, totpCode(secret, tt.unix/TOTP_PERIOD))
+	}
+}
+
+// Тест проверки кода: соседний шаг принимается, дальний и уже использованный - нет
+func TestMatchTotp(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111109, 0)
+	step := now.Unix() / TOTP_PERIOD
+
+	matched, ok := matchTotp(secret, "081804", now, 0)
+	require.True(t, ok)
+	assert.Equal(t, step, matched)
+
+	_, ok = matchTotp(secret, "081804", now.Add(TOTP_PERIOD*time.Second), 0)
+	assert.True(t, ok)
+
+	_, ok = matchTotp(secret, "081804", now.Add(3*TOTP_PERIOD*time.Second), 0)
+	assert.False(t, ok)
+
+	_, ok = matchTotp(secret, "081804", now, step)
+	assert.False(t, ok)
+
+	_, ok = matchTotp("not base32!", "081804", now, 0)
+	assert.False(t, ok)
+}
+
+// Тест ссылки otpauth:// для приложения
+func TestTotpUri(t *testing.T) {
+	uri, err := url.Parse(totpUri("User Service", "john@example.com", "SECRET"))
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/User Service:john@example.com", uri.Path)
+	assert.Equal(t, "SECRET", uri.Query().Get("secret"))
+	assert.Equal(t, "User Service", uri.Query().Get("issuer"))
+	assert.Equal(t, "6", uri.Query().Get("digits"))
+}
+
+// Тест кодов восстановления: уникальны и сравниваются без учета регистра и дефисов
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := newRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, RECOVERY_CODES)
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		assert.Len(t, code, RECOVERY_CODE_SIZE+1)
+		assert.False(t, isTotpCode(code))
+		assert.False(t, seen[code])
+		seen[code] = true
+	}
+
+	assert.Equal(t, hashRecoveryCode(codes[0]), hashRecoveryCode(strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))))
+	assert.True(t, isTotpCode("012345"))
+	assert.False(t, isTotpCode("01234a"))
+}
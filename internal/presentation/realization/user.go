@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 	"user/internal/domain"
 	"user/internal/interfaces"
@@ -19,24 +20,81 @@ const (
 )
 
 type UserService struct {
-	db    *db.DB
-	cache interfaces.CacheRepo
+	db     *db.DB
+	cache  interfaces.CacheRepo
+	cipher interfaces.FieldCipher
+	hasher interfaces.PasswordHasher
 }
 
-func NewUserService(db *db.DB, cache interfaces.CacheRepo) *UserService {
+// NewUserService создает новый экземпляр UserService
+// cipher - шифрование персональных данных в users
+// hasher - проверка, совпадает ли пароль из запроса с сохраненным
+func NewUserService(db *db.DB, cache interfaces.CacheRepo, cipher interfaces.FieldCipher, hasher interfaces.PasswordHasher) *UserService {
 	return &UserService{
-		db:    db,
-		cache: cache,
+		db:     db,
+		cache:  cache,
+		cipher: cipher,
+		hasher: hasher,
 	}
 }
 
-func (s *UserService) Create(user domain.User) (*domain.Id, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+func (s *UserService) Create(ctx context.Context, user domain.User) (*domain.Id, error) {
+	return s.createWith(ctx, user, false)
+}
+
+// CreateDeleted создает пользователя сразу мягко удаленным
+// Создание и удаление выполняются в одной транзакции, активным пользователь не бывает ни на миг
+func (s *UserService) CreateDeleted(ctx context.Context, user domain.User) (*domain.Id, error) {
+	return s.createWith(ctx, user, true)
+}
+
+// createWith создает пользователя и при deleted мягко удаляет его в той же транзакции
+func (s *UserService) createWith(ctx context.Context, user domain.User, deleted bool) (*domain.Id, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
 
-	var id domain.Id
 	logger.Logger.Debug("Creating user...")
-	err := s.db.Db.QueryRowContext(ctx, `INSERT INTO users (first_name, last_name, birthday, login, password) VALUES ($1, $2, $3, $4, $5) RETURNING id`, user.FirstName, user.LastName, user.BirthDay, user.Login, user.Password).Scan(&id)
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	id, err := s.create(ctx, tx, user)
+	if err != nil || id == nil {
+		return nil, err
+	}
+
+	if deleted {
+		created, err := s.lock(ctx, tx, *id, false)
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.remove(ctx, tx, created)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.commit(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Logger.Debug("The user has been created successful")
+	return id, nil
+}
+
+// create добавляет пользователя в транзакции tx
+// Возвращает nil, если логин занят, после этого транзакцию нужно откатить
+func (s *UserService) create(ctx context.Context, tx *sql.Tx, user domain.User) (*domain.Id, error) {
+	sealed, err := sealUser(s.cipher, &user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, `INSERT INTO users (tenant_id, first_name, last_name, birthday, login, login_index, key_id, password) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, version, created_at`, domain.TenantFrom(ctx), sealed.firstName, sealed.lastName, sealed.birthday, sealed.login, sealed.loginIndex, sealed.keyId, user.Password).Scan(&user.Id, &user.Version, &user.CreatedAt)
 	var pqErr *pq.Error
 	if err != nil {
 		if errors.As(err, &pqErr) && pqErr.Code == NOT_UNIQUE_LOGIN {
@@ -46,27 +104,53 @@ func (s *UserService) Create(user domain.User) (*domain.Id, error) {
 		return nil, fmt.Errorf("creating postgres user error: %v", err)
 	}
 
-	logger.Logger.Debug("The user has been created successful")
-	return &id, nil
+	diff := diffFields(auditFields(nil), auditFields(&user))
+	err = writeAudit(ctx, tx, user.Id, domain.AUDIT_CREATE, diff)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeEvent(ctx, tx, domain.EVENT_USER_CREATED, eventPayload(&user, nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return &user.Id, nil
 }
 
-func (s *UserService) Get(id domain.Id) (*domain.User, error) {
-	cacheUser, err := s.cache.GetByKey(id)
+// Get возвращает пользователя организации из контекста по идентификатору
+// Мягко удаленные пользователи возвращаются только при withDeleted
+func (s *UserService) Get(ctx context.Context, id domain.Id, withDeleted bool) (*domain.User, error) {
+	tenant := domain.TenantFrom(ctx)
+	cacheUser, err := s.cache.GetByKey(tenant, id)
 	if err != nil {
 		logger.Logger.Error(fmt.Sprintf("Getting Redis key error: %v", err))
 		return nil, err
 	}
 
-	if cacheUser != nil {
+	// Записи без версии остались в кэше с прошлых версий сервиса
+	if cacheUser != nil && cacheUser.Version != 0 {
 		return cacheUser, nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	return s.fetch(ctx, id, withDeleted)
+}
+
+// GetFresh возвращает активного пользователя из Postgres, минуя кэш, и обновляет кэш
+// Нужен, когда изменение строится на текущем состоянии пользователя
+func (s *UserService) GetFresh(ctx context.Context, id domain.Id) (*domain.User, error) {
+	return s.fetch(ctx, id, false)
+}
+
+// fetch читает пользователя из Postgres и сохраняет активного пользователя в кэш
+func (s *UserService) fetch(ctx context.Context, id domain.Id, withDeleted bool) (*domain.User, error) {
+	tenant := domain.TenantFrom(ctx)
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
 
 	logger.Logger.Debug("Getting user...")
-	var user domain.User
-	err = s.db.Db.QueryRowContext(ctx, `SELECT id, first_name, last_name, birthday, login FROM users WHERE id = $1`, id).Scan(&user.Id, &user.FirstName, &user.LastName, &user.BirthDay, &user.Login)
+	var row userRow
+	err := s.db.Db.QueryRowContext(ctx, `SELECT id, first_name, last_name, birthday, login, version, created_at, deleted_at FROM users WHERE id = $1 AND ($2 OR deleted_at IS NULL) AND tenant_id = $3`, id, withDeleted, tenant).Scan(row.dest()...)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -75,34 +159,553 @@ func (s *UserService) Get(id domain.Id) (*domain.User, error) {
 		return nil, fmt.Errorf("getting postgres user error: %v", err)
 	}
 
+	user, err := row.decrypt(s.cipher)
+	if err != nil {
+		return nil, err
+	}
+
 	user.Password = "***"
-	err = s.cache.CreateKey(id, user)
+	if user.DeletedAt != nil {
+		return user, nil
+	}
+
+	err = s.cache.CreateKey(tenant, id, *user)
 	if err != nil {
 		logger.Logger.Error(fmt.Sprintf("Creating Redis key error: %v", err))
 	}
 
 	logger.Logger.Debug("The user has been get successful")
-	return &user, nil
+	return user, nil
 }
 
-func (s *UserService) Update(user domain.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+// Update обновляет пользователя, при ненулевой Version проверяет ее совпадение
+func (s *UserService) Update(ctx context.Context, user domain.User) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
+
 	logger.Logger.Debug("Updating user...")
-	_, err := s.db.Db.ExecContext(ctx, `UPDATE users SET first_name = $2, last_name = $3, birthday = $4, login = $5, password = $6 WHERE id = $1`, user.Id, user.FirstName, user.LastName, user.BirthDay, user.Login, user.Password)
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	found, err := s.update(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		if user.Version != 0 {
+			return domain.ErrVersionMismatch
+		}
+		return nil
+	}
+
+	err = s.commit(tx)
+	if err != nil {
+		return err
+	}
+
+	s.invalidate(ctx, user.Id)
+	return nil
+}
+
+// update обновляет пользователя в транзакции tx, возвращает false, если активного пользователя нет
+// Ошибка уникальности логина возвращается как *pq.Error, при смене email подтверждение сбрасывается,
+// при смене пароля отзываются все сессии пользователя, кроме сессии запроса
+func (s *UserService) update(ctx context.Context, tx *sql.Tx, user domain.User) (bool, error) {
+	before, err := s.lock(ctx, tx, user.Id, false)
+	if err != nil || before == nil {
+		return false, err
+	}
+
+	if user.Version != 0 && user.Version != before.Version {
+		return false, domain.ErrVersionMismatch
+	}
+
+	// Тот же пароль сохраняет старый хэш: сессии на других устройствах и журнал не должны считать его сменой
+	if user.PlainPassword != "" {
+		same, err := s.hasher.Verify(user.PlainPassword, before.Password)
+		if err == nil && same {
+			user.Password = before.Password
+		}
+	}
+
+	sealed, err := sealUser(s.cipher, &user)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET first_name = $2, last_name = $3, birthday = $4, login = $5, login_index = $6, key_id = $7, password = $8, email_verified_at = CASE WHEN login_index = $6 THEN email_verified_at END, version = version + 1 WHERE id = $1`, user.Id, sealed.firstName, sealed.lastName, sealed.birthday, sealed.login, sealed.loginIndex, sealed.keyId, user.Password)
 	var pqErr *pq.Error
 	if err != nil {
 		if errors.As(err, &pqErr) && pqErr.Code == NOT_UNIQUE_LOGIN {
-			return err
+			return false, err
 		}
 		logger.Logger.Error(fmt.Sprintf("Updating user error: %v", err))
-		return fmt.Errorf("updating postgres user error: %v", err)
+		return false, fmt.Errorf("updating postgres user error: %v", err)
 	}
 
-	err = s.cache.DelKey(user.Id)
+	// После смены пароля остальные устройства должны войти заново
+	if user.Password != before.Password {
+		_, err = revokeOtherSessions(ctx, tx, user.Id)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	user.Version = before.Version + 1
+	user.CreatedAt = before.CreatedAt
+	diff := diffFields(auditFields(before), auditFields(&user))
+	err = writeAudit(ctx, tx, user.Id, domain.AUDIT_UPDATE, diff)
 	if err != nil {
-		logger.Logger.Error(fmt.Sprintf("Deleating Redis key error: %v", err))
+		return false, err
+	}
+
+	err = writeEvent(ctx, tx, domain.EVENT_USER_UPDATED, eventPayload(&user, diff))
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Patch обновляет только переданные поля пользователя
+func (s *UserService) Patch(ctx context.Context, id domain.Id, patch domain.UserPatch) (bool, error) {
+	if patch.Empty() {
+		return true, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	logger.Logger.Debug("Patching user...")
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	before, err := s.lock(ctx, tx, id, false)
+	if err != nil {
+		return false, err
+	}
+
+	if before == nil {
+		if patch.Version != 0 {
+			return false, domain.ErrVersionMismatch
+		}
+		return false, nil
+	}
+
+	if patch.Version != 0 && patch.Version != before.Version {
+		return false, domain.ErrVersionMismatch
+	}
+
+	changed, err := s.applyPatch(ctx, tx, before, patch)
+	if err != nil || !changed {
+		return err == nil, err
+	}
+
+	err = s.commit(tx)
+	if err != nil {
+		return false, err
+	}
+
+	s.invalidate(ctx, id)
+	logger.Logger.Debug("The user has been patched successful")
+	return true, nil
+}
+
+// applyPatch обновляет переданные поля заблокированного пользователя в транзакции tx и пишет журнал и событие
+// user приводится к новому состоянию, false если менять нечего
+func (s *UserService) applyPatch(ctx context.Context, tx *sql.Tx, user *domain.User, patch domain.UserPatch) (bool, error) {
+	// Тот же пароль не меняется: иначе сессии на других устройствах отзывались бы без смены пароля
+	if patch.Password != nil && patch.PlainPassword != "" {
+		same, err := s.hasher.Verify(patch.PlainPassword, user.Password)
+		if err == nil && same {
+			patch.Password = nil
+		}
+	}
+
+	if patch.Empty() {
+		return false, nil
+	}
+
+	before, after := *user, *user
+	set := []string{"version = version + 1"}
+	args := []any{user.Id}
+	column := func(name string, value any) {
+		args = append(args, value)
+		set = append(set, fmt.Sprintf("%s = $%d", name, len(args)))
+	}
+
+	if patch.FirstName != nil {
+		after.FirstName = *patch.FirstName
+	}
+	if patch.LastName != nil {
+		after.LastName = *patch.LastName
+	}
+	if patch.BirthDay != nil {
+		after.BirthDay = patch.BirthDay
+	}
+	if patch.ClearBirthDay {
+		after.BirthDay = nil
+	}
+	if patch.Login != nil {
+		after.Login = *patch.Login
+	}
+
+	// Персональные данные строки шифруются заново целиком, чтобы все поля были на одном ключе
+	if patch.FirstName != nil || patch.LastName != nil || patch.BirthDay != nil || patch.ClearBirthDay || patch.Login != nil {
+		sealed, err := sealUser(s.cipher, &after)
+		if err != nil {
+			return false, err
+		}
+
+		column("first_name", sealed.firstName)
+		column("last_name", sealed.lastName)
+		column("birthday", sealed.birthday)
+		column("login", sealed.login)
+		column("login_index", sealed.loginIndex)
+		column("key_id", sealed.keyId)
+	}
+	// Новый email нужно подтвердить заново
+	if after.Login != before.Login {
+		set = append(set, "email_verified_at = NULL")
+	}
+	if patch.Password != nil {
+		after.Password = *patch.Password
+		column("password", after.Password)
+	}
+
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE users SET %s WHERE id = $1`, strings.Join(set, ", ")), args...)
+	var pqErr *pq.Error
+	if err != nil {
+		if errors.As(err, &pqErr) && pqErr.Code == NOT_UNIQUE_LOGIN {
+			return false, err
+		}
+		logger.Logger.Error(fmt.Sprintf("Patching user error: %v", err))
+		return false, fmt.Errorf("patching postgres user error: %v", err)
+	}
+
+	if patch.Password != nil {
+		_, err = revokeOtherSessions(ctx, tx, user.Id)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	after.Version++
+	diff := diffFields(auditFields(&before), auditFields(&after))
+	err = writeAudit(ctx, tx, user.Id, domain.AUDIT_UPDATE, diff)
+	if err != nil {
+		return false, err
+	}
+
+	err = writeEvent(ctx, tx, domain.EVENT_USER_UPDATED, eventPayload(&after, diff))
+	if err != nil {
+		return false, err
+	}
+
+	*user = after
+	return true, nil
+}
+
+// Delete мягко удаляет пользователя и отзывает его сессии
+// При ненулевой версии проверяет ее совпадение
+func (s *UserService) Delete(ctx context.Context, id domain.Id, version uint64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	logger.Logger.Debug("Deleting user...")
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	before, err := s.lock(ctx, tx, id, false)
+	if err != nil {
+		return false, err
+	}
+
+	if before == nil {
+		if version != 0 {
+			return false, domain.ErrVersionMismatch
+		}
+		return false, nil
+	}
+
+	if version != 0 && version != before.Version {
+		return false, domain.ErrVersionMismatch
+	}
+
+	err = s.remove(ctx, tx, before)
+	if err != nil {
+		return false, err
+	}
+
+	err = s.commit(tx)
+	if err != nil {
+		return false, err
+	}
+
+	s.invalidate(ctx, id)
+	logger.Logger.Debug("The user has been deleted successful")
+	return true, nil
+}
+
+// remove мягко удаляет заблокированного пользователя в транзакции tx, отзывает его сессии
+// и пишет журнал и событие
+func (s *UserService) remove(ctx context.Context, tx *sql.Tx, before *domain.User) error {
+	var deletedAt time.Time
+	err := tx.QueryRowContext(ctx, `UPDATE users SET deleted_at = NOW(), version = version + 1 WHERE id = $1 RETURNING deleted_at`, before.Id).Scan(&deletedAt)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Deleting user error: %v", err))
+		return fmt.Errorf("deleting postgres user error: %v", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, before.Id)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Revoking user sessions error: %v", err))
+		return fmt.Errorf("revoking postgres sessions error: %v", err)
+	}
+
+	err = writeAudit(ctx, tx, before.Id, domain.AUDIT_DELETE, map[string]domain.FieldChange{
+		"deleted_at": {Old: nil, New: deletedAt},
+	})
+	if err != nil {
+		return err
+	}
+
+	before.Version++
+	before.DeletedAt = &deletedAt
+	return writeEvent(ctx, tx, domain.EVENT_USER_DELETED, eventPayload(before, nil))
+}
+
+// Restore восстанавливает мягко удаленного пользователя
+func (s *UserService) Restore(ctx context.Context, id domain.Id) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	logger.Logger.Debug("Restoring user...")
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	before, err := s.lock(ctx, tx, id, true)
+	if err != nil {
+		return false, err
+	}
+
+	if before == nil || before.DeletedAt == nil {
+		return false, nil
+	}
+
+	restored, err := s.restore(ctx, tx, before)
+	if err != nil || !restored {
+		return false, err
+	}
+
+	err = s.commit(tx)
+	if err != nil {
+		return false, err
+	}
+
+	s.invalidate(ctx, id)
+	logger.Logger.Debug("The user has been restored successful")
+	return true, nil
+}
+
+// restore снимает мягкое удаление с заблокированного пользователя в транзакции tx и пишет журнал и событие
+// Обезличенного пользователя восстановить нельзя, для него возвращается false
+func (s *UserService) restore(ctx context.Context, tx *sql.Tx, before *domain.User) (bool, error) {
+	result, err := tx.ExecContext(ctx, `UPDATE users SET deleted_at = NULL, version = version + 1 WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM user_erasures WHERE user_id = $1)`, before.Id)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Restoring user error: %v", err))
+		return false, fmt.Errorf("restoring postgres user error: %v", err)
+	}
+
+	restored, err := result.RowsAffected()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Restoring user error: %v", err))
+		return false, fmt.Errorf("restoring postgres user error: %v", err)
+	}
+
+	if restored == 0 {
+		return false, nil
+	}
+
+	diff := map[string]domain.FieldChange{
+		"deleted_at": {Old: before.DeletedAt, New: nil},
+	}
+	err = writeAudit(ctx, tx, before.Id, domain.AUDIT_RESTORE, diff)
+	if err != nil {
+		return false, err
+	}
+
+	before.Version++
+	before.DeletedAt = nil
+	return true, writeEvent(ctx, tx, domain.EVENT_USER_UPDATED, eventPayload(before, diff))
+}
+
+// SetState обновляет переданные поля и приводит пользователя к состоянию active в одной транзакции:
+// неактивный пользователь сначала восстанавливается, деактивируемый удаляется мягко после обновления
+// Возвращает false, если пользователя нет или он обезличен и не может быть восстановлен
+// Неактивного пользователя без восстановления изменить нельзя: ErrUserInactive
+func (s *UserService) SetState(ctx context.Context, id domain.Id, patch domain.UserPatch, active bool) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	logger.Logger.Debug("Setting user state...")
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	user, err := s.lock(ctx, tx, id, true)
+	if err != nil || user == nil {
+		return false, err
+	}
+
+	wasActive := user.DeletedAt == nil
+	if !wasActive && !active && !patch.Empty() {
+		return false, domain.ErrUserInactive
+	}
+
+	changed := false
+	if !wasActive && active {
+		changed, err = s.restore(ctx, tx, user)
+		if err != nil || !changed {
+			return false, err
+		}
+	}
+
+	patched, err := s.applyPatch(ctx, tx, user, patch)
+	if err != nil {
+		return false, err
+	}
+	changed = changed || patched
+
+	if wasActive && !active {
+		err = s.remove(ctx, tx, user)
+		if err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	if !changed {
+		return true, nil
+	}
+
+	err = s.commit(tx)
+	if err != nil {
+		return false, err
+	}
+
+	s.invalidate(ctx, id)
+	logger.Logger.Debug("The user state has been set successful")
+	return true, nil
+}
+
+// Purge безвозвратно удаляет пользователя вместе с его сессиями
+// Журнал изменений пользователя сохраняется
+func (s *UserService) Purge(ctx context.Context, id domain.Id) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	logger.Logger.Debug("Purging user...")
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	before, err := s.lock(ctx, tx, id, true)
+	if err != nil {
+		return false, err
+	}
+
+	if before == nil {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Purging user error: %v", err))
+		return false, fmt.Errorf("purging postgres user error: %v", err)
+	}
+
+	err = writeAudit(ctx, tx, id, domain.AUDIT_PURGE, diffFields(auditFields(before), auditFields(nil)))
+	if err != nil {
+		return false, err
+	}
+
+	payload := eventPayload(before, nil)
+	payload.Purged = true
+	err = writeEvent(ctx, tx, domain.EVENT_USER_DELETED, payload)
+	if err != nil {
+		return false, err
+	}
+
+	err = s.commit(tx)
+	if err != nil {
+		return false, err
+	}
+
+	s.invalidate(ctx, id)
+	logger.Logger.Debug("The user has been purged successful")
+	return true, nil
+}
+
+// lock читает пользователя вместе с хэшем пароля и блокирует строку до конца транзакции
+// Возвращает nil, если пользователя нет
+func (s *UserService) lock(ctx context.Context, tx *sql.Tx, id domain.Id, withDeleted bool) (*domain.User, error) {
+	var row userRow
+	err := tx.QueryRowContext(ctx, `SELECT id, first_name, last_name, birthday, login, password, version, created_at, deleted_at FROM users WHERE id = $1 AND ($2 OR deleted_at IS NULL) AND tenant_id = $3 FOR UPDATE`, id, withDeleted, domain.TenantFrom(ctx)).Scan(&row.user.Id, &row.firstName, &row.lastName, &row.birthday, &row.login, &row.user.Password, &row.user.Version, &row.user.CreatedAt, &row.user.DeletedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Locking user error: %v", err))
+		return nil, fmt.Errorf("locking postgres user error: %v", err)
+	}
+
+	return row.decrypt(s.cipher)
+}
+
+// begin открывает транзакцию
+func (s *UserService) begin(ctx context.Context) (*sql.Tx, error) {
+	tx, err := s.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Beginning transaction error: %v", err))
+		return nil, fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+
+	return tx, nil
+}
+
+// commit фиксирует транзакцию
+func (s *UserService) commit(tx *sql.Tx) error {
+	err := tx.Commit()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Committing transaction error: %v", err))
+		return fmt.Errorf("committing postgres transaction error: %v", err)
 	}
 
 	return nil
 }
+
+// invalidate удаляет пользователя организации из контекста из кэша
+func (s *UserService) invalidate(ctx context.Context, id domain.Id) {
+	err := s.cache.DelKey(domain.TenantFrom(ctx), id)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Deleating Redis key error: %v", err))
+	}
+}
@@ -0,0 +1,170 @@
+package realization
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тест полного обновления: тот же пароль сохраняет старый хэш и сессии, новый пароль отзывает остальные сессии
+func TestUpdate_Password(t *testing.T) {
+	s, mock, _ := newMockService(t)
+	stored, err := s.hasher.Hash("StrongPassword123!")
+	require.NoError(t, err)
+
+	update := func(password string, expect func()) {
+		hash, err := s.hasher.Hash(password)
+		require.NoError(t, err)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FOR UPDATE`).
+			WithArgs(domain.Id(5), false, domain.DEFAULT_TENANT).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "birthday", "login", "password", "version", "created_at", "deleted_at"}).
+				AddRow(5, encrypt(t, s.cipher, "John"), encrypt(t, s.cipher, ""), nil, encrypt(t, s.cipher, "john@example.com"), stored, 1, time.Now(), nil))
+		expect()
+		mock.ExpectExec(`INSERT INTO outbox`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		err = s.Update(context.Background(), domain.User{Id: 5, FirstName: "Jack", Login: "john@example.com", Password: hash, PlainPassword: password})
+		require.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	}
+
+	update("StrongPassword123!", func() {
+		mock.ExpectExec(`UPDATE users SET`).
+			WithArgs(domain.Id(5), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), stored).
+			WillReturnResult(driver.RowsAffected(1))
+		mock.ExpectExec(`INSERT INTO user_audit`).
+			WithArgs(domain.Id(5), domain.DEFAULT_TENANT, nil, "", domain.AUDIT_UPDATE, []byte(`{"name":{"old":"[REDACTED]","new":"[REDACTED]"}}`)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	})
+
+	update("OtherPassword123!", func() {
+		mock.ExpectExec(`UPDATE users SET`).WillReturnResult(driver.RowsAffected(1))
+		mock.ExpectExec(`UPDATE sessions SET revoked_at`).WithArgs(domain.Id(5), nil).WillReturnResult(driver.RowsAffected(1))
+		mock.ExpectExec(`INSERT INTO user_audit`).
+			WithArgs(domain.Id(5), domain.DEFAULT_TENANT, nil, "", domain.AUDIT_UPDATE, []byte(`{"name":{"old":"[REDACTED]","new":"[REDACTED]"},"password":{"old":"[REDACTED]","new":"[REDACTED]"}}`)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	})
+}
+
+// Тест частичного обновления: тот же пароль ничего не меняет и не отзывает сессии
+func TestPatch_Password(t *testing.T) {
+	s, mock, _ := newMockService(t)
+	stored, err := s.hasher.Hash("StrongPassword123!")
+	require.NoError(t, err)
+
+	patch := func(password string, expect func()) {
+		hash, err := s.hasher.Hash(password)
+		require.NoError(t, err)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FOR UPDATE`).
+			WithArgs(domain.Id(5), false, domain.DEFAULT_TENANT).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "birthday", "login", "password", "version", "created_at", "deleted_at"}).
+				AddRow(5, encrypt(t, s.cipher, "John"), encrypt(t, s.cipher, ""), nil, encrypt(t, s.cipher, "john@example.com"), stored, 1, time.Now(), nil))
+		expect()
+
+		ok, err := s.Patch(context.Background(), 5, domain.UserPatch{Password: &hash, PlainPassword: password})
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	}
+
+	patch("StrongPassword123!", func() {
+		mock.ExpectRollback()
+	})
+
+	patch("OtherPassword123!", func() {
+		mock.ExpectExec(`UPDATE users SET version = version \+ 1, password = \$2 WHERE id = \$1`).WillReturnResult(driver.RowsAffected(1))
+		mock.ExpectExec(`UPDATE sessions SET revoked_at`).WithArgs(domain.Id(5), nil).WillReturnResult(driver.RowsAffected(1))
+		mock.ExpectExec(`INSERT INTO user_audit`).
+			WithArgs(domain.Id(5), domain.DEFAULT_TENANT, nil, "", domain.AUDIT_UPDATE, []byte(`{"password":{"old":"[REDACTED]","new":"[REDACTED]"}}`)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`INSERT INTO outbox`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+	})
+}
+
+// Тест создания удаленного пользователя: создание и удаление выполняются в одной транзакции
+func TestCreateDeleted(t *testing.T) {
+	s, mock, _ := newMockService(t)
+	created := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO users`).WillReturnRows(sqlmock.NewRows([]string{"id", "version", "created_at"}).AddRow(5, 1, created))
+	mock.ExpectExec(`INSERT INTO user_audit`).WithArgs(domain.Id(5), domain.DEFAULT_TENANT, nil, "", domain.AUDIT_CREATE, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO outbox`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`FOR UPDATE`).
+		WithArgs(domain.Id(5), false, domain.DEFAULT_TENANT).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "birthday", "login", "password", "version", "created_at", "deleted_at"}).
+			AddRow(5, encrypt(t, s.cipher, ""), encrypt(t, s.cipher, ""), nil, encrypt(t, s.cipher, "john@example.com"), "hash", 1, created, nil))
+	mock.ExpectQuery(`UPDATE users SET deleted_at = NOW\(\)`).WithArgs(domain.Id(5)).WillReturnRows(sqlmock.NewRows([]string{"deleted_at"}).AddRow(time.Now()))
+	mock.ExpectExec(`UPDATE sessions SET revoked_at`).WithArgs(domain.Id(5)).WillReturnResult(driver.RowsAffected(0))
+	mock.ExpectExec(`INSERT INTO user_audit`).WithArgs(domain.Id(5), domain.DEFAULT_TENANT, nil, "", domain.AUDIT_DELETE, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO outbox`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	id, err := s.CreateDeleted(context.Background(), domain.User{Login: "john@example.com", Password: "hash"})
+	require.NoError(t, err)
+	require.NotNil(t, id)
+	assert.Equal(t, domain.Id(5), *id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест смены состояния: восстановление, изменение полей и удаление выполняются в одной транзакции
+func TestSetState(t *testing.T) {
+	s, mock, _ := newMockService(t)
+	name := "Jack"
+
+	lock := func(deletedAt any) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FOR UPDATE`).
+			WithArgs(domain.Id(5), true, domain.DEFAULT_TENANT).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "birthday", "login", "password", "version", "created_at", "deleted_at"}).
+				AddRow(5, encrypt(t, s.cipher, "John"), encrypt(t, s.cipher, ""), nil, encrypt(t, s.cipher, "john@example.com"), "hash", 1, time.Now(), deletedAt))
+	}
+
+	lock(time.Now())
+	mock.ExpectExec(`UPDATE users SET deleted_at = NULL`).WithArgs(domain.Id(5)).WillReturnResult(driver.RowsAffected(1))
+	mock.ExpectExec(`INSERT INTO user_audit`).WithArgs(domain.Id(5), domain.DEFAULT_TENANT, nil, "", domain.AUDIT_RESTORE, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO outbox`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE users SET version = version \+ 1, first_name = \$2`).WillReturnResult(driver.RowsAffected(1))
+	mock.ExpectExec(`INSERT INTO user_audit`).WithArgs(domain.Id(5), domain.DEFAULT_TENANT, nil, "", domain.AUDIT_UPDATE, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO outbox`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	ok, err := s.SetState(context.Background(), 5, domain.UserPatch{FirstName: &name}, true)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	lock(nil)
+	mock.ExpectExec(`UPDATE users SET version = version \+ 1, first_name = \$2`).WillReturnResult(driver.RowsAffected(1))
+	mock.ExpectExec(`INSERT INTO user_audit`).WithArgs(domain.Id(5), domain.DEFAULT_TENANT, nil, "", domain.AUDIT_UPDATE, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO outbox`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`UPDATE users SET deleted_at = NOW\(\)`).WithArgs(domain.Id(5)).WillReturnRows(sqlmock.NewRows([]string{"deleted_at"}).AddRow(time.Now()))
+	mock.ExpectExec(`UPDATE sessions SET revoked_at`).WithArgs(domain.Id(5)).WillReturnResult(driver.RowsAffected(0))
+	mock.ExpectExec(`INSERT INTO user_audit`).WithArgs(domain.Id(5), domain.DEFAULT_TENANT, nil, "", domain.AUDIT_DELETE, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO outbox`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	ok, err = s.SetState(context.Background(), 5, domain.UserPatch{FirstName: &name}, false)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	lock(time.Now())
+	mock.ExpectRollback()
+
+	_, err = s.SetState(context.Background(), 5, domain.UserPatch{FirstName: &name}, false)
+	assert.ErrorIs(t, err, domain.ErrUserInactive)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,229 @@
+package realization
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"user/internal/domain"
+	"user/internal/interfaces"
+	"user/internal/presentation/db"
+	"user/internal/presentation/logger"
+)
+
+// VERIFY_SUBJECT - тема письма с подтверждением email
+const VERIFY_SUBJECT = "Confirm your email"
+
+// VerificationService подтверждает email пользователей
+// Токен - подписанные HMAC идентификатор выдачи, пользователь и время истечения:
+//
+//	base64url(<выдача>.<пользователь>.<истечение>).base64url(HMAC-SHA256)
+//
+// Подпись отсекает подделанные и просроченные токены без запроса к базе,
+// строка выдачи в email_verifications делает токен одноразовым
+// и привязывает его к email, на который отправлено письмо
+type VerificationService struct {
+	db     *db.DB
+	cipher interfaces.FieldCipher
+	mailer interfaces.Mailer
+	secret []byte
+	ttl    time.Duration
+	link   string
+}
+
+// NewVerificationService создает новый экземпляр VerificationService
+// cipher - шифрование персональных данных, email сравнивается по слепому индексу
+// mailer - отправка писем
+// secret - ключ подписи токенов
+// ttl - время жизни токена
+// link - начало ссылки в письме, токен дописывается в конец
+func NewVerificationService(db *db.DB, cipher interfaces.FieldCipher, mailer interfaces.Mailer, secret []byte, ttl time.Duration, link string) (*VerificationService, error) {
+	if len(secret) < KEY_SIZE {
+		return nil, fmt.Errorf("verification secret must be at least %d bytes", KEY_SIZE)
+	}
+
+	return &VerificationService{
+		db:     db,
+		cipher: cipher,
+		mailer: mailer,
+		secret: secret,
+		ttl:    ttl,
+		link:   link,
+	}, nil
+}
+
+// Send выдает токен подтверждения и отправляет его на email пользователя
+// Ранее выданные токены продолжают действовать до истечения
+func (s *VerificationService) Send(ctx context.Context, id domain.Id, login string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var tokenId domain.Id
+	expires := time.Now().Add(s.ttl).Truncate(time.Second)
+	err := s.db.Db.QueryRowContext(ctx, `INSERT INTO email_verifications (user_id, login_index, expires_at) VALUES ($1, $2, $3) RETURNING id`, id, s.cipher.BlindIndex(login), expires).Scan(&tokenId)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Creating email verification error: %v", err))
+		return fmt.Errorf("creating postgres email verification error: %v", err)
+	}
+
+	token := s.sign(tokenId, id, expires)
+	err = s.mailer.Send(ctx, domain.Mail{
+		To:      login,
+		Subject: VERIFY_SUBJECT,
+		Body:    fmt.Sprintf("Confirm your email by following the link:\n\n%s%s\n\nThe link is valid until %s.\n", s.link, token, expires.UTC().Format(time.RFC1123)),
+	})
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Sending verification email to user %d error: %v", id, err))
+		return err
+	}
+
+	logger.Logger.Info(fmt.Sprintf("Verification email has been sent to user %d", id))
+	return nil
+}
+
+// Resend повторно отправляет токен активному пользователю организации с неподтвержденным email
+// Если такого пользователя нет, письмо не отправляется и ошибка не возвращается,
+// чтобы по ответу нельзя было узнать, зарегистрирован ли email
+func (s *VerificationService) Resend(ctx context.Context, login string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var id domain.Id
+	err := s.db.Db.QueryRowContext(ctx, `SELECT id FROM users WHERE login_index = $1 AND tenant_id = $2 AND deleted_at IS NULL AND email_verified_at IS NULL`, s.cipher.BlindIndex(login), domain.TenantFrom(ctx)).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting unverified user error: %v", err))
+		return fmt.Errorf("getting postgres unverified user error: %v", err)
+	}
+
+	return s.Send(ctx, id, login)
+}
+
+// Verify подтверждает email по токену и записывает подтверждение в журнал
+// Токен не действует, если email пользователя сменился после его выдачи
+// Возвращает ErrInvalidToken, если токен неверный, просрочен или уже использован
+func (s *VerificationService) Verify(ctx context.Context, token string) error {
+	tokenId, userId, err := s.parse(token)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	tx, err := s.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Beginning transaction error: %v", err))
+		return fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	var loginIndex string
+	err = tx.QueryRowContext(ctx, `UPDATE email_verifications SET used_at = NOW() WHERE id = $1 AND user_id = $2 AND used_at IS NULL AND expires_at > NOW() RETURNING login_index`, tokenId, userId).Scan(&loginIndex)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.ErrInvalidToken
+		}
+		logger.Logger.Error(fmt.Sprintf("Using email verification error: %v", err))
+		return fmt.Errorf("using postgres email verification error: %v", err)
+	}
+
+	var (
+		tenant   domain.Id
+		verified *time.Time
+	)
+	err = tx.QueryRowContext(ctx, `SELECT tenant_id, email_verified_at FROM users WHERE id = $1 AND login_index = $2 AND deleted_at IS NULL FOR UPDATE`, userId, loginIndex).Scan(&tenant, &verified)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.ErrInvalidToken
+		}
+		logger.Logger.Error(fmt.Sprintf("Locking user error: %v", err))
+		return fmt.Errorf("locking postgres user error: %v", err)
+	}
+
+	if verified == nil {
+		_, err = tx.ExecContext(ctx, `UPDATE users SET email_verified_at = NOW() WHERE id = $1`, userId)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Verifying user email error: %v", err))
+			return fmt.Errorf("verifying postgres user email error: %v", err)
+		}
+
+		// Подтверждение приходит без сессии, журнал пишется в организации пользователя
+		err = writeAudit(domain.WithTenant(ctx, tenant), tx, userId, domain.AUDIT_VERIFY, map[string]domain.FieldChange{
+			"email_verified": {Old: false, New: true},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Committing transaction error: %v", err))
+		return fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	logger.Logger.Info(fmt.Sprintf("Email of user %d has been verified", userId))
+	return nil
+}
+
+// sign подписывает токен выдачи tokenId для пользователя userId
+func (s *VerificationService) sign(tokenId, userId domain.Id, expires time.Time) string {
+	payload := fmt.Sprintf("%d.%d.%d", tokenId, userId, expires.Unix())
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(s.mac(payload))
+}
+
+// parse проверяет подпись и срок токена и возвращает выдачу и пользователя
+func (s *VerificationService) parse(token string) (domain.Id, domain.Id, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, 0, domain.ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, 0, domain.ErrInvalidToken
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, s.mac(string(payload))) {
+		return 0, 0, domain.ErrInvalidToken
+	}
+
+	parts := strings.Split(string(payload), ".")
+	if len(parts) != 3 {
+		return 0, 0, domain.ErrInvalidToken
+	}
+
+	tokenId, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, domain.ErrInvalidToken
+	}
+
+	userId, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, domain.ErrInvalidToken
+	}
+
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || time.Now().Unix() >= expires {
+		return 0, 0, domain.ErrInvalidToken
+	}
+
+	return tokenId, userId, nil
+}
+
+// mac возвращает HMAC-SHA256 данных токена
+func (s *VerificationService) mac(payload string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
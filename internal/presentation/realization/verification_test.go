@@ -0,0 +1,85 @@
+package realization
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestVerification(t *testing.T) (*VerificationService, sqlmock.Sqlmock, *MemoryMailer) {
+	users, mock, _ := newMockService(t)
+	mailer := NewMemoryMailer()
+
+	s, err := NewVerificationService(users.db, users.cipher, mailer, make([]byte, KEY_SIZE), time.Hour, "https://example.com/verify?token=")
+	require.NoError(t, err)
+	return s, mock, mailer
+}
+
+// Тест подписи токена: подделанный и просроченный токены отклоняются без запроса к базе
+func TestVerificationToken(t *testing.T) {
+	s, _, _ := newTestVerification(t)
+
+	token := s.sign(5, 3, time.Now().Add(time.Hour))
+	tokenId, userId, err := s.parse(token)
+	require.NoError(t, err)
+	assert.Equal(t, domain.Id(5), tokenId)
+	assert.Equal(t, domain.Id(3), userId)
+
+	_, _, err = s.parse(s.sign(5, 3, time.Now().Add(-time.Second)))
+	assert.ErrorIs(t, err, domain.ErrInvalidToken)
+
+	payload, signature, _ := strings.Cut(token, ".")
+	_, _, err = s.parse(s.sign(5, 4, time.Now().Add(time.Hour))[:len(payload)] + "." + signature)
+	assert.ErrorIs(t, err, domain.ErrInvalidToken)
+
+	_, _, err = s.parse("not a token")
+	assert.ErrorIs(t, err, domain.ErrInvalidToken)
+
+	_, err = NewVerificationService(nil, nil, nil, []byte("short"), time.Hour, "")
+	assert.Error(t, err)
+}
+
+// Тест отправки и подтверждения: токен из письма подтверждает email и пишет журнал в организации пользователя
+func TestVerify(t *testing.T) {
+	s, mock, mailer := newTestVerification(t)
+
+	mock.ExpectQuery(`INSERT INTO email_verifications`).
+		WithArgs(domain.Id(3), s.cipher.BlindIndex("john@example.com"), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	require.NoError(t, s.Send(context.Background(), 3, "john@example.com"))
+
+	sent := mailer.Mails()
+	require.Len(t, sent, 1)
+	assert.Equal(t, "john@example.com", sent[0].To)
+	_, rest, ok := strings.Cut(sent[0].Body, "https://example.com/verify?token=")
+	require.True(t, ok)
+	token, _, _ := strings.Cut(rest, "\n")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE email_verifications SET used_at = NOW\(\) WHERE id = \$1 AND user_id = \$2 AND used_at IS NULL`).
+		WithArgs(domain.Id(9), domain.Id(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"login_index"}).AddRow(s.cipher.BlindIndex("john@example.com")))
+	mock.ExpectQuery(`FROM users WHERE id = \$1 AND login_index = \$2 AND deleted_at IS NULL FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"tenant_id", "email_verified_at"}).AddRow(2, nil))
+	mock.ExpectExec(`UPDATE users SET email_verified_at = NOW\(\)`).
+		WithArgs(domain.Id(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO user_audit`).
+		WithArgs(domain.Id(3), domain.Id(2), nil, "", domain.AUDIT_VERIFY, []byte(`{"email_verified":{"old":false,"new":true}}`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, s.Verify(context.Background(), token))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE email_verifications`).WillReturnRows(sqlmock.NewRows([]string{"login_index"}))
+	mock.ExpectRollback()
+	assert.ErrorIs(t, s.Verify(context.Background(), token), domain.ErrInvalidToken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
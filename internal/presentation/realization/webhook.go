@@ -0,0 +1,552 @@
+package realization
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+	"user/internal/domain"
+	"user/internal/presentation/db"
+	"user/internal/presentation/logger"
+
+	"github.com/lib/pq"
+)
+
+const (
+	WEBHOOK_ID_HEADER        = "X-Webhook-Id"
+	WEBHOOK_TIMESTAMP_HEADER = "X-Webhook-Timestamp"
+	WEBHOOK_SIGNATURE_HEADER = "X-Webhook-Signature"
+
+	// SIGNATURE_PREFIX предваряет подпись в заголовке X-Webhook-Signature
+	SIGNATURE_PREFIX = "sha256="
+)
+
+// WebhookService управляет подписками на события и раскладывает события по доставкам
+// Реализует EventPublisher, поэтому подключается к OutboxRelay
+type WebhookService struct {
+	db *db.DB
+}
+
+// NewWebhookService создает новый экземпляр WebhookService
+func NewWebhookService(db *db.DB) *WebhookService {
+	return &WebhookService{
+		db: db,
+	}
+}
+
+// Create создает подписку со случайным ключом подписи
+func (s *WebhookService) Create(ctx context.Context, webhook domain.Webhook) (*domain.Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	secret, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if webhook.Events == nil {
+		webhook.Events = []string{}
+	}
+	webhook.Secret = secret
+	webhook.OwnerId = domain.ActorFrom(ctx).UserId
+
+	logger.Logger.Debug("Creating webhook...")
+	err = s.db.Db.QueryRowContext(ctx, `INSERT INTO webhooks (tenant_id, url, events, secret, owner_id) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`, domain.TenantFrom(ctx), webhook.Url, pq.Array(webhook.Events), webhook.Secret, webhook.OwnerId).Scan(&webhook.Id, &webhook.CreatedAt)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Creating webhook error: %v", err))
+		return nil, fmt.Errorf("creating postgres webhook error: %v", err)
+	}
+
+	return &webhook, nil
+}
+
+// Get возвращает подписку без ключа подписи
+func (s *WebhookService) Get(ctx context.Context, id domain.Id) (*domain.Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var webhook domain.Webhook
+	err := s.db.Db.QueryRowContext(ctx, `SELECT id, url, events, owner_id, created_at FROM webhooks WHERE id = $1 AND tenant_id = $2`, id, domain.TenantFrom(ctx)).Scan(&webhook.Id, &webhook.Url, pq.Array(&webhook.Events), &webhook.OwnerId, &webhook.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Logger.Error(fmt.Sprintf("Getting webhook error: %v", err))
+		return nil, fmt.Errorf("getting postgres webhook error: %v", err)
+	}
+
+	return &webhook, nil
+}
+
+// List возвращает все подписки без ключей подписи
+func (s *WebhookService) List(ctx context.Context) ([]domain.Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	rows, err := s.db.Db.QueryContext(ctx, `SELECT id, url, events, owner_id, created_at FROM webhooks WHERE tenant_id = $1 ORDER BY id`, domain.TenantFrom(ctx))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting webhooks error: %v", err))
+		return nil, fmt.Errorf("getting postgres webhooks error: %v", err)
+	}
+	defer rows.Close()
+
+	webhooks := []domain.Webhook{}
+	for rows.Next() {
+		var webhook domain.Webhook
+		err = rows.Scan(&webhook.Id, &webhook.Url, pq.Array(&webhook.Events), &webhook.OwnerId, &webhook.CreatedAt)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning webhook error: %v", err))
+			return nil, fmt.Errorf("scanning postgres webhook error: %v", err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting webhooks error: %v", err))
+		return nil, fmt.Errorf("getting postgres webhooks error: %v", err)
+	}
+
+	return webhooks, nil
+}
+
+// Delete удаляет подписку, доставки и попытки удаляются каскадно
+func (s *WebhookService) Delete(ctx context.Context, id domain.Id) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	res, err := s.db.Db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1 AND tenant_id = $2`, id, domain.TenantFrom(ctx))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Deleting webhook error: %v", err))
+		return false, fmt.Errorf("deleting postgres webhook error: %v", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("deleting postgres webhook error: %v", err)
+	}
+
+	return n > 0, nil
+}
+
+// Deliveries возвращает страницу доставок подписки, начиная с новых
+// cursor - идентификатор последней доставки предыдущей страницы
+func (s *WebhookService) Deliveries(ctx context.Context, webhookId domain.Id, cursor string, limit int) (*domain.DeliveryPage, error) {
+	var before uint64
+	if cursor != "" {
+		var err error
+		before, err = strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			return nil, domain.ErrInvalidCursor
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	limit = listLimit(limit)
+	rows, err := s.db.Db.QueryContext(ctx, `SELECT id, webhook_id, event_id, event_type, status, attempts, next_attempt_at, last_error, created_at, delivered_at FROM webhook_deliveries WHERE webhook_id = $1 AND ($2 = 0 OR id < $2) ORDER BY id DESC LIMIT $3`, webhookId, before, limit+1)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting webhook deliveries error: %v", err))
+		return nil, fmt.Errorf("getting postgres webhook deliveries error: %v", err)
+	}
+	defer rows.Close()
+
+	page := &domain.DeliveryPage{
+		Deliveries: make([]domain.WebhookDelivery, 0, limit),
+	}
+	ids := []int64{}
+	index := map[domain.Id]int{}
+	for rows.Next() {
+		if len(page.Deliveries) == limit {
+			page.NextCursor = strconv.FormatUint(page.Deliveries[limit-1].Id, 10)
+			break
+		}
+
+		var (
+			d             domain.WebhookDelivery
+			nextAttemptAt time.Time
+		)
+		err = rows.Scan(&d.Id, &d.WebhookId, &d.EventId, &d.EventType, &d.Status, &d.Attempts, &nextAttemptAt, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning webhook delivery error: %v", err))
+			return nil, fmt.Errorf("scanning postgres webhook delivery error: %v", err)
+		}
+
+		// Время следующей попытки имеет смысл только для ожидающих доставок
+		if d.Status == domain.DELIVERY_PENDING {
+			d.NextAttemptAt = &nextAttemptAt
+		}
+		d.History = []domain.WebhookAttempt{}
+
+		index[d.Id] = len(page.Deliveries)
+		ids = append(ids, int64(d.Id))
+		page.Deliveries = append(page.Deliveries, d)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting webhook deliveries error: %v", err))
+		return nil, fmt.Errorf("getting postgres webhook deliveries error: %v", err)
+	}
+	rows.Close()
+
+	if len(ids) == 0 {
+		return page, nil
+	}
+
+	attempts, err := s.db.Db.QueryContext(ctx, `SELECT id, delivery_id, status_code, error, duration_ms, attempted_at FROM webhook_attempts WHERE delivery_id = ANY($1) ORDER BY id`, pq.Array(ids))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting webhook attempts error: %v", err))
+		return nil, fmt.Errorf("getting postgres webhook attempts error: %v", err)
+	}
+	defer attempts.Close()
+
+	for attempts.Next() {
+		var (
+			a          domain.WebhookAttempt
+			deliveryId domain.Id
+		)
+		err = attempts.Scan(&a.Id, &deliveryId, &a.StatusCode, &a.Error, &a.DurationMs, &a.AttemptedAt)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Scanning webhook attempt error: %v", err))
+			return nil, fmt.Errorf("scanning postgres webhook attempt error: %v", err)
+		}
+
+		i := index[deliveryId]
+		page.Deliveries[i].History = append(page.Deliveries[i].History, a)
+	}
+
+	err = attempts.Err()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Getting webhook attempts error: %v", err))
+		return nil, fmt.Errorf("getting postgres webhook attempts error: %v", err)
+	}
+
+	return page, nil
+}
+
+// Redeliver ставит доставку в очередь заново со сбросом счетчика попыток
+// История прошлых попыток сохраняется
+func (s *WebhookService) Redeliver(ctx context.Context, webhookId, deliveryId domain.Id) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	res, err := s.db.Db.ExecContext(ctx, `UPDATE webhook_deliveries SET status = $3, attempts = 0, next_attempt_at = NOW(), delivered_at = NULL WHERE id = $1 AND webhook_id = (SELECT id FROM webhooks WHERE id = $2 AND tenant_id = $4)`, deliveryId, webhookId, domain.DELIVERY_PENDING, domain.TenantFrom(ctx))
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Redelivering webhook error: %v", err))
+		return false, fmt.Errorf("redelivering postgres webhook error: %v", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("redelivering postgres webhook error: %v", err)
+	}
+
+	return n > 0, nil
+}
+
+// Publish создает доставки события для всех подходящих подписок организации события
+// Повторная публикация того же события не создает дублей
+func (s *WebhookService) Publish(ctx context.Context, event domain.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("event marshalling error: %v", err)
+	}
+
+	_, err = s.db.Db.ExecContext(ctx, `INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, body) SELECT id, $1, $2, $3 FROM webhooks WHERE tenant_id = $4 AND (cardinality(events) = 0 OR $2 = ANY(events)) ON CONFLICT (webhook_id, event_id) DO NOTHING`, event.Id, event.Type, body, event.TenantId)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Creating webhook deliveries error: %v", err))
+		return fmt.Errorf("creating postgres webhook deliveries error: %v", err)
+	}
+
+	return nil
+}
+
+// WebhookDispatcher отправляет ожидающие доставки получателям
+// Неудачные попытки повторяются с экспоненциальной задержкой,
+// после maxAttempts попыток доставка переводится в dead
+type WebhookDispatcher struct {
+	db          *db.DB
+	client      *http.Client
+	lease       time.Duration
+	interval    time.Duration
+	batchSize   int
+	maxAttempts int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+}
+
+// NewWebhookDispatcher создает новый экземпляр WebhookDispatcher
+// interval - период опроса доставок
+// batchSize - количество доставок за один проход
+// maxAttempts - количество попыток до перевода доставки в dead
+// timeout - время ожидания ответа получателя
+// Адреса получателей принадлежат организациям, поэтому доставки не ходят во внутреннюю сеть и не следуют редиректам
+func NewWebhookDispatcher(db *db.DB, interval time.Duration, batchSize, maxAttempts int, timeout time.Duration) *WebhookDispatcher {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: publicAddressOnly,
+	}
+
+	return &WebhookDispatcher{
+		db: db,
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: timeout,
+				MaxIdleConns:        100,
+				IdleConnTimeout:     90 * time.Second,
+			},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		// Аренда покрывает отправку всей пачки, даже если каждый получатель отвечает по таймауту
+		lease:       timeout*time.Duration(batchSize) + time.Minute,
+		interval:    interval,
+		batchSize:   batchSize,
+		maxAttempts: maxAttempts,
+		minBackoff:  time.Second * 10,
+		maxBackoff:  time.Hour * 6,
+	}
+}
+
+// Run отправляет доставки до отмены контекста
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	logger.Logger.Info("Webhook dispatcher has been started")
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := d.DispatchBatch(ctx)
+			if err != nil {
+				logger.Logger.Error(fmt.Sprintf("Dispatching webhooks error: %v", err))
+			}
+
+			if err != nil || n < d.batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Logger.Info("Webhook dispatcher has been stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchBatch отправляет одну пачку готовых доставок и возвращает их количество
+// Доставки захватываются одним запросом: next_attempt_at сдвигается на время аренды,
+// поэтому строки не остаются заблокированными на время запросов к получателям
+// Попытки и результаты записываются второй короткой транзакцией
+func (d *WebhookDispatcher) DispatchBatch(ctx context.Context) (int, error) {
+	batch, err := d.claim(ctx)
+	if err != nil || len(batch) == 0 {
+		return 0, err
+	}
+
+	type result struct {
+		code     int
+		err      error
+		duration int64
+	}
+
+	results := make([]result, len(batch))
+	for i, p := range batch {
+		start := time.Now()
+		results[i].code, results[i].err = d.send(ctx, p.url, p.secret, p.id, p.eventId, p.eventType, p.body)
+		results[i].duration = time.Since(start).Milliseconds()
+	}
+
+	tx, err := d.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("beginning postgres transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	for i, p := range batch {
+		var (
+			statusCode *int
+			errText    *string
+		)
+		if results[i].code != 0 {
+			statusCode = &results[i].code
+		}
+		if results[i].err != nil {
+			text := results[i].err.Error()
+			errText = &text
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO webhook_attempts (delivery_id, status_code, error, duration_ms) VALUES ($1, $2, $3, $4)`, p.id, statusCode, errText, results[i].duration)
+		if err != nil {
+			return 0, fmt.Errorf("writing postgres webhook attempt error: %v", err)
+		}
+
+		if results[i].err == nil {
+			_, err = tx.ExecContext(ctx, `UPDATE webhook_deliveries SET status = $2, delivered_at = NOW(), last_error = NULL WHERE id = $1`, p.id, domain.DELIVERY_SUCCEEDED)
+			if err != nil {
+				return 0, fmt.Errorf("marking postgres webhook delivery error: %v", err)
+			}
+			continue
+		}
+
+		logger.Logger.Warn(fmt.Sprintf("Delivering webhook %d error: %v", p.id, results[i].err))
+		status := domain.DELIVERY_PENDING
+		if p.attempts+1 >= d.maxAttempts {
+			status = domain.DELIVERY_DEAD
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE webhook_deliveries SET status = $2, attempts = attempts + 1, next_attempt_at = $3, last_error = $4 WHERE id = $1`, p.id, status, time.Now().Add(backoff(p.attempts, d.minBackoff, d.maxBackoff)), *errText)
+		if err != nil {
+			return 0, fmt.Errorf("rescheduling postgres webhook delivery error: %v", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("committing postgres transaction error: %v", err)
+	}
+
+	return len(batch), nil
+}
+
+// pendingDelivery - захваченная доставка вместе с адресом и ключом подписи получателя
+type pendingDelivery struct {
+	id        domain.Id
+	eventId   domain.Id
+	eventType string
+	body      []byte
+	attempts  int
+	url       string
+	secret    string
+}
+
+// claim захватывает пачку готовых доставок на время аренды
+// Строки блокируются с SKIP LOCKED только на время захвата
+func (d *WebhookDispatcher) claim(ctx context.Context) ([]pendingDelivery, error) {
+	rows, err := d.db.Db.QueryContext(ctx, `WITH claimed AS (SELECT id FROM webhook_deliveries WHERE status = $1 AND next_attempt_at <= NOW() ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED) UPDATE webhook_deliveries d SET next_attempt_at = $3 FROM claimed, webhooks w WHERE d.id = claimed.id AND w.id = d.webhook_id RETURNING d.id, d.event_id, d.event_type, d.body, d.attempts, w.url, w.secret`, domain.DELIVERY_PENDING, d.batchSize, time.Now().Add(d.lease))
+	if err != nil {
+		return nil, fmt.Errorf("claiming postgres webhook deliveries error: %v", err)
+	}
+	defer rows.Close()
+
+	batch := []pendingDelivery{}
+	for rows.Next() {
+		var p pendingDelivery
+		err = rows.Scan(&p.id, &p.eventId, &p.eventType, &p.body, &p.attempts, &p.url, &p.secret)
+		if err != nil {
+			return nil, fmt.Errorf("scanning postgres webhook delivery error: %v", err)
+		}
+		batch = append(batch, p)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("claiming postgres webhook deliveries error: %v", err)
+	}
+
+	// RETURNING не сохраняет порядок выборки, а доставки отправляются в порядке событий
+	sort.Slice(batch, func(i, j int) bool {
+		return batch[i].id < batch[j].id
+	})
+
+	return batch, nil
+}
+
+// publicAddressOnly запрещает соединения с loopback, частными, link-local и служебными адресами
+// Проверяется адрес после разрешения имени, поэтому DNS не позволяет обойти запрет
+func publicAddressOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("webhook address %s is not public", host)
+	}
+
+	return nil
+}
+
+// isPublicIP сообщает, что адрес доступен из интернета
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified() && !carrierGradeNat.Contains(ip)
+}
+
+// carrierGradeNat - разделяемое адресное пространство 100.64.0.0/10 (RFC 6598)
+var carrierGradeNat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// send выполняет одну попытку доставки и возвращает код ответа, 0 при сетевой ошибке
+func (d *WebhookDispatcher) send(ctx context.Context, url, secret string, id, eventId domain.Id, eventType string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("creating webhook request error: %v", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WEBHOOK_ID_HEADER, strconv.FormatUint(id, 10))
+	req.Header.Set("X-Event-Id", strconv.FormatUint(eventId, 10))
+	req.Header.Set("X-Event-Type", eventType)
+	req.Header.Set(WEBHOOK_TIMESTAMP_HEADER, timestamp)
+	req.Header.Set(WEBHOOK_SIGNATURE_HEADER, SignWebhook(secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("sending webhook error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// SignWebhook возвращает подпись доставки для заголовка X-Webhook-Signature
+// Подписывается строка "<timestamp>.<body>", поэтому подпись нельзя переиспользовать с другим временем
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return SIGNATURE_PREFIX + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook проверяет подпись доставки на стороне получателя
+// tolerance - допустимое расхождение времени, защищает от повторной отправки перехваченных запросов
+func VerifyWebhook(secret, timestamp string, body []byte, signature string, tolerance time.Duration, now time.Time) bool {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	diff := now.Sub(time.Unix(unix, 0))
+	if diff > tolerance || diff < -tolerance {
+		return false
+	}
+
+	return hmac.Equal([]byte(SignWebhook(secret, timestamp, body)), []byte(signature))
+}
@@ -0,0 +1,155 @@
+package realization
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"user/internal/domain"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тест проверки подписи доставки
+func TestVerifyWebhook(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":1}`)
+	signature := SignWebhook("secret", "1700000000", body)
+
+	assert.True(t, VerifyWebhook("secret", "1700000000", body, signature, time.Minute*5, now))
+	assert.False(t, VerifyWebhook("other", "1700000000", body, signature, time.Minute*5, now))
+	assert.False(t, VerifyWebhook("secret", "1700000000", []byte(`{"id":2}`), signature, time.Minute*5, now))
+	assert.False(t, VerifyWebhook("secret", "1700000000", body, signature, time.Minute*5, now.Add(time.Minute*10)))
+	assert.False(t, VerifyWebhook("secret", "1700000001", body, signature, time.Minute*5, now))
+}
+
+// newLoopbackDispatcher возвращает диспетчер, которому разрешен тестовый сервер на loopback
+func newLoopbackDispatcher(srv *httptest.Server) *WebhookDispatcher {
+	d := NewWebhookDispatcher(nil, time.Second, 10, 3, time.Second)
+	d.client.Transport = srv.Client().Transport
+	return d
+}
+
+// Тест отправки подписанной доставки
+func TestWebhookDispatcher_Send(t *testing.T) {
+	var verified bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verified = VerifyWebhook("secret", r.Header.Get(WEBHOOK_TIMESTAMP_HEADER), body, r.Header.Get(WEBHOOK_SIGNATURE_HEADER), time.Minute, time.Now())
+		assert.Equal(t, "5", r.Header.Get(WEBHOOK_ID_HEADER))
+		assert.Equal(t, domain.EVENT_USER_UPDATED, r.Header.Get("X-Event-Type"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	d := newLoopbackDispatcher(srv)
+	code, err := d.send(context.Background(), srv.URL, "secret", 5, 7, domain.EVENT_USER_UPDATED, []byte(`{"id":7}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, verified)
+}
+
+// Тест неудачной попытки доставки
+func TestWebhookDispatcher_SendError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	d := newLoopbackDispatcher(srv)
+	code, err := d.send(context.Background(), srv.URL, "secret", 5, 7, domain.EVENT_USER_UPDATED, []byte(`{}`))
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadGateway, code)
+}
+
+// Тест защиты от SSRF: доставка не уходит на loopback и не следует редиректу
+func TestWebhookDispatcher_InternalTargets(t *testing.T) {
+	var reached bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	defer srv.Close()
+
+	d := NewWebhookDispatcher(nil, time.Second, 10, 3, time.Second)
+	code, err := d.send(context.Background(), srv.URL, "secret", 5, 7, domain.EVENT_USER_UPDATED, []byte(`{}`))
+	assert.ErrorContains(t, err, "is not public")
+	assert.Zero(t, code)
+	assert.False(t, reached)
+
+	redirect := httptest.NewServer(http.RedirectHandler(srv.URL, http.StatusFound))
+	defer redirect.Close()
+
+	d = newLoopbackDispatcher(redirect)
+	code, err = d.send(context.Background(), redirect.URL, "secret", 5, 7, domain.EVENT_USER_UPDATED, []byte(`{}`))
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusFound, code)
+	assert.False(t, reached)
+}
+
+// Тест публичных адресов получателей
+func TestIsPublicIP(t *testing.T) {
+	for _, addr := range []string{"93.184.216.34", "2606:2800:220:1::1"} {
+		assert.True(t, isPublicIP(net.ParseIP(addr)), addr)
+	}
+
+	for _, addr := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "::1", "fe80::1", "fd00::1", "224.0.0.1"} {
+		assert.False(t, isPublicIP(net.ParseIP(addr)), addr)
+	}
+}
+
+// Тест пачки доставок: доставки захватываются арендой, попытки и результаты пишутся после отправки
+func TestDispatchBatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(WEBHOOK_ID_HEADER) == "4" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	users, mock, _ := newMockService(t)
+	d := newLoopbackDispatcher(srv)
+	d.db = users.db
+
+	mock.ExpectQuery(`WITH claimed AS \(SELECT id FROM webhook_deliveries WHERE status = \$1 AND next_attempt_at <= NOW\(\) ORDER BY id LIMIT \$2 FOR UPDATE SKIP LOCKED\) UPDATE webhook_deliveries d SET next_attempt_at = \$3`).
+		WithArgs(domain.DELIVERY_PENDING, 10, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event_id", "event_type", "body", "attempts", "url", "secret"}).
+			AddRow(4, 7, domain.EVENT_USER_UPDATED, []byte(`{}`), 2, srv.URL, "secret").
+			AddRow(3, 7, domain.EVENT_USER_UPDATED, []byte(`{}`), 0, srv.URL, "secret"))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO webhook_attempts`).WithArgs(domain.Id(3), http.StatusOK, nil, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE webhook_deliveries SET status = \$2, delivered_at = NOW\(\)`).WithArgs(domain.Id(3), domain.DELIVERY_SUCCEEDED).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO webhook_attempts`).WithArgs(domain.Id(4), http.StatusInternalServerError, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE webhook_deliveries SET status = \$2, attempts = attempts \+ 1`).WithArgs(domain.Id(4), domain.DELIVERY_DEAD, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	n, err := d.DispatchBatch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+type failingPublisher struct{}
+
+func (failingPublisher) Publish(context.Context, domain.Event) error {
+	return errors.New("unavailable")
+}
+
+// Тест публикации в несколько публикаторов
+func TestMultiPublisher(t *testing.T) {
+	memory := NewMemoryPublisher()
+
+	err := NewMultiPublisher(failingPublisher{}, memory).Publish(context.Background(), testEvent())
+	assert.Error(t, err)
+	assert.Len(t, memory.Events(), 1)
+}
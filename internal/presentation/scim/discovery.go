@@ -0,0 +1,136 @@
+package scim
+
+const (
+	// MAX_RESULTS - максимальный размер страницы списка пользователей
+	MAX_RESULTS = 100
+	// MAX_SCAN - сколько пользователей можно проверить фильтром, который не выражается условиями базы
+	MAX_SCAN = 10000
+)
+
+// ServiceProviderConfig возвращает описание возможностей сервиса (RFC 7643 5)
+// base - адрес корня SCIM, например https://host/scim/v2
+func ServiceProviderConfig(base string) map[string]any {
+	return map[string]any{
+		"schemas":          []string{CONFIG_SCHEMA},
+		"documentationUri": "https://datatracker.ietf.org/doc/html/rfc7644",
+		"patch":            map[string]any{"supported": true},
+		"bulk":             map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":           map[string]any{"supported": true, "maxResults": MAX_RESULTS},
+		"changePassword":   map[string]any{"supported": true},
+		"sort":             map[string]any{"supported": false},
+		"etag":             map[string]any{"supported": false},
+		"authenticationSchemes": []map[string]any{
+			{
+				"type":        "oauthbearertoken",
+				"name":        "Bearer token",
+				"description": "Статический токен из SCIM_TOKEN в заголовке Authorization",
+				"primary":     true,
+			},
+		},
+		"meta": map[string]any{
+			"resourceType": "ServiceProviderConfig",
+			"location":     base + "/ServiceProviderConfig",
+		},
+	}
+}
+
+// ResourceTypes возвращает описание поддерживаемых типов ресурсов
+func ResourceTypes(base string) []map[string]any {
+	return []map[string]any{
+		{
+			"schemas":     []string{RESOURCE_TYPE_SCHEMA},
+			"id":          RESOURCE_TYPE_USER,
+			"name":        RESOURCE_TYPE_USER,
+			"endpoint":    "/Users",
+			"description": "User Account",
+			"schema":      USER_SCHEMA,
+			"meta": map[string]any{
+				"resourceType": "ResourceType",
+				"location":     base + "/ResourceTypes/" + RESOURCE_TYPE_USER,
+			},
+		},
+	}
+}
+
+// Schemas возвращает описание схемы пользователя
+// Описаны только атрибуты, которые сервис хранит
+func Schemas(base string) []map[string]any {
+	return []map[string]any{
+		{
+			"schemas":     []string{SCHEMA_SCHEMA},
+			"id":          USER_SCHEMA,
+			"name":        RESOURCE_TYPE_USER,
+			"description": "User Account",
+			"attributes": []map[string]any{
+				attribute("userName", "string", true, true, "readWrite", "always", "server",
+					"Адрес электронной почты, служит логином"),
+				{
+					"name":        "name",
+					"type":        "complex",
+					"multiValued": false,
+					"required":    false,
+					"mutability":  "readWrite",
+					"returned":    "default",
+					"uniqueness":  "none",
+					"subAttributes": []map[string]any{
+						attribute("givenName", "string", false, false, "readWrite", "default", "none", "Имя"),
+						attribute("familyName", "string", false, false, "readWrite", "default", "none", "Фамилия"),
+					},
+				},
+				attribute("displayName", "string", false, false, "readOnly", "default", "none",
+					"Имя и фамилия"),
+				{
+					"name":        "emails",
+					"type":        "complex",
+					"multiValued": true,
+					"required":    false,
+					"mutability":  "readWrite",
+					"returned":    "default",
+					"uniqueness":  "none",
+					"description": "Адрес совпадает с userName и используется, только если userName не задан",
+					"subAttributes": []map[string]any{
+						attribute("value", "string", false, false, "readWrite", "default", "none", "Адрес"),
+						attribute("type", "string", false, false, "readWrite", "default", "none", "Тип адреса"),
+						{
+							"name":       "primary",
+							"type":       "boolean",
+							"required":   false,
+							"mutability": "readWrite",
+							"returned":   "default",
+						},
+					},
+				},
+				{
+					"name":        "active",
+					"type":        "boolean",
+					"multiValued": false,
+					"required":    false,
+					"mutability":  "readWrite",
+					"returned":    "default",
+					"description": "false означает мягко удаленного пользователя",
+				},
+				attribute("password", "string", false, false, "writeOnly", "never", "none",
+					"Пароль, при отсутствии пользователь не сможет войти до сброса пароля"),
+			},
+			"meta": map[string]any{
+				"resourceType": "Schema",
+				"location":     base + "/Schemas/" + USER_SCHEMA,
+			},
+		},
+	}
+}
+
+// attribute возвращает описание простого атрибута схемы
+func attribute(name, kind string, required, caseExact bool, mutability, returned, uniqueness, description string) map[string]any {
+	return map[string]any{
+		"name":        name,
+		"type":        kind,
+		"multiValued": false,
+		"description": description,
+		"required":    required,
+		"caseExact":   caseExact,
+		"mutability":  mutability,
+		"returned":    returned,
+		"uniqueness":  uniqueness,
+	}
+}
@@ -0,0 +1,550 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"user/internal/domain"
+)
+
+// caseExact - атрибуты, строки которых сравниваются с учетом регистра
+// userName сравнивается точно, как и уникальность логина в базе
+var caseExact = map[string]bool{
+	"id":           true,
+	"username":     true,
+	"externalid":   true,
+	"meta.version": true,
+}
+
+// Filter - разобранное выражение фильтра SCIM
+type Filter interface {
+	// match проверяет выражение на элементе, представленном картой атрибутов
+	match(element map[string]any) bool
+}
+
+type logicalFilter struct {
+	or          bool
+	left, right Filter
+}
+
+func (f logicalFilter) match(element map[string]any) bool {
+	if f.or {
+		return f.left.match(element) || f.right.match(element)
+	}
+
+	return f.left.match(element) && f.right.match(element)
+}
+
+type notFilter struct {
+	filter Filter
+}
+
+func (f notFilter) match(element map[string]any) bool {
+	return !f.filter.match(element)
+}
+
+type compareFilter struct {
+	path  string
+	op    string
+	value any
+}
+
+func (f compareFilter) match(element map[string]any) bool {
+	for _, value := range lookup(element, f.path) {
+		if compare(value, f.op, f.value, caseExact[f.path]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// valuePathFilter - фильтр элементов многозначного атрибута, например emails[type eq "work"]
+// sub - необязательное сравнение податрибута отобранных элементов
+type valuePathFilter struct {
+	attr   string
+	filter Filter
+	sub    *compareFilter
+}
+
+func (f valuePathFilter) match(element map[string]any) bool {
+	items, _ := element[f.attr].([]any)
+	for _, value := range items {
+		item, ok := value.(map[string]any)
+		if !ok || !f.filter.match(item) {
+			continue
+		}
+
+		if f.sub == nil || f.sub.match(item) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Match проверяет, что ресурс удовлетворяет фильтру
+func Match(filter Filter, user *User) bool {
+	return filter.match(attributes(user))
+}
+
+// Narrow возвращает условия выборки из базы, которые следуют из фильтра
+// Условия только сужают выборку, итоговая проверка выполняется через Match
+// exact сообщает, что условия выражают весь фильтр и Match не нужен: так бывает только
+// для точного userName, который ищется по слепому индексу
+func Narrow(filter Filter) (narrowed domain.UserFilter, exact bool) {
+	narrowed = domain.UserFilter{
+		WithDeleted: true,
+	}
+	exact = true
+
+	var walk func(f Filter)
+	walk = func(f Filter) {
+		switch f := f.(type) {
+		case logicalFilter:
+			if f.or {
+				exact = false
+				return
+			}
+			walk(f.left)
+			walk(f.right)
+		case compareFilter:
+			value, _ := f.value.(string)
+			switch {
+			case f.path == "username" && f.op == "eq" && (narrowed.Login == "" || narrowed.Login == value):
+				narrowed.Login = value
+			case f.path == "meta.created" && (f.op == "gt" || f.op == "ge"):
+				exact = false
+				if t, err := time.Parse(time.RFC3339, value); err == nil {
+					narrowed.CreatedFrom = &t
+				}
+			case f.path == "meta.created" && (f.op == "lt" || f.op == "le"):
+				exact = false
+				if t, err := time.Parse(time.RFC3339, value); err == nil {
+					narrowed.CreatedTo = &t
+				}
+			default:
+				exact = false
+			}
+		default:
+			exact = false
+		}
+	}
+	walk(filter)
+
+	return narrowed, exact && narrowed.Login != ""
+}
+
+// ParseFilter разбирает фильтр по грамматике RFC 7644 3.4.2.2
+func ParseFilter(filter string) (Filter, error) {
+	tokens, err := tokenize(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos != len(p.tokens) {
+		return nil, newError(SCIM_TYPE_FILTER, fmt.Sprintf("Unexpected %q in filter", p.tokens[p.pos]))
+	}
+
+	return f, nil
+}
+
+// token - лексема фильтра, строки хранятся вместе с кавычками
+type token = string
+
+// tokenize разбивает фильтр на слова, скобки и строки в кавычках
+func tokenize(filter string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(filter); {
+		c := filter[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case strings.IndexByte("()[]", c) >= 0:
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(filter) && filter[j] != '"' {
+				if filter[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(filter) {
+				return nil, newError(SCIM_TYPE_FILTER, "Unterminated string in filter")
+			}
+			tokens = append(tokens, filter[i:j+1])
+			i = j + 1
+		default:
+			j := i
+			for j < len(filter) && strings.IndexByte(" \t()[]\"", filter[j]) < 0 {
+				j++
+			}
+			tokens = append(tokens, filter[i:j])
+			i = j
+		}
+	}
+
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *parser) expect(t string) error {
+	if p.next() != t {
+		return newError(SCIM_TYPE_FILTER, fmt.Sprintf("Expected %q in filter", t))
+	}
+
+	return nil
+}
+
+func (p *parser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for strings.EqualFold(p.peek(), "or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalFilter{or: true, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for strings.EqualFold(p.peek(), "and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalFilter{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Filter, error) {
+	if strings.EqualFold(p.peek(), "not") {
+		p.next()
+		if p.peek() != "(" {
+			return nil, newError(SCIM_TYPE_FILTER, `Expected "(" after not`)
+		}
+
+		f, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{filter: f}, nil
+	}
+
+	if p.peek() == "(" {
+		p.next()
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return f, p.expect(")")
+	}
+
+	attr, err := attrPath(p.next())
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek() == "[" {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.expect("]")
+		if err != nil {
+			return nil, err
+		}
+
+		f := valuePathFilter{attr: attr, filter: inner}
+		if sub, found := strings.CutPrefix(p.peek(), "."); found {
+			p.next()
+			cmp, err := p.parseCompare(strings.ToLower(sub))
+			if err != nil {
+				return nil, err
+			}
+			f.sub = &cmp
+		}
+
+		return f, nil
+	}
+
+	return p.parseCompare(attr)
+}
+
+// parseCompare разбирает оператор и значение сравнения для атрибута path
+func (p *parser) parseCompare(path string) (compareFilter, error) {
+	op := strings.ToLower(p.next())
+	switch op {
+	case "pr":
+		return compareFilter{path: path, op: op}, nil
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+	default:
+		return compareFilter{}, newError(SCIM_TYPE_FILTER, fmt.Sprintf("Unknown operator %q", op))
+	}
+
+	raw := p.next()
+	if raw == "" {
+		return compareFilter{}, newError(SCIM_TYPE_FILTER, "Missing comparison value")
+	}
+
+	var value any
+	switch strings.ToLower(raw) {
+	case "true":
+		value = true
+	case "false":
+		value = false
+	case "null":
+		value = nil
+	default:
+		if strings.HasPrefix(raw, `"`) {
+			var s string
+			err := json.Unmarshal([]byte(raw), &s)
+			if err != nil {
+				return compareFilter{}, newError(SCIM_TYPE_FILTER, "Invalid string in filter")
+			}
+			value = s
+			break
+		}
+
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return compareFilter{}, newError(SCIM_TYPE_FILTER, fmt.Sprintf("Invalid value %q", raw))
+		}
+		value = n
+	}
+
+	return compareFilter{path: path, op: op, value: value}, nil
+}
+
+// attrPath приводит путь атрибута к нижнему регистру и убирает префикс схемы User
+func attrPath(path string) (string, error) {
+	if path == "" || strings.ContainsAny(path, `()[]"`) {
+		return "", newError(SCIM_TYPE_FILTER, "Attribute path is expected")
+	}
+
+	lower := strings.ToLower(path)
+	if rest, found := strings.CutPrefix(lower, strings.ToLower(USER_SCHEMA)+":"); found {
+		lower = rest
+	}
+
+	return lower, nil
+}
+
+// attributes представляет ресурс картой атрибутов с ключами в нижнем регистре
+func attributes(user *User) map[string]any {
+	attrs := map[string]any{
+		"schemas":     toAny(user.Schemas),
+		"id":          user.Id,
+		"externalid":  user.ExternalId,
+		"username":    user.UserName,
+		"displayname": user.DisplayName,
+		"active":      user.IsActive(),
+	}
+
+	if user.Name != nil {
+		attrs["name"] = map[string]any{
+			"formatted":  user.Name.Formatted,
+			"givenname":  user.Name.GivenName,
+			"familyname": user.Name.FamilyName,
+		}
+	}
+
+	emails := []any{}
+	for _, email := range user.Emails {
+		emails = append(emails, map[string]any{
+			"value":   email.Value,
+			"type":    email.Type,
+			"primary": email.Primary,
+		})
+	}
+	attrs["emails"] = emails
+
+	if user.Meta != nil {
+		meta := map[string]any{
+			"resourcetype": user.Meta.ResourceType,
+			"location":     user.Meta.Location,
+			"version":      user.Meta.Version,
+		}
+		if user.Meta.Created != nil {
+			meta["created"] = *user.Meta.Created
+		}
+		if user.Meta.LastModified != nil {
+			meta["lastmodified"] = *user.Meta.LastModified
+		}
+		attrs["meta"] = meta
+	}
+
+	return attrs
+}
+
+func toAny(values []string) []any {
+	result := make([]any, 0, len(values))
+	for _, v := range values {
+		result = append(result, v)
+	}
+
+	return result
+}
+
+// lookup возвращает значения атрибута по пути, многозначные атрибуты разворачиваются
+// Для сложного многозначного атрибута без податрибута используется его value
+func lookup(element map[string]any, path string) []any {
+	name, rest, _ := strings.Cut(path, ".")
+	value, ok := element[name]
+	if !ok {
+		return nil
+	}
+
+	var items []any
+	if list, ok := value.([]any); ok {
+		items = list
+	} else {
+		items = []any{value}
+	}
+
+	result := []any{}
+	for _, item := range items {
+		sub, complex := item.(map[string]any)
+		switch {
+		case rest != "" && complex:
+			result = append(result, lookup(sub, rest)...)
+		case rest != "":
+		case complex && isMultiValued(value):
+			if v, ok := sub["value"]; ok {
+				result = append(result, v)
+			}
+		default:
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+func isMultiValued(value any) bool {
+	_, ok := value.([]any)
+	return ok
+}
+
+// compare сравнивает значение атрибута с операндом фильтра
+func compare(actual any, op string, expected any, exact bool) bool {
+	if op == "pr" {
+		switch v := actual.(type) {
+		case nil:
+			return false
+		case string:
+			return v != ""
+		case map[string]any:
+			return len(v) > 0
+		}
+		return true
+	}
+
+	switch v := actual.(type) {
+	case string:
+		s, ok := expected.(string)
+		if !ok {
+			return op == "ne"
+		}
+		if !exact {
+			v, s = strings.ToLower(v), strings.ToLower(s)
+		}
+		return compareOrdered(strings.Compare(v, s), op, func() bool {
+			switch op {
+			case "co":
+				return strings.Contains(v, s)
+			case "sw":
+				return strings.HasPrefix(v, s)
+			case "ew":
+				return strings.HasSuffix(v, s)
+			}
+			return false
+		})
+	case bool:
+		b, ok := expected.(bool)
+		switch op {
+		case "eq":
+			return ok && v == b
+		case "ne":
+			return !ok || v != b
+		}
+		return false
+	case time.Time:
+		s, ok := expected.(string)
+		if !ok {
+			return op == "ne"
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return op == "ne"
+		}
+		return compareOrdered(v.Compare(t), op, func() bool { return false })
+	}
+
+	return false
+}
+
+// compareOrdered проверяет оператор по результату сравнения, строковые операторы считает other
+func compareOrdered(cmp int, op string, other func() bool) bool {
+	switch op {
+	case "eq":
+		return cmp == 0
+	case "ne":
+		return cmp != 0
+	case "gt":
+		return cmp > 0
+	case "ge":
+		return cmp >= 0
+	case "lt":
+		return cmp < 0
+	case "le":
+		return cmp <= 0
+	}
+
+	return other()
+}
@@ -0,0 +1,114 @@
+package scim
+
+import (
+	"testing"
+	"time"
+
+	"user/internal/domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testUser() User {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	return FromDomain(&domain.User{
+		Id:        5,
+		FirstName: "John",
+		LastName:  "Doe",
+		Login:     "john@example.com",
+		Version:   3,
+		CreatedAt: created,
+	}, "http://localhost/scim/v2/Users/5")
+}
+
+// Тест проверки фильтров на ресурсе
+func TestMatch(t *testing.T) {
+	user := testUser()
+
+	cases := []struct {
+		filter string
+		match  bool
+	}{
+		{`userName eq "john@example.com"`, true},
+		{`userName eq "JOHN@example.com"`, false},
+		{`USERNAME Eq "john@example.com"`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "john@example.com"`, true},
+		{`name.givenName eq "JOHN"`, true},
+		{`name.familyName sw "D" and active eq true`, true},
+		{`name.familyName sw "X" or displayName co "ohn D"`, true},
+		{`not (active eq true)`, false},
+		{`active eq false`, false},
+		{`emails[type eq "work" and value ew "@example.com"]`, true},
+		{`emails[type eq "home"]`, false},
+		{`emails[type eq "work"].value eq "john@example.com"`, true},
+		{`emails.value eq "john@example.com"`, true},
+		{`emails co "example"`, true},
+		{`meta.created gt "2024-01-01T00:00:00Z"`, true},
+		{`meta.created lt "2024-01-01T00:00:00Z"`, false},
+		{`id eq "5"`, true},
+		{`externalId pr`, false},
+		{`name pr and (userName ne "other@example.com")`, true},
+	}
+
+	for _, c := range cases {
+		filter, err := ParseFilter(c.filter)
+		require.NoError(t, err, c.filter)
+		assert.Equal(t, c.match, Match(filter, &user), c.filter)
+	}
+}
+
+// Тест ошибок разбора фильтра
+func TestParseFilter_Invalid(t *testing.T) {
+	for _, filter := range []string{
+		`userName`,
+		`userName xx "a"`,
+		`userName eq "a`,
+		`userName eq "a" and`,
+		`(userName eq "a"`,
+		`emails[type eq "work"`,
+		`userName eq "a" extra`,
+		`not userName eq "a"`,
+		`userName eq bare`,
+	} {
+		_, err := ParseFilter(filter)
+		require.Error(t, err, filter)
+
+		scimErr, ok := AsError(err)
+		require.True(t, ok, filter)
+		assert.Equal(t, SCIM_TYPE_FILTER, scimErr.ScimType, filter)
+	}
+}
+
+// Тест сужения выборки по фильтру
+func TestNarrow(t *testing.T) {
+	filter, err := ParseFilter(`userName eq "john@example.com" and meta.created ge "2024-01-01T00:00:00Z"`)
+	require.NoError(t, err)
+
+	narrowed, exact := Narrow(filter)
+	assert.True(t, narrowed.WithDeleted)
+	assert.Equal(t, "john@example.com", narrowed.Login)
+	require.NotNil(t, narrowed.CreatedFrom)
+	assert.Equal(t, 2024, narrowed.CreatedFrom.Year())
+	assert.False(t, exact)
+
+	filter, err = ParseFilter(`userName eq "a@example.com" or userName eq "b@example.com"`)
+	require.NoError(t, err)
+	narrowed, exact = Narrow(filter)
+	assert.Empty(t, narrowed.Login)
+	assert.False(t, exact)
+
+	// Точный userName ищется по слепому индексу и не требует проверки Match
+	filter, err = ParseFilter(`userName eq "john@example.com"`)
+	require.NoError(t, err)
+	narrowed, exact = Narrow(filter)
+	assert.Equal(t, "john@example.com", narrowed.Login)
+	assert.True(t, exact)
+
+	// Префикс не выражается условиями базы и проверяется через Match
+	filter, err = ParseFilter(`userName sw "john"`)
+	require.NoError(t, err)
+	narrowed, exact = Narrow(filter)
+	assert.Empty(t, narrowed.Login)
+	assert.False(t, exact)
+}
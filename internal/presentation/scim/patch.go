@@ -0,0 +1,241 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PatchRequest - тело запроса PATCH (RFC 7644 3.5.2)
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation - одна операция PATCH
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ApplyPatch применяет операции к ресурсу по порядку
+// Операции без path принимают объект с атрибутами, в том числе в виде "name.givenName"
+func ApplyPatch(user *User, ops []PatchOperation) error {
+	for _, op := range ops {
+		kind := strings.ToLower(op.Op)
+		switch kind {
+		case "add", "replace":
+		case "remove":
+			if op.Path == "" {
+				return newError(SCIM_TYPE_NO_TARGET, "Path is required for remove")
+			}
+		default:
+			return newError(SCIM_TYPE_SYNTAX, fmt.Sprintf("Unknown operation %q", op.Op))
+		}
+
+		if op.Path != "" {
+			path, err := patchPath(op.Path)
+			if err != nil {
+				return err
+			}
+
+			err = setAttr(user, path, op.Value, kind == "remove")
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		var values map[string]json.RawMessage
+		err := json.Unmarshal(op.Value, &values)
+		if err != nil {
+			return newError(SCIM_TYPE_VALUE, "Value must be an object when path is omitted")
+		}
+
+		for name, value := range values {
+			path, err := attrPath(name)
+			if err != nil {
+				return newError(SCIM_TYPE_PATH, fmt.Sprintf("Invalid attribute %q", name))
+			}
+
+			// Объект name без path заменяет только переданные податрибуты
+			if path == "name" {
+				var name map[string]json.RawMessage
+				err = json.Unmarshal(value, &name)
+				if err != nil {
+					return newError(SCIM_TYPE_VALUE, "Invalid name")
+				}
+				for sub, v := range name {
+					err = setAttr(user, "name."+strings.ToLower(sub), v, false)
+					if err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			err = setAttr(user, path, value, false)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// setAttr заменяет или удаляет атрибут ресурса
+func setAttr(user *User, path string, value json.RawMessage, remove bool) error {
+	// Отбор элементов emails не влияет на результат, адрес у пользователя один
+	if strings.HasPrefix(path, "emails[") {
+		path = "emails"
+	}
+
+	switch path {
+	case "username":
+		if remove {
+			return newError(SCIM_TYPE_VALUE, "userName is required")
+		}
+		return decodeString(value, &user.UserName)
+	case "displayname":
+		if remove {
+			user.DisplayName = ""
+			return nil
+		}
+		return decodeString(value, &user.DisplayName)
+	case "externalid":
+		if remove {
+			user.ExternalId = ""
+			return nil
+		}
+		return decodeString(value, &user.ExternalId)
+	case "password":
+		if remove {
+			return newError(SCIM_TYPE_MUTABILITY, "password can't be removed")
+		}
+		return decodeString(value, &user.Password)
+	case "active":
+		if remove {
+			return newError(SCIM_TYPE_MUTABILITY, "active can't be removed")
+		}
+		active, err := decodeBool(value)
+		if err != nil {
+			return err
+		}
+		user.Active = &active
+		return nil
+	case "name":
+		if remove {
+			user.Name = nil
+			return nil
+		}
+		var name Name
+		err := json.Unmarshal(value, &name)
+		if err != nil {
+			return newError(SCIM_TYPE_VALUE, "Invalid name")
+		}
+		user.Name = &name
+		return nil
+	case "name.givenname", "name.familyname", "name.formatted":
+		if user.Name == nil {
+			user.Name = &Name{}
+		}
+		field := map[string]*string{
+			"name.givenname":  &user.Name.GivenName,
+			"name.familyname": &user.Name.FamilyName,
+			"name.formatted":  &user.Name.Formatted,
+		}[path]
+		if remove {
+			*field = ""
+			return nil
+		}
+		return decodeString(value, field)
+	case "emails", "emails.value":
+		if remove {
+			user.Emails = nil
+			return nil
+		}
+		return decodeEmails(value, &user.Emails)
+	}
+
+	// Атрибуты, которые сервис не хранит (title, расширения схемы и т.п.), игнорируются,
+	// иначе коннекторы IdP останавливают синхронизацию пользователя
+	return nil
+}
+
+// patchPath приводит path операции к нижнему регистру и убирает префикс схемы User
+// Фильтр элементов вида emails[type eq "work"].value сокращается до emails[
+func patchPath(path string) (string, error) {
+	lower := strings.ToLower(path)
+	if rest, found := strings.CutPrefix(lower, strings.ToLower(USER_SCHEMA)+":"); found {
+		lower = rest
+	}
+
+	if i := strings.IndexByte(lower, '['); i >= 0 {
+		if i == 0 || !strings.Contains(lower[i:], "]") {
+			return "", newError(SCIM_TYPE_PATH, fmt.Sprintf("Invalid path %q", path))
+		}
+		lower = lower[:i+1]
+	}
+
+	if lower == "" || strings.ContainsAny(lower, `()"`) {
+		return "", newError(SCIM_TYPE_PATH, fmt.Sprintf("Invalid path %q", path))
+	}
+
+	return lower, nil
+}
+
+// decodeString читает строковое значение атрибута
+func decodeString(value json.RawMessage, dst *string) error {
+	err := json.Unmarshal(value, dst)
+	if err != nil {
+		return newError(SCIM_TYPE_VALUE, "String value is expected")
+	}
+
+	return nil
+}
+
+// decodeBool читает логическое значение, строки "True" и "False" тоже допускаются
+func decodeBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if json.Unmarshal(value, &b) == nil {
+		return b, nil
+	}
+
+	var s string
+	if json.Unmarshal(value, &s) == nil {
+		switch strings.ToLower(s) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+
+	return false, newError(SCIM_TYPE_VALUE, "Boolean value is expected")
+}
+
+// decodeEmails читает список адресов, одиночный адрес или строку
+func decodeEmails(value json.RawMessage, dst *[]Email) error {
+	var emails []Email
+	if json.Unmarshal(value, &emails) == nil {
+		*dst = emails
+		return nil
+	}
+
+	var email Email
+	if json.Unmarshal(value, &email) == nil && email.Value != "" {
+		email.Primary = true
+		*dst = []Email{email}
+		return nil
+	}
+
+	var s string
+	if json.Unmarshal(value, &s) == nil {
+		*dst = []Email{{Value: s, Type: "work", Primary: true}}
+		return nil
+	}
+
+	return newError(SCIM_TYPE_VALUE, "Invalid emails")
+}
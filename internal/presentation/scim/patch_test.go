@@ -0,0 +1,74 @@
+package scim
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func operations(t *testing.T, data string) []PatchOperation {
+	var req PatchRequest
+	require.NoError(t, json.Unmarshal([]byte(data), &req))
+	return req.Operations
+}
+
+// Тест операций PATCH с path
+func TestApplyPatch(t *testing.T) {
+	user := testUser()
+
+	err := ApplyPatch(&user, operations(t, `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[
+		{"op":"replace","path":"name.givenName","value":"Jane"},
+		{"op":"Replace","path":"userName","value":"jane@example.com"},
+		{"op":"replace","path":"emails[type eq \"work\"].value","value":"jane@example.com"},
+		{"op":"remove","path":"name.familyName"},
+		{"op":"add","path":"title","value":"Engineer"}
+	]}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, "Jane", user.Name.GivenName)
+	assert.Equal(t, "", user.Name.FamilyName)
+	assert.Equal(t, "jane@example.com", user.UserName)
+	assert.Equal(t, "jane@example.com", user.Emails[0].Value)
+
+	domainUser := user.ToDomain()
+	assert.Equal(t, "Jane", domainUser.FirstName)
+	assert.Equal(t, "jane@example.com", domainUser.Login)
+}
+
+// Тест операций PATCH без path, как их отправляет Azure AD
+func TestApplyPatch_NoPath(t *testing.T) {
+	user := testUser()
+
+	err := ApplyPatch(&user, operations(t, `{"Operations":[
+		{"op":"Replace","value":{"active":"False","name.familyName":"Smith","name":{"givenName":"Jack"}}}
+	]}`))
+	require.NoError(t, err)
+
+	assert.False(t, user.IsActive())
+	assert.Equal(t, "Jack", user.Name.GivenName)
+	assert.Equal(t, "Smith", user.Name.FamilyName)
+}
+
+// Тест ошибок PATCH
+func TestApplyPatch_Invalid(t *testing.T) {
+	cases := map[string]string{
+		`{"Operations":[{"op":"move","path":"userName","value":"a"}]}`:       SCIM_TYPE_SYNTAX,
+		`{"Operations":[{"op":"remove"}]}`:                                   SCIM_TYPE_NO_TARGET,
+		`{"Operations":[{"op":"remove","path":"userName"}]}`:                 SCIM_TYPE_VALUE,
+		`{"Operations":[{"op":"replace","path":"active","value":"on"}]}`:     SCIM_TYPE_VALUE,
+		`{"Operations":[{"op":"replace","path":"emails[type","value":"a"}]}`: SCIM_TYPE_PATH,
+		`{"Operations":[{"op":"replace","value":"a"}]}`:                      SCIM_TYPE_VALUE,
+	}
+
+	for data, scimType := range cases {
+		user := testUser()
+		err := ApplyPatch(&user, operations(t, data))
+		require.Error(t, err, data)
+
+		scimErr, ok := AsError(err)
+		require.True(t, ok, data)
+		assert.Equal(t, scimType, scimErr.ScimType, data)
+	}
+}
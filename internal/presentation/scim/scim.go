@@ -0,0 +1,189 @@
+// Package scim реализует ресурс User протокола SCIM 2.0 (RFC 7643, RFC 7644):
+// отображение на domain.User, фильтры и операции PATCH
+package scim
+
+import (
+	"errors"
+	"strconv"
+	"time"
+	"user/internal/domain"
+)
+
+const (
+	CONTENT_TYPE = "application/scim+json"
+
+	USER_SCHEMA          = "urn:ietf:params:scim:schemas:core:2.0:User"
+	LIST_SCHEMA          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PATCH_SCHEMA         = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ERROR_SCHEMA         = "urn:ietf:params:scim:api:messages:2.0:Error"
+	CONFIG_SCHEMA        = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	RESOURCE_TYPE_SCHEMA = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SCHEMA_SCHEMA        = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	RESOURCE_TYPE_USER   = "User"
+
+	// Типы ошибок scimType из RFC 7644 3.12
+	SCIM_TYPE_FILTER     = "invalidFilter"
+	SCIM_TYPE_PATH       = "invalidPath"
+	SCIM_TYPE_VALUE      = "invalidValue"
+	SCIM_TYPE_SYNTAX     = "invalidSyntax"
+	SCIM_TYPE_UNIQUENESS = "uniqueness"
+	SCIM_TYPE_MUTABILITY = "mutability"
+	SCIM_TYPE_NO_TARGET  = "noTarget"
+	SCIM_TYPE_TOO_MANY   = "tooMany"
+)
+
+// Error - ошибка SCIM с типом из RFC 7644 3.12
+type Error struct {
+	ScimType string
+	Detail   string
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+// newError создает ошибку SCIM
+func newError(scimType, detail string) error {
+	return &Error{
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
+
+// AsError возвращает ошибку SCIM из цепочки ошибок
+func AsError(err error) (*Error, bool) {
+	var scimErr *Error
+	ok := errors.As(err, &scimErr)
+	return scimErr, ok
+}
+
+// Name - составное имя пользователя
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// Email - адрес электронной почты пользователя
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Meta - метаданные ресурса
+type Meta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location,omitempty"`
+	Version      string     `json:"version,omitempty"`
+}
+
+// User - ресурс пользователя SCIM
+// Пароль только принимается и никогда не возвращается
+type User struct {
+	Schemas     []string `json:"schemas"`
+	Id          string   `json:"id,omitempty"`
+	ExternalId  string   `json:"externalId,omitempty"`
+	UserName    string   `json:"userName"`
+	Name        *Name    `json:"name,omitempty"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Emails      []Email  `json:"emails,omitempty"`
+	Active      *bool    `json:"active,omitempty"`
+	Password    string   `json:"password,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// ListResponse - страница ресурсов
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []User   `json:"Resources"`
+}
+
+// FromDomain переводит пользователя в ресурс SCIM
+// location - адрес ресурса для meta.location
+func FromDomain(user *domain.User, location string) User {
+	active := user.DeletedAt == nil
+	created := user.CreatedAt
+	resource := User{
+		Schemas:  []string{USER_SCHEMA},
+		Id:       strconv.FormatUint(user.Id, 10),
+		UserName: user.Login,
+		Emails: []Email{
+			{Value: user.Login, Type: "work", Primary: true},
+		},
+		Active: &active,
+		Meta: &Meta{
+			ResourceType: RESOURCE_TYPE_USER,
+			Created:      &created,
+			Location:     location,
+			Version:      `W/"` + strconv.FormatUint(user.Version, 10) + `"`,
+		},
+	}
+
+	if user.FirstName != "" || user.LastName != "" {
+		resource.Name = &Name{
+			GivenName:  user.FirstName,
+			FamilyName: user.LastName,
+		}
+		resource.DisplayName = displayName(user.FirstName, user.LastName)
+	}
+
+	return resource
+}
+
+// ToDomain возвращает поля пользователя из ресурса SCIM
+// Логином служит userName, при его отсутствии - основной адрес почты
+func (u *User) ToDomain() domain.User {
+	user := domain.User{
+		Login:    u.UserName,
+		Password: u.Password,
+	}
+
+	if user.Login == "" {
+		user.Login = u.primaryEmail()
+	}
+
+	if u.Name != nil {
+		user.FirstName = u.Name.GivenName
+		user.LastName = u.Name.FamilyName
+	}
+
+	return user
+}
+
+// IsActive сообщает, что ресурс активен, отсутствие active означает true
+func (u *User) IsActive() bool {
+	return u.Active == nil || *u.Active
+}
+
+// primaryEmail возвращает основной адрес почты или первый из списка
+func (u *User) primaryEmail() string {
+	for _, email := range u.Emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+
+	return ""
+}
+
+// displayName возвращает имя для отображения из имени и фамилии
+func displayName(first, last string) string {
+	switch {
+	case first == "":
+		return last
+	case last == "":
+		return first
+	}
+
+	return first + " " + last
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"user/internal/domain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Audit возвращает страницу журнала изменений пользователя
+// Журнал доступен самому пользователю и пользователям с правом users:read:any
+func (Handlers) Audit(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	var limit int
+	if limitStr := ctx.Query("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+
+	page, err := AuditService.History(userContext(ctx), id, ctx.Query("cursor"), limit)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidCursor) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, page)
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+	"user/internal/domain"
+	"user/internal/presentation/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	SESSION_KEY = "session"
+	TOKEN_KEY   = "token"
+)
+
+// Login проверяет email и пароль и выдает пару токенов
+// Пользователю с MFA вместо пары токенов выдается токен второго шага для /auth/login/mfa
+// Во время задержки после неудачных попыток или блокировки учетной записи или адреса вход отклоняется с 429
+func (Handlers) Login(ctx *gin.Context) {
+	var creds domain.Credentials
+	err := json.NewDecoder(ctx.Request.Body).Decode(&creds)
+	defer func() {
+		err := ctx.Request.Body.Close()
+		if err != nil {
+			logger.Logger.Error("Close body error")
+		}
+	}()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body"})
+		return
+	}
+
+	if creds.Login == "" || creds.Password == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Email and password are required"})
+		return
+	}
+
+	// Недоступность Redis не должна закрывать вход, ошибки счетчиков попыток только пишутся в лог
+	// Попытка учитывается до проверки пароля и снимается только после выдачи сессии,
+	// поэтому верный пароль без второго фактора тоже остается неудачной попыткой
+	userCtx := userContext(ctx)
+	wait, err := LockoutService.Attempt(userCtx, creds.Login, ctx.ClientIP())
+	if err == nil && wait > 0 {
+		tooManyAttempts(ctx, wait)
+		return
+	}
+
+	tokens, challenge, err := AuthService.Login(userCtx, currentTenant(ctx), creds.Login, creds.Password)
+	if err == nil && tokens != nil {
+		_ = LockoutService.Succeed(userCtx, creds.Login, ctx.ClientIP())
+	}
+
+	if err != nil {
+		if errors.Is(err, domain.ErrEmailNotVerified) {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Email is not verified"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if challenge != nil {
+		ctx.JSON(http.StatusOK, challenge)
+		return
+	}
+
+	if tokens == nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+// tooManyAttempts отклоняет вход во время задержки или блокировки
+func tooManyAttempts(ctx *gin.Context, wait time.Duration) {
+	ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	ctx.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, try again later"})
+}
+
+// Unlock снимает блокировку входа с учетной записи пользователя
+func (Handlers) Unlock(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	ok, err := LockoutService.Unlock(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("User with id %d not exist", id)})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// LoginMfa проверяет код второго фактора и выдает пару токенов
+// Неверные коды учитываются в тех же счетчиках попыток учетной записи и адреса, что и пароли
+func (Handlers) LoginMfa(ctx *gin.Context) {
+	var body struct {
+		MfaToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil || body.MfaToken == "" || body.Code == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "MFA token and code are required"})
+		return
+	}
+
+	userCtx := userContext(ctx)
+	wait, err := LockoutService.AttemptMfa(userCtx, body.MfaToken, ctx.ClientIP())
+	if err == nil && wait > 0 {
+		tooManyAttempts(ctx, wait)
+		return
+	}
+
+	tokens, err := AuthService.LoginMfa(userCtx, body.MfaToken, body.Code)
+	if err == nil && tokens != nil {
+		_ = LockoutService.SucceedMfa(userCtx, body.MfaToken, ctx.ClientIP())
+	}
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if tokens == nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid MFA token or code"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+// Refresh выдает новую пару токенов по refresh токену
+func (Handlers) Refresh(ctx *gin.Context) {
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := json.NewDecoder(ctx.Request.Body).Decode(&body)
+	defer func() {
+		err := ctx.Request.Body.Close()
+		if err != nil {
+			logger.Logger.Error("Close body error")
+		}
+	}()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body"})
+		return
+	}
+
+	if body.RefreshToken == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Refresh token is required"})
+		return
+	}
+
+	tokens, err := AuthService.Refresh(body.RefreshToken)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if tokens == nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+// Logout отзывает текущую сессию
+func (Handlers) Logout(ctx *gin.Context) {
+	err := AuthService.Logout(ctx.GetString(TOKEN_KEY))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
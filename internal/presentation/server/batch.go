@@ -0,0 +1,233 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"user/internal/domain"
+	"user/internal/presentation/validation"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// MAX_BATCH - максимальное количество идентификаторов в batchGet
+	MAX_BATCH = 100
+
+	// MAX_BULK - максимальное количество операций в bulk
+	MAX_BULK = 100
+)
+
+// batchGetRequest - тело запроса POST /users:batchGet
+type batchGetRequest struct {
+	Ids []domain.Id `json:"ids"`
+}
+
+// bulkRequest - тело запроса POST /users:bulk
+type bulkRequest struct {
+	Atomic bool       `json:"atomic"`
+	Items  []bulkItem `json:"items"`
+}
+
+// bulkItem - операция пакета, для update идентификатор и версия берутся из user
+type bulkItem struct {
+	Op   string       `json:"op"`
+	User *domain.User `json:"user"`
+}
+
+// bulkResult - результат операции пакета, status совпадает с кодом ответа одиночного запроса
+type bulkResult struct {
+	Status int        `json:"status"`
+	Id     *domain.Id `json:"id,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// UsersMethod выполняет методы коллекции вида POST /users:batchGet
+// gin не различает маршруты, отличающиеся только суффиксом после ":", поэтому метод разбирается здесь
+func (h Handlers) UsersMethod(ctx *gin.Context) {
+	switch ctx.Param("method") {
+	case ":batchGet":
+		h.BatchGet(ctx)
+	case ":bulk":
+		h.Bulk(ctx)
+	default:
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Unknown method"})
+	}
+}
+
+// BatchGet возвращает активных пользователей по списку идентификаторов
+// Ненайденные идентификаторы возвращаются в missing
+// Чужие профили требуют права users:read:any
+func (Handlers) BatchGet(ctx *gin.Context) {
+	var req batchGetRequest
+	err := ctx.ShouldBindJSON(&req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body"})
+		return
+	}
+
+	if len(req.Ids) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Ids are required"})
+		return
+	}
+
+	if len(req.Ids) > MAX_BATCH {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("No more than %d ids are allowed", MAX_BATCH)})
+		return
+	}
+
+	if currentSession(ctx) == nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization is required"})
+		return
+	}
+
+	if !authorize(ctx, domain.PERM_USERS_READ_SELF, domain.PERM_USERS_READ_ANY, req.Ids...) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	users, err := UserService.BatchGet(userContext(ctx), req.Ids)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	found := make(map[domain.Id]bool, len(users))
+	for _, user := range users {
+		found[user.Id] = true
+	}
+
+	missing := []domain.Id{}
+	for _, id := range req.Ids {
+		if !found[id] {
+			missing = append(missing, id)
+			found[id] = true
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"users": users, "missing": missing})
+}
+
+// Bulk создает и обновляет пользователей пакетом, требует права users:write:any
+// Ответ всегда 200, результат каждой операции содержит свой код
+// При atomic ошибка любой операции отменяет весь пакет
+func (Handlers) Bulk(ctx *gin.Context) {
+	if currentSession(ctx) == nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization is required"})
+		return
+	}
+
+	if !can(ctx, domain.PERM_USERS_WRITE_ANY) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var req bulkRequest
+	err := ctx.ShouldBindJSON(&req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body"})
+		return
+	}
+
+	if len(req.Items) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Items are required"})
+		return
+	}
+
+	if len(req.Items) > MAX_BULK {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("No more than %d items are allowed", MAX_BULK)})
+		return
+	}
+
+	results := make([]bulkResult, len(req.Items))
+	items := []domain.BulkItem{}
+	indexes := []int{}
+	failed := false
+	for i, item := range req.Items {
+		status, msg := checkBulkItem(item)
+		if status != 0 {
+			results[i] = bulkResult{Status: status, Error: msg}
+			failed = true
+			continue
+		}
+
+		items = append(items, domain.BulkItem{Op: item.Op, User: *item.User})
+		indexes = append(indexes, i)
+	}
+
+	// Атомарный пакет с невалидной операцией не выполняется вовсе
+	if req.Atomic && failed {
+		for _, i := range indexes {
+			results[i] = bulkErrorResult(domain.ErrBulkAborted, req.Items[i].User)
+		}
+		ctx.JSON(http.StatusOK, gin.H{"results": results})
+		return
+	}
+
+	if len(items) > 0 {
+		done, err := UserService.Bulk(userContext(ctx), items, req.Atomic)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+			return
+		}
+
+		for j, result := range done {
+			i := indexes[j]
+			if result.Err != nil {
+				results[i] = bulkErrorResult(result.Err, req.Items[i].User)
+				continue
+			}
+
+			id := result.Id
+			results[i] = bulkResult{Status: http.StatusOK, Id: &id}
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// checkBulkItem проверяет операцию пакета так же, как одиночные Create и Put
+// Возвращает код и текст ошибки или 0, если операция валидна
+func checkBulkItem(item bulkItem) (int, string) {
+	if item.User == nil {
+		return http.StatusBadRequest, "User is required"
+	}
+
+	switch item.Op {
+	case domain.BULK_CREATE:
+		item.User.Id = 0
+		item.User.Version = 0
+	case domain.BULK_UPDATE:
+		if item.User.Id == 0 {
+			return http.StatusBadRequest, "Id is required"
+		}
+		if RequireIfMatch && item.User.Version == 0 {
+			return http.StatusPreconditionRequired, "Version is required"
+		}
+	default:
+		return http.StatusBadRequest, fmt.Sprintf("Unknown operation %q", item.Op)
+	}
+
+	err := validation.CheckUser(item.User)
+	if err != nil {
+		return http.StatusBadRequest, err.Error()
+	}
+
+	return 0, ""
+}
+
+// bulkErrorResult переводит ошибку операции пакета в код и текст одиночного запроса
+func bulkErrorResult(err error, user *domain.User) bulkResult {
+	switch {
+	case errors.Is(err, domain.ErrLoginExists):
+		return bulkResult{Status: http.StatusBadRequest, Error: fmt.Sprintf("User with email %s already exist", user.Login)}
+	case errors.Is(err, domain.ErrUserNotFound):
+		return bulkResult{Status: http.StatusBadRequest, Error: fmt.Sprintf("User with id %d not exist", user.Id)}
+	case errors.Is(err, domain.ErrVersionMismatch):
+		return bulkResult{Status: http.StatusPreconditionFailed, Error: "User has been modified"}
+	case errors.Is(err, domain.ErrBulkAborted):
+		return bulkResult{Status: http.StatusFailedDependency, Error: "Aborted because another operation failed"}
+	}
+
+	return bulkResult{Status: http.StatusInternalServerError, Error: "Internal error"}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"user/internal/domain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// etag возвращает ETag пользователя по его версии
+func etag(version uint64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// parseETags разбирает список ETag из заголовков If-Match и If-None-Match
+func parseETags(header string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+// ifMatch проверяет заголовок If-Match и возвращает версию, которую нужно сверить при записи
+// 0 означает, что проверка версии не нужна
+// Возвращает false, если запрос уже завершен с ошибкой
+func ifMatch(ctx *gin.Context, id domain.Id) (uint64, bool) {
+	header := ctx.GetHeader("If-Match")
+	if header == "" {
+		if RequireIfMatch {
+			ctx.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match is required"})
+			return 0, false
+		}
+		return 0, true
+	}
+
+	if strings.TrimSpace(header) == "*" {
+		return 0, true
+	}
+
+	// Версия сверяется с базой, кэш может отставать от последнего изменения
+	user, err := UserService.GetFresh(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return 0, false
+	}
+
+	// If-Match использует строгое сравнение, слабые ETag не подходят
+	if user != nil {
+		for _, tag := range parseETags(header) {
+			if tag == etag(user.Version) {
+				return user.Version, true
+			}
+		}
+	}
+
+	ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": "User has been modified"})
+	return 0, false
+}
+
+// notModified проверяет заголовок If-None-Match по слабому сравнению
+func notModified(ctx *gin.Context, tag string) bool {
+	header := ctx.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	for _, t := range parseETags(header) {
+		if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"user/internal/domain"
+	"user/internal/presentation/exporter"
+	"user/internal/presentation/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Export выгружает пользователей потоком в формате format: csv, ndjson или columns
+// Фильтры и сортировка те же, что у списка, курсор и limit не учитываются
+// fields - поля через запятую, пароль не выгружается никогда
+// После начала ответа ошибку уже нельзя вернуть кодом, поэтому выгрузка обрывается
+func (Handlers) Export(ctx *gin.Context) {
+	format := ctx.DefaultQuery("format", domain.EXPORT_CSV)
+	if format != domain.EXPORT_CSV && format != domain.EXPORT_NDJSON && format != domain.EXPORT_COLUMNS {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Format must be csv, ndjson or columns"})
+		return
+	}
+
+	fields, err := exporter.ParseFields(ctx.Query("fields"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fields"})
+		return
+	}
+
+	filter, ok := listFilter(ctx)
+	if !ok {
+		return
+	}
+	filter.Cursor = ""
+
+	writer, err := exporter.NewWriter(ctx.Writer, format, fields)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format"})
+		return
+	}
+
+	// Заголовки отправляются с первой строкой, до нее еще можно ответить ошибкой
+	started := false
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+
+		extension := format
+		if format == domain.EXPORT_COLUMNS {
+			extension = "json"
+		}
+		ctx.Header("Content-Type", exporter.ContentType(format))
+		ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="users.%s"`, extension))
+		ctx.Status(http.StatusOK)
+	}
+
+	err = UserService.Export(userContext(ctx), *filter, writer.Passes(), func(pass int, user domain.User) error {
+		start()
+		return writer.Write(pass, user)
+	})
+	if err != nil {
+		if started {
+			logger.Logger.Error(fmt.Sprintf("Export has been interrupted: %v", err))
+			ctx.Abort()
+			return
+		}
+
+		if errors.Is(err, domain.ErrInvalidSort) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	start()
+	err = writer.Close()
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Export has been interrupted: %v", err))
+		ctx.Abort()
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"user/internal/domain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// purposeRe - допустимое название цели обработки данных
+var purposeRe = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// GdprExport выгружает все данные пользователя одним JSON-файлом
+// Выгрузка доступна самому пользователю и пользователям с правом users:read:any
+func (Handlers) GdprExport(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	archive, err := GdprService.Archive(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if archive == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("User with id %d not exist", id)})
+		return
+	}
+
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-gdpr.json"`, id))
+	ctx.JSON(http.StatusOK, archive)
+}
+
+// Erase обезличивает персональные данные пользователя и возвращает запись об удалении
+func (Handlers) Erase(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	erasure, err := GdprService.Erase(userContext(ctx), id)
+	if err != nil {
+		if errors.Is(err, domain.ErrAlreadyErased) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("User with id %d has already been erased", id)})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if erasure == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("User with id %d not exist", id)})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, erasure)
+}
+
+// SetConsent дает или отзывает согласие пользователя на обработку данных с целью purpose
+// Изменить согласие может сам пользователь или пользователь с правом users:write:any
+func (Handlers) SetConsent(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	purpose := ctx.Param("purpose")
+	if !purposeRe.MatchString(purpose) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid purpose"})
+		return
+	}
+
+	var body struct {
+		Granted *bool `json:"granted"`
+	}
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil || body.Granted == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Granted is required"})
+		return
+	}
+
+	ok, err = GdprService.SetConsent(userContext(ctx), id, purpose, *body.Granted)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("User with id %d not exist", id)})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
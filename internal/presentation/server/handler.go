@@ -1,13 +1,18 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 	"user/internal/domain"
 	"user/internal/presentation/logger"
+	"user/internal/presentation/patch"
+	"user/internal/presentation/validation"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lib/pq"
@@ -29,7 +34,7 @@ func (Handlers) Create(ctx *gin.Context) {
 		return
 	}
 
-	id, err := UserService.Create(*user)
+	id, err := UserService.Create(userContext(ctx), *user)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
 		return
@@ -40,10 +45,21 @@ func (Handlers) Create(ctx *gin.Context) {
 		return
 	}
 
+	// Письмо можно запросить повторно, поэтому ошибка отправки не отменяет регистрацию
+	err = VerificationService.Send(userContext(ctx), *id, user.Login)
+	if err != nil {
+		logger.Logger.Warn(fmt.Sprintf("Verification email for user %d hasn't been sent: %v", *id, err))
+	}
+
 	ctx.JSON(http.StatusOK, gin.H{"id": id})
 }
 
 func (Handlers) Get(ctx *gin.Context) {
+	if !ctx.Request.URL.Query().Has("id") {
+		list(ctx)
+		return
+	}
+
 	idStr := ctx.Request.URL.Query().Get("id")
 	if idStr == "" {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Id is required"})
@@ -56,7 +72,13 @@ func (Handlers) Get(ctx *gin.Context) {
 		return
 	}
 
-	user, err := UserService.Get(domain.Id(id))
+	withDeleted := ctx.Query("with_deleted") == "true"
+	if withDeleted && !can(ctx, domain.PERM_USERS_MANAGE) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	user, err := UserService.Get(userContext(ctx), domain.Id(id), withDeleted)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
 		return
@@ -67,6 +89,13 @@ func (Handlers) Get(ctx *gin.Context) {
 		return
 	}
 
+	tag := etag(user.Version)
+	ctx.Header("ETag", tag)
+	if notModified(ctx, tag) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+
 	ctx.JSON(http.StatusOK, user)
 }
 
@@ -88,9 +117,20 @@ func (Handlers) Put(ctx *gin.Context) {
 		return
 	}
 
+	version, ok := ifMatch(ctx, domain.Id(id))
+	if !ok {
+		return
+	}
+
 	user.Id = domain.Id(id)
-	err = UserService.Update(*user)
+	user.Version = version
+	err = UserService.Update(userContext(ctx), *user)
 	if err != nil {
+		if errors.Is(err, domain.ErrVersionMismatch) {
+			ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": "User has been modified"})
+			return
+		}
+
 		var pqErr *pq.Error
 		if errors.As(err, &pqErr) && pqErr.Code == NOT_UNIQUE_LOGIN {
 			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("User with email %s already exist", user.Login)})
@@ -104,9 +144,25 @@ func (Handlers) Put(ctx *gin.Context) {
 	ctx.Status(http.StatusOK)
 }
 
-func validBody(ctx *gin.Context) *domain.User {
-	var user domain.User
-	err := json.NewDecoder(ctx.Request.Body).Decode(&user)
+// patchDocument - представление пользователя, к которому применяется патч
+// Пароль не читается, но может быть добавлен патчем
+type patchDocument struct {
+	Name     string     `json:"name"`
+	Surname  string     `json:"surname"`
+	Birthday *time.Time `json:"birthday"`
+	Email    string     `json:"email"`
+	Password *string    `json:"password,omitempty"`
+}
+
+// Patch частично обновляет пользователя через JSON Merge Patch или JSON Patch
+// Проверяются и сохраняются только измененные поля
+func (Handlers) Patch(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(ctx.Request.Body)
 	defer func() {
 		err := ctx.Request.Body.Close()
 		if err != nil {
@@ -115,26 +171,350 @@ func validBody(ctx *gin.Context) *domain.User {
 	}()
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body"})
-		return nil
+		return
+	}
+
+	version, ok := ifMatch(ctx, id)
+	if !ok {
+		return
+	}
+
+	// Патч применяется к состоянию из базы: кэш может отставать от последнего изменения
+	current, err := UserService.GetFresh(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if current == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("User with id %d not exist", id)})
+		return
+	}
+
+	if version != 0 && version != current.Version {
+		ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": "User has been modified"})
+		return
 	}
 
-	if user.Login == "" {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Email is required"})
+	doc, err := json.Marshal(patchDocument{
+		Name:     current.FirstName,
+		Surname:  current.LastName,
+		Birthday: current.BirthDay,
+		Email:    current.Login,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	var patched []byte
+	switch ctx.ContentType() {
+	case patch.MERGE_PATCH:
+		patched, err = patch.MergePatch(doc, body)
+	case patch.JSON_PATCH:
+		patched, err = patch.JSONPatch(doc, body)
+	default:
+		ctx.JSON(http.StatusUnsupportedMediaType, gin.H{"error": fmt.Sprintf("Content type must be %s or %s", patch.MERGE_PATCH, patch.JSON_PATCH)})
+		return
+	}
+	if err != nil {
+		if errors.Is(err, patch.ErrTestFailed) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "Patch test failed"})
+			return
+		}
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patch"})
+		return
+	}
+
+	changes := diffPatch(ctx, current, patched)
+	if changes == nil {
+		return
+	}
+
+	// Без If-Match патч все равно сохраняется только поверх прочитанной версии,
+	// иначе параллельное изменение полей, не затронутых патчем, было бы потеряно
+	changes.Version = current.Version
+	if changes.Empty() {
+		ctx.Status(http.StatusOK)
+		return
+	}
+
+	ok, err = UserService.Patch(userContext(ctx), id, *changes)
+	if err != nil {
+		if errors.Is(err, domain.ErrVersionMismatch) {
+			if version == 0 {
+				ctx.JSON(http.StatusConflict, gin.H{"error": "User has been modified concurrently, retry the patch"})
+				return
+			}
+			ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": "User has been modified"})
+			return
+		}
+
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == NOT_UNIQUE_LOGIN {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("User with email %s already exist", *changes.Login)})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("User with id %d not exist", id)})
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
+// diffPatch сравнивает пропатченный документ с текущим пользователем
+// и проверяет только измененные поля
+func diffPatch(ctx *gin.Context, current *domain.User, patched []byte) *domain.UserPatch {
+	var doc patchDocument
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&doc)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patch"})
 		return nil
 	}
 
-	valid := IsValidEmail(user.Login)
-	if !valid {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email"})
+	var changes domain.UserPatch
+	if doc.Name != current.FirstName {
+		changes.FirstName = &doc.Name
+	}
+
+	if doc.Surname != current.LastName {
+		changes.LastName = &doc.Surname
+	}
+
+	switch {
+	case doc.Birthday == nil && current.BirthDay != nil:
+		changes.ClearBirthDay = true
+	case doc.Birthday != nil && (current.BirthDay == nil || !doc.Birthday.Equal(*current.BirthDay)):
+		changes.BirthDay = doc.Birthday
+	}
+
+	if doc.Email != current.Login {
+		if doc.Email == "" {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Email is required"})
+			return nil
+		}
+
+		if !validation.IsValidEmail(doc.Email) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email"})
+			return nil
+		}
+		changes.Login = &doc.Email
+	}
+
+	if doc.Password != nil {
+		hashPass, err := validation.ValidPass(*doc.Password)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password"})
+			return nil
+		}
+		changes.Password = &hashPass
+		changes.PlainPassword = *doc.Password
+	}
+
+	return &changes
+}
+
+// list возвращает страницу пользователей по фильтрам из строки запроса
+func list(ctx *gin.Context) {
+	filter, ok := listFilter(ctx)
+	if !ok {
+		return
+	}
+
+	if filter.WithDeleted && !can(ctx, domain.PERM_USERS_MANAGE) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	page, err := UserService.List(userContext(ctx), *filter)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrInvalidSort):
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort"})
+		case errors.Is(err, domain.ErrInvalidCursor):
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, page)
+}
+
+// encryptedFilters - параметры выборки по зашифрованным полям, которые больше не поддерживаются
+// Персональные данные не могут искаться по индексу, а расшифровка всей организации на каждый запрос недопустима
+var encryptedFilters = []string{"email_prefix", "name", "birthday_from", "birthday_to"}
+
+// listFilter разбирает параметры выборки пользователей
+// email ищется точно по слепому индексу, фильтры по остальным персональным данным отклоняются
+func listFilter(ctx *gin.Context) (*domain.UserFilter, bool) {
+	for _, param := range encryptedFilters {
+		if ctx.Query(param) != "" {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Filter %s is not supported, personal data is encrypted: use email, created_from and created_to", param)})
+			return nil, false
+		}
+	}
+
+	filter := domain.UserFilter{
+		Login:       ctx.Query("email"),
+		WithDeleted: ctx.Query("with_deleted") == "true",
+		Sort:        ctx.Query("sort"),
+		Cursor:      ctx.Query("cursor"),
+	}
+
+	if limitStr := ctx.Query("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return nil, false
+		}
+		filter.Limit = limit
+	}
+
+	ranges := []struct {
+		param  string
+		layout string
+		dst    **time.Time
+	}{
+		{"created_from", time.RFC3339, &filter.CreatedFrom},
+		{"created_to", time.RFC3339, &filter.CreatedTo},
+	}
+	for _, r := range ranges {
+		value := ctx.Query(r.param)
+		if value == "" {
+			continue
+		}
+
+		t, err := time.Parse(r.layout, value)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s", r.param)})
+			return nil, false
+		}
+		*r.dst = &t
+	}
+
+	return &filter, true
+}
+
+// Delete мягко удаляет пользователя
+func (Handlers) Delete(ctx *gin.Context) {
+	idStr := ctx.Request.URL.Query().Get("id")
+	if idStr == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Id is required"})
+		return
+	}
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return
+	}
+
+	version, ok := ifMatch(ctx, domain.Id(id))
+	if !ok {
+		return
+	}
+
+	ok, err = UserService.Delete(userContext(ctx), domain.Id(id), version)
+	if err != nil {
+		if errors.Is(err, domain.ErrVersionMismatch) {
+			ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": "User has been modified"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("User with id %d not exist", id)})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// Restore восстанавливает мягко удаленного пользователя
+func (Handlers) Restore(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	ok, err := UserService.Restore(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Deleted user with id %d not exist", id)})
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
+// Purge безвозвратно удаляет пользователя
+func (Handlers) Purge(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	ok, err := UserService.Purge(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("User with id %d not exist", id)})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// idParam получает идентификатор пользователя из пути запроса
+func idParam(ctx *gin.Context) (domain.Id, bool) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return 0, false
+	}
+
+	return id, true
+}
+
+func validBody(ctx *gin.Context) *domain.User {
+	var user domain.User
+	err := json.NewDecoder(ctx.Request.Body).Decode(&user)
+	defer func() {
+		err := ctx.Request.Body.Close()
+		if err != nil {
+			logger.Logger.Error("Close body error")
+		}
+	}()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body"})
 		return nil
 	}
 
-	hashPass, err := ValidPass(user.Password)
+	err = validation.CheckUser(&user)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password"})
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return nil
 	}
 
-	user.Password = hashPass
 	return &user
 }
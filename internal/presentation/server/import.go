@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"user/internal/domain"
+	"user/internal/presentation/importer"
+	"user/internal/presentation/logger"
+	"user/internal/presentation/validation"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MAX_IMPORT_SIZE - максимальный размер файла импорта
+const MAX_IMPORT_SIZE = 32 << 20
+
+// CreateImport запускает импорт пользователей из тела запроса в формате CSV или NDJSON
+// Формат берется из параметра format или заголовка Content-Type
+// Файл разбирается сразу, проверка паролей и загрузка идут в фоне, ход задачи доступен через GetImport
+func (Handlers) CreateImport(ctx *gin.Context) {
+	format := ctx.Query("format")
+	if format == "" {
+		format = importer.Format(ctx.ContentType(), "")
+	}
+
+	if format != domain.IMPORT_CSV && format != domain.IMPORT_NDJSON {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Format must be csv or ndjson"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, MAX_IMPORT_SIZE))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File must be no larger than %d bytes", MAX_IMPORT_SIZE)})
+			return
+		}
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body"})
+		return
+	}
+
+	rows, rejected, err := importer.Parse(bytes.NewReader(body), format)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid file: %v", err)})
+		return
+	}
+
+	job := domain.ImportJob{
+		Format:            format,
+		DryRun:            ctx.Query("dry_run") == "true",
+		OverwritePassword: ctx.Query("overwrite_password") == "true",
+	}
+	id, err := ImportService.Create(userContext(ctx), job)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	// Импорт переживает запрос, но пишет журнал от имени его инициатора
+	jobCtx := context.WithoutCancel(userContext(ctx))
+	job.Id = *id
+	go func() {
+		valid, invalid := importer.Validate(rows, validation.CheckUser)
+		_, err := ImportService.Run(jobCtx, job, valid, append(rejected, invalid...))
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Import %d hasn't been finished: %v", *id, err))
+		}
+	}()
+
+	ctx.JSON(http.StatusAccepted, gin.H{"id": id, "status": domain.IMPORT_RUNNING})
+}
+
+// GetImport возвращает состояние задачи импорта
+func (Handlers) GetImport(ctx *gin.Context) {
+	job := importJob(ctx)
+	if job == nil {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, job)
+}
+
+// ImportReport отдает отчет по незагруженным строкам файлом CSV
+func (Handlers) ImportReport(ctx *gin.Context) {
+	job := importJob(ctx)
+	if job == nil {
+		return
+	}
+
+	if job.Status == domain.IMPORT_RUNNING {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "Import is still running"})
+		return
+	}
+
+	var report bytes.Buffer
+	err := importer.WriteReport(&report, job.Errors)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="import-%d-report.csv"`, job.Id))
+	ctx.Data(http.StatusOK, "text/csv; charset=utf-8", report.Bytes())
+}
+
+// importJob получает задачу импорта по идентификатору из пути
+// Возвращает nil, если запрос уже завершен с ошибкой
+func importJob(ctx *gin.Context) *domain.ImportJob {
+	id, ok := idParam(ctx)
+	if !ok {
+		return nil
+	}
+
+	job, err := ImportService.Get(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return nil
+	}
+
+	if job == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Import with id %d not exist", id)})
+		return nil
+	}
+
+	return job
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"user/internal/domain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EnrollTotp выдает пользователю новый секрет TOTP
+// Секрет виден только самому пользователю, поэтому подключить MFA за другого нельзя
+func (Handlers) EnrollTotp(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	enrollment, err := MfaService.Enroll(userContext(ctx), id)
+	if err != nil {
+		if errors.Is(err, domain.ErrMfaAlreadyEnabled) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "MFA is already enabled"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if enrollment == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("User with id %d not exist", id)})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmTotp включает MFA по коду из приложения и возвращает коды восстановления
+// Коды показываются один раз, в базе хранятся только их хэши
+func (Handlers) ConfirmTotp(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil || body.Code == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Code is required"})
+		return
+	}
+
+	codes, err := MfaService.Confirm(userContext(ctx), id, body.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrInvalidCode):
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		case errors.Is(err, domain.ErrMfaNotEnrolled):
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "MFA enrollment is not started"})
+		case errors.Is(err, domain.ErrMfaAlreadyEnabled):
+			ctx.JSON(http.StatusConflict, gin.H{"error": "MFA is already enabled"})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DisableTotp отключает MFA
+// Сам пользователь подтверждает отключение кодом TOTP или кодом восстановления,
+// пользователь с правом users:manage отключает MFA без кода
+func (Handlers) DisableTotp(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	if ctx.Request.ContentLength != 0 {
+		err := ctx.ShouldBindJSON(&body)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body"})
+			return
+		}
+	}
+
+	if body.Code == "" && !can(ctx, domain.PERM_USERS_MANAGE) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Code is required"})
+		return
+	}
+
+	ok, err := MfaService.Disable(userContext(ctx), id, body.Code)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidCode) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "MFA is not enabled"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"user/internal/domain"
+	"user/internal/presentation/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	REQUEST_ID_HEADER = "X-Request-Id"
+	REQUEST_ID_KEY    = "request_id"
+
+	TENANT_HEADER = "X-Tenant-Id"
+	TENANT_KEY    = "tenant"
+
+	// ORGANIZATION_KEY - организация из X-Tenant-Id, доступ к которой еще не проверен
+	ORGANIZATION_KEY = "organization"
+)
+
+// RequestId сохраняет идентификатор запроса из заголовка X-Request-Id
+// или генерирует новый и возвращает его в ответе
+func RequestId(ctx *gin.Context) {
+	id := ctx.GetHeader(REQUEST_ID_HEADER)
+	if id == "" || len(id) > 64 {
+		buf := make([]byte, 16)
+		_, _ = rand.Read(buf)
+		id = hex.EncodeToString(buf)
+	}
+
+	ctx.Set(REQUEST_ID_KEY, id)
+	ctx.Header(REQUEST_ID_HEADER, id)
+	ctx.Next()
+}
+
+// Tenant проверяет организацию из заголовка X-Tenant-Id
+// Запрос переходит в нее после проверки доступа: участия сессии в identify или политики организации в TenantPolicy
+// Без заголовка запрос работает в организации сессии, анонимный запрос - в DEFAULT_TENANT
+func Tenant(ctx *gin.Context) {
+	header := ctx.GetHeader(TENANT_HEADER)
+	if header == "" {
+		ctx.Next()
+		return
+	}
+
+	tenant, err := strconv.ParseUint(header, 10, 64)
+	if err != nil || tenant == 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant"})
+		return
+	}
+
+	org, err := OrganizationService.Get(ctx.Request.Context(), tenant)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if org == nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Organization with id %d not exist", tenant)})
+		return
+	}
+
+	ctx.Set(ORGANIZATION_KEY, org)
+	ctx.Next()
+}
+
+// TenantPolicy пускает анонимный запрос в организацию из X-Tenant-Id, только если ее политика разрешает policy
+// Анонимные запросы без этой проверки работают в DEFAULT_TENANT, запросы с сессией проверяет identify
+// Должен вызываться после Identify, если маршрут его использует
+func TenantPolicy(policy string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		org := requestedOrganization(ctx)
+		if org == nil || currentSession(ctx) != nil {
+			ctx.Next()
+			return
+		}
+
+		if !org.Allows(policy) {
+			logger.Logger.Warn(fmt.Sprintf("Anonymous %s in tenant %d denied by policy, request %s", policy, org.Id, ctx.GetString(REQUEST_ID_KEY)))
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Organization %d does not allow anonymous %s", org.Id, policy)})
+			return
+		}
+
+		ctx.Set(TENANT_KEY, org.Id)
+		ctx.Next()
+	}
+}
+
+// Authenticate проверяет access токен из заголовка Authorization
+// и сохраняет сессию в контексте запроса
+func Authenticate(ctx *gin.Context) {
+	if !identify(ctx) {
+		return
+	}
+
+	if _, ok := ctx.Get(SESSION_KEY); !ok {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization is required"})
+		return
+	}
+
+	ctx.Next()
+}
+
+// Identify сохраняет сессию в контексте запроса, если передан access токен
+// Запросы без заголовка Authorization пропускаются анонимно
+func Identify(ctx *gin.Context) {
+	if !identify(ctx) {
+		return
+	}
+
+	ctx.Next()
+}
+
+// RequirePermission пропускает только запросы с правом permission
+// Должен вызываться после Authenticate
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !can(ctx, permission) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// Authorize пропускает запросы к своему профилю с правом own и к любому пользователю с правом any
+// Пользователь берется из пути /users/:id или параметра id, без него требуется право any
+// Должен вызываться после Authenticate
+func Authorize(own, any string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		idStr := ctx.Param("id")
+		if idStr == "" {
+			idStr = ctx.Query("id")
+		}
+
+		var targets []domain.Id
+		if id, err := strconv.ParseUint(idStr, 10, 64); err == nil {
+			targets = append(targets, id)
+		}
+
+		if !authorize(ctx, own, any, targets...) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// identify проверяет токен, если он передан, и сохраняет сессию в контексте
+// Работать в чужой организации можно ее участнику и пользователю с правом organizations:manage
+// Возвращает false, если запрос уже завершен с ошибкой
+func identify(ctx *gin.Context) bool {
+	header := ctx.GetHeader("Authorization")
+	if header == "" {
+		return true
+	}
+
+	token, found := strings.CutPrefix(header, "Bearer ")
+	if !found || token == "" {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header"})
+		return false
+	}
+
+	session, err := AuthService.Authenticate(domain.WithClient(ctx.Request.Context(), requestClient(ctx)), token)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return false
+	}
+
+	if session == nil {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return false
+	}
+
+	if org := requestedOrganization(ctx); org != nil {
+		if !session.Member(org.Id) && !session.Can(domain.PERM_ORGANIZATIONS_MANAGE) {
+			logger.Logger.Warn(fmt.Sprintf("Access to tenant %d denied: user %d, request %s", org.Id, session.UserId, ctx.GetString(REQUEST_ID_KEY)))
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Access to tenant denied"})
+			return false
+		}
+
+		ctx.Set(TENANT_KEY, org.Id)
+	}
+
+	ctx.Set(SESSION_KEY, session)
+	ctx.Set(TOKEN_KEY, token)
+	return true
+}
+
+// currentSession возвращает сессию текущего запроса или nil
+func currentSession(ctx *gin.Context) *domain.Session {
+	session, ok := ctx.Get(SESSION_KEY)
+	if !ok {
+		return nil
+	}
+
+	return session.(*domain.Session)
+}
+
+// requestedOrganization возвращает организацию из X-Tenant-Id или nil
+func requestedOrganization(ctx *gin.Context) *domain.Organization {
+	org, ok := ctx.Get(ORGANIZATION_KEY)
+	if !ok {
+		return nil
+	}
+
+	return org.(*domain.Organization)
+}
+
+// currentTenant возвращает организацию текущего запроса
+// Организация из X-Tenant-Id действует только после проверки доступа к ней
+func currentTenant(ctx *gin.Context) domain.Id {
+	if tenant, ok := ctx.Get(TENANT_KEY); ok {
+		return tenant.(domain.Id)
+	}
+
+	if session := currentSession(ctx); session != nil {
+		return session.TenantId
+	}
+
+	return domain.DEFAULT_TENANT
+}
+
+// userContext возвращает контекст запроса с инициатором изменения для журнала, организацией и клиентом запроса
+func userContext(ctx *gin.Context) context.Context {
+	actor := domain.Actor{
+		RequestId: ctx.GetString(REQUEST_ID_KEY),
+	}
+
+	if session := currentSession(ctx); session != nil {
+		actor.UserId = &session.UserId
+		actor.SessionId = &session.Id
+	}
+
+	return domain.WithClient(domain.WithTenant(domain.WithActor(ctx.Request.Context(), actor), currentTenant(ctx)), requestClient(ctx))
+}
+
+// requestClient возвращает адрес и User-Agent клиента запроса
+func requestClient(ctx *gin.Context) domain.Client {
+	return domain.Client{
+		Ip:        ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+	}
+}
+
+// can проверяет право текущей сессии и записывает решение в лог
+func can(ctx *gin.Context, permission string) bool {
+	return authorize(ctx, "", permission)
+}
+
+// authorize проверяет право текущей сессии на действие с пользователями targets и записывает решение в лог
+// Если все targets - сам пользователь, достаточно права own, иначе нужно право any
+func authorize(ctx *gin.Context, own, any string, targets ...domain.Id) bool {
+	session := currentSession(ctx)
+	if session == nil {
+		logDecision(ctx, nil, any, targets, false)
+		return false
+	}
+
+	permission := any
+	if own != "" && len(targets) > 0 && !slices.ContainsFunc(targets, func(id domain.Id) bool { return id != session.UserId }) {
+		permission = own
+	}
+
+	allowed := session.Can(permission)
+	logDecision(ctx, session, permission, targets, allowed)
+	return allowed
+}
+
+// logDecision записывает решение о доступе: кто, к кому, по какому праву и маршруту
+func logDecision(ctx *gin.Context, session *domain.Session, permission string, targets []domain.Id, allowed bool) {
+	subject := "anonymous"
+	if session != nil {
+		subject = fmt.Sprintf("user %d %v in tenant %d", session.UserId, session.Roles, currentTenant(ctx))
+	}
+
+	decision := "denied"
+	if allowed {
+		decision = "granted"
+	}
+
+	msg := fmt.Sprintf("Access %s: %s, permission %s, targets %v, %s %s, request %s", decision, subject, permission, targets, ctx.Request.Method, ctx.FullPath(), ctx.GetString(REQUEST_ID_KEY))
+	if allowed {
+		logger.Logger.Info(msg)
+		return
+	}
+
+	logger.Logger.Warn(msg)
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"user/internal/domain"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// OpenIdConfiguration возвращает документ discovery OpenID Connect
+func (Handlers) OpenIdConfiguration(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, OAuthService.Configuration())
+}
+
+// Jwks возвращает открытые ключи подписи ID токенов
+// Кэш короче задержки публикации ключа, поэтому клиенты узнают о новом ключе до первой подписи им
+func (Handlers) Jwks(ctx *gin.Context) {
+	keys, err := OAuthService.Keys(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.Header("Cache-Control", "public, max-age=900")
+	ctx.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// oauthClientRedirect проверяет клиента и адрес возврата запроса авторизации
+// Пока адрес возврата не подтвержден, ошибку нельзя передать клиенту через него, поэтому она возвращается в ответе
+func oauthClientRedirect(ctx *gin.Context, req domain.OAuthAuthorizeRequest) bool {
+	client, err := OAuthService.Client(ctx, req.ClientId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return false
+	}
+
+	if client == nil || !slices.Contains(client.RedirectUris, req.RedirectUri) {
+		ctx.JSON(http.StatusBadRequest, domain.OAuthError{Code: domain.OAUTH_INVALID_REQUEST, Description: "Unknown client or redirect_uri"})
+		return false
+	}
+
+	return true
+}
+
+// redirectWith добавляет к адресу возврата параметры ответа авторизации и state
+func redirectWith(redirectUri string, params url.Values, state string) string {
+	if state != "" {
+		params.Set("state", state)
+	}
+
+	sep := "?"
+	if strings.Contains(redirectUri, "?") {
+		sep = "&"
+	}
+	return redirectUri + sep + params.Encode()
+}
+
+// OAuthAuthorizeRedirect принимает браузер пользователя от клиента и передает запрос авторизации странице входа
+// Страница входа аутентифицирует пользователя и подтверждает запрос через POST /oauth/authorize
+func (Handlers) OAuthAuthorizeRedirect(ctx *gin.Context) {
+	var req domain.OAuthAuthorizeRequest
+	err := ctx.ShouldBindQuery(&req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.OAuthError{Code: domain.OAUTH_INVALID_REQUEST, Description: "Invalid query"})
+		return
+	}
+
+	if !oauthClientRedirect(ctx, req) {
+		return
+	}
+
+	ctx.Redirect(http.StatusFound, redirectWith(OAuthLoginUrl, ctx.Request.URL.Query(), ""))
+}
+
+// OAuthAuthorize выдает код авторизации вошедшему пользователю
+// Возвращает адрес возврата клиента с кодом или ошибкой, на который страница входа перенаправляет браузер
+func (Handlers) OAuthAuthorize(ctx *gin.Context) {
+	var req domain.OAuthAuthorizeRequest
+	err := ctx.ShouldBindJSON(&req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.OAuthError{Code: domain.OAUTH_INVALID_REQUEST, Description: "Invalid body"})
+		return
+	}
+
+	if !oauthClientRedirect(ctx, req) {
+		return
+	}
+
+	code, err := OAuthService.Authorize(userContext(ctx), req)
+	var oauthErr *domain.OAuthError
+	if errors.As(err, &oauthErr) {
+		params := url.Values{"error": {oauthErr.Code}, "error_description": {oauthErr.Description}}
+		ctx.JSON(http.StatusOK, gin.H{"redirect_uri": redirectWith(req.RedirectUri, params, req.State)})
+		return
+	}
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"redirect_uri": redirectWith(req.RedirectUri, url.Values{"code": {code}}, req.State)})
+}
+
+// OAuthToken выдает токены клиенту
+// Клиент передает учетные данные в заголовке Basic или в теле запроса
+func (Handlers) OAuthToken(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Pragma", "no-cache")
+
+	var req domain.OAuthTokenRequest
+	err := ctx.ShouldBindWith(&req, binding.Form)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.OAuthError{Code: domain.OAUTH_INVALID_REQUEST, Description: "Invalid body"})
+		return
+	}
+
+	// В заголовке Basic учетные данные клиента закодированы как form-urlencoded (RFC 6749, раздел 2.3.1)
+	if id, secret, ok := ctx.Request.BasicAuth(); ok {
+		req.ClientId, err = url.QueryUnescape(id)
+		if err == nil {
+			req.ClientSecret, err = url.QueryUnescape(secret)
+		}
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, domain.OAuthError{Code: domain.OAUTH_INVALID_REQUEST, Description: "Invalid authorization header"})
+			return
+		}
+	}
+
+	tokens, err := OAuthService.Token(ctx, req)
+	var oauthErr *domain.OAuthError
+	if errors.As(err, &oauthErr) {
+		status := http.StatusBadRequest
+		if oauthErr.Code == domain.OAUTH_INVALID_CLIENT {
+			ctx.Header("WWW-Authenticate", `Basic realm="oauth"`)
+			status = http.StatusUnauthorized
+		}
+		ctx.JSON(status, oauthErr)
+		return
+	}
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+// OAuthUserInfo возвращает утверждения о владельце access токена
+func (Handlers) OAuthUserInfo(ctx *gin.Context) {
+	token, found := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	if !found || token == "" {
+		ctx.Header("WWW-Authenticate", `Bearer realm="oauth"`)
+		ctx.JSON(http.StatusUnauthorized, domain.OAuthError{Code: domain.OAUTH_INVALID_TOKEN, Description: "Access token is required"})
+		return
+	}
+
+	info, err := OAuthService.UserInfo(ctx, token)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if info == nil {
+		ctx.Header("WWW-Authenticate", `Bearer realm="oauth", error="invalid_token"`)
+		ctx.JSON(http.StatusUnauthorized, domain.OAuthError{Code: domain.OAUTH_INVALID_TOKEN, Description: "Access token is invalid or expired"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, info)
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"cmp"
+	"net/http"
+	"user/internal/domain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// oauthClientRequest - тело запроса регистрации и изменения клиента OAuth2
+type oauthClientRequest struct {
+	Name         string   `json:"name"`
+	Public       bool     `json:"public"`
+	RedirectUris []string `json:"redirect_uris"`
+	GrantTypes   []string `json:"grant_types"`
+	Scopes       []string `json:"scopes"`
+	IdTokenAlg   string   `json:"id_token_signed_response_alg"`
+}
+
+// oauthClientBody разбирает и проверяет настройки клиента
+// Возвращает nil, если запрос уже завершен с ошибкой
+func oauthClientBody(ctx *gin.Context) *domain.OAuthClient {
+	var req oauthClientRequest
+	err := ctx.ShouldBindJSON(&req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body"})
+		return nil
+	}
+
+	// Без явных настроек клиент входит пользователями по коду авторизации и получает их профиль и email
+	client := domain.OAuthClient{
+		Name:         req.Name,
+		Public:       req.Public,
+		RedirectUris: req.RedirectUris,
+		GrantTypes:   req.GrantTypes,
+		Scopes:       req.Scopes,
+		IdTokenAlg:   cmp.Or(req.IdTokenAlg, domain.ALG_RS256),
+	}
+
+	if client.RedirectUris == nil {
+		client.RedirectUris = []string{}
+	}
+
+	if client.GrantTypes == nil {
+		client.GrantTypes = []string{domain.GRANT_AUTHORIZATION_CODE, domain.GRANT_REFRESH_TOKEN}
+	}
+
+	if client.Scopes == nil {
+		client.Scopes = domain.UserScopes
+	}
+
+	if msg := validOAuthClient(client); msg != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return nil
+	}
+
+	return &client
+}
+
+// CreateOAuthClient регистрирует клиента OAuth2 в организации
+// Секрет конфиденциального клиента возвращается только в ответе на этот запрос
+func (Handlers) CreateOAuthClient(ctx *gin.Context) {
+	client := oauthClientBody(ctx)
+	if client == nil {
+		return
+	}
+
+	created, err := OAuthClientService.Create(userContext(ctx), *client)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, created)
+}
+
+// ListOAuthClients возвращает клиентов OAuth2 организации
+func (Handlers) ListOAuthClients(ctx *gin.Context) {
+	clients, err := OAuthClientService.List(userContext(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"clients": clients})
+}
+
+// GetOAuthClient возвращает клиента OAuth2
+func (Handlers) GetOAuthClient(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	client, err := OAuthClientService.Get(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if client == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "OAuth client not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, client)
+}
+
+// UpdateOAuthClient меняет настройки клиента OAuth2, тип клиента задается при регистрации и не меняется
+func (Handlers) UpdateOAuthClient(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	existing, err := OAuthClientService.Get(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if existing == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "OAuth client not found"})
+		return
+	}
+
+	client := oauthClientBody(ctx)
+	if client == nil {
+		return
+	}
+
+	if client.Public != existing.Public {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Client type cannot be changed"})
+		return
+	}
+
+	client.Id = id
+	found, err := OAuthClientService.Update(userContext(ctx), *client)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if !found {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "OAuth client not found"})
+		return
+	}
+
+	client.ClientId, client.OwnerId, client.CreatedAt = existing.ClientId, existing.OwnerId, existing.CreatedAt
+	ctx.JSON(http.StatusOK, client)
+}
+
+// DeleteOAuthClient удаляет клиента OAuth2 вместе с выданными ему токенами
+func (Handlers) DeleteOAuthClient(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	found, err := OAuthClientService.Delete(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if !found {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "OAuth client not found"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// RotateOAuthClientSecret выдает конфиденциальному клиенту новый секрет, прежний сразу перестает действовать
+func (Handlers) RotateOAuthClientSecret(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	client, err := OAuthClientService.RotateSecret(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if client == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Confidential OAuth client not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, client)
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"user/internal/domain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// slugRe - допустимое короткое имя организации
+var slugRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// organizationRequest - тело запроса создания организации
+type organizationRequest struct {
+	Slug        string `json:"slug"`
+	Name        string `json:"name"`
+	AllowSignup bool   `json:"allow_signup"`
+	AllowLogin  bool   `json:"allow_login"`
+}
+
+// policyRequest - тело запроса политики организации для анонимных запросов
+type policyRequest struct {
+	AllowSignup *bool `json:"allow_signup"`
+	AllowLogin  *bool `json:"allow_login"`
+}
+
+// CreateOrganization создает организацию
+func (Handlers) CreateOrganization(ctx *gin.Context) {
+	var req organizationRequest
+	err := ctx.ShouldBindJSON(&req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body"})
+		return
+	}
+
+	if !slugRe.MatchString(req.Slug) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid slug"})
+		return
+	}
+
+	if req.Name == "" || len(req.Name) > 255 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid name"})
+		return
+	}
+
+	org, err := OrganizationService.Create(userContext(ctx), domain.Organization{
+		Slug:        req.Slug,
+		Name:        req.Name,
+		AllowSignup: req.AllowSignup,
+		AllowLogin:  req.AllowLogin,
+	})
+	if err != nil {
+		if errors.Is(err, domain.ErrSlugExists) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Organization %s already exists", req.Slug)})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, org)
+}
+
+// ListOrganizations возвращает все организации
+func (Handlers) ListOrganizations(ctx *gin.Context) {
+	orgs, err := OrganizationService.List(userContext(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"organizations": orgs})
+}
+
+// Members возвращает пользователей других организаций, у которых есть доступ к организации
+func (Handlers) Members(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	members, err := OrganizationService.Members(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if members == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Organization with id %d not exist", id)})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"members": members})
+}
+
+// AddMember дает пользователю доступ к организации
+func (Handlers) AddMember(ctx *gin.Context) {
+	id, userId, ok := memberParams(ctx)
+	if !ok {
+		return
+	}
+
+	ok, err := OrganizationService.AddMember(userContext(ctx), id, userId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Organization or user not found"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// RemoveMember забирает у пользователя доступ к организации
+func (Handlers) RemoveMember(ctx *gin.Context) {
+	id, userId, ok := memberParams(ctx)
+	if !ok {
+		return
+	}
+
+	ok, err := OrganizationService.RemoveMember(userContext(ctx), id, userId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Membership not found"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// RotateScimToken выдает организации новый токен SCIM и показывает его один раз
+func (Handlers) RotateScimToken(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	token, err := OrganizationService.RotateScimToken(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if token == "" {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Organization with id %d not exist", id)})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"token": token})
+}
+
+// RevokeScimToken отключает SCIM организации
+func (Handlers) RevokeScimToken(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	ok, err := OrganizationService.RevokeScimToken(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Organization with id %d not exist", id)})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// SetOrganizationPolicy задает, можно ли анонимно регистрироваться и входить в организации
+func (Handlers) SetOrganizationPolicy(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	var req policyRequest
+	err := ctx.ShouldBindJSON(&req)
+	if err != nil || req.AllowSignup == nil || req.AllowLogin == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "allow_signup and allow_login are required"})
+		return
+	}
+
+	org, err := OrganizationService.SetPolicy(userContext(ctx), id, *req.AllowSignup, *req.AllowLogin)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if org == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Organization with id %d not exist", id)})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, org)
+}
+
+// memberParams разбирает организацию и пользователя из пути /organizations/:id/members/:user
+func memberParams(ctx *gin.Context) (domain.Id, domain.Id, bool) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return 0, 0, false
+	}
+
+	userId, err := strconv.ParseUint(ctx.Param("user"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+		return 0, 0, false
+	}
+
+	return id, userId, true
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+	"user/internal/domain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PASSKEY_NAME_LENGTH - максимальная длина названия ключа
+const PASSKEY_NAME_LENGTH = 64
+
+// BeginPasskeyRegistration выдает параметры navigator.credentials.create() для регистрации ключа
+// Ключ регистрирует только сам пользователь
+func (Handlers) BeginPasskeyRegistration(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	options, err := PasskeyService.BeginRegistration(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if options == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("User with id %d not exist", id)})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, options)
+}
+
+// FinishPasskeyRegistration проверяет ответ navigator.credentials.create() и сохраняет ключ
+func (Handlers) FinishPasskeyRegistration(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Name       string                    `json:"name"`
+		Credential domain.PasskeyAttestation `json:"credential"`
+	}
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body"})
+		return
+	}
+
+	if body.Name == "" {
+		body.Name = "Passkey"
+	}
+
+	if utf8.RuneCountInString(body.Name) > PASSKEY_NAME_LENGTH {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Name must be at most %d characters", PASSKEY_NAME_LENGTH)})
+		return
+	}
+
+	passkey, err := PasskeyService.FinishRegistration(userContext(ctx), id, body.Name, body.Credential)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidPasskey) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid passkey response"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, passkey)
+}
+
+// Passkeys возвращает ключи пользователя
+func (Handlers) Passkeys(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	passkeys, err := PasskeyService.List(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, passkeys)
+}
+
+// RevokePasskey удаляет ключ пользователя
+func (Handlers) RevokePasskey(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	passkey, err := strconv.ParseUint(ctx.Param("passkey"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid passkey id"})
+		return
+	}
+
+	ok, err = PasskeyService.Revoke(userContext(ctx), id, passkey)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Passkey with id %d not exist", passkey)})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// BeginPasskeyLogin выдает параметры navigator.credentials.get() для входа по ключу
+func (Handlers) BeginPasskeyLogin(ctx *gin.Context) {
+	options, err := PasskeyService.BeginLogin(userContext(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, options)
+}
+
+// FinishPasskeyLogin проверяет ответ navigator.credentials.get() и выдает пару токенов
+func (Handlers) FinishPasskeyLogin(ctx *gin.Context) {
+	var assertion domain.PasskeyAssertion
+	err := ctx.ShouldBindJSON(&assertion)
+	if err != nil || len(assertion.RawId) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body"})
+		return
+	}
+
+	tokens, err := PasskeyService.FinishLogin(userContext(ctx), assertion)
+	if err != nil {
+		if errors.Is(err, domain.ErrEmailNotVerified) {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Email is not verified"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if tokens == nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid passkey"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tokens)
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"user/internal/domain"
+	"user/internal/presentation/logger"
+	"user/internal/presentation/validation"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ForgotPassword отправляет ссылку сброса пароля на email
+// Ответ всегда 202: ни наличие пользователя, ни ошибка отправки письма не раскрываются
+func (Handlers) ForgotPassword(ctx *gin.Context) {
+	var body struct {
+		Login string `json:"email"`
+	}
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil || body.Login == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Email is required"})
+		return
+	}
+
+	err = PasswordResetService.Forgot(userContext(ctx), body.Login)
+	if err != nil {
+		logger.Logger.Warn(fmt.Sprintf("Password reset request error: %v", err))
+	}
+
+	ctx.Status(http.StatusAccepted)
+}
+
+// ResetPassword устанавливает новый пароль по токену из письма
+// Все сессии пользователя после сброса отзываются
+func (Handlers) ResetPassword(ctx *gin.Context) {
+	var body struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil || body.Token == "" || body.Password == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Token and password are required"})
+		return
+	}
+
+	hashPass, err := validation.ValidPass(body.Password)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = PasswordResetService.Reset(userContext(ctx), body.Token, hashPass)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidToken) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired token"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"user/internal/domain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles возвращает все роли с их правами
+func (Handlers) Roles(ctx *gin.Context) {
+	roles, err := RoleService.Roles(userContext(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// UserRoles возвращает назначенные роли пользователя
+func (Handlers) UserRoles(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	roles, err := RoleService.UserRoles(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if roles == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("User with id %d not exist", id)})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// AssignRole назначает роль пользователю
+func (Handlers) AssignRole(ctx *gin.Context) {
+	changeRole(ctx, true)
+}
+
+// RevokeRole отзывает роль у пользователя
+func (Handlers) RevokeRole(ctx *gin.Context) {
+	changeRole(ctx, false)
+}
+
+// changeRole назначает или отзывает роль из пути запроса
+func changeRole(ctx *gin.Context, granted bool) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	role := ctx.Param("role")
+	// Роль operator действует во всех организациях, поэтому ее раздает только operator
+	if role == domain.ROLE_OPERATOR && !can(ctx, domain.PERM_ORGANIZATIONS_MANAGE) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	change := RoleService.Revoke
+	if granted {
+		change = RoleService.Assign
+	}
+
+	ok, err := change(userContext(ctx), id, role)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrUnknownRole):
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown role %q", role)})
+		case errors.Is(err, domain.ErrLastRoleManager):
+			ctx.JSON(http.StatusConflict, gin.H{"error": "At least one user must be able to manage roles"})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		}
+		return
+	}
+
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("User with id %d not exist", id)})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
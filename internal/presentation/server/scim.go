@@ -0,0 +1,528 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"user/internal/domain"
+	"user/internal/presentation/scim"
+	"user/internal/presentation/validation"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// ScimAuth проверяет токен SCIM организации в заголовке Authorization
+// Организация запроса определяется токеном, заголовок X-Tenant-Id на маршрутах SCIM отклоняется
+func ScimAuth(ctx *gin.Context) {
+	if ctx.GetHeader(TENANT_HEADER) != "" {
+		scimError(ctx, http.StatusBadRequest, "", fmt.Sprintf("%s is not allowed, the organization is bound to the token", TENANT_HEADER))
+		ctx.Abort()
+		return
+	}
+
+	token, found := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	if !found || token == "" {
+		scimUnauthorized(ctx)
+		return
+	}
+
+	tenant, err := OrganizationService.ScimTenant(ctx.Request.Context(), token)
+	if err != nil {
+		scimError(ctx, http.StatusInternalServerError, "", "Internal error")
+		ctx.Abort()
+		return
+	}
+
+	if tenant == nil {
+		scimUnauthorized(ctx)
+		return
+	}
+
+	ctx.Set(TENANT_KEY, *tenant)
+	ctx.Next()
+}
+
+// scimUnauthorized отклоняет запрос с неверным токеном
+func scimUnauthorized(ctx *gin.Context) {
+	ctx.Header("WWW-Authenticate", `Bearer realm="scim"`)
+	scimError(ctx, http.StatusUnauthorized, "", "Invalid token")
+	ctx.Abort()
+}
+
+// ScimCreate создает пользователя из ресурса SCIM
+// Пользователь без пароля получает случайный пароль и не сможет войти до его сброса
+func (Handlers) ScimCreate(ctx *gin.Context) {
+	var resource scim.User
+	if !scimBody(ctx, &resource) {
+		return
+	}
+
+	user := resource.ToDomain()
+	if !scimValidLogin(ctx, user.Login) {
+		return
+	}
+
+	var err error
+	if user.Password != "" {
+		user.Password, err = validation.ValidPass(user.Password)
+		if err != nil {
+			scimError(ctx, http.StatusBadRequest, scim.SCIM_TYPE_VALUE, "Invalid password")
+			return
+		}
+	} else {
+		user.Password, err = randomPassword()
+		if err != nil {
+			scimError(ctx, http.StatusInternalServerError, "", "Internal error")
+			return
+		}
+	}
+
+	// Неактивный пользователь создается сразу удаленным, чтобы между созданием и отключением он не мог войти
+	create := UserService.Create
+	if !resource.IsActive() {
+		create = UserService.CreateDeleted
+	}
+
+	id, err := create(userContext(ctx), user)
+	if err != nil {
+		scimError(ctx, http.StatusInternalServerError, "", "Internal error")
+		return
+	}
+
+	if id == nil {
+		scimError(ctx, http.StatusConflict, scim.SCIM_TYPE_UNIQUENESS, fmt.Sprintf("User with userName %s already exist", user.Login))
+		return
+	}
+
+	created, err := UserService.Get(userContext(ctx), *id, true)
+	if err != nil || created == nil {
+		scimError(ctx, http.StatusInternalServerError, "", "Internal error")
+		return
+	}
+
+	location := scimLocation(ctx, *id)
+	ctx.Header("Location", location)
+	scimJSON(ctx, http.StatusCreated, scim.FromDomain(created, location))
+}
+
+// ScimGet возвращает пользователя, в том числе неактивного
+func (Handlers) ScimGet(ctx *gin.Context) {
+	user := scimUser(ctx)
+	if user == nil {
+		return
+	}
+
+	scimJSON(ctx, http.StatusOK, scim.FromDomain(user, scimLocation(ctx, user.Id)))
+}
+
+// ScimList возвращает пользователей по фильтру с постраничной выдачей startIndex/count
+func (Handlers) ScimList(ctx *gin.Context) {
+	startIndex, count := 1, scim.MAX_RESULTS
+	if value := ctx.Query("startIndex"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			scimError(ctx, http.StatusBadRequest, scim.SCIM_TYPE_VALUE, "Invalid startIndex")
+			return
+		}
+		// RFC 7644 3.4.2.4: значение меньше 1 трактуется как 1
+		startIndex = max(n, 1)
+	}
+
+	if value := ctx.Query("count"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			scimError(ctx, http.StatusBadRequest, scim.SCIM_TYPE_VALUE, "Invalid count")
+			return
+		}
+		count = min(max(n, 0), scim.MAX_RESULTS)
+	}
+
+	var filter scim.Filter
+	query, exact := domain.UserFilter{WithDeleted: true}, true
+	if value := ctx.Query("filter"); value != "" {
+		var err error
+		filter, err = scim.ParseFilter(value)
+		if err != nil {
+			scimError(ctx, http.StatusBadRequest, scim.SCIM_TYPE_FILTER, err.Error())
+			return
+		}
+		query, exact = scim.Narrow(filter)
+	}
+
+	resp := scim.ListResponse{
+		Schemas:    []string{scim.LIST_SCHEMA},
+		StartIndex: startIndex,
+		Resources:  []scim.User{},
+	}
+
+	// Фильтр целиком выражен условиями базы: totalResults считает COUNT(*), страница читается со смещением
+	if exact {
+		total, err := UserService.Count(userContext(ctx), query)
+		if err != nil {
+			scimError(ctx, http.StatusInternalServerError, "", "Internal error")
+			return
+		}
+		resp.TotalResults = total
+
+		if count > 0 && startIndex <= total {
+			query.Offset, query.Limit = startIndex-1, count
+			page, err := UserService.List(userContext(ctx), query)
+			if err != nil {
+				scimError(ctx, http.StatusInternalServerError, "", "Internal error")
+				return
+			}
+
+			for i := range page.Users {
+				resp.Resources = append(resp.Resources, scim.FromDomain(&page.Users[i], scimLocation(ctx, page.Users[i].Id)))
+			}
+		}
+
+		resp.ItemsPerPage = len(resp.Resources)
+		scimJSON(ctx, http.StatusOK, resp)
+		return
+	}
+
+	// Остальные фильтры проверяются через Match на страницах по id, выборка ограничена MAX_SCAN
+	query.Limit = scim.MAX_RESULTS
+	scanned := 0
+	for {
+		page, err := UserService.List(userContext(ctx), query)
+		if err != nil {
+			scimError(ctx, http.StatusInternalServerError, "", "Internal error")
+			return
+		}
+
+		scanned += len(page.Users)
+		if scanned > scim.MAX_SCAN {
+			scimError(ctx, http.StatusBadRequest, scim.SCIM_TYPE_TOO_MANY, fmt.Sprintf("Filter requires checking more than %d users, narrow it with userName eq", scim.MAX_SCAN))
+			return
+		}
+
+		for i := range page.Users {
+			resource := scim.FromDomain(&page.Users[i], scimLocation(ctx, page.Users[i].Id))
+			if !scim.Match(filter, &resource) {
+				continue
+			}
+
+			resp.TotalResults++
+			if resp.TotalResults >= startIndex && len(resp.Resources) < count {
+				resp.Resources = append(resp.Resources, resource)
+			}
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+
+	resp.ItemsPerPage = len(resp.Resources)
+	scimJSON(ctx, http.StatusOK, resp)
+}
+
+// ScimReplace заменяет атрибуты пользователя из SCIM
+// Атрибуты, которых нет в SCIM (дата рождения), сохраняются
+func (Handlers) ScimReplace(ctx *gin.Context) {
+	current := scimUser(ctx)
+	if current == nil {
+		return
+	}
+
+	var resource scim.User
+	if !scimBody(ctx, &resource) {
+		return
+	}
+
+	scimSave(ctx, current, resource)
+}
+
+// ScimPatch применяет операции PATCH к пользователю
+func (Handlers) ScimPatch(ctx *gin.Context) {
+	current := scimUser(ctx)
+	if current == nil {
+		return
+	}
+
+	var req scim.PatchRequest
+	if !scimBody(ctx, &req) {
+		return
+	}
+
+	resource := scim.FromDomain(current, "")
+	err := scim.ApplyPatch(&resource, req.Operations)
+	if err != nil {
+		if scimErr, ok := scim.AsError(err); ok {
+			scimError(ctx, http.StatusBadRequest, scimErr.ScimType, scimErr.Detail)
+			return
+		}
+
+		scimError(ctx, http.StatusBadRequest, scim.SCIM_TYPE_SYNTAX, "Invalid operations")
+		return
+	}
+
+	scimSave(ctx, current, resource)
+}
+
+// ScimDelete безвозвратно удаляет пользователя
+// Для отключения без удаления IdP использует active=false
+func (Handlers) ScimDelete(ctx *gin.Context) {
+	id, ok := scimId(ctx)
+	if !ok {
+		return
+	}
+
+	found, err := UserService.Purge(userContext(ctx), id)
+	if err != nil {
+		scimError(ctx, http.StatusInternalServerError, "", "Internal error")
+		return
+	}
+
+	if !found {
+		scimError(ctx, http.StatusNotFound, "", fmt.Sprintf("User %d not found", id))
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// ScimServiceProviderConfig возвращает описание возможностей сервиса
+func (Handlers) ScimServiceProviderConfig(ctx *gin.Context) {
+	scimJSON(ctx, http.StatusOK, scim.ServiceProviderConfig(scimBase(ctx)))
+}
+
+// ScimResourceTypes возвращает типы ресурсов или один тип по идентификатору
+func (Handlers) ScimResourceTypes(ctx *gin.Context) {
+	scimDiscovery(ctx, scim.ResourceTypes(scimBase(ctx)))
+}
+
+// ScimSchemas возвращает схемы ресурсов или одну схему по идентификатору
+func (Handlers) ScimSchemas(ctx *gin.Context) {
+	scimDiscovery(ctx, scim.Schemas(scimBase(ctx)))
+}
+
+// scimDiscovery отдает список ресурсов обнаружения или один ресурс по параметру id
+func scimDiscovery(ctx *gin.Context, resources []map[string]any) {
+	id := ctx.Param("id")
+	if id == "" {
+		scimJSON(ctx, http.StatusOK, gin.H{
+			"schemas":      []string{scim.LIST_SCHEMA},
+			"totalResults": len(resources),
+			"startIndex":   1,
+			"itemsPerPage": len(resources),
+			"Resources":    resources,
+		})
+		return
+	}
+
+	for _, resource := range resources {
+		if resource["id"] == id {
+			scimJSON(ctx, http.StatusOK, resource)
+			return
+		}
+	}
+
+	scimError(ctx, http.StatusNotFound, "", fmt.Sprintf("Resource %s not found", id))
+}
+
+// scimSave приводит пользователя к состоянию ресурса и отдает результат
+// Смена active выполняется через мягкое удаление и восстановление в одной транзакции с изменением полей
+func scimSave(ctx *gin.Context, current *domain.User, resource scim.User) {
+	user := resource.ToDomain()
+	if !scimValidLogin(ctx, user.Login) {
+		return
+	}
+
+	patch := domain.UserPatch{}
+	if user.FirstName != current.FirstName {
+		patch.FirstName = &user.FirstName
+	}
+	if user.LastName != current.LastName {
+		patch.LastName = &user.LastName
+	}
+	if user.Login != current.Login {
+		patch.Login = &user.Login
+	}
+	if user.Password != "" {
+		hashPass, err := validation.ValidPass(user.Password)
+		if err != nil {
+			scimError(ctx, http.StatusBadRequest, scim.SCIM_TYPE_VALUE, "Invalid password")
+			return
+		}
+		patch.Password = &hashPass
+		patch.PlainPassword = user.Password
+	}
+
+	found, err := UserService.SetState(userContext(ctx), current.Id, patch, resource.IsActive())
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.Is(err, domain.ErrUserInactive):
+			scimError(ctx, http.StatusBadRequest, scim.SCIM_TYPE_MUTABILITY, "Inactive user can't be modified")
+		case errors.As(err, &pqErr) && pqErr.Code == NOT_UNIQUE_LOGIN:
+			scimError(ctx, http.StatusConflict, scim.SCIM_TYPE_UNIQUENESS, fmt.Sprintf("User with userName %s already exist", user.Login))
+		default:
+			scimError(ctx, http.StatusInternalServerError, "", "Internal error")
+		}
+		return
+	}
+
+	if !found {
+		scimError(ctx, http.StatusNotFound, "", fmt.Sprintf("User %d not found", current.Id))
+		return
+	}
+
+	updated, err := UserService.Get(userContext(ctx), current.Id, true)
+	if err != nil {
+		scimError(ctx, http.StatusInternalServerError, "", "Internal error")
+		return
+	}
+
+	if updated == nil {
+		scimError(ctx, http.StatusNotFound, "", fmt.Sprintf("User %d not found", current.Id))
+		return
+	}
+
+	scimJSON(ctx, http.StatusOK, scim.FromDomain(updated, scimLocation(ctx, updated.Id)))
+}
+
+// scimUser возвращает пользователя из пути запроса, в том числе неактивного
+// Возвращает nil, если запрос уже завершен с ошибкой
+func scimUser(ctx *gin.Context) *domain.User {
+	id, ok := scimId(ctx)
+	if !ok {
+		return nil
+	}
+
+	user, err := UserService.Get(userContext(ctx), id, true)
+	if err != nil {
+		scimError(ctx, http.StatusInternalServerError, "", "Internal error")
+		return nil
+	}
+
+	if user == nil {
+		scimError(ctx, http.StatusNotFound, "", fmt.Sprintf("User %d not found", id))
+		return nil
+	}
+
+	return user
+}
+
+// scimId получает идентификатор пользователя из пути запроса
+func scimId(ctx *gin.Context) (domain.Id, bool) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		scimError(ctx, http.StatusNotFound, "", fmt.Sprintf("User %s not found", ctx.Param("id")))
+		return 0, false
+	}
+
+	return id, true
+}
+
+// scimBody читает тело запроса в dst
+func scimBody(ctx *gin.Context, dst any) bool {
+	err := json.NewDecoder(ctx.Request.Body).Decode(dst)
+	if err != nil {
+		scimError(ctx, http.StatusBadRequest, scim.SCIM_TYPE_SYNTAX, "Invalid body")
+		return false
+	}
+
+	return true
+}
+
+// scimValidLogin проверяет userName, логином сервиса служит адрес почты
+func scimValidLogin(ctx *gin.Context, login string) bool {
+	if login == "" {
+		scimError(ctx, http.StatusBadRequest, scim.SCIM_TYPE_VALUE, "userName is required")
+		return false
+	}
+
+	if !validation.IsValidEmail(login) {
+		scimError(ctx, http.StatusBadRequest, scim.SCIM_TYPE_VALUE, "userName must be an email")
+		return false
+	}
+
+	return true
+}
+
+// scimBase возвращает адрес корня SCIM для meta.location
+// X-Forwarded-Proto учитывается только от доверенного прокси, иначе клиент подменил бы схему ссылок
+func scimBase(ctx *gin.Context) string {
+	scheme := "http"
+	if ctx.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := ctx.GetHeader("X-Forwarded-Proto"); (proto == "http" || proto == "https") && trustedProxy(ctx.RemoteIP()) {
+		scheme = proto
+	}
+
+	return fmt.Sprintf("%s://%s/scim/v2", scheme, ctx.Request.Host)
+}
+
+// trustedProxy проверяет, входит ли адрес соединения в TrustedProxies
+// Ошибочные записи пропускаются, как и при настройке сервера
+func trustedProxy(remote string) bool {
+	ip := net.ParseIP(remote)
+	if ip == nil {
+		return false
+	}
+
+	for _, proxy := range TrustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			if ip.Equal(net.ParseIP(proxy)) {
+				return true
+			}
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// scimLocation возвращает адрес ресурса пользователя
+func scimLocation(ctx *gin.Context, id domain.Id) string {
+	return fmt.Sprintf("%s/Users/%d", scimBase(ctx), id)
+}
+
+// scimJSON отдает ответ с типом application/scim+json
+func scimJSON(ctx *gin.Context, code int, obj any) {
+	ctx.Header("Content-Type", scim.CONTENT_TYPE)
+	ctx.JSON(code, obj)
+}
+
+// scimError отдает ошибку в формате RFC 7644 3.12
+func scimError(ctx *gin.Context, code int, scimType, detail string) {
+	body := gin.H{
+		"schemas": []string{scim.ERROR_SCHEMA},
+		"status":  strconv.Itoa(code),
+		"detail":  detail,
+	}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+
+	scimJSON(ctx, code, body)
+}
+
+// randomPassword возвращает хэш случайного пароля для пользователя, созданного без пароля
+func randomPassword() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("generating password error: %v", err)
+	}
+
+	return validation.GenHash(base64.RawURLEncoding.EncodeToString(buf))
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"user/internal/domain"
 	"user/internal/interfaces"
 	"user/internal/presentation/db"
 	"user/internal/presentation/logger"
@@ -13,6 +14,35 @@ var (
 	DbService    *db.DB
 	CacheService interfaces.CacheRepo
 	UserService  interfaces.UserRepo
+	AuthService  interfaces.AuthRepo
+	AuditService interfaces.AuditRepo
+
+	WebhookService interfaces.WebhookRepo
+	ImportService  interfaces.ImportRepo
+	GdprService    interfaces.GdprRepo
+	RoleService    interfaces.RoleRepo
+
+	OrganizationService interfaces.OrganizationRepo
+	VerificationService interfaces.VerificationRepo
+
+	PasswordResetService interfaces.PasswordResetRepo
+	MfaService           interfaces.MfaRepo
+	PasskeyService       interfaces.PasskeyRepo
+	LockoutService       interfaces.LockoutRepo
+	SessionService       interfaces.SessionRepo
+
+	OAuthService       interfaces.OAuthRepo
+	OAuthClientService interfaces.OAuthClientRepo
+
+	// RequireIfMatch требует заголовок If-Match для изменения пользователя
+	RequireIfMatch bool
+
+	// OAuthLoginUrl - страница входа, которой передается запрос авторизации OAuth2
+	OAuthLoginUrl string
+
+	// TrustedProxies - адреса и сети обратных прокси, которым доверяются X-Forwarded-For и X-Forwarded-Proto
+	// Без них адрес клиента берется из соединения, иначе его можно подменить заголовком
+	TrustedProxies []string
 )
 
 // Server определяет сервер с сервисами
@@ -24,12 +54,114 @@ type Server struct {
 func NewServer() *Server {
 	// gin.SetMode(gin.ReleaseMode)
 	srv := gin.New()
+	// С ошибочным списком сервер не доверяет никому: адрес соединения безопаснее подмененного
+	err := srv.SetTrustedProxies(TrustedProxies)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Trusted proxies setting error - %v", err))
+		_ = srv.SetTrustedProxies(nil)
+	}
+	srv.Use(RequestId, Tenant)
 
 	h := NewHandlers()
 
-	srv.POST("/users", h.Create)
-	srv.GET("/users", h.Get)
-	srv.PUT("/users", h.Put)
+	// Регистрация открыта, остальные действия с пользователем проверяются по правам ролей
+	// Анонимно зарегистрироваться или войти в другой организации можно, только если это разрешает ее политика
+	srv.POST("/users", Identify, TenantPolicy(domain.POLICY_SIGNUP), h.Create)
+	srv.GET("/users", Authenticate, Authorize(domain.PERM_USERS_READ_SELF, domain.PERM_USERS_READ_ANY), h.Get)
+	srv.PUT("/users", Authenticate, Authorize(domain.PERM_USERS_WRITE_SELF, domain.PERM_USERS_WRITE_ANY), h.Put)
+	srv.DELETE("/users", Authenticate, Authorize(domain.PERM_USERS_DELETE_SELF, domain.PERM_USERS_DELETE_ANY), h.Delete)
+	srv.POST("/users:method", Identify, h.UsersMethod)
+	srv.POST("/users/verify-email", h.VerifyEmail)
+	srv.POST("/users/verify-email/resend", TenantPolicy(domain.POLICY_SIGNUP), h.ResendVerification)
+	srv.PATCH("/users/:id", Authenticate, Authorize(domain.PERM_USERS_WRITE_SELF, domain.PERM_USERS_WRITE_ANY), h.Patch)
+	srv.POST("/users/:id/restore", Authenticate, RequirePermission(domain.PERM_USERS_MANAGE), h.Restore)
+	srv.POST("/users/:id/purge", Authenticate, RequirePermission(domain.PERM_USERS_MANAGE), h.Purge)
+	srv.POST("/users/:id/unlock", Authenticate, RequirePermission(domain.PERM_USERS_MANAGE), h.Unlock)
+	srv.GET("/users/:id/audit", Authenticate, Authorize(domain.PERM_USERS_READ_SELF, domain.PERM_USERS_READ_ANY), h.Audit)
+	srv.GET("/users/:id/gdpr-export", Authenticate, Authorize(domain.PERM_USERS_READ_SELF, domain.PERM_USERS_READ_ANY), h.GdprExport)
+	srv.POST("/users/:id/erase", Authenticate, RequirePermission(domain.PERM_USERS_MANAGE), h.Erase)
+	srv.PUT("/users/:id/consents/:purpose", Authenticate, Authorize(domain.PERM_USERS_WRITE_SELF, domain.PERM_USERS_WRITE_ANY), h.SetConsent)
+	srv.GET("/users/:id/roles", Authenticate, Authorize(domain.PERM_USERS_READ_SELF, domain.PERM_ROLES_MANAGE), h.UserRoles)
+	srv.PUT("/users/:id/roles/:role", Authenticate, RequirePermission(domain.PERM_ROLES_MANAGE), h.AssignRole)
+	srv.DELETE("/users/:id/roles/:role", Authenticate, RequirePermission(domain.PERM_ROLES_MANAGE), h.RevokeRole)
+	srv.GET("/users/export", Authenticate, RequirePermission(domain.PERM_USERS_MANAGE), h.Export)
+	srv.POST("/users/:id/mfa/totp", Authenticate, Authorize(domain.PERM_USERS_WRITE_SELF, ""), h.EnrollTotp)
+	srv.POST("/users/:id/mfa/totp/confirm", Authenticate, Authorize(domain.PERM_USERS_WRITE_SELF, ""), h.ConfirmTotp)
+	srv.DELETE("/users/:id/mfa/totp", Authenticate, Authorize(domain.PERM_USERS_WRITE_SELF, domain.PERM_USERS_MANAGE), h.DisableTotp)
+	srv.POST("/users/:id/passkeys/register/begin", Authenticate, Authorize(domain.PERM_USERS_WRITE_SELF, ""), h.BeginPasskeyRegistration)
+	srv.POST("/users/:id/passkeys/register/finish", Authenticate, Authorize(domain.PERM_USERS_WRITE_SELF, ""), h.FinishPasskeyRegistration)
+	srv.GET("/users/:id/passkeys", Authenticate, Authorize(domain.PERM_USERS_READ_SELF, domain.PERM_USERS_READ_ANY), h.Passkeys)
+	srv.DELETE("/users/:id/passkeys/:passkey", Authenticate, Authorize(domain.PERM_USERS_WRITE_SELF, domain.PERM_USERS_MANAGE), h.RevokePasskey)
+	srv.GET("/users/:id/sessions", Authenticate, Authorize(domain.PERM_USERS_READ_SELF, domain.PERM_USERS_READ_ANY), h.Sessions)
+	srv.DELETE("/users/:id/sessions", Authenticate, Authorize(domain.PERM_USERS_WRITE_SELF, domain.PERM_USERS_MANAGE), h.RevokeSessions)
+	srv.DELETE("/users/:id/sessions/:session", Authenticate, Authorize(domain.PERM_USERS_WRITE_SELF, domain.PERM_USERS_MANAGE), h.RevokeSession)
+	srv.GET("/roles", Authenticate, RequirePermission(domain.PERM_ROLES_MANAGE), h.Roles)
+
+	organizations := srv.Group("/organizations", Authenticate, RequirePermission(domain.PERM_ORGANIZATIONS_MANAGE))
+	organizations.POST("", h.CreateOrganization)
+	organizations.GET("", h.ListOrganizations)
+	organizations.GET("/:id/members", h.Members)
+	organizations.PUT("/:id/members/:user", h.AddMember)
+	organizations.DELETE("/:id/members/:user", h.RemoveMember)
+	organizations.POST("/:id/scim-token", h.RotateScimToken)
+	organizations.DELETE("/:id/scim-token", h.RevokeScimToken)
+	organizations.PUT("/:id/policy", h.SetOrganizationPolicy)
+
+	webhooks := srv.Group("/webhooks", Authenticate, RequirePermission(domain.PERM_WEBHOOKS_MANAGE))
+	webhooks.POST("", h.CreateWebhook)
+	webhooks.GET("", h.ListWebhooks)
+	webhooks.GET("/:id", h.GetWebhook)
+	webhooks.DELETE("/:id", h.DeleteWebhook)
+	webhooks.GET("/:id/deliveries", h.Deliveries)
+	webhooks.POST("/:id/deliveries/:delivery/redeliver", h.Redeliver)
+
+	imports := srv.Group("/imports", Authenticate, RequirePermission(domain.PERM_USERS_MANAGE))
+	imports.POST("", h.CreateImport)
+	imports.GET("/:id", h.GetImport)
+	imports.GET("/:id/report", h.ImportReport)
+
+	scimGroup := srv.Group("/scim/v2")
+	scimGroup.GET("/ServiceProviderConfig", h.ScimServiceProviderConfig)
+	scimGroup.GET("/ResourceTypes", h.ScimResourceTypes)
+	scimGroup.GET("/ResourceTypes/:id", h.ScimResourceTypes)
+	scimGroup.GET("/Schemas", h.ScimSchemas)
+	scimGroup.GET("/Schemas/:id", h.ScimSchemas)
+
+	scimUsers := scimGroup.Group("/Users", ScimAuth)
+	scimUsers.POST("", h.ScimCreate)
+	scimUsers.GET("", h.ScimList)
+	scimUsers.GET("/:id", h.ScimGet)
+	scimUsers.PUT("/:id", h.ScimReplace)
+	scimUsers.PATCH("/:id", h.ScimPatch)
+	scimUsers.DELETE("/:id", h.ScimDelete)
+
+	oauthClients := srv.Group("/oauth/clients", Authenticate, RequirePermission(domain.PERM_OAUTH_CLIENTS_MANAGE))
+	oauthClients.POST("", h.CreateOAuthClient)
+	oauthClients.GET("", h.ListOAuthClients)
+	oauthClients.GET("/:id", h.GetOAuthClient)
+	oauthClients.PUT("/:id", h.UpdateOAuthClient)
+	oauthClients.DELETE("/:id", h.DeleteOAuthClient)
+	oauthClients.POST("/:id/secret", h.RotateOAuthClientSecret)
+
+	oauth := srv.Group("/oauth")
+	oauth.GET("/authorize", h.OAuthAuthorizeRedirect)
+	oauth.POST("/authorize", Authenticate, h.OAuthAuthorize)
+	oauth.POST("/token", h.OAuthToken)
+	oauth.GET("/userinfo", h.OAuthUserInfo)
+	oauth.POST("/userinfo", h.OAuthUserInfo)
+
+	srv.GET("/.well-known/openid-configuration", h.OpenIdConfiguration)
+	srv.GET("/.well-known/jwks.json", h.Jwks)
+
+	auth := srv.Group("/auth")
+	auth.POST("/login", TenantPolicy(domain.POLICY_LOGIN), h.Login)
+	auth.POST("/login/mfa", TenantPolicy(domain.POLICY_LOGIN), h.LoginMfa)
+	auth.POST("/passkey/begin", TenantPolicy(domain.POLICY_LOGIN), h.BeginPasskeyLogin)
+	auth.POST("/passkey/finish", TenantPolicy(domain.POLICY_LOGIN), h.FinishPasskeyLogin)
+	auth.POST("/refresh", h.Refresh)
+	auth.POST("/logout", Authenticate, h.Logout)
+	auth.POST("/password/forgot", TenantPolicy(domain.POLICY_LOGIN), h.ForgotPassword)
+	auth.POST("/password/reset", h.ResetPassword)
 
 	logger.Logger.Info("Server has been created")
 	return &Server{
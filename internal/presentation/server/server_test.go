@@ -2,22 +2,40 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"user/internal/domain"
+	"user/internal/interfaces"
 	"user/internal/presentation/db"
+	"user/internal/presentation/jose"
 	"user/internal/presentation/logger"
 	"user/internal/presentation/realization"
+	"user/internal/presentation/validation"
+	"user/internal/presentation/webauthn"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
 
+// mails - письма, отправленные в тестах
+var mails = realization.NewMemoryMailer()
+
+// PASSKEY_ORIGIN - источник страницы программного аутентификатора в тестах
+const PASSKEY_ORIGIN = "http://localhost:8080"
+
+// LOGIN_MAX_ATTEMPTS - количество неудачных входов до блокировки в тестах
+const LOGIN_MAX_ATTEMPTS = 3
+
 func SetEnv() {
 	gin.SetMode(gin.TestMode)
 
@@ -55,11 +73,66 @@ func SetEnv() {
 		return
 	}
 
-	cacheRepo := realization.NewConnectRedis(redisHost, redisPort, redisPass)
+	keys, err := realization.ParseKeys(os.Getenv("ENCRYPTION_KEYS"))
+	if err != nil {
+		logger.Logger.Fatal(fmt.Sprintf("Keyring creating error - %v", err))
+		return
+	}
+
+	indexKey, _ := base64.StdEncoding.DecodeString(os.Getenv("BLIND_INDEX_KEY"))
+	keyring, err := realization.NewKeyring(keys, os.Getenv("ENCRYPTION_ACTIVE_KEY"), indexKey)
+	if err != nil {
+		logger.Logger.Fatal(fmt.Sprintf("Keyring creating error - %v", err))
+		return
+	}
+
+	_, err = realization.NewKeyRotator(dataBase, keyring, time.Minute, 100).Backfill(context.Background())
+	if err != nil {
+		logger.Logger.Fatal(fmt.Sprintf("Encrypting users error - %v", err))
+		return
+	}
+
+	cacheRepo := realization.NewConnectRedis(redisHost, redisPort, redisPass, keyring)
 	CacheService = cacheRepo
 
-	userService := realization.NewUserService(dataBase, cacheRepo)
+	hasher, err := realization.NewPasswordHasher(realization.ARGON2ID, realization.NewArgon2idHasher(1, 64*1024, 2), realization.NewBcryptHasher(4))
+	if err != nil {
+		logger.Logger.Fatal(fmt.Sprintf("Password hasher creating error - %v", err))
+		return
+	}
+	validation.Hasher = hasher
+
+	userService := realization.NewUserService(dataBase, cacheRepo, keyring, hasher)
 	UserService = userService
+	AuditService = realization.NewAuditService(dataBase)
+	GdprService = realization.NewGdprService(dataBase, cacheRepo, keyring)
+	RoleService = realization.NewRoleService(dataBase)
+	OrganizationService = realization.NewOrganizationService(dataBase)
+
+	mfaService := realization.NewMfaService(dataBase, keyring, "User", nil)
+	MfaService = mfaService
+
+	authService := realization.NewAuthService(dataBase, hasher, keyring, mfaService, cacheRepo, time.Minute*15, time.Hour, false)
+	AuthService = authService
+	SessionService = realization.NewSessionService(dataBase, cacheRepo, keyring)
+	LockoutService = realization.NewLockoutService(dataBase, cacheRepo, keyring, LOGIN_MAX_ATTEMPTS, 100, time.Minute)
+	PasskeyService = realization.NewPasskeyService(dataBase, keyring, authService, webauthn.RelyingParty{Id: "localhost", Name: "User", Origins: []string{PASSKEY_ORIGIN}})
+
+	verificationService, err := realization.NewVerificationService(dataBase, keyring, mails, make([]byte, realization.KEY_SIZE), time.Hour, "")
+	if err != nil {
+		logger.Logger.Fatal(fmt.Sprintf("Verification service creating error - %v", err))
+	}
+	VerificationService = verificationService
+	PasswordResetService = realization.NewPasswordResetService(dataBase, cacheRepo, keyring, mails, time.Hour, "")
+
+	oauthService := realization.NewOAuthService(dataBase, keyring, "http://localhost:8080", time.Minute*15, time.Hour, time.Hour*24*30)
+	err = oauthService.RotateKeys(context.Background())
+	if err != nil {
+		logger.Logger.Fatal(fmt.Sprintf("OAuth signing keys creating error - %v", err))
+	}
+	OAuthService = oauthService
+	OAuthClientService = realization.NewOAuthClientService(dataBase)
+	OAuthLoginUrl = "http://localhost:8080/login"
 }
 
 func TestCreateHandler(t *testing.T) {
@@ -272,3 +345,914 @@ func TestPutHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestLoginHandler(t *testing.T) {
+	SetEnv()
+
+	tests := []struct {
+		name         string
+		input        domain.Credentials
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name: "Missing password",
+			input: domain.Credentials{
+				Login: "john.doe@example.com",
+			},
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"error":"Email and password are required"}`,
+		},
+		{
+			name: "Unknown email",
+			input: domain.Credentials{
+				Login:    "nobody@example.com",
+				Password: "StrongPassword123!",
+			},
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"error":"Invalid email or password"}`,
+		},
+		{
+			name: "Wrong password",
+			input: domain.Credentials{
+				Login:    "updated.user@example.com",
+				Password: "WrongPassword123!",
+			},
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"error":"Invalid email or password"}`,
+		},
+		{
+			name: "Valid credentials",
+			input: domain.Credentials{
+				Login:    "updated.user@example.com",
+				Password: "UpdatedStrongPassword123!",
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: `"access_token":`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := gin.Default()
+			h := NewHandlers()
+			router.POST("/login", h.Login)
+
+			body, _ := json.Marshal(test.input)
+			req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != test.expectedCode {
+				t.Errorf("expected %d, got %d", test.expectedCode, w.Code)
+			}
+
+			if test.expectedBody != "" && !bytes.Contains(w.Body.Bytes(), []byte(test.expectedBody)) {
+				t.Errorf("expected body to contain %s, got %s", test.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestLoginMfaHandler(t *testing.T) {
+	SetEnv()
+
+	tests := []struct {
+		name         string
+		input        map[string]string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Missing code",
+			input:        map[string]string{"mfa_token": "token"},
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"error":"MFA token and code are required"}`,
+		},
+		{
+			name:         "Unknown token",
+			input:        map[string]string{"mfa_token": "token", "code": "123456"},
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"error":"Invalid MFA token or code"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := gin.Default()
+			h := NewHandlers()
+			router.POST("/auth/login/mfa", h.LoginMfa)
+
+			body, _ := json.Marshal(test.input)
+			req, _ := http.NewRequest(http.MethodPost, "/auth/login/mfa", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != test.expectedCode {
+				t.Errorf("expected %d, got %d", test.expectedCode, w.Code)
+			}
+
+			if test.expectedBody != "" && !bytes.Contains(w.Body.Bytes(), []byte(test.expectedBody)) {
+				t.Errorf("expected body to contain %s, got %s", test.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestDeleteHandler(t *testing.T) {
+	SetEnv()
+
+	tests := []struct {
+		name         string
+		queryParam   string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Missing ID",
+			queryParam:   "",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"error":"Id is required"}`,
+		},
+		{
+			name:         "Invalid ID format",
+			queryParam:   "abc",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"error":"Invalid id"}`,
+		},
+		{
+			name:         "User not found",
+			queryParam:   "999",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"error":"User with id 999 not exist"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := gin.Default()
+			h := NewHandlers()
+			router.DELETE("/delete", h.Delete)
+
+			req, _ := http.NewRequest(http.MethodDelete, "/delete?id="+test.queryParam, nil)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != test.expectedCode {
+				t.Errorf("expected %d, got %d", test.expectedCode, w.Code)
+			}
+
+			if test.expectedBody != "" && !bytes.Contains(w.Body.Bytes(), []byte(test.expectedBody)) {
+				t.Errorf("expected body to contain %s, got %s", test.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		expected bool
+	}{
+		{name: "No header", header: "", expected: false},
+		{name: "Same version", header: `"3"`, expected: true},
+		{name: "Weak same version", header: `W/"3"`, expected: true},
+		{name: "Other version", header: `"2"`, expected: false},
+		{name: "List with version", header: `"1", "3"`, expected: true},
+		{name: "Any", header: "*", expected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request, _ = http.NewRequest(http.MethodGet, "/users?id=1", nil)
+			if test.header != "" {
+				ctx.Request.Header.Set("If-None-Match", test.header)
+			}
+
+			if got := notModified(ctx, etag(3)); got != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestUsersMethodHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tooMany := make([]domain.Id, MAX_BATCH+1)
+	tooManyBody, _ := json.Marshal(map[string]any{"ids": tooMany})
+
+	tests := []struct {
+		name         string
+		path         string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Unknown method",
+			path:         "/users:unknown",
+			body:         `{}`,
+			expectedCode: http.StatusNotFound,
+			expectedBody: `{"error":"Unknown method"}`,
+		},
+		{
+			name:         "Batch get without ids",
+			path:         "/users:batchGet",
+			body:         `{"ids":[]}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"error":"Ids are required"}`,
+		},
+		{
+			name:         "Batch get with too many ids",
+			path:         "/users:batchGet",
+			body:         string(tooManyBody),
+			expectedCode: http.StatusBadRequest,
+			expectedBody: fmt.Sprintf(`{"error":"No more than %d ids are allowed"}`, MAX_BATCH),
+		},
+		{
+			name:         "Bulk without authorization",
+			path:         "/users:bulk",
+			body:         `{"items":[]}`,
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"error":"Authorization is required"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := gin.New()
+			h := NewHandlers()
+			router.POST("/users:method", h.UsersMethod)
+
+			req, _ := http.NewRequest(http.MethodPost, test.path, bytes.NewBufferString(test.body))
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != test.expectedCode {
+				t.Errorf("expected %d, got %d", test.expectedCode, w.Code)
+			}
+
+			if test.expectedBody != "" && !bytes.Contains(w.Body.Bytes(), []byte(test.expectedBody)) {
+				t.Errorf("expected body to contain %s, got %s", test.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	if err := logger.NewLogger(); err != nil {
+		t.Fatal(err)
+	}
+
+	self := &domain.Session{UserId: 1, Permissions: []string{domain.PERM_USERS_READ_SELF}}
+	support := &domain.Session{UserId: 2, Roles: []string{domain.ROLE_SUPPORT}, Permissions: []string{domain.PERM_USERS_READ_SELF, domain.PERM_USERS_READ_ANY}}
+
+	tests := []struct {
+		name         string
+		session      *domain.Session
+		path         string
+		expectedCode int
+	}{
+		{"Anonymous", nil, "/users?id=1", http.StatusForbidden},
+		{"Self", self, "/users?id=1", http.StatusOK},
+		{"Other user", self, "/users?id=2", http.StatusForbidden},
+		{"List without permission", self, "/users", http.StatusForbidden},
+		{"Other user with permission", support, "/users?id=1", http.StatusOK},
+		{"List with permission", support, "/users", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/users", func(ctx *gin.Context) {
+				if test.session != nil {
+					ctx.Set(SESSION_KEY, test.session)
+				}
+			}, Authorize(domain.PERM_USERS_READ_SELF, domain.PERM_USERS_READ_ANY), func(ctx *gin.Context) {
+				ctx.Status(http.StatusOK)
+			})
+
+			req, _ := http.NewRequest(http.MethodGet, test.path, nil)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != test.expectedCode {
+				t.Errorf("expected %d, got %d", test.expectedCode, w.Code)
+			}
+		})
+	}
+}
+
+// organizations - организации для теста выбора организации запроса
+// Организация 1 разрешает анонимную регистрацию и вход, 2 - только регистрацию, 3 - ничего
+type organizations struct {
+	interfaces.OrganizationRepo
+}
+
+func (organizations) Get(_ context.Context, id domain.Id) (*domain.Organization, error) {
+	if id > 3 {
+		return nil, nil
+	}
+
+	return &domain.Organization{Id: id, AllowSignup: id <= 2, AllowLogin: id == 1}, nil
+}
+
+// tenantSessions - сессии для теста выбора организации запроса
+type tenantSessions struct {
+	interfaces.AuthRepo
+	sessions map[string]*domain.Session
+}
+
+func (a tenantSessions) Authenticate(_ context.Context, token string) (*domain.Session, error) {
+	return a.sessions[token], nil
+}
+
+// Тест выбора организации запроса по заголовку X-Tenant-Id, сессии и политике организации
+func TestTenant(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	if err := logger.NewLogger(); err != nil {
+		t.Fatal(err)
+	}
+
+	previousOrganizations, previousAuth := OrganizationService, AuthService
+	OrganizationService = organizations{}
+	AuthService = tenantSessions{sessions: map[string]*domain.Session{
+		"member": {UserId: 1, TenantId: 2, Tenants: []domain.Id{domain.DEFAULT_TENANT}},
+	}}
+	t.Cleanup(func() {
+		OrganizationService, AuthService = previousOrganizations, previousAuth
+	})
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		header         string
+		token          string
+		expectedCode   int
+		expectedTenant domain.Id
+	}{
+		{"Anonymous", http.MethodPost, "/users", "", "", http.StatusOK, domain.DEFAULT_TENANT},
+		{"Session tenant", http.MethodGet, "/users", "", "member", http.StatusOK, 2},
+		{"Header overrides session", http.MethodGet, "/users", "1", "member", http.StatusOK, domain.DEFAULT_TENANT},
+		{"Not a member", http.MethodGet, "/users", "3", "member", http.StatusForbidden, 0},
+		{"Not a member on signup route", http.MethodPost, "/users", "3", "member", http.StatusForbidden, 0},
+		{"Anonymous signup allowed", http.MethodPost, "/users", "2", "", http.StatusOK, 2},
+		{"Anonymous signup denied", http.MethodPost, "/users", "3", "", http.StatusForbidden, 0},
+		{"Anonymous login allowed", http.MethodPost, "/auth/login", "1", "", http.StatusOK, domain.DEFAULT_TENANT},
+		{"Anonymous login denied", http.MethodPost, "/auth/login", "2", "", http.StatusForbidden, 0},
+		{"Anonymous without policy", http.MethodPost, "/users/verify-email", "2", "", http.StatusOK, domain.DEFAULT_TENANT},
+		{"Invalid header", http.MethodPost, "/users", "acme", "", http.StatusBadRequest, 0},
+		{"Unknown tenant", http.MethodPost, "/users", "4", "", http.StatusBadRequest, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var tenant domain.Id
+			handler := func(ctx *gin.Context) {
+				tenant = domain.TenantFrom(userContext(ctx))
+				ctx.Status(http.StatusOK)
+			}
+
+			router := gin.New()
+			router.Use(Tenant)
+			router.GET("/users", Authenticate, handler)
+			router.POST("/users", Identify, TenantPolicy(domain.POLICY_SIGNUP), handler)
+			router.POST("/users/verify-email", handler)
+			router.POST("/auth/login", TenantPolicy(domain.POLICY_LOGIN), handler)
+
+			req, _ := http.NewRequest(test.method, test.path, nil)
+			if test.header != "" {
+				req.Header.Set(TENANT_HEADER, test.header)
+			}
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != test.expectedCode {
+				t.Errorf("expected %d, got %d", test.expectedCode, w.Code)
+			}
+
+			if tenant != test.expectedTenant {
+				t.Errorf("expected tenant %d, got %d", test.expectedTenant, tenant)
+			}
+		})
+	}
+}
+
+// Тест подтверждения email: токен из письма действует один раз
+// Тест адреса SCIM: X-Forwarded-Proto учитывается только от доверенного прокси
+func TestScimBase(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	previous := TrustedProxies
+	TrustedProxies = []string{"10.0.0.0/8", "192.168.1.1"}
+	t.Cleanup(func() {
+		TrustedProxies = previous
+	})
+
+	tests := []struct {
+		name     string
+		remote   string
+		expected string
+	}{
+		{"Trusted network", "10.1.2.3:1234", "https://example.com/scim/v2"},
+		{"Trusted address", "192.168.1.1:1234", "https://example.com/scim/v2"},
+		{"Untrusted client", "203.0.113.5:1234", "http://example.com/scim/v2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest(http.MethodGet, "http://example.com/scim/v2/Users", nil)
+			ctx.Request.RemoteAddr = test.remote
+			ctx.Request.Header.Set("X-Forwarded-Proto", "https")
+
+			if base := scimBase(ctx); base != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, base)
+			}
+		})
+	}
+}
+
+func TestVerifyEmailHandler(t *testing.T) {
+	SetEnv()
+
+	router := gin.New()
+	h := NewHandlers()
+	router.POST("/create", h.Create)
+	router.POST("/users/verify-email", h.VerifyEmail)
+
+	body, _ := json.Marshal(domain.User{Login: "verify.me@example.com", Password: "StrongPassword123!"})
+	req, _ := http.NewRequest(http.MethodPost, "/create", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, w.Code)
+	}
+
+	sent := mails.Mails()
+	if len(sent) == 0 || sent[len(sent)-1].To != "verify.me@example.com" {
+		t.Fatalf("verification email hasn't been sent")
+	}
+	token := strings.Split(sent[len(sent)-1].Body, "\n")[2]
+
+	for _, expectedCode := range []int{http.StatusNoContent, http.StatusBadRequest} {
+		body, _ := json.Marshal(map[string]string{"token": token})
+		req, _ := http.NewRequest(http.MethodPost, "/users/verify-email", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != expectedCode {
+			t.Errorf("expected %d, got %d", expectedCode, w.Code)
+		}
+	}
+}
+
+func TestResetPasswordHandler(t *testing.T) {
+	SetEnv()
+
+	router := gin.New()
+	h := NewHandlers()
+	router.POST("/create", h.Create)
+	router.POST("/auth/login", h.Login)
+	router.POST("/auth/password/forgot", h.ForgotPassword)
+	router.POST("/auth/password/reset", h.ResetPassword)
+
+	body, _ := json.Marshal(domain.User{Login: "forgot.me@example.com", Password: "StrongPassword123!"})
+	req, _ := http.NewRequest(http.MethodPost, "/create", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, w.Code)
+	}
+
+	for _, login := range []string{"forgot.me@example.com", "nobody@example.com"} {
+		body, _ := json.Marshal(map[string]string{"email": login})
+		req, _ := http.NewRequest(http.MethodPost, "/auth/password/forgot", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusAccepted {
+			t.Errorf("expected %d, got %d", http.StatusAccepted, w.Code)
+		}
+	}
+
+	PasswordResetService.(*realization.PasswordResetService).Wait()
+	sent := mails.Mails()
+	if len(sent) == 0 || sent[len(sent)-1].Subject != realization.RESET_SUBJECT {
+		t.Fatalf("password reset email hasn't been sent")
+	}
+	token := strings.Split(sent[len(sent)-1].Body, "\n")[2]
+
+	tests := []struct {
+		password     string
+		expectedCode int
+	}{
+		{"weak", http.StatusBadRequest},
+		{"NewPassword123!", http.StatusNoContent},
+		{"OtherPassword123!", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		body, _ := json.Marshal(map[string]string{"token": token, "password": tt.password})
+		req, _ := http.NewRequest(http.MethodPost, "/auth/password/reset", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.expectedCode {
+			t.Errorf("expected %d, got %d", tt.expectedCode, w.Code)
+		}
+	}
+
+	body, _ = json.Marshal(domain.Credentials{Login: "forgot.me@example.com", Password: "NewPassword123!"})
+	req, _ = http.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestPasskeyHandlers(t *testing.T) {
+	SetEnv()
+
+	router := gin.New()
+	h := NewHandlers()
+	router.POST("/create", h.Create)
+	router.POST("/users/:id/passkeys/register/begin", h.BeginPasskeyRegistration)
+	router.POST("/users/:id/passkeys/register/finish", h.FinishPasskeyRegistration)
+	router.GET("/users/:id/passkeys", h.Passkeys)
+	router.DELETE("/users/:id/passkeys/:passkey", h.RevokePasskey)
+	router.POST("/auth/passkey/begin", h.BeginPasskeyLogin)
+	router.POST("/auth/passkey/finish", h.FinishPasskeyLogin)
+
+	do := func(method, path string, body any, expectedCode int, result any) {
+		t.Helper()
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(data))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != expectedCode {
+			t.Fatalf("%s %s: expected %d, got %d: %s", method, path, expectedCode, w.Code, w.Body.String())
+		}
+		if result != nil {
+			_ = json.Unmarshal(w.Body.Bytes(), result)
+		}
+	}
+
+	var created struct {
+		Id domain.Id `json:"id"`
+	}
+	do(http.MethodPost, "/create", domain.User{Login: fmt.Sprintf("passkey.%d@example.com", time.Now().UnixNano()), Password: "StrongPassword123!"}, http.StatusOK, &created)
+	users := fmt.Sprintf("/users/%d/passkeys", created.Id)
+
+	authenticator := webauthn.NewAuthenticator(PASSKEY_ORIGIN)
+	var creation domain.PasskeyCreationOptions
+	do(http.MethodPost, users+"/register/begin", nil, http.StatusOK, &creation)
+	attestation, err := authenticator.Create(creation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var passkey domain.Passkey
+	do(http.MethodPost, users+"/register/finish", gin.H{"name": "Laptop", "credential": attestation}, http.StatusCreated, &passkey)
+	// Challenge регистрации используется один раз
+	do(http.MethodPost, users+"/register/finish", gin.H{"name": "Laptop", "credential": attestation}, http.StatusBadRequest, nil)
+
+	var request domain.PasskeyRequestOptions
+	do(http.MethodPost, "/auth/passkey/begin", nil, http.StatusOK, &request)
+	assertion, err := authenticator.Get(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tokens domain.Tokens
+	do(http.MethodPost, "/auth/passkey/finish", assertion, http.StatusOK, &tokens)
+	if tokens.AccessToken == "" {
+		t.Errorf("access token hasn't been issued")
+	}
+	do(http.MethodPost, "/auth/passkey/finish", assertion, http.StatusUnauthorized, nil)
+
+	var passkeys []domain.Passkey
+	do(http.MethodGet, users, nil, http.StatusOK, &passkeys)
+	if len(passkeys) != 1 || passkeys[0].Name != "Laptop" || passkeys[0].SignCount != 2 || passkeys[0].LastUsedAt == nil {
+		t.Errorf("unexpected passkeys %+v", passkeys)
+	}
+
+	do(http.MethodDelete, fmt.Sprintf("%s/%d", users, passkey.Id), nil, http.StatusNoContent, nil)
+	do(http.MethodDelete, fmt.Sprintf("%s/%d", users, passkey.Id), nil, http.StatusBadRequest, nil)
+}
+
+func TestLoginLockout(t *testing.T) {
+	SetEnv()
+
+	router := gin.New()
+	h := NewHandlers()
+	router.POST("/create", h.Create)
+	router.POST("/auth/login", h.Login)
+	router.POST("/users/:id/unlock", h.Unlock)
+
+	login := fmt.Sprintf("lockout.%d@example.com", time.Now().UnixNano())
+	body, _ := json.Marshal(domain.User{Login: login, Password: "StrongPassword123!"})
+	req, _ := http.NewRequest(http.MethodPost, "/create", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var created struct {
+		Id domain.Id `json:"id"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &created)
+
+	tryLogin := func(password string, expectedCode int) *httptest.ResponseRecorder {
+		t.Helper()
+		body, _ := json.Marshal(domain.Credentials{Login: login, Password: password})
+		req, _ := http.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != expectedCode {
+			t.Fatalf("expected %d, got %d", expectedCode, w.Code)
+		}
+		return w
+	}
+
+	for i := 0; i < LOGIN_MAX_ATTEMPTS; i++ {
+		tryLogin("WrongPassword123!", http.StatusUnauthorized)
+	}
+
+	// Заблокированная учетная запись не принимает даже верный пароль
+	w = tryLogin("StrongPassword123!", http.StatusTooManyRequests)
+	if w.Header().Get("Retry-After") == "" {
+		t.Errorf("Retry-After header hasn't been set")
+	}
+
+	for _, tt := range []struct {
+		id           domain.Id
+		expectedCode int
+	}{
+		{created.Id, http.StatusNoContent},
+		{0, http.StatusBadRequest},
+	} {
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/users/%d/unlock", tt.id), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.expectedCode {
+			t.Errorf("expected %d, got %d", tt.expectedCode, w.Code)
+		}
+	}
+
+	tryLogin("StrongPassword123!", http.StatusOK)
+}
+
+func TestSessionHandlers(t *testing.T) {
+	SetEnv()
+
+	router := gin.New()
+	h := NewHandlers()
+	router.POST("/create", h.Create)
+	router.POST("/auth/login", h.Login)
+	router.PUT("/users", Authenticate, h.Put)
+	router.GET("/users/:id/sessions", Authenticate, h.Sessions)
+	router.DELETE("/users/:id/sessions", Authenticate, h.RevokeSessions)
+	router.DELETE("/users/:id/sessions/:session", Authenticate, h.RevokeSession)
+
+	do := func(method, path, token, userAgent string, body any, expectedCode int, result any) {
+		t.Helper()
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(data))
+		req.Header.Set("User-Agent", userAgent)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != expectedCode {
+			t.Fatalf("%s %s: expected %d, got %d: %s", method, path, expectedCode, w.Code, w.Body.String())
+		}
+		if result != nil {
+			_ = json.Unmarshal(w.Body.Bytes(), result)
+		}
+	}
+
+	login := fmt.Sprintf("sessions.%d@example.com", time.Now().UnixNano())
+	var created struct {
+		Id domain.Id `json:"id"`
+	}
+	do(http.MethodPost, "/create", "", "", domain.User{Login: login, Password: "StrongPassword123!"}, http.StatusOK, &created)
+	sessions := fmt.Sprintf("/users/%d/sessions", created.Id)
+
+	tokens := make([]domain.Tokens, 3)
+	for i, userAgent := range []string{"Firefox/121.0 (X11; Linux x86_64)", "Chrome/120.0 (Windows NT 10.0)", "curl/8.4.0"} {
+		do(http.MethodPost, "/auth/login", "", userAgent, domain.Credentials{Login: login, Password: "StrongPassword123!"}, http.StatusOK, &tokens[i])
+	}
+
+	var list []domain.Session
+	do(http.MethodGet, sessions, tokens[0].AccessToken, "", nil, http.StatusOK, &list)
+	if len(list) != 3 {
+		t.Fatalf("expected 3 sessions, got %+v", list)
+	}
+
+	devices := map[string]bool{}
+	var current, chrome domain.Session
+	for _, session := range list {
+		devices[session.Device] = true
+		if session.Current {
+			current = session
+		}
+		if session.Device == "Chrome on Windows" {
+			chrome = session
+		}
+	}
+	if current.Device != "Firefox on Linux" || !devices["curl"] || chrome.Id == 0 {
+		t.Errorf("unexpected sessions %+v", list)
+	}
+
+	do(http.MethodDelete, fmt.Sprintf("%s/%d", sessions, chrome.Id), tokens[0].AccessToken, "", nil, http.StatusNoContent, nil)
+	do(http.MethodDelete, fmt.Sprintf("%s/%d", sessions, chrome.Id), tokens[0].AccessToken, "", nil, http.StatusBadRequest, nil)
+	do(http.MethodGet, sessions, tokens[1].AccessToken, "", nil, http.StatusUnauthorized, nil)
+
+	// Смена пароля завершает остальные сессии, сессия запроса остается
+	do(http.MethodPut, fmt.Sprintf("/users?id=%d", created.Id), tokens[0].AccessToken, "", domain.User{Login: login, Password: "NewStrongPassword123!"}, http.StatusOK, nil)
+	do(http.MethodGet, sessions, tokens[2].AccessToken, "", nil, http.StatusUnauthorized, nil)
+
+	var revoked struct {
+		Revoked int64 `json:"revoked"`
+	}
+	do(http.MethodDelete, sessions, tokens[0].AccessToken, "", nil, http.StatusOK, &revoked)
+	if revoked.Revoked != 1 {
+		t.Errorf("expected 1 revoked session, got %d", revoked.Revoked)
+	}
+	do(http.MethodGet, sessions, tokens[0].AccessToken, "", nil, http.StatusUnauthorized, nil)
+}
+
+func TestOAuthHandlers(t *testing.T) {
+	SetEnv()
+
+	router := gin.New()
+	h := NewHandlers()
+	router.POST("/create", h.Create)
+	router.POST("/auth/login", h.Login)
+	router.POST("/oauth/clients", Authenticate, h.CreateOAuthClient)
+	router.GET("/oauth/authorize", h.OAuthAuthorizeRedirect)
+	router.POST("/oauth/authorize", Authenticate, h.OAuthAuthorize)
+	router.POST("/oauth/token", h.OAuthToken)
+	router.GET("/oauth/userinfo", h.OAuthUserInfo)
+	router.GET("/.well-known/openid-configuration", h.OpenIdConfiguration)
+	router.GET("/.well-known/jwks.json", h.Jwks)
+
+	serve := func(req *http.Request, expectedCode int, result any) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != expectedCode {
+			t.Fatalf("%s %s: expected %d, got %d: %s", req.Method, req.URL, expectedCode, w.Code, w.Body.String())
+		}
+		if result != nil {
+			_ = json.Unmarshal(w.Body.Bytes(), result)
+		}
+		return w
+	}
+
+	do := func(method, path, token string, body any, expectedCode int, result any) *httptest.ResponseRecorder {
+		t.Helper()
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(data))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return serve(req, expectedCode, result)
+	}
+
+	token := func(form url.Values, clientId, secret string, expectedCode int, result any) {
+		t.Helper()
+		// Публичный клиент передает идентификатор в теле, конфиденциальный - в заголовке Basic
+		body := url.Values{"client_id": {clientId}}
+		if secret != "" {
+			body = url.Values{}
+		}
+		for key, values := range form {
+			body[key] = values
+		}
+
+		req, _ := http.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(body.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if secret != "" {
+			req.SetBasicAuth(url.QueryEscape(clientId), url.QueryEscape(secret))
+		}
+		serve(req, expectedCode, result)
+	}
+
+	login := fmt.Sprintf("oauth.%d@example.com", time.Now().UnixNano())
+	var created struct {
+		Id domain.Id `json:"id"`
+	}
+	do(http.MethodPost, "/create", "", domain.User{FirstName: "John", Login: login, Password: "StrongPassword123!"}, http.StatusOK, &created)
+	var session domain.Tokens
+	do(http.MethodPost, "/auth/login", "", domain.Credentials{Login: login, Password: "StrongPassword123!"}, http.StatusOK, &session)
+
+	var config domain.OpenIdConfiguration
+	do(http.MethodGet, "/.well-known/openid-configuration", "", nil, http.StatusOK, &config)
+	if config.Issuer != "http://localhost:8080" || config.TokenEndpoint != "http://localhost:8080/oauth/token" {
+		t.Errorf("unexpected configuration %+v", config)
+	}
+
+	var jwks struct {
+		Keys []domain.Jwk `json:"keys"`
+	}
+	do(http.MethodGet, "/.well-known/jwks.json", "", nil, http.StatusOK, &jwks)
+
+	var app domain.OAuthClient
+	do(http.MethodPost, "/oauth/clients", session.AccessToken, gin.H{"name": "App", "public": true, "redirect_uris": []string{"https://app.example.com/cb"}}, http.StatusCreated, &app)
+	if app.ClientId == "" || app.Secret != "" {
+		t.Fatalf("unexpected public client %+v", app)
+	}
+	do(http.MethodPost, "/oauth/clients", session.AccessToken, gin.H{"name": "App", "redirect_uris": []string{"http://app.example.com/cb"}}, http.StatusBadRequest, nil)
+
+	// Браузер передается странице входа только с зарегистрированным адресом возврата
+	authorize := url.Values{"response_type": {"code"}, "client_id": {app.ClientId}, "redirect_uri": {"https://app.example.com/cb"}, "scope": {"openid email"}, "state": {"s-1"}, "nonce": {"n-1"}, "code_challenge": {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"}, "code_challenge_method": {"S256"}}
+	w := do(http.MethodGet, "/oauth/authorize?"+authorize.Encode(), "", nil, http.StatusFound, nil)
+	if !strings.HasPrefix(w.Header().Get("Location"), OAuthLoginUrl+"?") {
+		t.Errorf("unexpected location %s", w.Header().Get("Location"))
+	}
+	do(http.MethodGet, "/oauth/authorize?client_id="+app.ClientId+"&redirect_uri=https://evil.example.com/cb", "", nil, http.StatusBadRequest, nil)
+
+	var redirect struct {
+		RedirectUri string `json:"redirect_uri"`
+	}
+	request := domain.OAuthAuthorizeRequest{ResponseType: "code", ClientId: app.ClientId, RedirectUri: "https://app.example.com/cb", Scope: "openid email", State: "s-1", Nonce: "n-1"}
+	do(http.MethodPost, "/oauth/authorize", session.AccessToken, request, http.StatusOK, &redirect)
+	if !strings.Contains(redirect.RedirectUri, "error=invalid_request") || !strings.Contains(redirect.RedirectUri, "state=s-1") {
+		t.Errorf("expected PKCE error, got %s", redirect.RedirectUri)
+	}
+
+	request.CodeChallenge, request.CodeChallengeMethod = authorize.Get("code_challenge"), domain.PKCE_S256
+	do(http.MethodPost, "/oauth/authorize", session.AccessToken, request, http.StatusOK, &redirect)
+	callback, _ := url.Parse(redirect.RedirectUri)
+	code := callback.Query().Get("code")
+	if code == "" || callback.Query().Get("state") != "s-1" {
+		t.Fatalf("unexpected redirect %s", redirect.RedirectUri)
+	}
+
+	exchange := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {"https://app.example.com/cb"}, "code_verifier": {"dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"}}
+	var tokens domain.OAuthTokens
+	token(exchange, app.ClientId, "", http.StatusOK, &tokens)
+	if tokens.RefreshToken == "" || tokens.IdToken == "" {
+		t.Fatalf("unexpected tokens %+v", tokens)
+	}
+
+	var claims domain.IdTokenClaims
+	err := jose.Verify(tokens.IdToken, jwks.Keys, &claims)
+	if err != nil || claims.Nonce != "n-1" || claims.Audience != app.ClientId || claims.Email != login || claims.Subject != fmt.Sprint(created.Id) {
+		t.Errorf("unexpected id token claims %+v: %v", claims, err)
+	}
+
+	var info domain.UserInfo
+	do(http.MethodGet, "/oauth/userinfo", tokens.AccessToken, nil, http.StatusOK, &info)
+	if info.Email != login || info.GivenName != "" {
+		t.Errorf("unexpected userinfo %+v", info)
+	}
+
+	// Повторный обмен кода отзывает выданные по нему токены
+	token(exchange, app.ClientId, "", http.StatusBadRequest, nil)
+	do(http.MethodGet, "/oauth/userinfo", tokens.AccessToken, nil, http.StatusUnauthorized, nil)
+	token(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}}, app.ClientId, "", http.StatusBadRequest, nil)
+
+	// Refresh токен меняется на новую пару, прежний перестает действовать
+	request.State = "s-2"
+	do(http.MethodPost, "/oauth/authorize", session.AccessToken, request, http.StatusOK, &redirect)
+	callback, _ = url.Parse(redirect.RedirectUri)
+	exchange.Set("code", callback.Query().Get("code"))
+	token(exchange, app.ClientId, "", http.StatusOK, &tokens)
+
+	var refreshed domain.OAuthTokens
+	token(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}, "scope": {"openid"}}, app.ClientId, "", http.StatusOK, &refreshed)
+	if refreshed.Scope != "openid" || refreshed.IdToken == "" {
+		t.Errorf("unexpected refreshed tokens %+v", refreshed)
+	}
+	do(http.MethodGet, "/oauth/userinfo", tokens.AccessToken, nil, http.StatusUnauthorized, nil)
+	do(http.MethodGet, "/oauth/userinfo", refreshed.AccessToken, nil, http.StatusOK, nil)
+	token(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}}, app.ClientId, "", http.StatusBadRequest, nil)
+
+	// Конфиденциальный клиент получает токен для себя по секрету
+	var service domain.OAuthClient
+	do(http.MethodPost, "/oauth/clients", session.AccessToken, gin.H{"name": "Service", "grant_types": []string{"client_credentials"}, "scopes": []string{"api"}}, http.StatusCreated, &service)
+	token(url.Values{"grant_type": {"client_credentials"}}, service.ClientId, "wrong", http.StatusUnauthorized, nil)
+	token(url.Values{"grant_type": {"client_credentials"}}, service.ClientId, service.Secret, http.StatusOK, &tokens)
+	if tokens.Scope != "api" || tokens.RefreshToken != "" {
+		t.Errorf("unexpected client credentials tokens %+v", tokens)
+	}
+	do(http.MethodGet, "/oauth/userinfo", tokens.AccessToken, nil, http.StatusUnauthorized, nil)
+}
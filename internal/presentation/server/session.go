@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Sessions возвращает активные сессии пользователя, последние активные первыми
+func (Handlers) Sessions(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	sessions, err := SessionService.List(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession отзывает одну сессию пользователя
+func (Handlers) RevokeSession(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	session, err := strconv.ParseUint(ctx.Param("session"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session id"})
+		return
+	}
+
+	ok, err = SessionService.Revoke(userContext(ctx), id, session)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Session with id %d not exist", session)})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// RevokeSessions завершает все сессии пользователя, включая текущую, - выход на всех устройствах
+func (Handlers) RevokeSessions(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	revoked, err := SessionService.RevokeAll(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"revoked": revoked})
+}
@@ -1,77 +1,99 @@
 package server
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-	"errors"
-	"regexp"
+	"net/url"
+	"slices"
 	"strings"
-	"unicode"
+	"user/internal/domain"
 )
 
-// ValidPass проверяет пароль и возвращает его хэш, если он валиден
-func ValidPass(pass string) (string, error) {
-	if !isValidLength(pass) {
-		return "", errors.New("the password length must be from 3 to 30 characters inclusive")
-	}
-
-	if !containsUpperAndDigit(pass) {
-		return "", errors.New("password must contain at least 1 capital letter and 1 number")
+// isValidWebhookUrl проверяет, что адрес получателя - абсолютный http(s) URL
+func isValidWebhookUrl(str string) bool {
+	if len(str) > 2048 {
+		return false
 	}
 
-	if containsInvalidChars(pass) {
-		return "", errors.New("the password must consist of letters of the Latin alphabet, numbers and symbols _!@#&*-")
+	u, err := url.Parse(str)
+	if err != nil {
+		return false
 	}
 
-	return GenHash(pass), nil
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
 }
 
-// GenHash создает хэш пароля
-func GenHash(str string) string {
-	hasher := sha256.New()
-	hasher.Write([]byte(str)) // Преобразуем строку в хэш
-	hash := hasher.Sum(nil)
+// isValidEvents проверяет, что все типы событий известны
+func isValidEvents(events []string) bool {
+	for _, event := range events {
+		if !slices.Contains(domain.EventTypes, event) {
+			return false
+		}
+	}
 
-	return hex.EncodeToString(hash) // Возвращаем хэш в виде шестнадцатеричной строки
+	return true
 }
 
-// IsValidEmail проверяет, является ли строка допустимым адресом электронной почты
-func IsValidEmail(email string) bool {
-	if len(email) > 256 {
+// isValidRedirectUri проверяет адрес возврата клиента OAuth2
+// Допускаются https, http только для адресов компьютера пользователя и собственные схемы приложений вида com.example.app,
+// фрагмент запрещен: в нем код мог бы попасть в историю браузера
+func isValidRedirectUri(str string) bool {
+	if len(str) > 2048 {
 		return false
 	}
 
-	// Регулярное выражение для проверки адреса электронной почты
-	var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+	u, err := url.Parse(str)
+	if err != nil || !u.IsAbs() || u.Fragment != "" || strings.HasSuffix(str, "#") {
+		return false
+	}
 
-	return emailRegex.MatchString(email)
-}
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		return slices.Contains([]string{"localhost", "127.0.0.1", "::1"}, u.Hostname())
+	}
 
-// isValidLength проверяет длину пароля
-func isValidLength(pass string) bool {
-	return len(pass) >= 3 && len(pass) <= 30
+	return strings.Contains(u.Scheme, ".")
 }
 
-// containsUpperAndDigit проверяет наличие заглавной буквы и цифры
-func containsUpperAndDigit(pass string) bool {
-	hasUpper, hasDigit := false, false
-	for _, char := range pass {
-		switch {
-		case unicode.IsUpper(char):
-			hasUpper = true
-		case unicode.IsDigit(char):
-			hasDigit = true
+// validOAuthClient проверяет настройки клиента OAuth2 и возвращает описание первой ошибки
+func validOAuthClient(client domain.OAuthClient) string {
+	if client.Name == "" || len(client.Name) > 255 {
+		return "Name is required and must be at most 255 characters"
+	}
+
+	for _, uri := range client.RedirectUris {
+		if !isValidRedirectUri(uri) {
+			return "Invalid redirect uri " + uri
 		}
 	}
-	return hasUpper && hasDigit
-}
 
-// containsInvalidChars проверяет наличие недопустимых символов в пароле
-func containsInvalidChars(pass string) bool {
-	for _, char := range pass {
-		if !unicode.IsLetter(char) && !unicode.IsDigit(char) && !strings.Contains("_!@#&*-", string(char)) {
-			return true
+	for _, grantType := range client.GrantTypes {
+		if grantType != domain.GRANT_AUTHORIZATION_CODE && grantType != domain.GRANT_CLIENT_CREDENTIALS && grantType != domain.GRANT_REFRESH_TOKEN {
+			return "Unknown grant type " + grantType
 		}
 	}
-	return false
+
+	if len(client.GrantTypes) == 0 {
+		return "At least one grant type is required"
+	}
+
+	if client.Allows(domain.GRANT_AUTHORIZATION_CODE) && len(client.RedirectUris) == 0 {
+		return "Authorization code grant requires a redirect uri"
+	}
+
+	if client.Public && client.Allows(domain.GRANT_CLIENT_CREDENTIALS) {
+		return "Public client cannot use client credentials grant"
+	}
+
+	for _, scope := range client.Scopes {
+		if scope == "" || len(scope) > 64 || strings.ContainsAny(scope, " \"\\") {
+			return "Invalid scope " + scope
+		}
+	}
+
+	if client.IdTokenAlg != domain.ALG_RS256 && client.IdTokenAlg != domain.ALG_ES256 {
+		return "Unsupported id token algorithm " + client.IdTokenAlg
+	}
+
+	return ""
 }
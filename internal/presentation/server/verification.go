@@ -0,0 +1,55 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"user/internal/domain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VerifyEmail подтверждает email пользователя по токену из письма
+func (Handlers) VerifyEmail(ctx *gin.Context) {
+	var body struct {
+		Token string `json:"token"`
+	}
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil || body.Token == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Token is required"})
+		return
+	}
+
+	err = VerificationService.Verify(userContext(ctx), body.Token)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidToken) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired token"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// ResendVerification повторно отправляет письмо с подтверждением email
+// Ответ не зависит от того, есть ли пользователь с таким email
+func (Handlers) ResendVerification(ctx *gin.Context) {
+	var body struct {
+		Login string `json:"email"`
+	}
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil || body.Login == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Email is required"})
+		return
+	}
+
+	err = VerificationService.Resend(userContext(ctx), body.Login)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.Status(http.StatusAccepted)
+}
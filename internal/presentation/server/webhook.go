@@ -0,0 +1,170 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"user/internal/domain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webhookRequest - тело запроса создания подписки
+type webhookRequest struct {
+	Url    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// CreateWebhook создает подписку на события пользователей
+// Ключ подписи возвращается только в ответе на этот запрос
+func (Handlers) CreateWebhook(ctx *gin.Context) {
+	var req webhookRequest
+	err := ctx.ShouldBindJSON(&req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body"})
+		return
+	}
+
+	if !isValidWebhookUrl(req.Url) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid url"})
+		return
+	}
+
+	if !isValidEvents(req.Events) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Unknown event type"})
+		return
+	}
+
+	webhook, err := WebhookService.Create(userContext(ctx), domain.Webhook{
+		Url:    req.Url,
+		Events: req.Events,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, webhook)
+}
+
+// ListWebhooks возвращает все подписки
+func (Handlers) ListWebhooks(ctx *gin.Context) {
+	webhooks, err := WebhookService.List(userContext(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"webhooks": webhooks})
+}
+
+// GetWebhook возвращает подписку
+func (Handlers) GetWebhook(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	webhook, err := WebhookService.Get(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if webhook == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, webhook)
+}
+
+// DeleteWebhook удаляет подписку вместе с историей доставок
+func (Handlers) DeleteWebhook(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	found, err := WebhookService.Delete(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if !found {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// Deliveries возвращает страницу доставок подписки с историей попыток
+func (Handlers) Deliveries(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	var limit int
+	if limitStr := ctx.Query("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+
+	webhook, err := WebhookService.Get(userContext(ctx), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if webhook == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	page, err := WebhookService.Deliveries(userContext(ctx), id, ctx.Query("cursor"), limit)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidCursor) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, page)
+}
+
+// Redeliver ставит доставку в очередь повторно, в том числе из dead
+func (Handlers) Redeliver(ctx *gin.Context) {
+	id, ok := idParam(ctx)
+	if !ok {
+		return
+	}
+
+	deliveryId, err := strconv.ParseUint(ctx.Param("delivery"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery id"})
+		return
+	}
+
+	found, err := WebhookService.Redeliver(userContext(ctx), id, deliveryId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if !found {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		return
+	}
+
+	ctx.Status(http.StatusAccepted)
+}
@@ -0,0 +1,100 @@
+// Package validation проверяет данные пользователя одинаково для HTTP, gRPC и импорта из командной строки
+package validation
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+	"user/internal/domain"
+	"user/internal/interfaces"
+)
+
+// Hasher хэширует пароли прошедших проверку пользователей
+var Hasher interfaces.PasswordHasher
+
+// CheckUser проверяет логин и пароль пользователя и заменяет пароль его хэшем
+// Используется в HTTP и gRPC API и при импорте пользователей
+// Текст ошибки можно вернуть клиенту
+func CheckUser(user *domain.User) error {
+	if user.Login == "" {
+		return errors.New("Email is required")
+	}
+
+	valid := IsValidEmail(user.Login)
+	if !valid {
+		return errors.New("Invalid email")
+	}
+
+	hashPass, err := ValidPass(user.Password)
+	if err != nil {
+		return errors.New("Invalid password")
+	}
+
+	user.PlainPassword = user.Password
+	user.Password = hashPass
+	return nil
+}
+
+// ValidPass проверяет пароль и возвращает его хэш, если он валиден
+func ValidPass(pass string) (string, error) {
+	if !isValidLength(pass) {
+		return "", errors.New("the password length must be from 3 to 30 characters inclusive")
+	}
+
+	if !containsUpperAndDigit(pass) {
+		return "", errors.New("password must contain at least 1 capital letter and 1 number")
+	}
+
+	if containsInvalidChars(pass) {
+		return "", errors.New("the password must consist of letters of the Latin alphabet, numbers and symbols _!@#&*-")
+	}
+
+	return GenHash(pass)
+}
+
+// GenHash создает хэш пароля текущим алгоритмом
+func GenHash(str string) (string, error) {
+	return Hasher.Hash(str)
+}
+
+// IsValidEmail проверяет, является ли строка допустимым адресом электронной почты
+func IsValidEmail(email string) bool {
+	if len(email) > 256 {
+		return false
+	}
+
+	// Регулярное выражение для проверки адреса электронной почты
+	var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+
+	return emailRegex.MatchString(email)
+}
+
+// isValidLength проверяет длину пароля
+func isValidLength(pass string) bool {
+	return len(pass) >= 3 && len(pass) <= 30
+}
+
+// containsUpperAndDigit проверяет наличие заглавной буквы и цифры
+func containsUpperAndDigit(pass string) bool {
+	hasUpper, hasDigit := false, false
+	for _, char := range pass {
+		switch {
+		case unicode.IsUpper(char):
+			hasUpper = true
+		case unicode.IsDigit(char):
+			hasDigit = true
+		}
+	}
+	return hasUpper && hasDigit
+}
+
+// containsInvalidChars проверяет наличие недопустимых символов в пароле
+func containsInvalidChars(pass string) bool {
+	for _, char := range pass {
+		if !unicode.IsLetter(char) && !unicode.IsDigit(char) && !strings.Contains("_!@#&*-", string(char)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package validation
+
+import (
+	"testing"
+
+	"user/internal/domain"
+	"user/internal/interfaces"
+	"user/internal/presentation/realization"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setHasher задает быстрый хэшер паролей для тестов
+func setHasher(t *testing.T) interfaces.PasswordHasher {
+	hasher, err := realization.NewPasswordHasher(realization.BCRYPT, realization.NewArgon2idHasher(1, 64*1024, 2), realization.NewBcryptHasher(4))
+	require.NoError(t, err)
+	Hasher = hasher
+	return hasher
+}
+
+// Тест проверки email и пароля
+func TestCheckUser(t *testing.T) {
+	hasher := setHasher(t)
+
+	assert.EqualError(t, CheckUser(&domain.User{Password: "StrongPassword123!"}), "Email is required")
+	assert.EqualError(t, CheckUser(&domain.User{Login: "john", Password: "StrongPassword123!"}), "Invalid email")
+	assert.EqualError(t, CheckUser(&domain.User{Login: "john@example.com", Password: "weak"}), "Invalid password")
+
+	user := domain.User{Login: "john@example.com", Password: "StrongPassword123!"}
+	require.NoError(t, CheckUser(&user))
+	assert.Equal(t, "StrongPassword123!", user.PlainPassword)
+	same, err := hasher.Verify("StrongPassword123!", user.Password)
+	require.NoError(t, err)
+	assert.True(t, same)
+}
+
+// Тест правил пароля
+func TestValidPass(t *testing.T) {
+	setHasher(t)
+
+	_, err := ValidPass("Ab1")
+	assert.NoError(t, err)
+
+	for _, pass := range []string{"A1", "lowercase123", "NoDigits", "Invalid 123", "Toolongpassword1234567890123456"} {
+		_, err = ValidPass(pass)
+		assert.Error(t, err, pass)
+	}
+}
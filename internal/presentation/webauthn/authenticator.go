@@ -0,0 +1,201 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"user/internal/domain"
+)
+
+// Authenticator - программный аутентификатор с ключами ES256 в памяти
+// Проводит церемонии так же, как браузер с аппаратным ключом, используется в тестах
+type Authenticator struct {
+	// Origin - источник страницы, записываемый в клиентские данные
+	Origin string
+	// Packed - подписывать аттестацию ключом учетных данных вместо аттестации none
+	Packed bool
+	// SkipVerification - не подтверждать личность пользователя при входе, так работает ключ без PIN и биометрии
+	SkipVerification bool
+
+	mu          sync.Mutex
+	credentials []*softCredential
+}
+
+// softCredential - учетные данные программного аутентификатора
+type softCredential struct {
+	id         []byte
+	rpId       string
+	userHandle []byte
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+}
+
+// NewAuthenticator создает новый экземпляр Authenticator для страниц с источником origin
+func NewAuthenticator(origin string) *Authenticator {
+	return &Authenticator{Origin: origin}
+}
+
+// Create создает учетные данные по параметрам navigator.credentials.create()
+func (a *Authenticator) Create(options domain.PasskeyCreationOptions) (*domain.PasskeyAttestation, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	credential := &softCredential{
+		id:         id,
+		rpId:       options.RelyingParty.Id,
+		userHandle: options.User.Id,
+		key:        key,
+		signCount:  1,
+	}
+
+	cose, err := encodeCbor(map[any]any{
+		int64(coseKty): int64(coseKtyEc2),
+		int64(coseAlg): int64(ALG_ES256),
+		int64(-1):      int64(coseCrvP256),
+		int64(-2):      key.X.FillBytes(make([]byte, 32)),
+		int64(-3):      key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	authData := authenticatorData(credential.rpId, FLAG_UP|FLAG_UV|FLAG_AT, credential.signCount)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(id)))
+	authData = append(authData, id...)
+	authData = append(authData, cose...)
+
+	clientDataJSON, err := a.clientData(TYPE_CREATE, options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	format, statement := "none", map[any]any{}
+	if a.Packed {
+		signature, err := credential.sign(signedData(authData, clientDataJSON))
+		if err != nil {
+			return nil, err
+		}
+		format, statement = "packed", map[any]any{"alg": int64(ALG_ES256), "sig": signature}
+	}
+
+	attestationObject, err := encodeCbor(map[any]any{
+		"fmt":      format,
+		"attStmt":  statement,
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	a.credentials = append(a.credentials, credential)
+	a.mu.Unlock()
+
+	attestation := &domain.PasskeyAttestation{
+		Id:    base64.RawURLEncoding.EncodeToString(id),
+		RawId: id,
+		Type:  "public-key",
+	}
+	attestation.Response.ClientDataJSON = clientDataJSON
+	attestation.Response.AttestationObject = attestationObject
+	return attestation, nil
+}
+
+// Get подписывает challenge последними созданными для домена учетными данными
+// по параметрам navigator.credentials.get()
+func (a *Authenticator) Get(options domain.PasskeyRequestOptions) (*domain.PasskeyAssertion, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var credential *softCredential
+	for _, c := range a.credentials {
+		if c.rpId == options.RelyingPartyId {
+			credential = c
+		}
+	}
+
+	if credential == nil {
+		return nil, errors.New("no credentials for relying party")
+	}
+
+	credential.signCount++
+	flags := byte(FLAG_UP | FLAG_UV)
+	if a.SkipVerification {
+		flags = FLAG_UP
+	}
+	authData := authenticatorData(credential.rpId, flags, credential.signCount)
+
+	clientDataJSON, err := a.clientData(TYPE_GET, options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := credential.sign(signedData(authData, clientDataJSON))
+	if err != nil {
+		return nil, err
+	}
+
+	assertion := &domain.PasskeyAssertion{
+		Id:    base64.RawURLEncoding.EncodeToString(credential.id),
+		RawId: credential.id,
+		Type:  "public-key",
+	}
+	assertion.Response.ClientDataJSON = clientDataJSON
+	assertion.Response.AuthenticatorData = authData
+	assertion.Response.Signature = signature
+	assertion.Response.UserHandle = credential.userHandle
+	return assertion, nil
+}
+
+// SetSignCount устанавливает счетчик подписей всех учетных данных, так выглядит скопированный ключ
+func (a *Authenticator) SetSignCount(count uint32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, c := range a.credentials {
+		c.signCount = count
+	}
+}
+
+// clientData собирает клиентские данные так, как это делает браузер
+func (a *Authenticator) clientData(kind string, challenge []byte) ([]byte, error) {
+	data, err := json.Marshal(ClientData{
+		Type:      kind,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    a.Origin,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encoding client data error: %v", err)
+	}
+
+	return data, nil
+}
+
+// sign подписывает данные ключом учетных данных
+func (c *softCredential) sign(data []byte) ([]byte, error) {
+	hash := sha256.Sum256(data)
+	return ecdsa.SignASN1(rand.Reader, c.key, hash[:])
+}
+
+// authenticatorData собирает начало данных аутентификатора
+func authenticatorData(rpId string, flags byte, signCount uint32) []byte {
+	rpIdHash := sha256.Sum256([]byte(rpId))
+	data := append(rpIdHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, signCount)
+}
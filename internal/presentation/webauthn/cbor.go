@@ -0,0 +1,252 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// CBOR (RFC 8949) в объеме, нужном WebAuthn: целые, строки, массивы, словари и простые значения
+// Значения неопределенной длины и числа с плавающей точкой аутентификаторы не используют
+
+const (
+	cborUint   = 0
+	cborNegint = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborSimple = 7
+
+	// cborMaxDepth ограничивает вложенность, чтобы ответ не исчерпал стек
+	cborMaxDepth = 16
+)
+
+var errCbor = errors.New("invalid cbor")
+
+// decodeCbor декодирует первое значение из data и возвращает его вместе с остатком данных
+// Целые декодируются в int64, байты в []byte, текст в string, массивы в []any, словари в map[any]any
+func decodeCbor(data []byte) (any, []byte, error) {
+	return decodeCborValue(data, 0)
+}
+
+func decodeCborValue(data []byte, depth int) (any, []byte, error) {
+	if depth > cborMaxDepth {
+		return nil, nil, fmt.Errorf("%w: too deep", errCbor)
+	}
+
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("%w: unexpected end", errCbor)
+	}
+
+	major, info := data[0]>>5, data[0]&0x1f
+	data = data[1:]
+
+	if major == cborSimple {
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22, 23:
+			return nil, data, nil
+		}
+		return nil, nil, fmt.Errorf("%w: unsupported simple value %d", errCbor, info)
+	}
+
+	arg, data, err := cborArgument(info, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case cborUint:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: integer overflow", errCbor)
+		}
+		return int64(arg), data, nil
+	case cborNegint:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: integer overflow", errCbor)
+		}
+		return -1 - int64(arg), data, nil
+	case cborBytes, cborText:
+		if arg > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end", errCbor)
+		}
+		value := data[:arg]
+		if major == cborText {
+			return string(value), data[arg:], nil
+		}
+		return append([]byte(nil), value...), data[arg:], nil
+	case cborArray:
+		// Каждый элемент занимает хотя бы байт, длина больше остатка - заведомо ошибка
+		if arg > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end", errCbor)
+		}
+		items := make([]any, 0, arg)
+		for range arg {
+			var item any
+			item, data, err = decodeCborValue(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+	case cborMap:
+		if arg > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end", errCbor)
+		}
+		items := make(map[any]any, arg)
+		for range arg {
+			var key, value any
+			key, data, err = decodeCborValue(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("%w: unsupported map key", errCbor)
+			}
+
+			if _, ok := items[key]; ok {
+				return nil, nil, fmt.Errorf("%w: duplicate map key", errCbor)
+			}
+
+			value, data, err = decodeCborValue(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items[key] = value
+		}
+		return items, data, nil
+	}
+
+	return nil, nil, fmt.Errorf("%w: unsupported major type %d", errCbor, major)
+}
+
+// cborArgument читает аргумент заголовка значения
+func cborArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	case info >= 28:
+		return 0, nil, fmt.Errorf("%w: indefinite length is not supported", errCbor)
+	}
+
+	return 0, nil, fmt.Errorf("%w: unexpected end", errCbor)
+}
+
+// encodeCbor кодирует значение в каноническом виде CTAP2: ключи словаря упорядочены по закодированным байтам
+// Поддерживаются int, int64, string, []byte, []any, map[any]any и bool
+func encodeCbor(value any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := encodeCborValue(&buf, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func encodeCborValue(buf *bytes.Buffer, value any) error {
+	switch v := value.(type) {
+	case int:
+		return encodeCborValue(buf, int64(v))
+	case int64:
+		if v < 0 {
+			cborHeader(buf, cborNegint, uint64(-1-v))
+		} else {
+			cborHeader(buf, cborUint, uint64(v))
+		}
+	case string:
+		cborHeader(buf, cborText, uint64(len(v)))
+		buf.WriteString(v)
+	case []byte:
+		cborHeader(buf, cborBytes, uint64(len(v)))
+		buf.Write(v)
+	case bool:
+		if v {
+			buf.WriteByte(cborSimple<<5 | 21)
+		} else {
+			buf.WriteByte(cborSimple<<5 | 20)
+		}
+	case []any:
+		cborHeader(buf, cborArray, uint64(len(v)))
+		for _, item := range v {
+			err := encodeCborValue(buf, item)
+			if err != nil {
+				return err
+			}
+		}
+	case map[any]any:
+		type pair struct {
+			key, value []byte
+		}
+
+		pairs := make([]pair, 0, len(v))
+		for key, item := range v {
+			k, err := encodeCbor(key)
+			if err != nil {
+				return err
+			}
+
+			value, err := encodeCbor(item)
+			if err != nil {
+				return err
+			}
+			pairs = append(pairs, pair{k, value})
+		}
+
+		sort.Slice(pairs, func(i, j int) bool {
+			if len(pairs[i].key) != len(pairs[j].key) {
+				return len(pairs[i].key) < len(pairs[j].key)
+			}
+			return bytes.Compare(pairs[i].key, pairs[j].key) < 0
+		})
+
+		cborHeader(buf, cborMap, uint64(len(pairs)))
+		for _, p := range pairs {
+			buf.Write(p.key)
+			buf.Write(p.value)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported type %T", errCbor, value)
+	}
+
+	return nil
+}
+
+// cborHeader записывает заголовок значения с аргументом минимальной длины
+func cborHeader(buf *bytes.Buffer, major byte, arg uint64) {
+	switch {
+	case arg < 24:
+		buf.WriteByte(major<<5 | byte(arg))
+	case arg <= math.MaxUint8:
+		buf.WriteByte(major<<5 | 24)
+		buf.WriteByte(byte(arg))
+	case arg <= math.MaxUint16:
+		buf.WriteByte(major<<5 | 25)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(arg)))
+	case arg <= math.MaxUint32:
+		buf.WriteByte(major<<5 | 26)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(arg)))
+	default:
+		buf.WriteByte(major<<5 | 27)
+		buf.Write(binary.BigEndian.AppendUint64(nil, arg))
+	}
+}
@@ -0,0 +1,122 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Алгоритмы COSE (RFC 9053), которые принимает сервис
+const (
+	ALG_ES256 = -7
+	ALG_EDDSA = -8
+	ALG_RS256 = -257
+)
+
+const (
+	coseKty = 1
+	coseAlg = 3
+
+	coseKtyOkp = 1
+	coseKtyEc2 = 2
+	coseKtyRsa = 3
+
+	coseCrvP256    = 1
+	coseCrvEd25519 = 6
+
+	// rsaMinBits - минимальная длина ключа RSA
+	rsaMinBits = 2048
+)
+
+var ErrUnsupportedKey = errors.New("unsupported credential public key")
+
+// PublicKey - открытый ключ учетных данных из COSE_Key
+type PublicKey struct {
+	Alg int64
+	key crypto.PublicKey
+}
+
+// ParsePublicKey разбирает открытый ключ в формате COSE_Key
+func ParsePublicKey(data []byte) (*PublicKey, error) {
+	value, rest, err := decodeCbor(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing data", ErrUnsupportedKey)
+	}
+
+	return publicKeyFromCose(value)
+}
+
+// publicKeyFromCose проверяет декодированный COSE_Key и создает по нему ключ
+func publicKeyFromCose(value any) (*PublicKey, error) {
+	params, ok := value.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: not a map", ErrUnsupportedKey)
+	}
+
+	kty, _ := params[int64(coseKty)].(int64)
+	alg, _ := params[int64(coseAlg)].(int64)
+	crv, _ := params[int64(-1)].(int64)
+
+	switch {
+	case kty == coseKtyEc2 && alg == ALG_ES256 && crv == coseCrvP256:
+		x, okX := params[int64(-2)].([]byte)
+		y, okY := params[int64(-3)].([]byte)
+		if !okX || !okY || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("%w: invalid ec2 coordinates", ErrUnsupportedKey)
+		}
+
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("%w: point is not on curve", ErrUnsupportedKey)
+		}
+		return &PublicKey{Alg: alg, key: key}, nil
+
+	case kty == coseKtyOkp && alg == ALG_EDDSA && crv == coseCrvEd25519:
+		x, ok := params[int64(-2)].([]byte)
+		if !ok || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: invalid okp key", ErrUnsupportedKey)
+		}
+		return &PublicKey{Alg: alg, key: ed25519.PublicKey(x)}, nil
+
+	case kty == coseKtyRsa && alg == ALG_RS256:
+		n, okN := params[int64(-1)].([]byte)
+		e, okE := params[int64(-2)].([]byte)
+		if !okN || !okE || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("%w: invalid rsa key", ErrUnsupportedKey)
+		}
+
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < rsaMinBits {
+			return nil, fmt.Errorf("%w: rsa key is too short", ErrUnsupportedKey)
+		}
+		return &PublicKey{Alg: alg, key: key}, nil
+	}
+
+	return nil, fmt.Errorf("%w: kty %d alg %d", ErrUnsupportedKey, kty, alg)
+}
+
+// Verify проверяет подпись данных алгоритмом ключа
+func (k *PublicKey) Verify(data, signature []byte) bool {
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		hash := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(key, hash[:], signature)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, signature)
+	case *rsa.PublicKey:
+		hash := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) == nil
+	}
+
+	return false
+}
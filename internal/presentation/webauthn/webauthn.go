@@ -0,0 +1,274 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Типы клиентских данных церемоний
+const (
+	TYPE_CREATE = "webauthn.create"
+	TYPE_GET    = "webauthn.get"
+)
+
+// Флаги данных аутентификатора
+const (
+	FLAG_UP = 0x01 // пользователь присутствует
+	FLAG_UV = 0x04 // пользователь подтвердил личность
+	FLAG_AT = 0x40 // есть данные новых учетных данных
+	FLAG_ED = 0x80 // есть расширения
+)
+
+var (
+	ErrInvalidResponse = errors.New("invalid webauthn response")
+
+	// ErrSignCount - счетчик подписей не вырос, ключ мог быть скопирован
+	ErrSignCount = errors.New("webauthn sign count did not increase")
+
+	// ErrUserNotVerified - аутентификатор не подтвердил личность пользователя PIN или биометрией
+	ErrUserNotVerified = errors.New("webauthn user is not verified")
+)
+
+// RelyingParty - сервис, от имени которого проводятся церемонии WebAuthn
+type RelyingParty struct {
+	// Id - домен, к которому привязаны ключи
+	Id   string
+	Name string
+	// Origins - допустимые источники страниц, с которых проводятся церемонии
+	Origins []string
+}
+
+// ClientData - клиентские данные церемонии, подписанные вместе с данными аутентификатора
+type ClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// Credential - учетные данные, созданные при регистрации
+type Credential struct {
+	Id        []byte
+	PublicKey []byte
+	SignCount uint32
+	Aaguid    []byte
+}
+
+// AuthenticatorData - разобранные данные аутентификатора
+type AuthenticatorData struct {
+	RpIdHash  []byte
+	Flags     byte
+	SignCount uint32
+
+	// Credential - новые учетные данные, только при регистрации
+	Credential *Credential
+}
+
+// ParseClientData разбирает клиентские данные, challenge из них используется для поиска церемонии
+func ParseClientData(data []byte) (*ClientData, error) {
+	var clientData ClientData
+	err := json.Unmarshal(data, &clientData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: client data: %v", ErrInvalidResponse, err)
+	}
+
+	return &clientData, nil
+}
+
+// VerifyRegistration проверяет ответ navigator.credentials.create() и возвращает новые учетные данные
+// Принимаются аттестации none и packed с самоподписью: сервис не ограничивает модели аутентификаторов
+func (rp RelyingParty) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte) (*Credential, error) {
+	err := rp.verifyClientData(TYPE_CREATE, challenge, clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	value, rest, err := decodeCbor(attestationObject)
+	if err != nil || len(rest) != 0 {
+		return nil, fmt.Errorf("%w: attestation object", ErrInvalidResponse)
+	}
+
+	attestation, ok := value.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: attestation object", ErrInvalidResponse)
+	}
+
+	format, _ := attestation["fmt"].(string)
+	statement, _ := attestation["attStmt"].(map[any]any)
+	rawAuthData, _ := attestation["authData"].([]byte)
+
+	authData, err := rp.verifyAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	if authData.Credential == nil {
+		return nil, fmt.Errorf("%w: no attested credential", ErrInvalidResponse)
+	}
+
+	key, err := ParsePublicKey(authData.Credential.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	switch format {
+	case "none":
+		if len(statement) != 0 {
+			return nil, fmt.Errorf("%w: none attestation with statement", ErrInvalidResponse)
+		}
+	case "packed":
+		alg, _ := statement["alg"].(int64)
+		signature, _ := statement["sig"].([]byte)
+		if _, ok := statement["x5c"]; ok {
+			return nil, fmt.Errorf("%w: packed attestation with certificates is not supported", ErrInvalidResponse)
+		}
+
+		if alg != key.Alg || !key.Verify(signedData(rawAuthData, clientDataJSON), signature) {
+			return nil, fmt.Errorf("%w: invalid packed self attestation", ErrInvalidResponse)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported attestation format %q", ErrInvalidResponse, format)
+	}
+
+	authData.Credential.SignCount = authData.SignCount
+	return authData.Credential, nil
+}
+
+// VerifyAssertion проверяет ответ navigator.credentials.get() ключом publicKey и возвращает новый счетчик подписей
+// storedCount - счетчик из последнего входа, ErrSignCount если новый счетчик не больше него
+// Аутентификаторы без счетчика всегда присылают 0, для них проверка пропускается
+// Вход по ключу заменяет и пароль, и второй фактор, поэтому без флага UV возвращается ErrUserNotVerified
+func (rp RelyingParty) VerifyAssertion(challenge, publicKey []byte, storedCount uint32, clientDataJSON, authenticatorData, signature []byte) (uint32, error) {
+	err := rp.verifyClientData(TYPE_GET, challenge, clientDataJSON)
+	if err != nil {
+		return 0, err
+	}
+
+	authData, err := rp.verifyAuthenticatorData(authenticatorData)
+	if err != nil {
+		return 0, err
+	}
+
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	if !key.Verify(signedData(authenticatorData, clientDataJSON), signature) {
+		return 0, fmt.Errorf("%w: invalid signature", ErrInvalidResponse)
+	}
+
+	if authData.Flags&FLAG_UV == 0 {
+		return 0, ErrUserNotVerified
+	}
+
+	if (authData.SignCount != 0 || storedCount != 0) && authData.SignCount <= storedCount {
+		return 0, ErrSignCount
+	}
+
+	return authData.SignCount, nil
+}
+
+// verifyClientData проверяет тип церемонии, challenge и источник
+func (rp RelyingParty) verifyClientData(kind string, challenge, clientDataJSON []byte) error {
+	clientData, err := ParseClientData(clientDataJSON)
+	if err != nil {
+		return err
+	}
+
+	if clientData.Type != kind {
+		return fmt.Errorf("%w: unexpected type %q", ErrInvalidResponse, clientData.Type)
+	}
+
+	if clientData.Challenge != base64.RawURLEncoding.EncodeToString(challenge) {
+		return fmt.Errorf("%w: challenge mismatch", ErrInvalidResponse)
+	}
+
+	if !slices.Contains(rp.Origins, clientData.Origin) {
+		return fmt.Errorf("%w: unexpected origin %q", ErrInvalidResponse, clientData.Origin)
+	}
+
+	return nil
+}
+
+// verifyAuthenticatorData разбирает данные аутентификатора и проверяет домен и присутствие пользователя
+func (rp RelyingParty) verifyAuthenticatorData(data []byte) (*AuthenticatorData, error) {
+	authData, err := ParseAuthenticatorData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	rpIdHash := sha256.Sum256([]byte(rp.Id))
+	if !bytes.Equal(authData.RpIdHash, rpIdHash[:]) {
+		return nil, fmt.Errorf("%w: rp id mismatch", ErrInvalidResponse)
+	}
+
+	if authData.Flags&FLAG_UP == 0 {
+		return nil, fmt.Errorf("%w: user is not present", ErrInvalidResponse)
+	}
+
+	return authData, nil
+}
+
+// ParseAuthenticatorData разбирает данные аутентификатора
+func ParseAuthenticatorData(data []byte) (*AuthenticatorData, error) {
+	if len(data) < 37 {
+		return nil, fmt.Errorf("%w: authenticator data is too short", ErrInvalidResponse)
+	}
+
+	authData := &AuthenticatorData{
+		RpIdHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+
+	if authData.Flags&FLAG_AT != 0 {
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("%w: attested credential data is too short", ErrInvalidResponse)
+		}
+
+		credential := &Credential{Aaguid: rest[:16]}
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLen == 0 || idLen > 1023 || len(rest) < idLen {
+			return nil, fmt.Errorf("%w: invalid credential id", ErrInvalidResponse)
+		}
+		credential.Id = rest[:idLen]
+		rest = rest[idLen:]
+
+		// Ключ занимает ровно одно значение CBOR, его длина известна только после разбора
+		_, after, err := decodeCbor(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: credential public key", ErrInvalidResponse)
+		}
+		credential.PublicKey = rest[:len(rest)-len(after)]
+		rest = after
+		authData.Credential = credential
+	}
+
+	if authData.Flags&FLAG_ED != 0 {
+		_, after, err := decodeCbor(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: extensions", ErrInvalidResponse)
+		}
+		rest = after
+	}
+
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing authenticator data", ErrInvalidResponse)
+	}
+
+	return authData, nil
+}
+
+// signedData возвращает данные, которые подписывает аутентификатор
+func signedData(authenticatorData, clientDataJSON []byte) []byte {
+	hash := sha256.Sum256(clientDataJSON)
+	return append(append([]byte(nil), authenticatorData...), hash[:]...)
+}
@@ -0,0 +1,138 @@
+package webauthn
+
+import (
+	"testing"
+	"user/internal/domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRp = RelyingParty{Id: "example.com", Name: "Example", Origins: []string{"https://example.com"}}
+
+func creationOptions(challenge string) domain.PasskeyCreationOptions {
+	return domain.PasskeyCreationOptions{
+		Challenge:    domain.Base64URL(challenge),
+		RelyingParty: domain.PasskeyRelyingParty{Id: testRp.Id, Name: testRp.Name},
+		User:         domain.PasskeyUser{Id: domain.Base64URL("3"), Name: "john@example.com"},
+	}
+}
+
+func requestOptions(challenge string) domain.PasskeyRequestOptions {
+	return domain.PasskeyRequestOptions{Challenge: domain.Base64URL(challenge), RelyingPartyId: testRp.Id}
+}
+
+// Тест CBOR: значения кодируются и декодируются обратно, испорченные данные отклоняются
+func TestCbor(t *testing.T) {
+	value := map[any]any{
+		int64(1):    int64(2),
+		int64(-257): []byte{1, 2, 3},
+		"fmt":       "none",
+		"list":      []any{int64(1000000), int64(-1), true, false},
+		"nested":    map[any]any{},
+	}
+
+	data, err := encodeCbor(value)
+	require.NoError(t, err)
+
+	decoded, rest, err := decodeCbor(append(data, 0xff))
+	require.NoError(t, err)
+	assert.Equal(t, value, decoded)
+	assert.Equal(t, []byte{0xff}, rest)
+
+	for _, bad := range [][]byte{
+		{},
+		{0x5f},                         // байты неопределенной длины
+		{0x43, 1, 2},                   // строка длиннее данных
+		{0x9b, 0xff, 0xff},             // обрезанная длина массива
+		{0xa2, 0x01, 0x01, 0x01, 0x02}, // повторный ключ
+		{0xa1, 0x40, 0x01},             // байтовый ключ
+		{0xf9, 0x00, 0x00},             // число с плавающей точкой
+	} {
+		_, _, err := decodeCbor(bad)
+		assert.Error(t, err, "%x", bad)
+	}
+}
+
+// Тест регистрации и входа программным аутентификатором с аттестациями none и packed
+func TestCeremonies(t *testing.T) {
+	for _, packed := range []bool{false, true} {
+		authenticator := NewAuthenticator("https://example.com")
+		authenticator.Packed = packed
+
+		attestation, err := authenticator.Create(creationOptions("register"))
+		require.NoError(t, err)
+
+		credential, err := testRp.VerifyRegistration([]byte("register"), attestation.Response.ClientDataJSON, attestation.Response.AttestationObject)
+		require.NoError(t, err)
+		assert.Equal(t, []byte(attestation.RawId), credential.Id)
+		assert.Equal(t, uint32(1), credential.SignCount)
+
+		assertion, err := authenticator.Get(requestOptions("login"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("3"), []byte(assertion.Response.UserHandle))
+
+		count, err := testRp.VerifyAssertion([]byte("login"), credential.PublicKey, credential.SignCount, assertion.Response.ClientDataJSON, assertion.Response.AuthenticatorData, assertion.Response.Signature)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(2), count)
+	}
+}
+
+// Тест проверок: чужой challenge, источник, домен, подпись и откат счетчика отклоняются
+func TestVerifyAssertion_Rejects(t *testing.T) {
+	authenticator := NewAuthenticator("https://example.com")
+	attestation, err := authenticator.Create(creationOptions("register"))
+	require.NoError(t, err)
+
+	_, err = testRp.VerifyRegistration([]byte("other"), attestation.Response.ClientDataJSON, attestation.Response.AttestationObject)
+	assert.ErrorIs(t, err, ErrInvalidResponse)
+
+	credential, err := testRp.VerifyRegistration([]byte("register"), attestation.Response.ClientDataJSON, attestation.Response.AttestationObject)
+	require.NoError(t, err)
+
+	// Ответ на регистрацию нельзя выдать за вход
+	_, err = testRp.VerifyAssertion([]byte("register"), credential.PublicKey, 0, attestation.Response.ClientDataJSON, attestation.Response.AttestationObject, nil)
+	assert.ErrorIs(t, err, ErrInvalidResponse)
+
+	assertion, err := authenticator.Get(requestOptions("login"))
+	require.NoError(t, err)
+	verify := func(rp RelyingParty, challenge string, stored uint32, signature []byte) error {
+		_, err := rp.VerifyAssertion([]byte(challenge), credential.PublicKey, stored, assertion.Response.ClientDataJSON, assertion.Response.AuthenticatorData, signature)
+		return err
+	}
+
+	assert.NoError(t, verify(testRp, "login", 1, assertion.Response.Signature))
+	assert.ErrorIs(t, verify(testRp, "other", 1, assertion.Response.Signature), ErrInvalidResponse)
+	assert.ErrorIs(t, verify(RelyingParty{Id: "example.com", Origins: []string{"https://evil.com"}}, "login", 1, assertion.Response.Signature), ErrInvalidResponse)
+	assert.ErrorIs(t, verify(RelyingParty{Id: "evil.com", Origins: testRp.Origins}, "login", 1, assertion.Response.Signature), ErrInvalidResponse)
+
+	tampered := append([]byte(nil), assertion.Response.Signature...)
+	tampered[len(tampered)-1] ^= 1
+	assert.ErrorIs(t, verify(testRp, "login", 1, tampered), ErrInvalidResponse)
+
+	// Скопированный ключ присылает счетчик не больше сохраненного
+	assert.ErrorIs(t, verify(testRp, "login", 2, assertion.Response.Signature), ErrSignCount)
+	authenticator.SetSignCount(0)
+	assertion, err = authenticator.Get(requestOptions("login"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, verify(testRp, "login", 5, assertion.Response.Signature), ErrSignCount)
+
+	// Ключ без PIN и биометрии подтверждает только присутствие пользователя
+	authenticator.SkipVerification = true
+	assertion, err = authenticator.Get(requestOptions("login"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, verify(testRp, "login", 1, assertion.Response.Signature), ErrUserNotVerified)
+}
+
+// Тест ключей COSE: короткий RSA и неизвестный алгоритм не принимаются
+func TestParsePublicKey(t *testing.T) {
+	data, err := encodeCbor(map[any]any{int64(1): int64(3), int64(3): int64(ALG_RS256), int64(-1): []byte{0xc5}, int64(-2): []byte{1, 0, 1}})
+	require.NoError(t, err)
+	_, err = ParsePublicKey(data)
+	assert.ErrorIs(t, err, ErrUnsupportedKey)
+
+	data, err = encodeCbor(map[any]any{int64(1): int64(2), int64(3): int64(-36)})
+	require.NoError(t, err)
+	_, err = ParsePublicKey(data)
+	assert.ErrorIs(t, err, ErrUnsupportedKey)
+}